@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/backup"
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// backupCommand writes a backup to a file, to an s3:// URL, or with "-" to
+// stdout.
+func backupCommand(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "backup-"+time.Now().UTC().Format("20060102-150405")+".json.gz", "file, s3://bucket/key, or - for stdout")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	db := openDB(cfg)
+	defer db.Close()
+
+	bucket, key, toS3 := backup.ParseS3URL(*out)
+	var f *os.File
+	switch {
+	case *out == "-":
+		f = os.Stdout
+	case toS3:
+		// S3 wants the size up front, so the dump is staged in a
+		// temporary file first.
+		tmp, err := os.CreateTemp("", "backup-*.json.gz")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		f = tmp
+	default:
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		f = file
+	}
+
+	h, err := backup.Dump(ctx, db, f)
+	if err != nil {
+		return err
+	}
+	if toS3 {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s3Client(cfg).Put(ctx, bucket, key, f); err != nil {
+			return err
+		}
+	}
+	if f != os.Stdout {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	log.Printf("Backed up %d tables at schema version %d to %s", len(h.Tables), h.SchemaVersion, *out)
+	return nil
+}
+
+// restoreCommand loads a backup from a file, an s3:// URL, or with "-"
+// from stdin. It migrates the database first, so a new one can be
+// restored into straight away.
+func restoreCommand(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	replace := fs.Bool("replace", false, "replace the data in a database that already has accounts")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: restore [-replace] FILE|s3://bucket/key|-")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	src := fs.Arg(0)
+
+	ctx := context.Background()
+	var r io.Reader
+	if bucket, key, ok := backup.ParseS3URL(src); ok {
+		body, err := s3Client(cfg).Get(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		r = body
+	} else if src == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	b, err := backup.Open(r)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restoring %d tables backed up at schema version %d on %s",
+		len(b.Header.Tables), b.Header.SchemaVersion, b.Header.CreatedAt.Format(time.RFC1123))
+
+	db := openDB(cfg)
+	defer db.Close()
+	migrate(ctx, cfg, db)
+
+	err = b.Restore(ctx, db, *replace)
+	if errors.Is(err, backup.ErrNotEmpty) {
+		return fmt.Errorf("%w; pass -replace to overwrite it", err)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %s", src)
+	return nil
+}
+
+func s3Client(cfg config.Config) backup.S3 {
+	return backup.S3{
+		Endpoint:     cfg.S3.Endpoint,
+		Region:       cfg.S3.Region,
+		AccessKey:    cfg.S3.AccessKeyID,
+		SecretKey:    cfg.S3.SecretAccessKey,
+		SessionToken: cfg.S3.SessionToken,
+	}
+}
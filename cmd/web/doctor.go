@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/backup"
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	apphttp "github.com/Trailblazors/htmx-go-postgres/internal/http"
+	"github.com/Trailblazors/htmx-go-postgres/internal/mail"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// doctorTimeout bounds each of doctor's checks.
+const doctorTimeout = 10 * time.Second
+
+// checkResult is how one of doctor's checks went. A warning is something
+// to know about that doesn't stop the app serving.
+type checkResult struct {
+	status string
+	detail string
+}
+
+func passed(format string, args ...any) checkResult {
+	return checkResult{"ok", fmt.Sprintf(format, args...)}
+}
+
+func warned(format string, args ...any) checkResult {
+	return checkResult{"WARN", fmt.Sprintf(format, args...)}
+}
+
+func failed(err error) checkResult {
+	return checkResult{"FAIL", err.Error()}
+}
+
+func skipped(format string, args ...any) checkResult {
+	return checkResult{"skip", fmt.Sprintf(format, args...)}
+}
+
+// doctorCommand checks what the app needs before it can serve, without
+// changing anything, and prints a report: the configuration, the
+// database and its schema, the templates, SMTP, the Redis cache, the ACME
+// certificate cache and, given -s3, the backup bucket. It fails if any
+// check does, so it can gate a deploy.
+func doctorCommand(cfg config.Config, cfgErr error, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	s3URL := fs.String("s3", "", "s3://bucket/prefix to check backups can be written to")
+	fs.Parse(args)
+
+	var db *sql.DB
+	checks := []struct {
+		name string
+		run  func(ctx context.Context) checkResult
+	}{
+		{"config", func(ctx context.Context) checkResult {
+			if cfgErr != nil {
+				return failed(cfgErr)
+			}
+			return passed("loaded, serving %s", cfg.BaseURL)
+		}},
+		{"database", func(ctx context.Context) checkResult {
+			if cfgErr != nil {
+				return skipped("needs the config")
+			}
+			var err error
+			if db, err = sql.Open("postgres", cfg.DatabaseURL); err != nil {
+				return failed(err)
+			}
+			start := time.Now()
+			var version string
+			if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+				db.Close()
+				db = nil
+				return failed(err)
+			}
+			return passed("PostgreSQL %s, answered in %s", version, time.Since(start).Round(time.Millisecond))
+		}},
+		{"migrations", func(ctx context.Context) checkResult {
+			if db == nil {
+				return skipped("needs the database")
+			}
+			version, err := store.Version(ctx, db)
+			if err != nil {
+				return failed(err)
+			}
+			build := store.BuildVersion()
+			switch {
+			case version == 0:
+				return warned("not migrated yet; serve migrates it to schema version %d on boot", build)
+			case version > build:
+				return failed(fmt.Errorf("the database is at schema version %d but this build only knows up to %d; deploy a newer build", version, build))
+			case version < build:
+				return warned("at schema version %d; serve migrates it to %d on boot", version, build)
+			}
+			if err := store.Check(ctx, db); err != nil {
+				return failed(err)
+			}
+			return passed("schema version %d, with every index and statement in place", version)
+		}},
+		{"templates", func(ctx context.Context) checkResult {
+			summary, err := apphttp.CheckTemplates()
+			if err != nil {
+				return failed(err)
+			}
+			return passed("%s", summary)
+		}},
+		{"smtp", func(ctx context.Context) checkResult {
+			if cfgErr != nil {
+				return skipped("needs the config")
+			}
+			if cfg.SMTP.Host == "" {
+				return skipped("SMTP_HOST isn't set, so email is logged")
+			}
+			s := mail.SMTP{Addr: cfg.SMTP.Host + ":" + cfg.SMTP.Port, Username: cfg.SMTP.Username, Password: cfg.SMTP.Password}
+			if err := s.Check(ctx); err != nil {
+				return failed(err)
+			}
+			if s.Username == "" {
+				return passed("%s answered", s.Addr)
+			}
+			return passed("%s accepted the login", s.Addr)
+		}},
+		{"cache", func(ctx context.Context) checkResult {
+			if cfgErr != nil {
+				return skipped("needs the config")
+			}
+			if cfg.CacheStore != "redis" {
+				return skipped("kept in memory")
+			}
+			r, err := cache.NewRedis(cfg.RedisURL)
+			if err == nil {
+				_, _, err = r.Get(ctx, "doctor")
+			}
+			if err != nil {
+				return failed(err)
+			}
+			return passed("Redis answered")
+		}},
+		{"certificates", func(ctx context.Context) checkResult {
+			if cfgErr != nil {
+				return skipped("needs the config")
+			}
+			if !cfg.TLS.Enabled() {
+				return skipped("TLS_DOMAINS isn't set")
+			}
+			if err := os.MkdirAll(cfg.TLS.CacheDir, 0o700); err != nil {
+				return failed(err)
+			}
+			f, err := os.CreateTemp(cfg.TLS.CacheDir, ".doctor-*")
+			if err != nil {
+				return failed(err)
+			}
+			f.Close()
+			os.Remove(f.Name())
+			dir, _ := filepath.Abs(cfg.TLS.CacheDir)
+			return passed("%s is writable", dir)
+		}},
+		{"backups", func(ctx context.Context) checkResult {
+			if cfgErr != nil {
+				return skipped("needs the config")
+			}
+			if *s3URL == "" {
+				return skipped("pass -s3 s3://bucket/prefix to check a backup bucket")
+			}
+			bucket, prefix, ok := backup.ParseS3URL(strings.TrimSuffix(*s3URL, "/") + "/doctor")
+			if !ok {
+				return failed(errors.New("-s3: want s3://bucket/prefix"))
+			}
+			client := s3Client(cfg)
+			key := prefix + "-" + time.Now().UTC().Format("20060102-150405")
+			if err := client.Upload(ctx, bucket, key, "text/plain", strings.NewReader("doctor")); err != nil {
+				return failed(err)
+			}
+			if err := client.Delete(ctx, bucket, key); err != nil {
+				return warned("wrote %s but couldn't delete it: %v", key, err)
+			}
+			return passed("wrote and deleted s3://%s/%s", bucket, key)
+		}},
+	}
+
+	failures := 0
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		res := c.run(ctx)
+		cancel()
+		if res.status == "FAIL" {
+			failures++
+		}
+		fmt.Printf("%-4s  %-12s  %s\n", res.status, c.name, res.detail)
+	}
+	if db != nil {
+		db.Close()
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(checks))
+	}
+	return nil
+}
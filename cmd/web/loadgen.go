@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	apphttp "github.com/Trailblazors/htmx-go-postgres/internal/http"
+	"github.com/Trailblazors/htmx-go-postgres/internal/loadgen"
+)
+
+// loadgenCommand fills the database with synthetic data for load tests,
+// signs each synthetic user in and writes the targets file k6 reads.
+// With -delete it removes the synthetic data instead.
+func loadgenCommand(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	var opts loadgen.Options
+	fs.IntVar(&opts.Users, "users", 100, "synthetic users to make")
+	fs.IntVar(&opts.ListsPerUser, "lists", 3, "lists each user owns")
+	fs.IntVar(&opts.Todos, "todos", 1_000_000, "todos in all, spread over every list")
+	fs.BoolVar(&opts.Replace, "replace", false, "replace synthetic data from an earlier run")
+	out := fs.String("o", "loadtest-targets.json", "where to write the targets for scripts/loadtest.js")
+	del := fs.Bool("delete", false, "delete the synthetic data and exit")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	db := openDB(cfg)
+	defer db.Close()
+	app := migrate(ctx, cfg, db)
+
+	if *del {
+		if err := loadgen.Delete(ctx, db); err != nil {
+			return err
+		}
+		log.Printf("Deleted the synthetic data")
+		return nil
+	}
+
+	users, err := loadgen.Generate(ctx, db, opts)
+	if errors.Is(err, loadgen.ErrExists) {
+		return fmt.Errorf("%w; pass -replace to generate it again", err)
+	}
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		u := &users[i]
+		if u.Session, err = app.Sessions.Create(ctx, u.ID, "loadgen", "127.0.0.1"); err != nil {
+			return err
+		}
+		if u.Token, err = app.Tokens.Create(ctx, u.ID, "Load test", "loadgen"); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := loadgen.WriteTargets(f, cfg.BaseURL, apphttp.SessionCookie, users); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Generated %d users with %d lists each and %d todos; targets are in %s",
+		opts.Users, opts.ListsPerUser, opts.Todos, *out)
+	return nil
+}
@@ -1,178 +1,213 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
-	"html/template"
 	"log"
-	"net/http"
+	"net"
+	"net/url"
 	"os"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	_ "github.com/lib/pq"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/clock"
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	apphttp "github.com/Trailblazors/htmx-go-postgres/internal/http"
+	"github.com/Trailblazors/htmx-go-postgres/internal/querylog"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+	"github.com/Trailblazors/htmx-go-postgres/internal/worker"
 )
 
-type Application struct {
-	DB        *sql.DB
-	Templates *template.Template
-}
-
-type Todo struct {
-	ID        int
-	Title     string
-	Completed bool
-}
-
 func main() {
-	// Get port from environment (Railway sets this)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	command, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
 	}
 
-	// Get database URL from environment
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable required")
+	// doctor reports a bad configuration along with everything else
+	cfg, err := config.Load()
+	if err != nil && command != "doctor" {
+		log.Fatal(err)
+	}
+	switch command {
+	case "serve":
+		err = serve(cfg, args)
+	case "backup":
+		err = backupCommand(cfg, args)
+	case "restore":
+		err = restoreCommand(cfg, args)
+	case "loadgen":
+		err = loadgenCommand(cfg, args)
+	case "rotate-keys":
+		err = rotateKeysCommand(cfg, args)
+	case "doctor":
+		err = doctorCommand(cfg, err, args)
+	case "partition":
+		err = partitionCommand(cfg, args)
+	default:
+		err = fmt.Errorf("unknown command %q; want serve, doctor, backup, restore, loadgen, rotate-keys or partition", command)
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	// Connect to database
-	db, err := sql.Open("postgres", dbURL)
+// openDB connects to the database and checks it answers. Statements are
+// recorded for requests that ask, for the development error page and
+// replays from /admin/requests.
+func openDB(cfg config.Config) *sql.DB {
+	dsn := cfg.DatabaseURL
+	if cfg.TransactionPooling {
+		dsn = withBinaryParameters(dsn)
+	}
+	connector, err := pq.NewConnector(dsn)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	defer db.Close()
-
-	// Test connection
+	db := sql.OpenDB(querylog.Connector(connector))
 	if err = db.Ping(); err != nil {
 		log.Fatal("Failed to ping database:", err)
 	}
+	return db
+}
 
-	// Create table if not exists
-	createTable(db)
-
-	// Parse templates
-	tmpl := template.Must(template.ParseGlob("templates/*.html"))
-
-	app := &Application{
-		DB:        db,
-		Templates: tmpl,
+// withBinaryParameters has lib/pq send a query's parameters along with it.
+// Otherwise it prepares the query and binds them in two round trips, and
+// a transaction pooler can send the second to another server connection,
+// where the query was never prepared. Parameters passed as []byte then go
+// as binary, so JSON is passed as a string.
+func withBinaryParameters(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("binary_parameters", "yes")
+		u.RawQuery = q.Encode()
+		return u.String()
 	}
+	return dsn + " binary_parameters=yes"
+}
 
-	// Setup router
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-
-	// Serve static files
-	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-
-	// Routes
-	r.Get("/", app.homeHandler)
-	r.Get("/todos", app.getTodos)
-	r.Post("/todos", app.createTodo)
-	r.Delete("/todos/{id}", app.deleteTodo)
-	r.Put("/todos/{id}/toggle", app.toggleTodo)
-	r.Get("/health", healthHandler)
-
-	// Start server
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatal(err)
+// migrate brings the whole schema up to date, the core tables and each
+// feature's own, and returns the app built on it.
+func migrate(ctx context.Context, cfg config.Config, db *sql.DB) *apphttp.Application {
+	// Migrate the schema, refusing one a newer build has already migrated,
+	// then check it has everything the queries need. Behind a pooler that's
+	// done over DATABASE_DIRECT_URL if set, so the migration's locks and
+	// long statements don't hold up a pooled connection or hit its limits.
+	schemaDB := db
+	if cfg.DirectDatabaseURL != "" {
+		direct, err := sql.Open("postgres", cfg.DirectDatabaseURL)
+		if err != nil {
+			log.Fatal("Failed to connect to database directly:", err)
+		}
+		defer direct.Close()
+		schemaDB = direct
+	}
+	if err := store.Migrate(ctx, schemaDB); err != nil {
+		log.Fatal("Migration failed: ", err)
+	}
+	if err := store.Check(ctx, schemaDB); err != nil {
+		log.Fatal("Schema check failed: ", err)
 	}
-}
 
-func createTable(db *sql.DB) {
-	query := `
-		CREATE TABLE IF NOT EXISTS todos (
-			id SERIAL PRIMARY KEY,
-			title TEXT NOT NULL,
-			completed BOOLEAN DEFAULT FALSE
-		);
-	`
-	_, err := db.Exec(query)
+	app, err := apphttp.New(ctx, cfg, db)
 	if err != nil {
-		log.Fatal("Failed to create table:", err)
+		log.Fatal(err)
 	}
+	return app
 }
 
-func (app *Application) homeHandler(w http.ResponseWriter, r *http.Request) {
-	app.Templates.ExecuteTemplate(w, "index.html", nil)
-}
+func serve(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	freeze := fs.String("freeze-time", "", "run the date logic at this YYYY-MM-DD date or RFC 3339 time, for development")
+	fs.Parse(args)
 
-func (app *Application) getTodos(w http.ResponseWriter, r *http.Request) {
-	rows, err := app.DB.Query("SELECT id, title, completed FROM todos ORDER BY id DESC")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
+	db := openDB(cfg)
+	defer db.Close()
 
-	var todos []Todo
-	for rows.Next() {
-		var todo Todo
-		if err := rows.Scan(&todo.ID, &todo.Title, &todo.Completed); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	app := migrate(context.Background(), cfg, db)
+	if *freeze != "" {
+		frozen, err := clock.Parse(*freeze)
+		if err != nil {
+			return fmt.Errorf("-freeze-time: %w", err)
 		}
-		todos = append(todos, todo)
+		app.SetClock(frozen)
+		log.Printf("Time is frozen at %s", frozen.Now().Format(time.RFC1123))
 	}
+	defer app.Errors.Flush(2 * time.Second)
+	handler := app.Handler()
 
-	app.Templates.ExecuteTemplate(w, "todo-list.html", todos)
-}
+	// Background jobs, stopped on shutdown
+	jobs := worker.New()
+	app.Schedule(jobs)
 
-func (app *Application) createTodo(w http.ResponseWriter, r *http.Request) {
-	title := r.FormValue("title")
-	if title == "" {
-		http.Error(w, "Title required", http.StatusBadRequest)
-		return
-	}
-
-	var id int
-	err := app.DB.QueryRow(
-		"INSERT INTO todos (title) VALUES ($1) RETURNING id",
-		title,
-	).Scan(&id)
+	// Terminate TLS ourselves when domains are configured
+	srv, redirect, err := apphttp.Server(cfg, handler)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	// Return the todo list
-	app.getTodos(w, r)
-}
-
-func (app *Application) deleteTodo(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	_, err := app.DB.Exec("DELETE FROM todos WHERE id = $1", id)
+	// Listen on PORT, or on a Unix socket for a proxy on the same machine
+	var ln net.Listener
+	if cfg.Socket != "" {
+		ln, err = apphttp.ListenUnix(cfg.Socket, cfg.SocketMode)
+	} else {
+		ln, err = apphttp.Listen(srv.Addr, cfg.ReusePort)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Fatal(err)
 	}
 
-	// Return updated list
-	app.getTodos(w, r)
-}
-
-func (app *Application) toggleTodo(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	_, err := app.DB.Exec(
-		"UPDATE todos SET completed = NOT completed WHERE id = $1",
-		id,
-	)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Start server
+	served := make(chan error, 2)
+	go func() {
+		log.Printf("Server starting on %s", ln.Addr())
+		if cfg.TLS.Enabled() {
+			served <- srv.ServeTLS(ln, "", "")
+		} else {
+			served <- srv.Serve(ln)
+		}
+	}()
+	if redirect != nil {
+		go func() {
+			log.Printf("Redirecting HTTP to HTTPS on %s", redirect.Addr)
+			served <- redirect.ListenAndServe()
+		}()
 	}
 
-	// Return updated list
-	app.getTodos(w, r)
+	// Apply safe config changes on SIGHUP or when CONFIG_FILE changes
+	stopWatching := make(chan struct{})
+	go watchConfig(app, cfg.File, stopWatching)
+	defer close(stopWatching)
+
+	// On SIGTERM, fail /readyz and give the load balancer time to notice,
+	// then stop accepting connections and let in-flight requests finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-served:
+		log.Fatal(err)
+	case <-stop:
+	}
+	app.Drain()
+	log.Printf("Draining for %s before shutting down", cfg.DrainDelay)
+	time.Sleep(cfg.DrainDelay)
+
+	shutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if redirect != nil {
+		if err := redirect.Shutdown(shutdown); err != nil {
+			log.Printf("Shutdown of the HTTP redirect: %v", err)
+		}
+	}
+	if err := srv.Shutdown(shutdown); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+	jobs.Stop()
+	log.Printf("Server stopped")
+	return nil
 }
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK")
-}
\ No newline at end of file
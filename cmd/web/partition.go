@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// partitionCommand splits todo_events and audit_events into a partition a
+// month, so their retention jobs drop old months instead of deleting rows.
+// It locks each table while copying it, so run it in a quiet moment. It's
+// safe to run again; tables already partitioned are left alone.
+func partitionCommand(cfg config.Config, args []string) error {
+	flag.NewFlagSet("partition", flag.ExitOnError).Parse(args)
+
+	ctx := context.Background()
+	db := openDB(cfg)
+	defer db.Close()
+	app := migrate(ctx, cfg, db)
+
+	converted, err := app.PartitionTables(ctx)
+	for _, name := range converted {
+		log.Printf("Partitioned %s by month", name)
+	}
+	if err == nil && len(converted) == 0 {
+		log.Print("Everything was already partitioned")
+	}
+	return err
+}
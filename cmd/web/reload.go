@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	apphttp "github.com/Trailblazors/htmx-go-postgres/internal/http"
+)
+
+// reloadInterval is how often serve checks CONFIG_FILE for changes.
+const reloadInterval = 5 * time.Second
+
+// watchConfig reloads the config on SIGHUP and, when path is set, when the
+// file there changes, until stop is closed.
+func watchConfig(app *apphttp.Application, path string, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if path != "" {
+		t := time.NewTicker(reloadInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	modified := modTime(path)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+		case <-tick:
+			m := modTime(path)
+			if m.Equal(modified) {
+				continue
+			}
+			modified = m
+		}
+		reloadConfig(app)
+	}
+}
+
+// modTime is when the file at path last changed, or the zero time if it
+// can't be read; a file that's being replaced shows up on the next check.
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func reloadConfig(app *apphttp.Application) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Config reload failed, keeping the running config: %v", err)
+		return
+	}
+	applied, pending := app.Reload(cfg)
+	if len(applied) == 0 && len(pending) == 0 {
+		log.Printf("Config reloaded, nothing changed")
+		return
+	}
+	if len(applied) > 0 {
+		log.Printf("Config reloaded, applied %s", strings.Join(applied, ", "))
+	}
+	if len(pending) > 0 {
+		log.Printf("Config changed %s, which apply on restart", strings.Join(pending, ", "))
+	}
+}
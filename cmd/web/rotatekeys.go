@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// rotateKeysCommand reseals the app's stored secrets under ENCRYPTION_KEY.
+// To rotate, move the old key to ENCRYPTION_OLD_KEYS, set the new one,
+// deploy, then run this; once it's done the old key can go.
+func rotateKeysCommand(cfg config.Config, args []string) error {
+	flag.NewFlagSet("rotate-keys", flag.ExitOnError).Parse(args)
+
+	ctx := context.Background()
+	db := openDB(cfg)
+	defer db.Close()
+	app := migrate(ctx, cfg, db)
+
+	rotated, err := app.RotateKeys(ctx)
+	for _, r := range rotated {
+		log.Printf("Resealed %d values in %s", r.Resealed, r.Column)
+	}
+	return err
+}
@@ -3,6 +3,14 @@ module github.com/Trailblazors/htmx-go-postgres
 go 1.23
 
 require (
+	github.com/SherClockHolmes/webpush-go v1.3.0
+	github.com/getsentry/sentry-go v0.29.1
 	github.com/go-chi/chi/v5 v5.2.3
 	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.28.0
+	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.26.0
+	golang.org/x/text v0.19.0
 )
+
+require github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
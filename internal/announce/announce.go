@@ -0,0 +1,220 @@
+// Package announce keeps the banners admins publish to everyone, such as a
+// maintenance window coming up or a new feature, and which users have
+// dismissed which.
+//
+// Live announcements are cached in memory and refreshed periodically, since
+// every page shows them; only dismissals are looked up per request.
+package announce
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of announcement, which set the banner's colour.
+const (
+	Info    = "info"
+	Warning = "warning"
+)
+
+// Kinds lists the kinds in the order the admin form offers them.
+var Kinds = []string{Info, Warning}
+
+type Announcement struct {
+	ID      int
+	Message string
+	Kind    string
+	// StartsAt and EndsAt bound when the banner shows; zero means
+	// straight away and until deleted.
+	StartsAt  time.Time
+	EndsAt    time.Time
+	CreatedAt time.Time
+}
+
+// Live reports whether a is showing at t.
+func (a Announcement) Live(t time.Time) bool {
+	return !t.Before(a.StartsAt) && (a.EndsAt.IsZero() || t.Before(a.EndsAt))
+}
+
+type Store struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	cache    []Announcement
+	loadedAt time.Time
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db, ttl: 30 * time.Second}
+}
+
+// Migrate creates the announcements tables. Dismissals reference users, so
+// run it after the users table exists.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS announcements (
+			id SERIAL PRIMARY KEY,
+			message TEXT NOT NULL,
+			kind TEXT NOT NULL DEFAULT 'info' CHECK (kind IN ('info', 'warning')),
+			starts_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			ends_at TIMESTAMPTZ CHECK (ends_at > starts_at),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS announcement_dismissals (
+			announcement_id INTEGER NOT NULL REFERENCES announcements(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			dismissed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, announcement_id)
+		);
+	`)
+	return err
+}
+
+const columns = "id, message, kind, starts_at, COALESCE(ends_at, 'epoch'), created_at"
+
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]Announcement, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Announcement
+	for rows.Next() {
+		var a Announcement
+		if err := rows.Scan(&a.ID, &a.Message, &a.Kind, &a.StartsAt, &a.EndsAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if a.EndsAt.Equal(time.Unix(0, 0)) {
+			a.EndsAt = time.Time{}
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// List returns every announcement that hasn't ended, scheduled ones
+// included, soonest first. Ended ones are kept for the record but not
+// listed.
+func (s *Store) List(ctx context.Context) ([]Announcement, error) {
+	return s.query(ctx, "SELECT "+columns+" FROM announcements WHERE ends_at IS NULL OR ends_at > NOW() ORDER BY starts_at, id")
+}
+
+// Create publishes a and returns its ID.
+func (s *Store) Create(ctx context.Context, a Announcement) (int, error) {
+	var ends sql.NullTime
+	if !a.EndsAt.IsZero() {
+		ends = sql.NullTime{Time: a.EndsAt, Valid: true}
+	}
+	starts := a.StartsAt
+	if starts.IsZero() {
+		starts = time.Now()
+	}
+	var id int
+	err := s.db.QueryRowContext(ctx,
+		"INSERT INTO announcements (message, kind, starts_at, ends_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		a.Message, a.Kind, starts, ends,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("create announcement: %w", err)
+	}
+	s.invalidate()
+	return id, nil
+}
+
+// Delete takes an announcement down.
+func (s *Store) Delete(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM announcements WHERE id = $1", id)
+	s.invalidate()
+	return err
+}
+
+// Dismiss hides an announcement from userID for good. Unknown ones are
+// ignored, as they may just have been deleted.
+func (s *Store) Dismiss(ctx context.Context, id, userID int) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO announcement_dismissals (announcement_id, user_id)
+		SELECT id, $2 FROM announcements WHERE id = $1
+		ON CONFLICT DO NOTHING`, id, userID)
+	return err
+}
+
+// For returns the announcements showing now that userID hasn't dismissed.
+// Visitors who aren't signed in (userID 0) see them all.
+func (s *Store) For(ctx context.Context, userID int) ([]Announcement, error) {
+	all, err := s.cached(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var live []Announcement
+	var ids []int64
+	for _, a := range all {
+		if a.Live(now) {
+			live = append(live, a)
+			ids = append(ids, int64(a.ID))
+		}
+	}
+	if len(live) == 0 || userID == 0 {
+		return live, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT announcement_id FROM announcement_dismissals
+		WHERE user_id = $1 AND announcement_id = ANY($2)`, userID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	dismissed := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		dismissed[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	shown := live[:0]
+	for _, a := range live {
+		if !dismissed[a.ID] {
+			shown = append(shown, a)
+		}
+	}
+	return shown, nil
+}
+
+// cached returns List, refreshed every ttl. Scheduled announcements are
+// included, so one starting needs no refresh to show up.
+func (s *Store) cached(ctx context.Context) ([]Announcement, error) {
+	s.mu.RLock()
+	list, fresh := s.cache, time.Since(s.loadedAt) < s.ttl
+	s.mu.RUnlock()
+	if fresh {
+		return list, nil
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cache, s.loadedAt = list, time.Now()
+	s.mu.Unlock()
+	return list, nil
+}
+
+func (s *Store) invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
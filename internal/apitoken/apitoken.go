@@ -0,0 +1,165 @@
+// Package apitoken keeps the personal access tokens integrations use to call
+// the JSON API on a user's behalf.
+//
+// Like sessions, only a token's SHA-256 hash is stored; the token itself is
+// shown once, when it's made. Each token has a namespace its integration
+// writes todo metadata under, so a replacement token can pick up where the
+// old one left off by reusing it.
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Prefix starts every token, so they're easy to spot in logs and leaked
+// config.
+const Prefix = "htg_"
+
+// ErrNotFound is returned for tokens that are unknown or revoked.
+var ErrNotFound = errors.New("token not found")
+
+// NamespacePattern is what a namespace may look like.
+var NamespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+type Token struct {
+	ID         int
+	UserID     int
+	Name       string
+	Namespace  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Migrate creates the tokens table. It references users, so run it after
+// the users table exists.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id SERIAL PRIMARY KEY,
+			token_hash BYTEA NOT NULL UNIQUE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			namespace TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_used_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens (user_id);
+	`)
+	return err
+}
+
+// Slug turns a token's name into a namespace, for when none is given.
+func Slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+			b.WriteRune(c)
+			dash = false
+		case b.Len() > 0 && !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimRight(b.String(), "-")
+	if len(slug) > 32 {
+		slug = strings.TrimRight(slug[:32], "-")
+	}
+	return slug
+}
+
+func hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+const columns = "id, user_id, name, namespace, created_at, COALESCE(last_used_at, 'epoch')"
+
+func scan(row interface{ Scan(...any) error }) (Token, error) {
+	var t Token
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Namespace, &t.CreatedAt, &t.LastUsedAt)
+	if t.LastUsedAt.Equal(time.Unix(0, 0)) {
+		t.LastUsedAt = time.Time{}
+	}
+	return t, err
+}
+
+// Create makes a token for userID and returns it, the only time it's
+// available.
+func (s *Store) Create(ctx context.Context, userID int, name, namespace string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := Prefix + base64.RawURLEncoding.EncodeToString(b)
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO api_tokens (token_hash, user_id, name, namespace) VALUES ($1, $2, $3, $4)",
+		hash(token), userID, name, namespace,
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Load returns the token behind raw, noting that it was used. Like
+// sessions, last_used_at is only written once a minute.
+func (s *Store) Load(ctx context.Context, raw string) (Token, error) {
+	t, err := scan(s.db.QueryRowContext(ctx, "SELECT "+columns+" FROM api_tokens WHERE token_hash = $1", hash(raw)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrNotFound
+	}
+	if err != nil || time.Since(t.LastUsedAt) < time.Minute {
+		return t, err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1", t.ID)
+	return t, err
+}
+
+// List returns userID's tokens, newest first.
+func (s *Store) List(ctx context.Context, userID int) ([]Token, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke deletes one of userID's tokens by ID.
+func (s *Store) Revoke(ctx context.Context, userID, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Package assets bundles the JavaScript and CSS under static/ into
+// content-hashed files, built once at startup. Because a file's name changes
+// whenever its content does, bundles are served with a year-long immutable
+// cache header and a deploy still reaches every browser straight away.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Prefix is the URL path bundles are served under.
+const Prefix = "/assets/"
+
+// Bundle is one output file, concatenated from Files in order.
+type Bundle struct {
+	// Name is what templates ask for, e.g. "app.js". The served file gets
+	// the content hash inserted: app.1a2b3c4d.js.
+	Name string
+	// Files are paths or glob patterns. A file matched more than once is
+	// only bundled the first time. Files ending in .min.js or .min.css
+	// are taken as they are; the rest are minified. A bundle none of whose
+	// files exist is left out, unless it's pinned with Integrity.
+	Files []string
+	// Integrity pins a vendored library to the subresource integrity hash
+	// of its Files, "sha384-" and the base64 digest, as its project
+	// publishes it. Build refuses files that don't match, so a library only
+	// changes when its pin does.
+	Integrity string
+}
+
+// Pipeline holds the built bundles.
+type Pipeline struct {
+	names []string
+	urls  map[string]string
+	files map[string][]byte
+}
+
+// Build reads and bundles every Bundle.
+func Build(bundles []Bundle) (*Pipeline, error) {
+	p := &Pipeline{urls: map[string]string{}, files: map[string][]byte{}}
+	for _, b := range bundles {
+		var paths []string
+		for _, pattern := range b.Files {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("assets: %s: %w", b.Name, err)
+			}
+			for _, m := range matches {
+				if !slices.Contains(paths, m) {
+					paths = append(paths, m)
+				}
+			}
+		}
+		if len(paths) == 0 {
+			if b.Integrity != "" {
+				return nil, fmt.Errorf("assets: %s: no files match %s", b.Name, strings.Join(b.Files, ", "))
+			}
+			continue
+		}
+
+		var out bytes.Buffer
+		digest := sha512.New384()
+		for _, file := range paths {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("assets: %s: %w", b.Name, err)
+			}
+			digest.Write(src)
+			out.Write(minify(file, src))
+			if path.Ext(b.Name) == ".js" {
+				// Keep a file missing its final semicolon from running
+				// into the next one.
+				out.WriteString(";\n")
+			} else {
+				out.WriteString("\n")
+			}
+		}
+
+		if b.Integrity != "" {
+			if got := "sha384-" + base64.StdEncoding.EncodeToString(digest.Sum(nil)); got != b.Integrity {
+				return nil, fmt.Errorf("assets: %s: files hash to %s, not the pinned %s", b.Name, got, b.Integrity)
+			}
+		}
+
+		sum := sha256.Sum256(out.Bytes())
+		ext := path.Ext(b.Name)
+		hashed := strings.TrimSuffix(b.Name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+		p.names = append(p.names, b.Name)
+		p.urls[b.Name] = Prefix + hashed
+		p.files[hashed] = out.Bytes()
+	}
+	return p, nil
+}
+
+// URL returns where the named bundle is served from, or "" if it was left
+// out.
+func (p *Pipeline) URL(name string) string {
+	return p.urls[name]
+}
+
+// URLs lists every bundle's URL in the order they were declared.
+func (p *Pipeline) URLs() []string {
+	urls := make([]string, len(p.names))
+	for i, name := range p.names {
+		urls[i] = p.urls[name]
+	}
+	return urls
+}
+
+// ServeHTTP serves the bundles under Prefix.
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	body, ok := p.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(body))
+}
+
+func minify(file string, src []byte) []byte {
+	switch {
+	case strings.Contains(filepath.Base(file), ".min."):
+		return src
+	case strings.HasSuffix(file, ".js"):
+		return minifyJS(src)
+	case strings.HasSuffix(file, ".css"):
+		return minifyCSS(src)
+	}
+	return src
+}
+
+// minifyJS is deliberately conservative: it trims indentation and drops
+// blank lines and whole-line // comments, and nothing that would need a
+// parser to get right.
+func minifyJS(src []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+var (
+	cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssSpace   = regexp.MustCompile(`\s+`)
+	cssPunct   = regexp.MustCompile(`\s*([{};,>])\s*`)
+)
+
+// minifyCSS strips comments and collapses whitespace. Spaces around colons
+// are kept, since "a :hover" and "a:hover" are different selectors, and so
+// is the one in a custom property set to nothing, like Tailwind's
+// "--tw-ring-inset: ;", which older browsers drop without it.
+func minifyCSS(src []byte) []byte {
+	src = cssComment.ReplaceAll(src, nil)
+	src = cssSpace.ReplaceAll(src, []byte(" "))
+	src = cssPunct.ReplaceAll(src, []byte("$1"))
+	src = bytes.ReplaceAll(src, []byte(":;"), []byte(": ;"))
+	return bytes.TrimSpace(src)
+}
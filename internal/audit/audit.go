@@ -0,0 +1,138 @@
+// Package audit records security-relevant events (logins, password changes,
+// sharing) with where they came from, for users to review their own account
+// activity and for admins to investigate.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Actions recorded in the log.
+const (
+	Login           = "login"
+	LoginFailed     = "login_failed"
+	Logout          = "logout"
+	PasswordChanged = "password_changed"
+	ListShared      = "list_shared"
+	// BotBlocked is a login or signup turned away for filling in the
+	// honeypot field or failing the CAPTCHA.
+	BotBlocked     = "bot_blocked"
+	EmailVerified  = "email_verified"
+	IdentityLinked = "identity_linked"
+	// AccountsMerged is an account folded into the user's; Detail is its
+	// email.
+	AccountsMerged = "accounts_merged"
+	// Impersonation events are recorded against the user acted as, with
+	// the admin's email in Detail, so they show up in that user's own
+	// activity. Impersonated is a change made while acting as them.
+	ImpersonationStarted = "impersonation_started"
+	ImpersonationEnded   = "impersonation_ended"
+	Impersonated         = "impersonated"
+	// RequestReplayed is a request served again from /admin/requests,
+	// recorded against the user it ran as; Detail is the request and the
+	// admin who replayed it.
+	RequestReplayed = "request_replayed"
+)
+
+type Event struct {
+	ID int64
+	// UserID is zero for events without a known account, such as a failed
+	// login for an email nobody signed up with.
+	UserID    int
+	Email     string
+	Action    string
+	Detail    string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+type Log struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Log {
+	return &Log{db: db}
+}
+
+// Migrate creates the audit_events table. It references users, so run it
+// after the users table exists.
+func (l *Log) Migrate(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id BIGSERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			email TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (user_id, created_at DESC);
+		CREATE INDEX IF NOT EXISTS audit_events_created_at ON audit_events (created_at DESC);
+		CREATE INDEX IF NOT EXISTS audit_events_ip ON audit_events (ip, created_at DESC);
+	`)
+	return err
+}
+
+// Record stores e. ID and CreatedAt are assigned by the database.
+func (l *Log) Record(ctx context.Context, e Event) error {
+	var userID sql.NullInt64
+	if e.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO audit_events (user_id, email, action, detail, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, e.Email, e.Action, e.Detail, e.IP, e.UserAgent,
+	)
+	return err
+}
+
+const columns = "id, COALESCE(user_id, 0), email, action, detail, ip, user_agent, created_at"
+
+// ForUser returns the latest events for one account, newest first.
+func (l *Log) ForUser(ctx context.Context, userID, limit int) ([]Event, error) {
+	return l.query(ctx, "SELECT "+columns+" FROM audit_events WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2", userID, limit)
+}
+
+// Recent returns the latest events across all accounts, newest first.
+// A non-empty action narrows it to that action.
+func (l *Log) Recent(ctx context.Context, action string, limit int) ([]Event, error) {
+	return l.query(ctx, "SELECT "+columns+" FROM audit_events WHERE $1 = '' OR action = $1 ORDER BY created_at DESC LIMIT $2", action, limit)
+}
+
+// Count returns how many events with one of actions were recorded since
+// then, from ip or, when it's empty, from anywhere.
+func (l *Log) Count(ctx context.Context, actions []string, ip string, since time.Time) (int, error) {
+	var n int
+	err := l.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM audit_events
+		WHERE action = ANY($1) AND ($2 = '' OR ip = $2) AND created_at > $3`,
+		pq.Array(actions), ip, since,
+	).Scan(&n)
+	return n, err
+}
+
+func (l *Log) query(ctx context.Context, query string, args ...any) ([]Event, error) {
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Email, &e.Action, &e.Detail, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
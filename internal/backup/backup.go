@@ -0,0 +1,387 @@
+// Package backup snapshots the app's tables to a single file and restores
+// them, without needing pg_dump or its flags.
+//
+// A backup is gzipped JSON, one record per line: a header with the schema
+// version it was taken at, then each table's columns followed by its rows.
+// Tables are written parents first, so restoring them in the same order
+// never trips a foreign key.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// format is bumped if the file layout changes, not the schema.
+const format = 1
+
+// batchSize is how many rows are inserted per statement when restoring.
+const batchSize = 500
+
+// ErrNotEmpty is returned by Restore for a database that already has
+// accounts, unless it's told to replace them.
+var ErrNotEmpty = errors.New("the database already has data")
+
+type Header struct {
+	Format        int       `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Tables        []string  `json:"tables"`
+}
+
+// record is a line after the header: either the start of a table or one
+// of its rows.
+type record struct {
+	Table   string          `json:"table,omitempty"`
+	Columns []string        `json:"columns,omitempty"`
+	Row     json.RawMessage `json:"row,omitempty"`
+}
+
+// Dump writes every table in db to w. It reads from a single snapshot, so
+// the backup is consistent even while the app keeps writing.
+func Dump(ctx context.Context, db *sql.DB, w io.Writer) (Header, error) {
+	h := Header{Format: format, CreatedAt: time.Now().UTC()}
+	var err error
+	if h.SchemaVersion, err = store.Version(ctx, db); err != nil {
+		return h, err
+	}
+	if h.SchemaVersion == 0 {
+		return h, errors.New("the database hasn't been migrated; start the app against it first")
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return h, err
+	}
+	defer tx.Rollback()
+
+	if h.Tables, err = tables(ctx, tx); err != nil {
+		return h, err
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(h); err != nil {
+		return h, err
+	}
+	for _, table := range h.Tables {
+		if err := dumpTable(ctx, tx, enc, table); err != nil {
+			return h, fmt.Errorf("dump %s: %w", table, err)
+		}
+	}
+	return h, zw.Close()
+}
+
+func dumpTable(ctx context.Context, tx *sql.Tx, enc *json.Encoder, table string) error {
+	columns, err := columns(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(record{Table: table, Columns: columns}); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT row_to_json(t) FROM "+pq.QuoteIdentifier(table)+" t")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row json.RawMessage
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if err := enc.Encode(record{Row: row}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Backup is a backup being read, with its header already checked.
+type Backup struct {
+	Header Header
+	dec    *json.Decoder
+}
+
+// Open reads the header of the backup in r.
+func Open(r io.Reader) (*Backup, error) {
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	b := &Backup{dec: json.NewDecoder(zr)}
+	if err := b.dec.Decode(&b.Header); err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	if b.Header.Format != format {
+		return nil, fmt.Errorf("backup format %d isn't supported by this build", b.Header.Format)
+	}
+	return b, nil
+}
+
+// Restore loads the backup into db, whose schema must be at the version
+// the backup was taken at. Every table in the backup is emptied first, in
+// one transaction with the load, so a restore that fails changes nothing.
+// Unless replace is set, a database with accounts is refused with
+// ErrNotEmpty.
+func (b *Backup) Restore(ctx context.Context, db *sql.DB, replace bool) error {
+	version, err := store.Version(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version != b.Header.SchemaVersion {
+		return fmt.Errorf("the backup is at schema version %d but the database is at %d; restore it with the build that took it, then upgrade", b.Header.SchemaVersion, version)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !replace {
+		var hasData bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users)").Scan(&hasData); err != nil {
+			return err
+		}
+		if hasData {
+			return ErrNotEmpty
+		}
+	}
+
+	existing, err := tables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, t := range existing {
+		known[t] = true
+	}
+	quoted := make([]string, len(b.Header.Tables))
+	for i, t := range b.Header.Tables {
+		if !known[t] {
+			return fmt.Errorf("the database has no %s table; start the app against it once before restoring", t)
+		}
+		quoted[i] = pq.QuoteIdentifier(t)
+	}
+
+	// Triggers would stamp restored rows with new updated_at times.
+	stmts := []string{"TRUNCATE " + strings.Join(quoted, ", ") + " CASCADE"}
+	for _, t := range quoted {
+		stmts = append(stmts, "ALTER TABLE "+t+" DISABLE TRIGGER USER")
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if err := b.load(ctx, tx); err != nil {
+		return err
+	}
+
+	for _, t := range quoted {
+		if _, err := tx.ExecContext(ctx, "ALTER TABLE "+t+" ENABLE TRIGGER USER"); err != nil {
+			return err
+		}
+	}
+	if err := resetSequences(ctx, tx, b.Header.Tables); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// load inserts the backup's rows, a batch at a time.
+func (b *Backup) load(ctx context.Context, tx *sql.Tx) error {
+	var table string
+	var insert string
+	var batch []json.RawMessage
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+		if _, err := tx.ExecContext(ctx, insert, string(rows)); err != nil {
+			return fmt.Errorf("restore %s: %w", table, err)
+		}
+		return nil
+	}
+
+	for {
+		var rec record
+		err := b.dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read backup: %w", err)
+		}
+
+		if rec.Table != "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			table = rec.Table
+			cols := make([]string, len(rec.Columns))
+			for i, c := range rec.Columns {
+				cols[i] = pq.QuoteIdentifier(c)
+			}
+			list := strings.Join(cols, ", ")
+			t := pq.QuoteIdentifier(table)
+			insert = "INSERT INTO " + t + " (" + list + ") SELECT " + list + " FROM json_populate_recordset(NULL::" + t + ", $1)"
+			continue
+		}
+		if table == "" {
+			return errors.New("read backup: row before any table")
+		}
+		batch = append(batch, rec.Row)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// tables lists the app's tables, parents before the tables whose foreign
+// keys point at them. schema_version is left out: it describes the
+// database, not its data. A partitioned table is read and written whole,
+// rather than a partition at a time, so a backup restores whether or not
+// the database it's restored to has been partitioned.
+func tables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.relname,
+			COALESCE(array_agg(DISTINCT p.relname) FILTER (WHERE p.relname IS NOT NULL AND p.relname <> c.relname), '{}')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_constraint fk ON fk.conrelid = c.oid AND fk.contype = 'f'
+		LEFT JOIN pg_class p ON p.oid = fk.confrelid
+		WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p') AND NOT c.relispartition AND c.relname <> 'schema_version'
+		GROUP BY c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := map[string][]string{}
+	var names []string
+	for rows.Next() {
+		var name string
+		var refs []string
+		if err := rows.Scan(&name, pq.Array(&refs)); err != nil {
+			return nil, err
+		}
+		parents[name] = refs
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var ordered []string
+	done := map[string]bool{}
+	var visit func(name string, path map[string]bool) error
+	visit = func(name string, path map[string]bool) error {
+		if done[name] {
+			return nil
+		}
+		if path[name] {
+			return fmt.Errorf("foreign keys loop through %s", name)
+		}
+		path[name] = true
+		for _, p := range parents[name] {
+			if err := visit(p, path); err != nil {
+				return err
+			}
+		}
+		delete(path, name)
+		done[name] = true
+		ordered = append(ordered, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// columns lists table's columns that can be written, in order.
+func columns(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND is_generated = 'NEVER'
+		ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+// resetSequences moves each serial column's sequence past the restored
+// rows, so new rows don't collide with them.
+func resetSequences(ctx context.Context, tx *sql.Tx, tables []string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT table_name, column_name, seq FROM (
+			SELECT table_name, column_name, pg_get_serial_sequence(quote_ident(table_name), column_name) AS seq
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ANY($1)
+		) c WHERE seq IS NOT NULL`, pq.Array(tables))
+	if err != nil {
+		return err
+	}
+	type serial struct{ table, column, seq string }
+	var serials []serial
+	for rows.Next() {
+		var s serial
+		if err := rows.Scan(&s.table, &s.column, &s.seq); err != nil {
+			rows.Close()
+			return err
+		}
+		serials = append(serials, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range serials {
+		_, err := tx.ExecContext(ctx,
+			"SELECT setval($1, COALESCE((SELECT MAX("+pq.QuoteIdentifier(s.column)+") FROM "+pq.QuoteIdentifier(s.table)+"), 0) + 1, false)",
+			s.seq)
+		if err != nil {
+			return fmt.Errorf("reset %s: %w", s.seq, err)
+		}
+	}
+	return nil
+}
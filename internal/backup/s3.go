@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/awssig"
+)
+
+// S3 is a bucket on S3 or a compatible service like MinIO, R2 or Google
+// Cloud Storage with HMAC keys. Objects are addressed path-style, which
+// they all understand.
+type S3 struct {
+	// Endpoint defaults to AWS's for Region.
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+// ParseS3URL splits s3://bucket/key into its bucket and key.
+func ParseS3URL(s string) (bucket, key string, ok bool) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return bucket, key, bucket != "" && key != ""
+}
+
+// Put uploads f, from the start, as key in bucket.
+func (s S3) Put(ctx context.Context, bucket, key string, f *os.File) error {
+	return s.Upload(ctx, bucket, key, "application/gzip", f)
+}
+
+// Upload uploads body, from the start, as key in bucket.
+func (s S3) Upload(ctx context.Context, bucket, key, contentType string, body io.ReadSeeker) error {
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(bucket, key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, hex.EncodeToString(h.Sum(nil)))
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Get downloads key from bucket. The caller closes the body.
+func (s S3) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, awssig.EmptySHA256)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+// Delete removes key from bucket. A key that isn't there is no error.
+func (s S3) Delete(ctx context.Context, bucket, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, awssig.EmptySHA256)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s S3) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s S3) objectURL(bucket, key string) string {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg)
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/" + uriEncode(bucket) + "/" + strings.Join(segments, "/")
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return fmt.Errorf("s3: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sign adds an AWS Signature Version 4 to req, whose body hashes to
+// payloadHash.
+func (s S3) sign(req *http.Request, payloadHash string) {
+	awssig.Sign(req, awssig.Credentials{AccessKey: s.AccessKey, SecretKey: s.SecretKey, SessionToken: s.SessionToken}, s.Region, "s3", payloadHash)
+}
+
+// uriEncode escapes s the way SigV4 expects: everything but unreserved
+// characters, spaces included, as %XX.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
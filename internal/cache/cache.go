@@ -0,0 +1,128 @@
+// Package cache keeps data that's costly to work out and read far more
+// often than it changes, like effort totals and streaks, between requests.
+//
+// Values live in a Store: an LRU in each process, or Redis to share them
+// between instances. Keys are "kind:..." and the Cache counts hits and
+// misses per kind. Callers delete the keys a write affects; entries also
+// expire after a TTL, which bounds how stale anything a write path misses
+// can get. A failing store is logged and treated as a miss, so the cache
+// can go away without taking pages with it.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the cached bytes.
+type Store interface {
+	// Get returns the value at key and whether there was one.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Stats are the lookups for one kind of key since the process started.
+type Stats struct {
+	Kind   string
+	Hits   int64
+	Misses int64
+	Errors int64
+}
+
+// HitRate is the share of lookups answered from the cache, in percent.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return 100 * float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type counters struct {
+	hits, misses, errors atomic.Int64
+}
+
+type Cache struct {
+	store Store
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	kinds map[string]*counters
+}
+
+func New(store Store, ttl time.Duration) *Cache {
+	return &Cache{store: store, ttl: ttl, kinds: map[string]*counters{}}
+}
+
+func (c *Cache) counters(key string) *counters {
+	kind, _, _ := strings.Cut(key, ":")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := c.kinds[kind]
+	if k == nil {
+		k = &counters{}
+		c.kinds[kind] = k
+	}
+	return k
+}
+
+// Load returns the value cached at key, or works it out with fn and caches
+// it. Values are stored as JSON, so T's fields need to be exported.
+func Load[T any](ctx context.Context, c *Cache, key string, fn func() (T, error)) (T, error) {
+	n := c.counters(key)
+	b, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		n.errors.Add(1)
+		log.Printf("cache: get %s: %v", key, err)
+	}
+	if ok {
+		var v T
+		if err := json.Unmarshal(b, &v); err == nil {
+			n.hits.Add(1)
+			return v, nil
+		}
+	}
+	n.misses.Add(1)
+
+	v, err := fn()
+	if err != nil {
+		return v, err
+	}
+	if b, err = json.Marshal(v); err == nil {
+		err = c.store.Set(ctx, key, b, c.ttl)
+	}
+	if err != nil {
+		n.errors.Add(1)
+		log.Printf("cache: set %s: %v", key, err)
+	}
+	return v, nil
+}
+
+// Delete drops keys after a write that changes what they hold.
+func (c *Cache) Delete(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if err := c.store.Delete(ctx, keys...); err != nil {
+		c.counters(keys[0]).errors.Add(1)
+		log.Printf("cache: delete %s: %v", strings.Join(keys, " "), err)
+	}
+}
+
+// Stats returns the counts for each kind of key, by kind.
+func (c *Cache) Stats() []Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Stats, 0, len(c.kinds))
+	for kind, k := range c.kinds {
+		out = append(out, Stats{Kind: kind, Hits: k.hits.Load(), Misses: k.misses.Load(), Errors: k.errors.Load()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
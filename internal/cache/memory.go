@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// Memory is an LRU in the process, holding up to a number of entries.
+// Each instance has its own, so a write on one only invalidates its copy;
+// the TTL is what brings the others round.
+type Memory struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewMemory(max int) *Memory {
+	return &Memory{max: max, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		m.order.Remove(el)
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, time.Now().Add(ttl)
+		m.order.MoveToFront(el)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(&entry{key: key, value: value, expires: time.Now().Add(ttl)})
+	for m.order.Len() > m.max {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*entry).key)
+	}
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := m.entries[key]; ok {
+			m.order.Remove(el)
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds each command, so a slow Redis costs a miss rather
+// than a slow page.
+const redisTimeout = 500 * time.Millisecond
+
+// Redis keeps entries on a Redis server, shared by every instance, so a
+// write on one invalidates them for all. It speaks just enough of the
+// protocol for GET, SET and DEL, over a small pool of connections.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	tls      bool
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewRedis connects to a redis:// URL, or rediss:// for TLS, with an
+// optional password and database number: redis://:secret@host:6379/2.
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("REDIS_URL: want redis://[:password@]host:port[/db], got %q", rawURL)
+	}
+	r := &Redis{addr: u.Host, tls: u.Scheme == "rediss", pool: make(chan *redisConn, 8)}
+	if _, port, _ := net.SplitHostPort(u.Host); port == "" {
+		r.addr = net.JoinHostPort(u.Host, "6379")
+	}
+	r.password, _ = u.User.Password()
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("REDIS_URL: database must be a number, got %q", db)
+		}
+	}
+	return r, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := r.do(ctx, "GET", key)
+	if err != nil || v == nil {
+		return nil, false, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("GET: unexpected reply %v", v)
+	}
+	return b, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	_, err := r.do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// do sends a command and reads its reply. A connection that fails is
+// closed rather than going back in the pool.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	c, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > redisTimeout {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	c.SetDeadline(deadline)
+
+	v, err := c.command(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.Close()
+		return nil, err
+	}
+	select {
+	case r.pool <- c:
+	default:
+		c.Close()
+	}
+	return v, err
+}
+
+func (r *Redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.tls {
+		host, _, _ := net.SplitHostPort(r.addr)
+		nc = tls.Client(nc, &tls.Config{ServerName: host})
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	if r.password != "" {
+		if _, err := c.command("AUTH", r.password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.command("SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// redisError is an error reply, after which the connection is still fine.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisConn) command(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads one reply: nil, a []byte, a string or an int64. Commands
+// used here never reply with arrays.
+func (c *redisConn) reply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
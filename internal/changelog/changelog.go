@@ -0,0 +1,207 @@
+// Package changelog keeps the release notes admins write for /changelog,
+// and when each user last read them, so a dot can mark the link until
+// they've seen what's new.
+//
+// When the latest release came out is cached in memory and refreshed
+// periodically, since every page checks it; only when the user last read
+// the changelog is looked up per request.
+package changelog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Release struct {
+	ID int
+	// Version is a label like "v2.3" or "March 2026"; it can be empty.
+	Version string
+	Title   string
+	// Body is shown with the inline Markdown titles support, keeping its
+	// line breaks.
+	Body string
+	// PublishedAt is when the release shows up; one in the future is
+	// scheduled, and only admins see it until then.
+	PublishedAt time.Time
+}
+
+// Published reports whether r is showing at t.
+func (r Release) Published(t time.Time) bool {
+	return !t.Before(r.PublishedAt)
+}
+
+type Store struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	latest   time.Time
+	loadedAt time.Time
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db, ttl: 30 * time.Second}
+}
+
+// Migrate creates the changelog tables. Reads reference users, so run it
+// after the users table exists.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS releases (
+			id SERIAL PRIMARY KEY,
+			version TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL,
+			body TEXT NOT NULL DEFAULT '',
+			published_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS releases_published_at ON releases (published_at);
+		CREATE TABLE IF NOT EXISTS changelog_reads (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			read_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	return err
+}
+
+const columns = "id, version, title, body, published_at"
+
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]Release, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Release
+	for rows.Next() {
+		var r Release
+		if err := rows.Scan(&r.ID, &r.Version, &r.Title, &r.Body, &r.PublishedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// Published returns the releases out by now, newest first.
+func (s *Store) Published(ctx context.Context) ([]Release, error) {
+	return s.query(ctx, "SELECT "+columns+" FROM releases WHERE published_at <= NOW() ORDER BY published_at DESC, id DESC")
+}
+
+// List returns every release, scheduled ones included, newest first.
+func (s *Store) List(ctx context.Context) ([]Release, error) {
+	return s.query(ctx, "SELECT "+columns+" FROM releases ORDER BY published_at DESC, id DESC")
+}
+
+// Get returns a release, or sql.ErrNoRows.
+func (s *Store) Get(ctx context.Context, id int) (Release, error) {
+	list, err := s.query(ctx, "SELECT "+columns+" FROM releases WHERE id = $1", id)
+	if err != nil {
+		return Release{}, err
+	}
+	if len(list) == 0 {
+		return Release{}, sql.ErrNoRows
+	}
+	return list[0], nil
+}
+
+// Create adds r and returns its ID. A zero PublishedAt publishes it now.
+func (s *Store) Create(ctx context.Context, r Release) (int, error) {
+	if r.PublishedAt.IsZero() {
+		r.PublishedAt = time.Now()
+	}
+	var id int
+	err := s.db.QueryRowContext(ctx,
+		"INSERT INTO releases (version, title, body, published_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		r.Version, r.Title, r.Body, r.PublishedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("create release: %w", err)
+	}
+	s.invalidate()
+	return id, nil
+}
+
+// Update saves r over the release with its ID, or returns sql.ErrNoRows.
+func (s *Store) Update(ctx context.Context, r Release) error {
+	if r.PublishedAt.IsZero() {
+		r.PublishedAt = time.Now()
+	}
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE releases SET version = $2, title = $3, body = $4, published_at = $5 WHERE id = $1",
+		r.ID, r.Version, r.Title, r.Body, r.PublishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("update release: %w", err)
+	}
+	s.invalidate()
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) Delete(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM releases WHERE id = $1", id)
+	s.invalidate()
+	return err
+}
+
+// MarkRead records that userID has read the changelog as of now.
+func (s *Store) MarkRead(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO changelog_reads (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET read_at = NOW()`, userID)
+	return err
+}
+
+// Unread reports whether a release has come out since userID last read
+// the changelog. Someone who never has counts as having read it when they
+// signed up, so new accounts don't start with a dot.
+func (s *Store) Unread(ctx context.Context, userID int) (bool, error) {
+	latest, err := s.cached(ctx)
+	if err != nil || latest.IsZero() || userID == 0 {
+		return false, err
+	}
+	var read time.Time
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT read_at FROM changelog_reads WHERE user_id = $1), created_at)
+		FROM users WHERE id = $1`, userID,
+	).Scan(&read)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return latest.After(read), err
+}
+
+// cached returns when the latest release out by now was published,
+// refreshed every ttl. A scheduled release comes out within ttl of its
+// time.
+func (s *Store) cached(ctx context.Context) (time.Time, error) {
+	s.mu.RLock()
+	latest, fresh := s.latest, time.Since(s.loadedAt) < s.ttl
+	s.mu.RUnlock()
+	if fresh {
+		return latest, nil
+	}
+
+	var t sql.NullTime
+	if err := s.db.QueryRowContext(ctx, "SELECT MAX(published_at) FROM releases WHERE published_at <= NOW()").Scan(&t); err != nil {
+		return time.Time{}, err
+	}
+	s.mu.Lock()
+	s.latest, s.loadedAt = t.Time, time.Now()
+	s.mu.Unlock()
+	return t.Time, nil
+}
+
+func (s *Store) invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
@@ -0,0 +1,46 @@
+// Package clock tells the app what time it is, so what depends on the date
+// (overdue todos, due reminders, My Day, streaks, how long todos have been
+// open) can be run at a time of the developer's choosing instead of
+// whenever it happens to be.
+//
+// Only the date logic goes through a Clock. Timestamps the database
+// records, like when a todo was completed, still come from its own clock.
+package clock
+
+import (
+	"fmt"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the real time.
+type System struct{}
+
+func (System) Now() time.Time { return time.Now() }
+
+// Frozen is always the same time.
+type Frozen time.Time
+
+func (f Frozen) Now() time.Time { return time.Time(f) }
+
+// Today is c's date as DATE columns scan: midnight UTC.
+func Today(c Clock) time.Time {
+	y, m, d := c.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Parse reads a time to freeze at: RFC 3339, or a YYYY-MM-DD date, meaning
+// nine in the morning local time.
+func Parse(s string) (Frozen, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return Frozen(t), nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return Frozen{}, fmt.Errorf("want a YYYY-MM-DD date or an RFC 3339 time, got %q", s)
+	}
+	return Frozen(t.Add(9 * time.Hour)), nil
+}
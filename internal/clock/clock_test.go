@@ -0,0 +1,51 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToday(t *testing.T) {
+	auckland := time.FixedZone("NZDT", 13*60*60)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"morning", time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC), time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"just before midnight", time.Date(2026, 10, 15, 23, 59, 59, 0, time.UTC), time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		// The local date counts, not UTC's, which is still the 14th.
+		{"ahead of UTC", time.Date(2026, 10, 15, 8, 0, 0, 0, auckland), time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Today(Frozen(tt.now)); !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("Today(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+		ok   bool
+	}{
+		{"2026-10-15T18:30:00Z", time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC), true},
+		{"2026-10-15T18:30:00+02:00", time.Date(2026, 10, 15, 16, 30, 0, 0, time.UTC), true},
+		{"2026-10-15", time.Date(2026, 10, 15, 9, 0, 0, 0, time.Local), true},
+		{"2026-02-30", time.Time{}, false},
+		{"15/10/2026", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := Parse(tt.s)
+			if (err == nil) != tt.ok || !got.Now().Equal(tt.want) {
+				t.Errorf("Parse(%q) = %v, %v, want %v", tt.s, got.Now(), err, tt.want)
+			}
+		})
+	}
+}
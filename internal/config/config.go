@@ -0,0 +1,586 @@
+// Package config reads the app's settings from the environment or a
+// settings file, or for secrets, from files or a provider like Vault.
+// Everything is parsed and checked up front by Load, so a typo in a
+// variable stops the app at boot instead of surfacing on the first request
+// that needs it.
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/flags"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/ratelimit"
+)
+
+type Config struct {
+	Port        string
+	DatabaseURL string
+	// TransactionPooling says DatabaseURL goes through a pooler in
+	// transaction mode, like PgBouncer's, which hands each transaction to
+	// whichever server connection is free, so nothing can rely on the
+	// session outliving it. DirectDatabaseURL, if set, connects straight
+	// to Postgres, for migrations.
+	TransactionPooling bool
+	DirectDatabaseURL  string
+	// BaseURL is the public URL used for links in emails, without a
+	// trailing slash.
+	BaseURL string
+	// Socket is a Unix domain socket to listen on instead of Port, for a
+	// proxy on the same machine, created with SocketMode.
+	Socket     string
+	SocketMode os.FileMode
+
+	SentryDSN         string
+	SentryEnvironment string
+
+	// MaintenanceMode pins the mode; when empty it's toggled from /admin.
+	MaintenanceMode maintenance.Mode
+	// FeatureFlags pins flags on or off by name, over /admin/flags.
+	FeatureFlags map[string]bool
+
+	AdminPassword string
+	// AdminEmails decides who is an admin, lower-cased.
+	AdminEmails []string
+	// VerifyEmailToLogin keeps accounts from logging in until they've
+	// followed the link emailed at signup. When false, unverified accounts
+	// can log in but not share lists.
+	VerifyEmailToLogin bool
+
+	// Dev is for running the app on a developer's machine: a panic shows a
+	// page with its stack trace, the request and the SQL it ran, instead
+	// of the friendly error page.
+	Dev bool
+
+	// Demo runs the app as a public demo: visitors get a throwaway account
+	// with sample data, deleted DemoTTL after it's made, and features that
+	// email people or store files are switched off.
+	Demo    bool
+	DemoTTL time.Duration
+
+	// Login sessions slide forward on use, up to an absolute lifetime.
+	SessionIdleTimeout time.Duration
+	SessionMaxLifetime time.Duration
+	// ImpersonationTTL is how long an admin can act as another user
+	// before they're returned to their own account.
+	ImpersonationTTL time.Duration
+
+	RateLimits ratelimit.Config
+	// RateLimitStore is "postgres" to share counts between instances, or
+	// empty to count in memory.
+	RateLimitStore string
+
+	// CacheStore is "redis" to share cached data between instances through
+	// RedisURL, or empty to cache in each process, up to CacheSize entries.
+	// Entries expire after CacheTTL.
+	CacheStore string
+	RedisURL   string
+	CacheSize  int
+	CacheTTL   time.Duration
+
+	SMTP     SMTP
+	MailFrom string
+	Push     Push
+
+	// DailyCapacityMinutes is how many estimated minutes fit in a day
+	// before it's flagged as overbooked.
+	DailyCapacityMinutes int
+
+	HTTP      HTTP
+	Retention Retention
+	AccessLog AccessLog
+	Replay    Replay
+	CORS      CORS
+	TLS       TLS
+	Captcha   Captcha
+	// Geocoder is "nominatim" to look up where the places typed into todos
+	// are, at GeocoderURL if set, or empty to keep them as plain text.
+	Geocoder    string
+	GeocoderURL string
+	Transcriber Transcriber
+	LLM         LLM
+	// Google and GitHub are the apps registered for signing in with those
+	// providers; each is off until its client ID is set.
+	Google OAuthClient
+	GitHub OAuthClient
+	// S3 is where the backup command uploads to, and restore downloads
+	// from, when given an s3:// URL.
+	S3 S3
+	// EncryptionKey seals credentials the app keeps for users, like those
+	// for scheduled exports, which are off without it. It's 32 bytes, set
+	// hex-encoded. OldEncryptionKeys are keys it has replaced, still used
+	// to open values until rotate-keys has resealed them.
+	EncryptionKey     []byte
+	OldEncryptionKeys [][]byte
+
+	// File is the CONFIG_FILE read for settings the environment doesn't
+	// set. serve reloads it when it changes or on SIGHUP.
+	File string
+
+	// ReusePort binds with SO_REUSEPORT, so a new process can start
+	// alongside the old one during a deploy.
+	ReusePort bool
+	// DrainDelay is how long to keep serving after SIGTERM, and
+	// ShutdownTimeout how long to wait for requests in flight after that.
+	DrainDelay      time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Transcriber turns voice notes into text for their todo's description.
+// With no Provider, voice notes are kept as recordings only.
+type Transcriber struct {
+	// Provider is "whisper", for OpenAI's Whisper API or a server that
+	// speaks it at URL.
+	Provider string
+	URL      string
+	APIKey   string
+	Model    string
+}
+
+// LLM is the language model that suggests how to break a todo down. With
+// no Provider, the suggestions are off.
+type LLM struct {
+	// Provider is "openai", for OpenAI's API or a server that speaks it at
+	// URL, or "anthropic".
+	Provider string
+	URL      string
+	APIKey   string
+	Model    string
+}
+
+// Captcha guards login and signup with a challenge once an IP, or everyone
+// together, has failed too often lately. With no Provider, only the
+// honeypot field is checked.
+type Captcha struct {
+	// Provider is "hcaptcha" or "turnstile".
+	Provider string
+	SiteKey  string
+	Secret   string
+	// Threshold is how many failures from one IP within Window bring in
+	// the challenge for it; zero challenges everyone, always.
+	// GlobalThreshold is the same across all IPs, for attacks spread over
+	// many addresses.
+	Threshold       int
+	GlobalThreshold int
+	Window          time.Duration
+}
+
+// OAuthClient is an app registered with a sign-in provider, whose
+// callback URL is BaseURL + "/auth/{provider}/callback".
+type OAuthClient struct {
+	ID     string
+	Secret string
+}
+
+// S3 holds the credentials for an S3-compatible service. Endpoint is
+// only needed for services other than AWS, like MinIO or R2.
+type S3 struct {
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// SMTP is the mail server; with no Host, email is logged instead of sent.
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// Push holds the VAPID keys for web push. Empty keys are generated and
+// stored in the database on first boot.
+type Push struct {
+	Subject    string
+	PublicKey  string
+	PrivateKey string
+}
+
+// HTTP tunes the server's connections. ReadHeaderTimeout bounds how long
+// a client has to send a request's headers, so slow clients can't hold
+// connections open, and IdleTimeout how long a keep-alive connection waits
+// for its next request. H2C accepts HTTP/2 without TLS, for a proxy in
+// front that speaks it; only from TrustedProxies, or anyone on a Unix
+// socket, so it needs one or the other.
+type HTTP struct {
+	H2C               bool
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	// TrustedProxies are the addresses X-Real-IP and X-Forwarded-For are
+	// believed from. Anyone else could set them to pass for another client.
+	TrustedProxies []netip.Prefix
+}
+
+// Retention is how long data is kept. Zero keeps it forever.
+type Retention struct {
+	// ArchivedTodoDays is how long archived todos are kept before they're
+	// deleted for good.
+	ArchivedTodoDays int
+	// InactiveMonths is how long an account can go unused before it's
+	// anonymized. Admins are never anonymized.
+	InactiveMonths int
+	// AuditMonths is how long audit events are kept.
+	AuditMonths int
+	// DryRun has the retention jobs only count what they would remove.
+	DryRun bool
+}
+
+type AccessLog struct {
+	// Level is "debug" to log every request, "info" for failed ones and a
+	// sample of the rest, "warn" for failed ones only, or "error" for
+	// server errors only.
+	Level string
+	// SampleRate is the fraction of successful requests that are logged.
+	// Responses with a 4xx or 5xx status are always logged.
+	SampleRate float64
+	// Redact lists field names to redact on top of the built-in ones.
+	Redact []string
+}
+
+// Replay keeps recent requests in memory for /admin/requests, up to Size
+// of them: every one that failed with a 5xx and SampleRate of the rest.
+// A Size of zero keeps none.
+type Replay struct {
+	Size       int
+	SampleRate float64
+}
+
+type CORS struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+}
+
+// TLS holds the settings for serving HTTPS directly with certificates from
+// Let's Encrypt, for self-hosters without a TLS-terminating proxy. It's
+// off when Domains is empty.
+type TLS struct {
+	Domains  []string
+	Email    string
+	CacheDir string
+}
+
+func (t TLS) Enabled() bool {
+	return len(t.Domains) > 0
+}
+
+// Load reads the configuration from the environment, with CONFIG_FILE,
+// NAME_FILE files and SECRETS_PROVIDER filling in variables it doesn't set.
+func Load() (Config, error) {
+	env, err := loadEnvironment()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{
+		Port:              env.getenv("PORT", "8080"),
+		Socket:            env.get("UNIX_SOCKET"),
+		SocketMode:        0o660,
+		DatabaseURL:       env.get("DATABASE_URL"),
+		DirectDatabaseURL: env.get("DATABASE_DIRECT_URL"),
+		SentryDSN:         env.get("SENTRY_DSN"),
+		SentryEnvironment: env.getenv("SENTRY_ENVIRONMENT", "production"),
+		AdminPassword:     env.get("ADMIN_PASSWORD"),
+		AdminEmails:       SplitList(strings.ToLower(env.get("ADMIN_EMAILS"))),
+		RateLimitStore:    env.get("RATE_LIMIT_STORE"),
+		CacheStore:        env.get("CACHE_STORE"),
+		RedisURL:          env.get("REDIS_URL"),
+		CacheSize:         10000,
+		MailFrom:          env.get("MAIL_FROM"),
+		SMTP: SMTP{
+			Host:     env.get("SMTP_HOST"),
+			Port:     env.getenv("SMTP_PORT", "587"),
+			Username: env.get("SMTP_USERNAME"),
+			Password: env.get("SMTP_PASSWORD"),
+		},
+		Push: Push{
+			Subject:    env.getenv("VAPID_SUBJECT", "mailto:"+env.get("MAIL_FROM")),
+			PublicKey:  env.get("VAPID_PUBLIC_KEY"),
+			PrivateKey: env.get("VAPID_PRIVATE_KEY"),
+		},
+		DailyCapacityMinutes: 8 * 60,
+		HTTP:                 HTTP{H2C: env.get("H2C") == "true"},
+		AccessLog: AccessLog{
+			Level:      env.getenv("LOG_LEVEL", "info"),
+			SampleRate: 1,
+			Redact:     SplitList(strings.ToLower(env.get("LOG_REDACT_FIELDS"))),
+		},
+		Replay: Replay{Size: 500, SampleRate: 0.01},
+		CORS: CORS{
+			AllowedOrigins:   SplitList(env.get("CORS_ALLOWED_ORIGINS")),
+			AllowedMethods:   SplitList(env.get("CORS_ALLOWED_METHODS")),
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
+			AllowCredentials: env.get("CORS_ALLOW_CREDENTIALS") == "true",
+		},
+		TLS: TLS{
+			Domains:  SplitList(env.get("TLS_DOMAINS")),
+			Email:    env.get("ACME_EMAIL"),
+			CacheDir: env.getenv("ACME_CACHE_DIR", "certs"),
+		},
+		Captcha: Captcha{
+			Provider:        env.get("CAPTCHA_PROVIDER"),
+			SiteKey:         env.get("CAPTCHA_SITE_KEY"),
+			Secret:          env.get("CAPTCHA_SECRET"),
+			Threshold:       5,
+			GlobalThreshold: 100,
+		},
+		Geocoder:    env.get("GEOCODER"),
+		GeocoderURL: env.get("GEOCODER_URL"),
+		Transcriber: Transcriber{
+			Provider: env.get("TRANSCRIBER"),
+			URL:      env.get("TRANSCRIBER_URL"),
+			APIKey:   env.get("TRANSCRIBER_API_KEY"),
+			Model:    env.get("TRANSCRIBER_MODEL"),
+		},
+		LLM: LLM{
+			Provider: env.get("LLM_PROVIDER"),
+			URL:      env.get("LLM_URL"),
+			APIKey:   env.get("LLM_API_KEY"),
+			Model:    env.get("LLM_MODEL"),
+		},
+		Dev:       env.get("DEV_MODE") == "true",
+		Demo:      env.get("DEMO_MODE") == "true",
+		Retention: Retention{DryRun: env.get("RETENTION_DRY_RUN") == "true"},
+		S3: S3{
+			Endpoint:        env.get("S3_ENDPOINT"),
+			Region:          env.getenv("AWS_REGION", "us-east-1"),
+			AccessKeyID:     env.get("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: env.get("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    env.get("AWS_SESSION_TOKEN"),
+		},
+		Google:    OAuthClient{ID: env.get("GOOGLE_CLIENT_ID"), Secret: env.get("GOOGLE_CLIENT_SECRET")},
+		GitHub:    OAuthClient{ID: env.get("GITHUB_CLIENT_ID"), Secret: env.get("GITHUB_CLIENT_SECRET")},
+		File:      env.get("CONFIG_FILE"),
+		ReusePort: env.get("REUSE_PORT") == "true",
+	}
+	if cfg.DatabaseURL == "" {
+		return cfg, fmt.Errorf("DATABASE_URL environment variable required")
+	}
+	if len(cfg.CORS.AllowedMethods) == 0 {
+		cfg.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	cfg.BaseURL = strings.TrimSuffix(env.get("BASE_URL"), "/")
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:" + cfg.Port
+	}
+
+	if v := env.get("UNIX_SOCKET_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0o777 {
+			return cfg, fmt.Errorf("UNIX_SOCKET_MODE: want octal permissions like 0660, got %q", v)
+		}
+		cfg.SocketMode = os.FileMode(mode)
+	}
+	if cfg.Socket != "" && cfg.TLS.Enabled() {
+		return cfg, fmt.Errorf("UNIX_SOCKET and TLS_DOMAINS can't be used together; terminate TLS in the proxy in front")
+	}
+	if cfg.HTTP.H2C && cfg.TLS.Enabled() {
+		return cfg, fmt.Errorf("H2C is for plain HTTP behind a proxy; with TLS_DOMAINS, HTTP/2 is on already")
+	}
+	for _, v := range SplitList(env.get("TRUSTED_PROXIES")) {
+		prefix, err := parsePrefix(v)
+		if err != nil {
+			return cfg, fmt.Errorf("TRUSTED_PROXIES: want addresses or CIDR ranges like 10.0.0.0/8, got %q", v)
+		}
+		cfg.HTTP.TrustedProxies = append(cfg.HTTP.TrustedProxies, prefix)
+	}
+	if cfg.HTTP.H2C && cfg.Socket == "" && len(cfg.HTTP.TrustedProxies) == 0 {
+		return cfg, fmt.Errorf("H2C is only accepted from the proxy in front; set TRUSTED_PROXIES to its addresses, or listen on UNIX_SOCKET")
+	}
+	if cfg.Socket != "" && cfg.ReusePort {
+		return cfg, fmt.Errorf("REUSE_PORT only applies to TCP, not UNIX_SOCKET")
+	}
+	if v := env.get("MAINTENANCE_MODE"); v != "" {
+		if cfg.MaintenanceMode, err = maintenance.ParseMode(v); err != nil {
+			return cfg, err
+		}
+	}
+	for _, v := range SplitList(env.get("FEATURE_FLAGS")) {
+		name, state, _ := strings.Cut(v, "=")
+		if !flags.Known(name) || (state != "on" && state != "off") {
+			return cfg, fmt.Errorf("FEATURE_FLAGS: want flag=on or flag=off for known flags, got %q", v)
+		}
+		if cfg.FeatureFlags == nil {
+			cfg.FeatureFlags = map[string]bool{}
+		}
+		cfg.FeatureFlags[name] = state == "on"
+	}
+	switch v := env.getenv("EMAIL_VERIFICATION", "required"); v {
+	case "required", "optional":
+		cfg.VerifyEmailToLogin = v == "required"
+	default:
+		return cfg, fmt.Errorf("EMAIL_VERIFICATION: want required or optional, got %q", v)
+	}
+	if cfg.RateLimits, err = ratelimit.ParseConfig(env.get("RATE_LIMITS")); err != nil {
+		return cfg, err
+	}
+	switch v := env.get("DATABASE_POOL_MODE"); v {
+	case "", "session":
+	case "transaction":
+		cfg.TransactionPooling = true
+	default:
+		return cfg, fmt.Errorf("DATABASE_POOL_MODE: want session or transaction, got %q", v)
+	}
+	switch cfg.CacheStore {
+	case "", "memory":
+	case "redis":
+		if cfg.RedisURL == "" {
+			return cfg, fmt.Errorf("CACHE_STORE=redis needs REDIS_URL")
+		}
+	default:
+		return cfg, fmt.Errorf("CACHE_STORE: want memory or redis, got %q", cfg.CacheStore)
+	}
+	if v := env.get("CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("CACHE_SIZE: want a number of entries, got %q", v)
+		}
+		cfg.CacheSize = n
+	}
+	if v := env.get("ENCRYPTION_KEY"); v != "" {
+		if cfg.EncryptionKey, err = encryptionKey("ENCRYPTION_KEY", v); err != nil {
+			return cfg, err
+		}
+	}
+	for _, v := range SplitList(env.get("ENCRYPTION_OLD_KEYS")) {
+		if cfg.EncryptionKey == nil {
+			return cfg, fmt.Errorf("ENCRYPTION_OLD_KEYS needs ENCRYPTION_KEY")
+		}
+		key, err := encryptionKey("ENCRYPTION_OLD_KEYS", v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.OldEncryptionKeys = append(cfg.OldEncryptionKeys, key)
+	}
+	if n, err := strconv.Atoi(env.get("DAILY_CAPACITY_MINUTES")); err == nil && n > 0 {
+		cfg.DailyCapacityMinutes = n
+	}
+	switch cfg.AccessLog.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return cfg, fmt.Errorf("LOG_LEVEL: want debug, info, warn or error, got %q", cfg.AccessLog.Level)
+	}
+	rates := []struct {
+		name string
+		dst  *float64
+	}{
+		{"LOG_SAMPLE_RATE", &cfg.AccessLog.SampleRate},
+		{"REPLAY_SAMPLE_RATE", &cfg.Replay.SampleRate},
+	}
+	for _, r := range rates {
+		if v := env.get(r.name); v != "" {
+			rate, err := strconv.ParseFloat(v, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return cfg, fmt.Errorf("%s: want a number from 0 to 1, got %q", r.name, v)
+			}
+			*r.dst = rate
+		}
+	}
+	if v := env.get("REPLAY_BUFFER_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("REPLAY_BUFFER_SIZE: want a number of requests, got %q", v)
+		}
+		cfg.Replay.Size = n
+	}
+
+	thresholds := []struct {
+		name string
+		dst  *int
+	}{
+		{"CAPTCHA_THRESHOLD", &cfg.Captcha.Threshold},
+		{"CAPTCHA_GLOBAL_THRESHOLD", &cfg.Captcha.GlobalThreshold},
+	}
+	for _, t := range thresholds {
+		if v := env.get(t.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s: want a whole number of failures, got %q", t.name, v)
+			}
+			*t.dst = n
+		}
+	}
+
+	ages := []struct {
+		name string
+		dst  *int
+		unit string
+	}{
+		{"PURGE_ARCHIVED_AFTER_DAYS", &cfg.Retention.ArchivedTodoDays, "days"},
+		{"ANONYMIZE_INACTIVE_AFTER_MONTHS", &cfg.Retention.InactiveMonths, "months"},
+		{"PURGE_AUDIT_AFTER_MONTHS", &cfg.Retention.AuditMonths, "months"},
+	}
+	for _, a := range ages {
+		if v := env.get(a.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s: want a whole number of %s, got %q", a.name, a.unit, v)
+			}
+			*a.dst = n
+		}
+	}
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+		def  time.Duration
+	}{
+		{"SESSION_IDLE_TIMEOUT", &cfg.SessionIdleTimeout, 14 * 24 * time.Hour},
+		{"SESSION_MAX_LIFETIME", &cfg.SessionMaxLifetime, 30 * 24 * time.Hour},
+		{"IMPERSONATION_TTL", &cfg.ImpersonationTTL, 30 * time.Minute},
+		{"DEMO_TTL", &cfg.DemoTTL, time.Hour},
+		{"DRAIN_DELAY", &cfg.DrainDelay, 5 * time.Second},
+		{"SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout, 30 * time.Second},
+		{"CAPTCHA_WINDOW", &cfg.Captcha.Window, 15 * time.Minute},
+		{"CACHE_TTL", &cfg.CacheTTL, 5 * time.Minute},
+		{"READ_HEADER_TIMEOUT", &cfg.HTTP.ReadHeaderTimeout, 10 * time.Second},
+		{"IDLE_TIMEOUT", &cfg.HTTP.IdleTimeout, 2 * time.Minute},
+	}
+	for _, d := range durations {
+		if *d.dst, err = env.duration(d.name, d.def); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+func encryptionKey(name, v string) ([]byte, error) {
+	key, err := hex.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s: want 64 hex characters, like the output of openssl rand -hex 32", name)
+	}
+	return key, nil
+}
+
+// parsePrefix reads a CIDR range, or a single address as the range of
+// just that address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// SplitList splits a comma-separated list, dropping blanks.
+func SplitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
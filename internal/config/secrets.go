@@ -0,0 +1,121 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SecretSource is somewhere other than the environment that settings can
+// be kept, like Vault or AWS SSM Parameter Store, so credentials needn't
+// sit in plain environment variables.
+type SecretSource interface {
+	// Secrets returns every setting the source holds, by the name of the
+	// environment variable it stands in for.
+	Secrets(ctx context.Context) (map[string]string, error)
+}
+
+// secretSources are the SECRETS_PROVIDER values there are, each set up
+// from the variables it needs.
+var secretSources = map[string]func(env environment) (SecretSource, error){
+	"vault": newVault,
+	"ssm":   newSSM,
+}
+
+// secretsTimeout bounds fetching secrets at boot, so an unreachable
+// provider stops the app rather than hanging it.
+const secretsTimeout = 15 * time.Second
+
+// environment is what Load reads settings from: the process environment,
+// then for a variable it doesn't set, the CONFIG_FILE, then the file
+// NAME_FILE names, then the SECRETS_PROVIDER's value for it.
+type environment map[string]string
+
+func loadEnvironment() (environment, error) {
+	env := environment{}
+	for _, kv := range os.Environ() {
+		name, v, _ := strings.Cut(kv, "=")
+		env[name] = v
+	}
+
+	if path := env.get("CONFIG_FILE"); path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+		for name, v := range settings {
+			if env[name] == "" {
+				env[name] = v
+			}
+		}
+	}
+
+	files := map[string]string{}
+	for name, path := range env {
+		if target, ok := strings.CutSuffix(name, "_FILE"); ok && target != "" && path != "" {
+			files[target] = path
+		}
+	}
+	for name, path := range files {
+		if env[name] != "" {
+			return nil, fmt.Errorf("%s and %s_FILE are both set; use one", name, name)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		env[name] = strings.TrimRight(string(b), "\r\n")
+	}
+
+	provider := env.get("SECRETS_PROVIDER")
+	if provider == "" {
+		return env, nil
+	}
+	newSource, ok := secretSources[provider]
+	if !ok {
+		return nil, fmt.Errorf("SECRETS_PROVIDER: want vault or ssm, got %q", provider)
+	}
+	source, err := newSource(env)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	secrets, err := source.Secrets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets from %s: %w", provider, err)
+	}
+	for name, v := range secrets {
+		if env[name] == "" {
+			env[name] = v
+		}
+	}
+	return env, nil
+}
+
+func (e environment) get(name string) string {
+	return e[name]
+}
+
+func (e environment) getenv(name, def string) string {
+	if v := e[name]; v != "" {
+		return v
+	}
+	return def
+}
+
+// duration parses a Go duration such as "336h", falling back to def when
+// unset.
+func (e environment) duration(name string, def time.Duration) (time.Duration, error) {
+	v := e.get(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", name, v)
+	}
+	return d, nil
+}
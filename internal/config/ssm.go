@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/awssig"
+)
+
+// ssm reads settings from AWS Systems Manager Parameter Store: every
+// parameter directly under a path, named for its variable, like
+// /htmx-go-postgres/DATABASE_URL. SecureString parameters are decrypted.
+type ssm struct {
+	endpoint string
+	region   string
+	path     string
+	creds    awssig.Credentials
+}
+
+func newSSM(env environment) (SecretSource, error) {
+	s := ssm{
+		region: env.getenv("AWS_REGION", "us-east-1"),
+		path:   "/" + strings.Trim(env.get("SSM_PARAMETER_PATH"), "/"),
+		creds: awssig.Credentials{
+			AccessKey:    env.get("AWS_ACCESS_KEY_ID"),
+			SecretKey:    env.get("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: env.get("AWS_SESSION_TOKEN"),
+		},
+	}
+	s.endpoint = "https://ssm." + s.region + ".amazonaws.com/"
+	if s.path == "/" || s.creds.AccessKey == "" || s.creds.SecretKey == "" {
+		return nil, fmt.Errorf("SECRETS_PROVIDER=ssm needs SSM_PARAMETER_PATH, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func (s ssm) Secrets(ctx context.Context) (map[string]string, error) {
+	out := map[string]string{}
+	next := ""
+	for {
+		input := map[string]any{"Path": s.path, "WithDecryption": true, "MaxResults": 10}
+		if next != "" {
+			input["NextToken"] = next
+		}
+		var page struct {
+			Parameters []struct {
+				Name  string `json:"Name"`
+				Value string `json:"Value"`
+			} `json:"Parameters"`
+			NextToken string `json:"NextToken"`
+		}
+		if err := s.call(ctx, "GetParametersByPath", input, &page); err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parameters {
+			out[path.Base(p.Name)] = p.Value
+		}
+		if next = page.NextToken; next == "" {
+			return out, nil
+		}
+	}
+}
+
+func (s ssm) call(ctx context.Context, action string, input, output any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonSSM."+action)
+	awssig.Sign(req, s.creds, s.region, "ssm", awssig.PayloadHash(body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("ssm: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(output)
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// vault reads settings from a HashiCorp Vault KV secret, one key per
+// variable.
+type vault struct {
+	addr      string
+	token     string
+	namespace string
+	// path is the secret's API path under /v1/, like
+	// secret/data/htmx-go-postgres for a KV v2 engine mounted at secret.
+	path string
+}
+
+func newVault(env environment) (SecretSource, error) {
+	v := vault{
+		addr:      strings.TrimSuffix(env.get("VAULT_ADDR"), "/"),
+		token:     env.get("VAULT_TOKEN"),
+		namespace: env.get("VAULT_NAMESPACE"),
+		path:      strings.Trim(env.get("VAULT_SECRET_PATH"), "/"),
+	}
+	if v.addr == "" || v.token == "" || v.path == "" {
+		return nil, fmt.Errorf("SECRETS_PROVIDER=vault needs VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH")
+	}
+	return v, nil
+}
+
+func (v vault) Secrets(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("vault: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// KV v2 nests the secret's keys under data.data, v1 right under data.
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	fields := secret.Data
+	if nested, ok := fields["data"]; ok {
+		var inner map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &inner); err == nil {
+			fields = inner
+		}
+	}
+	out := map[string]string{}
+	for name, raw := range fields {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			// Numbers and booleans go in as written.
+			s = string(raw)
+		}
+		out[name] = s
+	}
+	return out, nil
+}
@@ -0,0 +1,315 @@
+// Package connector keeps a list in two-way sync with a tracker elsewhere,
+// such as a GitHub repository's issues.
+//
+// Each tracker is an Adapter. A Connection ties one list to one place in a
+// tracker, with the credential to act there, the secret its webhooks are
+// signed with and a cursor marking how far its changes have been read.
+// Remote items are linked to the todos they became in sync_links, which
+// also records the state both sides last agreed on: whichever side has
+// moved away from it since has changed, and its change is copied to the
+// other. The app does that copying; this package only talks to trackers
+// and keeps the connections.
+package connector
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/secretbox"
+)
+
+var (
+	// ErrNotFound is returned for connections that don't exist.
+	ErrNotFound = errors.New("connection not found")
+	// ErrExists is returned when the list is already connected to the same
+	// place.
+	ErrExists = errors.New("already connected")
+	// ErrSignature is returned for webhook deliveries that aren't signed
+	// with the connection's secret.
+	ErrSignature = errors.New("bad webhook signature")
+)
+
+// Item is a tracker's item as far as a todo cares.
+type Item struct {
+	// RemoteID identifies the item within its connection's target.
+	RemoteID string
+	Title    string
+	Done     bool
+	URL      string
+}
+
+// Adapter is a tracker the app can sync with.
+type Adapter interface {
+	// Label is how the tracker is shown, like "GitHub Issues".
+	Label() string
+	// Check validates a target and credential before they're saved.
+	Check(ctx context.Context, c Connection) error
+	// Changes returns the items changed since cursor, oldest first, and
+	// the cursor to pass next time. An empty cursor is a first sync,
+	// which only needs the open items.
+	Changes(ctx context.Context, c Connection, cursor string) ([]Item, string, error)
+	// SetDone closes or reopens an item.
+	SetDone(ctx context.Context, c Connection, remoteID string, done bool) error
+	// Webhook reads the items a webhook delivery says have changed,
+	// checking that it's signed with c's secret. Deliveries about
+	// anything else return no items.
+	Webhook(r *http.Request, c Connection) ([]Item, error)
+}
+
+// adapters are the trackers there are, by the name stored with each
+// connection.
+var adapters = map[string]Adapter{
+	"github": newGitHub(),
+}
+
+// Get returns the named adapter.
+func Get(name string) (Adapter, bool) {
+	a, ok := adapters[name]
+	return a, ok
+}
+
+// Provider is an adapter as offered on the form for a new connection.
+type Provider struct {
+	Name  string
+	Label string
+}
+
+// Providers lists the adapters by name.
+func Providers() []Provider {
+	var out []Provider
+	for name, a := range adapters {
+		out = append(out, Provider{Name: name, Label: a.Label()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+type Connection struct {
+	ID     int
+	ListID int
+	// UserID is who connected the list; Credential acts as them.
+	UserID   int
+	Provider string
+	// Target is where in the tracker, like "owner/repo" for GitHub.
+	Target        string
+	Credential    string
+	WebhookSecret string
+	Cursor        string
+	SyncedAt      time.Time
+	// LastError is why the last sync failed, or empty if it didn't.
+	LastError string
+	CreatedAt time.Time
+}
+
+// Adapter returns c's adapter, which is nil if it's no longer there.
+func (c Connection) Adapter() Adapter {
+	return adapters[c.Provider]
+}
+
+type Store struct {
+	db *sql.DB
+	// secrets seals each connection's credential and webhook secret. When
+	// it's nil they're stored as they are.
+	secrets *secretbox.Box
+}
+
+func New(db *sql.DB, secrets *secretbox.Box) *Store {
+	return &Store{db: db, secrets: secrets}
+}
+
+// SealedColumns are the columns the store seals, for rotate-keys. They held
+// plaintext before they were sealed, and do while there's no key.
+var SealedColumns = []secretbox.Column{
+	{Table: "sync_connections", Key: "id", Name: "credential", Plaintext: true},
+	{Table: "sync_connections", Key: "id", Name: "webhook_secret", Plaintext: true},
+}
+
+func (s *Store) seal(v string) (string, error) {
+	if s.secrets == nil {
+		return v, nil
+	}
+	return s.secrets.Seal([]byte(v))
+}
+
+// open returns a sealed value's plaintext, and plaintext as it is.
+func (s *Store) open(v string) (string, error) {
+	if !secretbox.IsSealed(v) {
+		return v, nil
+	}
+	if s.secrets == nil {
+		return "", errors.New("connection secrets are sealed; set ENCRYPTION_KEY to read them")
+	}
+	plaintext, err := s.secrets.Open(v)
+	return string(plaintext), err
+}
+
+// Migrate creates the connections and links tables. They reference lists,
+// todos and users, so run it after store.Migrate.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS sync_connections (
+			id SERIAL PRIMARY KEY,
+			list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider TEXT NOT NULL,
+			target TEXT NOT NULL,
+			credential TEXT NOT NULL,
+			webhook_secret TEXT NOT NULL,
+			cursor TEXT NOT NULL DEFAULT '',
+			synced_at TIMESTAMPTZ,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (list_id, provider, target)
+		);
+		CREATE INDEX IF NOT EXISTS sync_connections_user_id ON sync_connections (user_id);
+
+		CREATE TABLE IF NOT EXISTS sync_links (
+			connection_id INTEGER NOT NULL REFERENCES sync_connections(id) ON DELETE CASCADE,
+			remote_id TEXT NOT NULL,
+			todo_id INTEGER NOT NULL UNIQUE REFERENCES todos(id) ON DELETE CASCADE,
+			url TEXT NOT NULL DEFAULT '',
+			synced_title TEXT NOT NULL,
+			synced_done BOOLEAN NOT NULL,
+			PRIMARY KEY (connection_id, remote_id)
+		);
+	`)
+	return err
+}
+
+const columns = `id, list_id, user_id, provider, target, credential, webhook_secret, cursor,
+	COALESCE(synced_at, 'epoch'), last_error, created_at`
+
+// scan reads a connection, opening its credential and webhook secret.
+func (s *Store) scan(row interface{ Scan(...any) error }) (Connection, error) {
+	var c Connection
+	err := row.Scan(&c.ID, &c.ListID, &c.UserID, &c.Provider, &c.Target, &c.Credential, &c.WebhookSecret,
+		&c.Cursor, &c.SyncedAt, &c.LastError, &c.CreatedAt)
+	if err != nil {
+		return c, err
+	}
+	if c.SyncedAt.Equal(time.Unix(0, 0)) {
+		c.SyncedAt = time.Time{}
+	}
+	if c.Credential, err = s.open(c.Credential); err != nil {
+		return c, fmt.Errorf("connection %d credential: %w", c.ID, err)
+	}
+	if c.WebhookSecret, err = s.open(c.WebhookSecret); err != nil {
+		return c, fmt.Errorf("connection %d webhook secret: %w", c.ID, err)
+	}
+	return c, nil
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]Connection, error) {
+	return s.collect(s.db.QueryContext(ctx, "SELECT "+columns+" FROM sync_connections "+query, args...))
+}
+
+func (s *Store) collect(rows *sql.Rows, err error) ([]Connection, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Connection
+	for rows.Next() {
+		c, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// Create saves a new connection, with a fresh webhook secret, after its
+// adapter has checked it.
+func (s *Store) Create(ctx context.Context, c Connection) (Connection, error) {
+	a := c.Adapter()
+	if a == nil {
+		return c, fmt.Errorf("unknown tracker %q", c.Provider)
+	}
+	if err := a.Check(ctx, c); err != nil {
+		return c, err
+	}
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return c, err
+	}
+	c.WebhookSecret = hex.EncodeToString(b)
+	credential, err := s.seal(c.Credential)
+	if err != nil {
+		return c, err
+	}
+	secret, err := s.seal(c.WebhookSecret)
+	if err != nil {
+		return c, err
+	}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO sync_connections (list_id, user_id, provider, target, credential, webhook_secret)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (list_id, provider, target) DO NOTHING
+		RETURNING id, created_at`,
+		c.ListID, c.UserID, c.Provider, c.Target, credential, secret,
+	).Scan(&c.ID, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrExists
+	}
+	return c, err
+}
+
+func (s *Store) Get(ctx context.Context, id int) (Connection, error) {
+	c, err := s.scan(s.db.QueryRowContext(ctx, "SELECT "+columns+" FROM sync_connections WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// ForList returns the list's connections, oldest first.
+func (s *Store) ForList(ctx context.Context, listID int) ([]Connection, error) {
+	return s.query(ctx, "WHERE list_id = $1 ORDER BY id", listID)
+}
+
+// Due claims the connections last synced longer than every ago, so that
+// with several instances running the sync job each is synced by one.
+func (s *Store) Due(ctx context.Context, every time.Duration) ([]Connection, error) {
+	return s.collect(s.db.QueryContext(ctx, `
+		UPDATE sync_connections SET synced_at = NOW()
+		WHERE synced_at IS NULL OR synced_at < NOW() - make_interval(secs => $1)
+		RETURNING `+columns,
+		every.Seconds(),
+	))
+}
+
+// Synced records the outcome of a sync of connection id: the cursor to
+// carry on from and, if it failed, why.
+func (s *Store) Synced(ctx context.Context, id int, cursor string, syncErr error) error {
+	message := ""
+	if syncErr != nil {
+		message = syncErr.Error()
+	}
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE sync_connections SET cursor = $2, last_error = $3, synced_at = NOW() WHERE id = $1",
+		id, cursor, message,
+	)
+	return err
+}
+
+// Delete removes one of the list's connections. Its todos stay, unlinked.
+func (s *Store) Delete(ctx context.Context, listID, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM sync_connections WHERE id = $1 AND list_id = $2", id, listID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
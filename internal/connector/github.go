@@ -0,0 +1,201 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const githubAPI = "https://api.github.com"
+
+// maxPages bounds how many pages of issues one sync reads; the cursor
+// picks up where it stopped next time.
+const maxPages = 10
+
+var repoPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+// gitHub syncs a repository's issues, identified by number. Pull requests,
+// which GitHub also lists as issues, are skipped. The credential is a
+// token that can write the repository's issues.
+type gitHub struct {
+	client *http.Client
+}
+
+func newGitHub() *gitHub {
+	return &gitHub{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (g *gitHub) Label() string {
+	return "GitHub Issues"
+}
+
+type githubIssue struct {
+	Number      int       `json:"number"`
+	Title       string    `json:"title"`
+	State       string    `json:"state"`
+	HTMLURL     string    `json:"html_url"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+func (i githubIssue) item() Item {
+	return Item{RemoteID: strconv.Itoa(i.Number), Title: i.Title, Done: i.State == "closed", URL: i.HTMLURL}
+}
+
+func (g *gitHub) Check(ctx context.Context, c Connection) error {
+	if !repoPattern.MatchString(c.Target) {
+		return fmt.Errorf("Enter the repository as owner/name")
+	}
+	var repo struct {
+		HasIssues   bool `json:"has_issues"`
+		Permissions struct {
+			Push   bool `json:"push"`
+			Triage bool `json:"triage"`
+		} `json:"permissions"`
+	}
+	if err := g.do(ctx, c, http.MethodGet, "/repos/"+c.Target, nil, &repo); err != nil {
+		return err
+	}
+	if !repo.HasIssues {
+		return fmt.Errorf("%s has issues turned off", c.Target)
+	}
+	if !repo.Permissions.Push && !repo.Permissions.Triage {
+		return fmt.Errorf("The token can't close issues in %s", c.Target)
+	}
+	return nil
+}
+
+// Changes pages through the issues updated since cursor, an RFC 3339 time.
+// GitHub's since is inclusive, so the issues updated at the cursor itself
+// come back again; applying them twice does nothing.
+func (g *gitHub) Changes(ctx context.Context, c Connection, cursor string) ([]Item, string, error) {
+	q := url.Values{
+		"state":     {"all"},
+		"sort":      {"updated"},
+		"direction": {"asc"},
+		"per_page":  {"100"},
+	}
+	if cursor == "" {
+		q.Set("state", "open")
+	} else {
+		q.Set("since", cursor)
+	}
+
+	var items []Item
+	next := cursor
+	for page := 1; page <= maxPages; page++ {
+		q.Set("page", strconv.Itoa(page))
+		var issues []githubIssue
+		if err := g.do(ctx, c, http.MethodGet, "/repos/"+c.Target+"/issues?"+q.Encode(), nil, &issues); err != nil {
+			return items, next, err
+		}
+		for _, i := range issues {
+			if i.PullRequest == nil {
+				items = append(items, i.item())
+			}
+			next = i.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		if len(issues) < 100 {
+			break
+		}
+	}
+	if next == "" {
+		// Nothing open yet: later syncs start from now.
+		next = time.Now().UTC().Format(time.RFC3339)
+	}
+	return items, next, nil
+}
+
+func (g *gitHub) SetDone(ctx context.Context, c Connection, remoteID string, done bool) error {
+	state := "open"
+	if done {
+		state = "closed"
+	}
+	return g.do(ctx, c, http.MethodPatch, "/repos/"+c.Target+"/issues/"+url.PathEscape(remoteID), map[string]string{"state": state}, nil)
+}
+
+// Webhook reads "issues" events, which GitHub signs with an HMAC of the
+// body in X-Hub-Signature-256.
+func (g *gitHub) Webhook(r *http.Request, c Connection) ([]Item, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(c.WebhookSecret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(want)) {
+		return nil, ErrSignature
+	}
+
+	if r.Header.Get("X-GitHub-Event") != "issues" {
+		return nil, nil
+	}
+	var event struct {
+		Issue      githubIssue `json:"issue"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(event.Repository.FullName, c.Target) || event.Issue.PullRequest != nil {
+		return nil, nil
+	}
+	return []Item{event.Issue.item()}, nil
+}
+
+func (g *gitHub) do(ctx context.Context, c Connection, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, githubAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Credential)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("GitHub refused the token; it may have expired")
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("GitHub can't find %s, or the token can't see it", c.Target)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("GitHub %s %s: %s", method, req.URL.Path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,123 @@
+// Package diff compares two versions of a piece of text word by word, for
+// showing what an edit changed.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Op is what happened to a chunk of text between the two versions.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Chunk is a run of text that was kept, added or removed.
+type Chunk struct {
+	Op   Op
+	Text string
+}
+
+// maxCells bounds the table the diff is worked out in. Texts that differ by
+// more than it allows come back as one removal and one insertion.
+const maxCells = 1 << 20
+
+// Words returns the chunks that turn a into b, splitting both into words
+// and the whitespace between them. Chunks with the same Op are merged.
+func Words(a, b string) []Chunk {
+	x, y := tokens(a), tokens(b)
+
+	// Most edits touch the middle of a text; the shared ends needn't go
+	// through the table.
+	var prefix, suffix int
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var chunks []Chunk
+	add := func(op Op, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(chunks); n > 0 && chunks[n-1].Op == op {
+			chunks[n-1].Text += text
+			return
+		}
+		chunks = append(chunks, Chunk{op, text})
+	}
+
+	add(Equal, strings.Join(x[:prefix], ""))
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+	if (len(mx)+1)*(len(my)+1) > maxCells {
+		add(Delete, strings.Join(mx, ""))
+		add(Insert, strings.Join(my, ""))
+	} else {
+		for _, c := range lcs(mx, my) {
+			add(c.Op, c.Text)
+		}
+	}
+	add(Equal, strings.Join(x[len(x)-suffix:], ""))
+	return chunks
+}
+
+// lcs diffs x and y by their longest common subsequence, putting removals
+// before insertions where both fall at the same place.
+func lcs(x, y []string) []Chunk {
+	// n[i][j] is the length of the LCS of x[i:] and y[j:].
+	n := make([][]int, len(x)+1)
+	for i := range n {
+		n[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				n[i][j] = n[i+1][j+1] + 1
+			} else {
+				n[i][j] = max(n[i+1][j], n[i][j+1])
+			}
+		}
+	}
+
+	var chunks []Chunk
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			chunks = append(chunks, Chunk{Equal, x[i]})
+			i++
+			j++
+		case j == len(y) || (i < len(x) && n[i+1][j] >= n[i][j+1]):
+			chunks = append(chunks, Chunk{Delete, x[i]})
+			i++
+		default:
+			chunks = append(chunks, Chunk{Insert, y[j]})
+			j++
+		}
+	}
+	return chunks
+}
+
+// tokens splits s into words and runs of whitespace, which together make
+// up all of s.
+func tokens(s string) []string {
+	var out []string
+	start, space := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			out = append(out, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
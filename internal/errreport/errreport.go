@@ -0,0 +1,43 @@
+// Package errreport sends panics and server errors to an error tracking
+// backend, falling back to the standard logger when none is configured.
+package errreport
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+type Event struct {
+	// Message describes what went wrong: the panic value or the body of a
+	// 5xx response.
+	Message string
+	// Stack is the goroutine stack at the point of a panic, empty for
+	// plain 5xx responses.
+	Stack   []byte
+	Status  int
+	Request *http.Request
+	UserID  string
+}
+
+type Reporter interface {
+	Report(Event)
+	// Flush waits up to timeout for queued events to be delivered.
+	Flush(timeout time.Duration)
+}
+
+// Log writes events to the standard logger.
+type Log struct{}
+
+func (Log) Report(e Event) {
+	if e.Request != nil {
+		log.Printf("error: %d %s %s: %s", e.Status, e.Request.Method, e.Request.URL.Path, e.Message)
+	} else {
+		log.Printf("error: %d: %s", e.Status, e.Message)
+	}
+	if len(e.Stack) > 0 {
+		log.Printf("%s", e.Stack)
+	}
+}
+
+func (Log) Flush(time.Duration) {}
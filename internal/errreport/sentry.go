@@ -0,0 +1,51 @@
+package errreport
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Sentry reports events to a Sentry project. Events are also logged, so the
+// platform logs stay useful when Sentry is unreachable.
+type Sentry struct {
+	client *sentry.Client
+}
+
+func NewSentry(dsn, environment string) (*Sentry, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      environment,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Sentry{client: client}, nil
+}
+
+func (s *Sentry) Report(e Event) {
+	Log{}.Report(e)
+
+	scope := sentry.NewScope()
+	if e.Request != nil {
+		scope.SetRequest(e.Request)
+		scope.SetTag("route", e.Request.Method+" "+e.Request.URL.Path)
+	}
+	if e.UserID != "" {
+		scope.SetUser(sentry.User{ID: e.UserID})
+	}
+	scope.SetTag("status", strconv.Itoa(e.Status))
+	if len(e.Stack) > 0 {
+		scope.SetTag("panic", "true")
+		scope.SetExtra("stack", string(e.Stack))
+	}
+
+	event := s.client.EventFromMessage(e.Message, sentry.LevelError)
+	s.client.CaptureEvent(event, nil, scope)
+}
+
+func (s *Sentry) Flush(timeout time.Duration) {
+	s.client.Flush(timeout)
+}
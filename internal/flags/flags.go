@@ -0,0 +1,225 @@
+// Package flags evaluates feature flags stored in Postgres.
+//
+// A flag is either off, on for everyone, or on for a stable percentage of
+// subjects (usually a user or visitor ID). Flags are cached in memory and
+// refreshed periodically so evaluating them on every request is cheap.
+package flags
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// defaults are the known flags and their descriptions. Declaring a flag
+// here seeds it into the flags table so it shows up in the admin UI before
+// anyone has flipped it. There are none while nothing ships dark.
+var defaults = map[string]string{}
+
+// Known reports whether name is one of the flags declared above.
+func Known(name string) bool {
+	_, ok := defaults[name]
+	return ok
+}
+
+type Flag struct {
+	Name        string
+	Description string
+	Enabled     bool
+	Rollout     int // percentage of subjects (0-100) that see the flag when enabled
+	UpdatedAt   time.Time
+	// Pinned is set when FEATURE_FLAGS overrides the stored state.
+	Pinned bool
+}
+
+// On reports whether the flag is on for the given subject.
+func (f Flag) On(subject string) bool {
+	if !f.Enabled {
+		return false
+	}
+	if f.Rollout >= 100 {
+		return true
+	}
+	if f.Rollout <= 0 || subject == "" {
+		return false
+	}
+	return bucket(f.Name, subject) < f.Rollout
+}
+
+// bucket maps a subject to 0-99, salted with the flag name so the same users
+// aren't always first in line for every rollout.
+func bucket(name, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write([]byte(subject))
+	return int(h.Sum32() % 100)
+}
+
+type Store struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	flags     map[string]Flag
+	loadedAt  time.Time
+	overrides map[string]bool
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, ttl: 30 * time.Second}
+}
+
+// Migrate creates the flags table and seeds the known flags.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS flags (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			rollout INTEGER NOT NULL DEFAULT 100 CHECK (rollout BETWEEN 0 AND 100),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	for name, desc := range defaults {
+		_, err := s.db.ExecContext(ctx,
+			"INSERT INTO flags (name, description) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING",
+			name, desc,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns all flags ordered by name, bypassing the cache.
+func (s *Store) List(ctx context.Context) ([]Flag, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT name, description, enabled, rollout, updated_at FROM flags ORDER BY name",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []Flag
+	for rows.Next() {
+		var f Flag
+		if err := rows.Scan(&f.Name, &f.Description, &f.Enabled, &f.Rollout, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		flags = append(flags, s.pin(f))
+	}
+	return flags, rows.Err()
+}
+
+// SetOverrides pins flags on or off regardless of what the database says,
+// replacing any pinned before. It takes effect on the next evaluation.
+func (s *Store) SetOverrides(overrides map[string]bool) {
+	s.mu.Lock()
+	s.overrides = overrides
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *Store) pin(f Flag) Flag {
+	s.mu.RLock()
+	on, ok := s.overrides[f.Name]
+	s.mu.RUnlock()
+	if ok {
+		f.Enabled, f.Rollout, f.Pinned = on, 100, true
+	}
+	return f
+}
+
+// Get returns a single flag, bypassing the cache.
+func (s *Store) Get(ctx context.Context, name string) (Flag, error) {
+	var f Flag
+	err := s.db.QueryRowContext(ctx,
+		"SELECT name, description, enabled, rollout, updated_at FROM flags WHERE name = $1",
+		name,
+	).Scan(&f.Name, &f.Description, &f.Enabled, &f.Rollout, &f.UpdatedAt)
+	return s.pin(f), err
+}
+
+// Set updates a flag's state and invalidates the cache.
+func (s *Store) Set(ctx context.Context, name string, enabled bool, rollout int) (Flag, error) {
+	if rollout < 0 {
+		rollout = 0
+	}
+	if rollout > 100 {
+		rollout = 100
+	}
+	var f Flag
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO flags (name, enabled, rollout) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET enabled = $2, rollout = $3, updated_at = NOW()
+		RETURNING name, description, enabled, rollout, updated_at`,
+		name, enabled, rollout,
+	).Scan(&f.Name, &f.Description, &f.Enabled, &f.Rollout, &f.UpdatedAt)
+	if err != nil {
+		return f, err
+	}
+
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+	return s.pin(f), nil
+}
+
+// Enabled reports whether the named flag is on for subject. Unknown flags and
+// lookup errors evaluate to false so a broken flags table ships features dark.
+func (s *Store) Enabled(ctx context.Context, name, subject string) bool {
+	flags, err := s.cached(ctx)
+	if err != nil {
+		return false
+	}
+	return flags[name].On(subject)
+}
+
+// Evaluate returns the state of every flag for subject, for passing to
+// templates.
+func (s *Store) Evaluate(ctx context.Context, subject string) map[string]bool {
+	set := make(map[string]bool)
+	flags, err := s.cached(ctx)
+	if err != nil {
+		return set
+	}
+	for name, f := range flags {
+		set[name] = f.On(subject)
+	}
+	return set
+}
+
+func (s *Store) cached(ctx context.Context) (map[string]Flag, error) {
+	s.mu.RLock()
+	flags, fresh := s.flags, time.Since(s.loadedAt) < s.ttl
+	s.mu.RUnlock()
+	if fresh {
+		return flags, nil
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		// Keep serving the last known state rather than flapping features off.
+		if flags != nil {
+			return flags, nil
+		}
+		return nil, err
+	}
+	flags = make(map[string]Flag, len(list))
+	for _, f := range list {
+		flags[f.Name] = f
+	}
+
+	s.mu.Lock()
+	s.flags, s.loadedAt = flags, time.Now()
+	s.mu.Unlock()
+	return flags, nil
+}
@@ -0,0 +1,130 @@
+// Package geocode turns the place typed into a todo, like "Hardware store,
+// Leeds", into coordinates, so todos can be sorted by how near they are.
+//
+// Each geocoding service is a Provider. Nominatim, OpenStreetMap's own, is
+// the one built in; it needs no key, but asks that each client sends at
+// most one request a second and says who it is.
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a provider has no match for a query.
+var ErrNotFound = errors.New("place not found")
+
+// Place is where a query was found.
+type Place struct {
+	// Name is the provider's full name for it, like "Leeds, West
+	// Yorkshire, England, United Kingdom".
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+// Provider is a geocoding service.
+type Provider interface {
+	// Search returns the best match for query, or ErrNotFound.
+	Search(ctx context.Context, query string) (Place, error)
+}
+
+// New returns the named provider. baseURL replaces the provider's public
+// endpoint when set, for a self-hosted instance, and userAgent identifies
+// the app to it.
+func New(name, baseURL, userAgent string) (Provider, error) {
+	switch name {
+	case "nominatim":
+		if baseURL == "" {
+			baseURL = "https://nominatim.openstreetmap.org"
+		}
+		return &Nominatim{
+			baseURL:   strings.TrimSuffix(baseURL, "/"),
+			userAgent: userAgent,
+			client:    &http.Client{Timeout: 5 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("GEOCODER: want nominatim, got %q", name)
+	}
+}
+
+// Nominatim searches OpenStreetMap through a Nominatim server. Requests
+// from one process are spaced a second apart, as the public server's usage
+// policy asks.
+type Nominatim struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (n *Nominatim) Search(ctx context.Context, query string) (Place, error) {
+	if err := n.wait(ctx); err != nil {
+		return Place{}, err
+	}
+
+	u := n.baseURL + "/search?" + url.Values{"q": {query}, "format": {"jsonv2"}, "limit": {"1"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Place{}, err
+	}
+	req.Header.Set("User-Agent", n.userAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return Place{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Place{}, fmt.Errorf("nominatim: %s", resp.Status)
+	}
+
+	// Nominatim sends coordinates as strings.
+	var results []struct {
+		DisplayName string `json:"display_name"`
+		Lat         string `json:"lat"`
+		Lon         string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return Place{}, fmt.Errorf("nominatim: %w", err)
+	}
+	if len(results) == 0 {
+		return Place{}, ErrNotFound
+	}
+	p := Place{Name: results[0].DisplayName}
+	if p.Latitude, err = strconv.ParseFloat(results[0].Lat, 64); err != nil {
+		return Place{}, fmt.Errorf("nominatim: latitude: %w", err)
+	}
+	if p.Longitude, err = strconv.ParseFloat(results[0].Lon, 64); err != nil {
+		return Place{}, fmt.Errorf("nominatim: longitude: %w", err)
+	}
+	return p, nil
+}
+
+// wait holds a request until a second after the one before.
+func (n *Nominatim) wait(ctx context.Context) error {
+	n.mu.Lock()
+	next := n.last.Add(time.Second)
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	n.last = next
+	n.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(next)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
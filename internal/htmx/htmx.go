@@ -0,0 +1,129 @@
+// Package htmx reads htmx request headers and writes its response headers,
+// so handlers don't spell out header names and value formats by hand.
+//
+// See https://htmx.org/reference/#response_headers for what each one does.
+package htmx
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Swap is an hx-swap value.
+type Swap string
+
+const (
+	InnerHTML   Swap = "innerHTML"
+	OuterHTML   Swap = "outerHTML"
+	BeforeBegin Swap = "beforebegin"
+	AfterBegin  Swap = "afterbegin"
+	BeforeEnd   Swap = "beforeend"
+	AfterEnd    Swap = "afterend"
+	Delete      Swap = "delete"
+	None        Swap = "none"
+)
+
+// IsRequest reports whether r was made by htmx rather than a plain
+// navigation or form post.
+func IsRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// Trigger fires events on the client once the response is swapped in.
+// Calling it more than once adds to the events already set.
+func Trigger(w http.ResponseWriter, events ...string) {
+	t := triggers(w)
+	for _, e := range events {
+		if _, ok := t[e]; !ok {
+			t[e] = nil
+		}
+	}
+	setTriggers(w, t)
+}
+
+// TriggerDetail fires event with detail, which arrives as event.detail in
+// the browser.
+func TriggerDetail(w http.ResponseWriter, event string, detail any) {
+	t := triggers(w)
+	t[event] = detail
+	setTriggers(w, t)
+}
+
+// triggers parses the HX-Trigger header already set on w, in either its
+// comma-separated or JSON form.
+func triggers(w http.ResponseWriter) map[string]any {
+	t := map[string]any{}
+	v := w.Header().Get("HX-Trigger")
+	if strings.HasPrefix(v, "{") {
+		json.Unmarshal([]byte(v), &t)
+		return t
+	}
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			t[e] = nil
+		}
+	}
+	return t
+}
+
+// setTriggers writes the plain comma-separated form unless an event carries
+// a detail, which needs JSON.
+func setTriggers(w http.ResponseWriter, t map[string]any) {
+	names := make([]string, 0, len(t))
+	plain := true
+	for e, detail := range t {
+		names = append(names, e)
+		if detail != nil {
+			plain = false
+		}
+	}
+	sort.Strings(names)
+
+	if plain {
+		w.Header().Set("HX-Trigger", strings.Join(names, ", "))
+		return
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	w.Header().Set("HX-Trigger", string(b))
+}
+
+// Redirect makes htmx do a full page load of url.
+func Redirect(w http.ResponseWriter, url string) {
+	w.Header().Set("HX-Redirect", url)
+}
+
+// Refresh makes htmx reload the current page.
+func Refresh(w http.ResponseWriter) {
+	w.Header().Set("HX-Refresh", "true")
+}
+
+// PushURL adds url to the browser history without a page load.
+func PushURL(w http.ResponseWriter, url string) {
+	w.Header().Set("HX-Push-Url", url)
+}
+
+// Retarget swaps the response into the element matching selector instead
+// of the request's hx-target.
+func Retarget(w http.ResponseWriter, selector string) {
+	w.Header().Set("HX-Retarget", selector)
+}
+
+// Reswap overrides the request's hx-swap.
+func Reswap(w http.ResponseWriter, s Swap) {
+	w.Header().Set("HX-Reswap", string(s))
+}
+
+// RedirectOrSeeOther sends htmx requests to url with HX-Redirect and
+// everything else with a 303, for handlers that serve both.
+func RedirectOrSeeOther(w http.ResponseWriter, r *http.Request, url string) {
+	if IsRequest(r) {
+		Redirect(w, url)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
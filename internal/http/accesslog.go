@@ -0,0 +1,122 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// redacted replaces the value of a sensitive field in the access log.
+const redacted = "[REDACTED]"
+
+// sensitiveFields are matched against lower-cased field names; any name
+// containing one of them is redacted, so new_password and csrf_token are
+// covered along with password and token.
+var sensitiveFields = []string{"password", "token", "secret", "key", "auth"}
+
+// maxLoggedForm is the largest urlencoded body accessLog reads to log its
+// fields. Bigger bodies are passed through and logged without them.
+const maxLoggedForm = 64 << 10
+
+// accessLog replaces chi's Logger. At the info level it logs every failed
+// request and a sample of the rest, with the query string and any
+// urlencoded form fields, and redacts the values of fields that look like
+// passwords, tokens or keys. It reads its settings on each request, so
+// Reload can change them.
+func accessLog(settings *atomic.Pointer[config.AccessLog]) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			form := readForm(r)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			cfg := settings.Load()
+			if !logged(cfg, status) {
+				return
+			}
+
+			uri := r.URL.Path
+			if r.URL.RawQuery != "" {
+				uri += "?" + redact(cfg, r.URL.Query())
+			}
+			line := fmt.Sprintf("%s %s %d %dB in %s from %s", r.Method, uri, status, ww.BytesWritten(), time.Since(start).Round(time.Microsecond), clientIP(r))
+			if len(form) > 0 {
+				line += " form: " + redact(cfg, form)
+			}
+			requestLog(r.Context()).Print(line)
+		})
+	}
+}
+
+// logged reports whether a response with status is logged at cfg's level.
+func logged(cfg *config.AccessLog, status int) bool {
+	switch cfg.Level {
+	case "debug":
+		return true
+	case "warn":
+		return status >= 400
+	case "error":
+		return status >= 500
+	}
+	return status >= 400 || rand.Float64() < cfg.SampleRate
+}
+
+func redact(cfg *config.AccessLog, values url.Values) string {
+	for name := range values {
+		if sensitive(cfg, name) {
+			values[name] = []string{redacted}
+		}
+	}
+	// Encode escapes the brackets; keep the marker readable.
+	return strings.ReplaceAll(values.Encode(), url.QueryEscape(redacted), redacted)
+}
+
+// sensitive reports whether a field or header name looks like it holds a
+// secret, containing one of sensitiveFields or cfg's extra names.
+func sensitive(cfg *config.AccessLog, name string) bool {
+	lower := strings.ToLower(name)
+	contains := func(s string) bool { return strings.Contains(lower, s) }
+	return slices.ContainsFunc(sensitiveFields, contains) || slices.ContainsFunc(cfg.Redact, contains)
+}
+
+// readForm parses an urlencoded request body for logging and puts the body
+// back for the handler. Anything else, or anything too big, yields nil.
+func readForm(r *http.Request) url.Values {
+	if r.Body == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedForm+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > maxLoggedForm {
+		return nil
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+	return form
+}
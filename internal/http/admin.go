@@ -0,0 +1,228 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/usage"
+)
+
+func (app *Application) flagOn(r *http.Request, name string) bool {
+	return app.Flags.Enabled(r.Context(), name, flagSubject(r))
+}
+
+// flagSubject is who rollouts bucket r by: the signed-in user, so their
+// flags follow them across devices, otherwise the visitor cookie.
+func flagSubject(r *http.Request) string {
+	if user, _ := currentUser(r); user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	if id := visitorID(r); id != "" {
+		return "visitor:" + id
+	}
+	return ""
+}
+
+type adminDashboard struct {
+	Page
+	Daily       []usage.DayUsage
+	TopRoutes   []usage.RouteUsage
+	TopSubjects []usage.SubjectUsage
+	Lists       []adminList
+	Impersonate impersonateForm
+	Retention   retentionReport
+	// Cache is this instance's hits and misses since it started.
+	Cache      []cache.Stats
+	CacheStore string
+	Outbox     outboxStats
+}
+
+type adminList struct {
+	ID      int
+	Name    string
+	Members int
+	Todos   int
+}
+
+// allLists returns every list with its member and todo counts, so admins can
+// find lists nobody belongs to anymore. Todos are from todo_counters, so
+// archived ones aren't counted.
+func (app *Application) allLists(ctx context.Context) ([]adminList, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT l.id, l.name,
+			(SELECT COUNT(*) FROM list_members m WHERE m.list_id = l.id),
+			COALESCE(c.open + c.completed, 0)
+		FROM lists l
+		LEFT JOIN todo_counters c ON c.list_id = l.id
+		ORDER BY l.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []adminList
+	for rows.Next() {
+		var l adminList
+		if err := rows.Scan(&l.ID, &l.Name, &l.Members, &l.Todos); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+func (app *Application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	data := adminDashboard{Page: app.page(r), Cache: app.Cache.Stats(), CacheStore: app.Config.CacheStore}
+	if data.CacheStore == "" {
+		data.CacheStore = "memory"
+	}
+
+	var err error
+	if data.Daily, err = app.Usage.Daily(r.Context(), 14); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.TopRoutes, err = app.Usage.TopRoutes(r.Context(), 7, 20); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.TopSubjects, err = app.Usage.TopSubjects(r.Context(), 7, 20); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Lists, err = app.allLists(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Retention, err = app.retentionReport(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Outbox, err = app.outboxStats(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "admin.html", data)
+}
+
+type auditPage struct {
+	Action  string
+	Actions []string
+	Events  []audit.Event
+}
+
+// adminAudit shows the latest security events across all accounts,
+// optionally narrowed to one action.
+func (app *Application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	data := auditPage{
+		Action: r.URL.Query().Get("action"),
+		Actions: []string{
+			audit.Login, audit.LoginFailed, audit.Logout, audit.PasswordChanged, audit.ListShared, audit.BotBlocked,
+			audit.EmailVerified, audit.IdentityLinked, audit.AccountsMerged,
+			audit.ImpersonationStarted, audit.ImpersonationEnded, audit.Impersonated,
+			audit.RequestReplayed,
+		},
+	}
+
+	var err error
+	if data.Events, err = app.Audit.Recent(r.Context(), data.Action, 200); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "admin-audit.html", data)
+}
+
+func (app *Application) adminFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := app.Flags.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "admin-flags.html", flags)
+}
+
+func (app *Application) updateFlag(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	current, err := app.Flags.Get(r.Context(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current.Pinned {
+		http.Error(w, "This flag is pinned by FEATURE_FLAGS", http.StatusConflict)
+		return
+	}
+
+	enabled := r.FormValue("enabled") == "on"
+	rollout := current.Rollout
+	if v := r.FormValue("rollout"); v != "" {
+		rollout, err = strconv.Atoi(v)
+		if err != nil || rollout < 0 || rollout > 100 {
+			http.Error(w, "Rollout must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flag, err := app.Flags.Set(r.Context(), name, enabled, rollout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "flag-row", flag)
+}
+
+type maintenancePage struct {
+	Mode       maintenance.Mode
+	Modes      []maintenance.Mode
+	Overridden bool
+}
+
+func (app *Application) maintenancePage(r *http.Request) maintenancePage {
+	return maintenancePage{
+		Mode:       app.Maintenance.Mode(r.Context()),
+		Modes:      []maintenance.Mode{maintenance.Off, maintenance.ReadOnly, maintenance.Full},
+		Overridden: app.Maintenance.Overridden(),
+	}
+}
+
+func (app *Application) adminMaintenance(w http.ResponseWriter, r *http.Request) {
+	app.Templates.ExecuteTemplate(w, "admin-maintenance.html", app.maintenancePage(r))
+}
+
+func (app *Application) updateMaintenance(w http.ResponseWriter, r *http.Request) {
+	if app.Maintenance.Overridden() {
+		http.Error(w, "Maintenance mode is pinned by MAINTENANCE_MODE", http.StatusConflict)
+		return
+	}
+
+	mode, err := maintenance.ParseMode(r.FormValue("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Maintenance.Set(r.Context(), mode); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "maintenance-form", app.maintenancePage(r))
+}
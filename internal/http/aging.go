@@ -0,0 +1,158 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+type agingForm struct {
+	List    model.List
+	Message string
+	Error   string
+}
+
+func (p membersPage) Aging() agingForm {
+	return agingForm{List: p.List}
+}
+
+// setAging updates the list's aging thresholds; a blank one is turned off.
+func (app *Application) setAging(w http.ResponseWriter, r *http.Request) {
+	a := listAccess(r)
+	l, err := app.loadList(r.Context(), a)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	warn, err := agingDays(r.FormValue("aging_warn_days"))
+	var alert *int
+	if err == nil {
+		alert, err = agingDays(r.FormValue("aging_alert_days"))
+	}
+	if err == nil && warn != nil && alert != nil && *alert <= *warn {
+		err = errors.New("Todos need attention after more days than they're aging")
+	}
+	if err != nil {
+		app.Templates.ExecuteTemplate(w, "aging-form", agingForm{List: l, Error: err.Error()})
+		return
+	}
+
+	_, err = app.DB.ExecContext(r.Context(),
+		"UPDATE lists SET aging_warn_days = $2, aging_alert_days = $3 WHERE id = $1",
+		a.ListID, warn, alert,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if l, err = app.loadList(r.Context(), a); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "aging-form", agingForm{List: l, Message: "Saved."})
+}
+
+func agingDays(v string) (*int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return nil, errors.New("Days must be a positive number")
+	}
+	return &n, nil
+}
+
+// agingList is how many of a list's open todos have been open too long.
+type agingList struct {
+	List   model.List `json:"list"`
+	Open   int        `json:"open"`
+	Warn   int        `json:"warn"`
+	Alert  int        `json:"alert"`
+	Oldest int        `json:"oldest_days"`
+}
+
+// agingReport is the stats page's look at how long todos have been open
+// on the user's lists, with the longest-open todos that are flagged.
+type agingReport struct {
+	Lists []agingList    `json:"lists"`
+	Todos []assignedTodo `json:"todos"`
+}
+
+// agingReportLimit is how many of the longest-open flagged todos the
+// report lists.
+const agingReportLimit = 10
+
+func (app *Application) agingReport(ctx context.Context, userID int) (agingReport, error) {
+	report := agingReport{Lists: []agingList{}, Todos: []assignedTodo{}}
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT l.id, l.name, COALESCE(l.aging_warn_days, 0), COALESCE(l.aging_alert_days, 0),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE a.level = 'warn'),
+			COUNT(*) FILTER (WHERE a.level = 'alert'),
+			COALESCE(MAX(EXTRACT(DAY FROM $2::timestamptz - todos.created_at)), 0)::integer
+		FROM todos
+		JOIN lists l ON l.id = todos.list_id,
+		LATERAL (SELECT `+store.AgingLevel(2)+` AS level) a
+		WHERE NOT todos.completed AND todos.archived_at IS NULL
+		  AND todos.list_id IN `+store.MemberLists(1)+`
+		GROUP BY l.id
+		ORDER BY 7 DESC, 6 DESC, lower(l.name), l.id`,
+		userID, app.Clock.Now(),
+	)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a agingList
+		if err := rows.Scan(&a.List.ID, &a.List.Name, &a.List.AgingWarnDays, &a.List.AgingAlertDays, &a.Open, &a.Warn, &a.Alert, &a.Oldest); err != nil {
+			return report, err
+		}
+		report.Lists = append(report.Lists, a)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	rows, err = app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(3)+`, (SELECT name FROM lists WHERE lists.id = todos.list_id)
+		FROM todos
+		WHERE NOT completed AND archived_at IS NULL
+		  AND list_id IN `+store.MemberLists(1)+`
+		  AND `+store.AgingLevel(3)+` <> ''
+		ORDER BY created_at, id
+		LIMIT $2`,
+		userID, agingReportLimit, app.Clock.Now(),
+	)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t assignedTodo
+		if err := rows.Scan(append(store.TodoFields(&t.Todo), &t.ListName)...); err != nil {
+			return report, err
+		}
+		report.Todos = append(report.Todos, t)
+	}
+	return report, rows.Err()
+}
+
+// getAgingReport renders the aging report, which the stats page loads on
+// its own since it reads every open todo.
+func (app *Application) getAgingReport(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	report, err := app.agingReport(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.render(w, r, view{Fragment: "aging-report", Data: report, JSON: report})
+}
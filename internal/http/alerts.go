@@ -0,0 +1,260 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+)
+
+// maxSavedSearches is how many search alerts one account can have.
+const maxSavedSearches = 20
+
+// Ways a search alert can be sent.
+const (
+	alertByEmail = "email"
+	alertByPush  = "push"
+)
+
+// savedSearch is a search someone asked to be alerted about, run from the
+// list it was saved on.
+type savedSearch struct {
+	ID       int
+	UserID   int
+	Email    string
+	ListID   int
+	ListName string
+	Query    string
+	Channel  string
+}
+
+// searchAlert is the email sent when todos start matching a saved search.
+type searchAlert struct {
+	Query    string
+	ListName string
+	ListURL  string
+	BaseURL  string
+	Todos    []model.Todo
+}
+
+// refreshMatches brings what s matched last time up to date with what it
+// matches now, returning the todos that are new to it. The diff happens in
+// the statement, so two instances running it at once can't both report
+// the same todo.
+func (app *Application) refreshMatches(ctx context.Context, s savedSearch) ([]model.Todo, error) {
+	text, filter, _, err := app.parseSearch(ctx, s.UserID, s.ListID, s.Query)
+	if err != nil {
+		return nil, err
+	}
+	if text != "" {
+		filter.matchText(text)
+	}
+	id := filter.arg(s.ID)
+	rows, err := app.db(ctx).QueryContext(ctx, `
+		WITH current AS (
+			SELECT id FROM todos WHERE archived_at IS NULL AND `+filter.String()+`
+		), gone AS (
+			DELETE FROM saved_search_matches
+			WHERE search_id = `+id+` AND todo_id NOT IN (SELECT id FROM current)
+		), added AS (
+			INSERT INTO saved_search_matches (search_id, todo_id)
+			SELECT `+id+`, id FROM current
+			ON CONFLICT DO NOTHING
+			RETURNING todo_id
+		)
+		SELECT t.id, t.title FROM todos t JOIN added a ON a.todo_id = t.id ORDER BY t.id`,
+		filter.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var added []model.Todo
+	for rows.Next() {
+		var t model.Todo
+		if err := rows.Scan(&t.ID, &t.Title); err != nil {
+			return nil, err
+		}
+		added = append(added, t)
+	}
+	return added, rows.Err()
+}
+
+// createSearchAlert saves the search in the list's search box. What it
+// matches now is recorded straight away, so only todos that match later
+// are alerted about.
+func (app *Application) createSearchAlert(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	s := savedSearch{
+		UserID:  user.ID,
+		ListID:  listAccess(r).ListID,
+		Query:   strings.TrimSpace(r.FormValue("q")),
+		Channel: r.FormValue("channel"),
+	}
+	if s.Query == "" {
+		app.Templates.RenderComponent(w, "toast", toast{Message: "Search for something first, then save it.", Error: true})
+		return
+	}
+	if s.Channel != alertByEmail && s.Channel != alertByPush {
+		app.Templates.RenderComponent(w, "toast", toast{Message: "Pick email or browser notifications.", Error: true})
+		return
+	}
+
+	err := app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		db := app.db(ctx)
+		var count int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM saved_searches WHERE user_id = $1", s.UserID).Scan(&count); err != nil {
+			return err
+		}
+		if count >= maxSavedSearches {
+			return errTooManyAlerts
+		}
+		err := db.QueryRowContext(ctx,
+			"INSERT INTO saved_searches (user_id, list_id, query, channel) VALUES ($1, $2, $3, $4) RETURNING id",
+			s.UserID, s.ListID, s.Query, s.Channel,
+		).Scan(&s.ID)
+		if err != nil {
+			return err
+		}
+		_, err = app.refreshMatches(ctx, s)
+		return err
+	})
+	if errors.Is(err, errTooManyAlerts) {
+		app.Templates.RenderComponent(w, "toast", toast{Message: fmt.Sprintf("You can have up to %d search alerts. Remove one in Settings first.", maxSavedSearches), Error: true})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.RenderComponent(w, "toast", toast{Message: "Saved. You'll hear about new todos matching " + s.Query + "."})
+}
+
+var errTooManyAlerts = errors.New("too many search alerts")
+
+// savedSearches returns userID's search alerts, for the settings page.
+func (app *Application) savedSearches(ctx context.Context, userID int) ([]savedSearch, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT s.id, s.list_id, l.name, s.query, s.channel FROM saved_searches s
+		JOIN lists l ON l.id = s.list_id
+		WHERE s.user_id = $1 ORDER BY s.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []savedSearch
+	for rows.Next() {
+		s := savedSearch{UserID: userID}
+		if err := rows.Scan(&s.ID, &s.ListID, &s.ListName, &s.Query, &s.Channel); err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+func (app *Application) deleteSearchAlert(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if _, err := app.DB.ExecContext(r.Context(), "DELETE FROM saved_searches WHERE id = $1 AND user_id = $2", id, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	searches, err := app.savedSearches(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "search-alerts", searches)
+}
+
+// sendSearchAlerts reruns the saved searches not checked in the last ten
+// minutes and alerts their owners about todos that have started matching.
+// Searches are claimed as they're checked, so with several instances
+// running the job each is run by one.
+func (app *Application) sendSearchAlerts(ctx context.Context) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		UPDATE saved_searches s SET checked_at = NOW()
+		FROM users u, lists l
+		WHERE u.id = s.user_id AND l.id = s.list_id AND s.checked_at < NOW() - INTERVAL '10 minutes'
+		RETURNING s.id, s.user_id, u.email, s.list_id, l.name, s.query, s.channel`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var due []savedSearch
+	for rows.Next() {
+		var s savedSearch
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Email, &s.ListID, &s.ListName, &s.Query, &s.Channel); err != nil {
+			return err
+		}
+		due = append(due, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range due {
+		// Someone taken off the list stops hearing about it.
+		role, err := app.Queries.MemberRole(ctx, s.ListID, s.UserID)
+		if err != nil {
+			return err
+		}
+		if role < model.Viewer {
+			continue
+		}
+		added, err := app.refreshMatches(ctx, s)
+		if err != nil {
+			return err
+		}
+		if len(added) == 0 {
+			continue
+		}
+		if err := app.sendSearchAlert(ctx, s, added); err != nil {
+			log.Printf("search alert %d to user %d failed: %v", s.ID, s.UserID, err)
+		}
+	}
+	return nil
+}
+
+func (app *Application) sendSearchAlert(ctx context.Context, s savedSearch, todos []model.Todo) error {
+	listURL := fmt.Sprintf("%s/lists/%d", app.Config.BaseURL, s.ListID)
+	if s.Channel == alertByPush {
+		titles := make([]string, len(todos))
+		for i, t := range todos {
+			titles[i] = t.Title
+		}
+		return app.Push.SendTo(ctx, s.UserID, push.Notification{
+			Title: fmt.Sprintf("%s matching “%s” in %s", pluralize(len(todos), "new todo", "new todos"), s.Query, s.ListName),
+			Body:  strings.Join(titles, "\n"),
+			URL:   listURL,
+			Tag:   fmt.Sprintf("search-alert-%d", s.ID),
+		})
+	}
+	subject := fmt.Sprintf("New todos matching “%s”", s.Query)
+	return app.sendEmail(ctx, s.Email, subject, "search-alert", searchAlert{
+		Query:    s.Query,
+		ListName: s.ListName,
+		ListURL:  listURL,
+		BaseURL:  app.Config.BaseURL,
+		Todos:    todos,
+	})
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/announce"
+)
+
+// announcementTime is the format of datetime-local inputs.
+const announcementTime = "2006-01-02T15:04"
+
+type announcementsPage struct {
+	Page
+	Announcements []announce.Announcement
+	Kinds         []string
+	Error         string
+}
+
+func (app *Application) renderAnnouncements(w http.ResponseWriter, r *http.Request, fragment bool, message string) {
+	list, err := app.Announcements.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := announcementsPage{Page: app.page(r), Announcements: list, Kinds: announce.Kinds, Error: message}
+	if fragment {
+		app.Templates.ExecuteTemplate(w, "announcements-admin", data)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "admin-announcements.html", data)
+}
+
+func (app *Application) adminAnnouncements(w http.ResponseWriter, r *http.Request) {
+	app.renderAnnouncements(w, r, false, "")
+}
+
+// createAnnouncement publishes a banner. The times come from
+// datetime-local inputs, in the admin's timezone.
+func (app *Application) createAnnouncement(w http.ResponseWriter, r *http.Request) {
+	a := announce.Announcement{
+		Message: strings.TrimSpace(r.FormValue("message")),
+		Kind:    r.FormValue("kind"),
+	}
+	if a.Message == "" {
+		app.renderAnnouncements(w, r, true, "Write a message.")
+		return
+	}
+	if a.Kind != announce.Warning {
+		a.Kind = announce.Info
+	}
+	loc, err := time.LoadLocation(r.FormValue("timezone"))
+	if err != nil {
+		loc = time.UTC
+	}
+	for _, f := range []struct {
+		name string
+		dst  *time.Time
+	}{{"starts_at", &a.StartsAt}, {"ends_at", &a.EndsAt}} {
+		v := r.FormValue(f.name)
+		if v == "" {
+			continue
+		}
+		if *f.dst, err = time.ParseInLocation(announcementTime, v, loc); err != nil {
+			app.renderAnnouncements(w, r, true, "Enter the times as dates and times.")
+			return
+		}
+	}
+	start := a.StartsAt
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if !a.EndsAt.IsZero() && !a.EndsAt.After(start) {
+		app.renderAnnouncements(w, r, true, "The end has to be after the start.")
+		return
+	}
+
+	if _, err := app.Announcements.Create(r.Context(), a); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderAnnouncements(w, r, true, "")
+}
+
+func (app *Application) deleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if err := app.Announcements.Delete(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderAnnouncements(w, r, true, "")
+}
+
+// dismissAnnouncement hides a banner from the current user on every
+// device. The banner swaps itself out with the empty response.
+func (app *Application) dismissAnnouncement(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if err := app.Announcements.Dismiss(r.Context(), id, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
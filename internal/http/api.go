@@ -0,0 +1,199 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func jsonError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiListTodos lists the caller's todos. Called with an API token, each
+// comes with the token's metadata, and ?metadata.<key>=<value> finds the
+// ones whose metadata holds value, such as the todo synced with an issue.
+func (app *Application) apiListTodos(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	token := apiToken(r)
+	key, value, filtered, err := metadataFilter(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filtered && token == nil {
+		jsonError(w, http.StatusForbidden, "Metadata is kept per API token; send one in the Authorization header")
+		return
+	}
+
+	var todos []model.Todo
+	if filtered {
+		todos, err = app.Queries.FindUserTodos(r.Context(), user.ID, metadataNamespace(token), key, value)
+	} else {
+		todos, err = app.Queries.ListUserTodos(r.Context(), user.ID)
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if todos == nil {
+		todos = []model.Todo{}
+	}
+	if token == nil {
+		writeJSON(w, http.StatusOK, todos)
+		return
+	}
+
+	withMetadata, err := app.withMetadata(r, token, todos)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, withMetadata)
+}
+
+func (app *Application) apiCreateTodo(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ListID          int    `json:"list_id"`
+		Title           string `json:"title"`
+		EstimateMinutes int    `json:"estimate_minutes"`
+		DueDate         string `json:"due_date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if input.Title == "" {
+		jsonError(w, http.StatusBadRequest, "Title required")
+		return
+	}
+
+	estimate, due, err := parseEffort(strconv.Itoa(input.EstimateMinutes), input.DueDate)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Without a list_id the todo goes into the caller's default list.
+	user, _ := currentUser(r)
+	if input.ListID == 0 {
+		if input.ListID, err = app.defaultList(r.Context(), user.ID); err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	role, err := app.roleFor(r.Context(), user, input.ListID)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if role < model.Editor {
+		app.forbidden(w, r)
+		return
+	}
+
+	todo := model.Todo{ListID: input.ListID, Title: input.Title, EstimateMinutes: estimate, DueDate: due}
+	todo.ID, err = app.insertTodo(r.Context(), r.Header.Get("Idempotency-Key"), todo.ListID, todo.Title, todo.EstimateMinutes, todo.DueDate)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	app.forgetList(r.Context(), todo.ListID)
+
+	writeJSON(w, http.StatusCreated, todo)
+}
+
+func (app *Application) apiToggleTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !todo.Completed && todo.Blocked && r.URL.Query().Get("override") != "true" {
+		blockers, err := app.openBlockers(r.Context(), id)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":    "Todo is blocked by open todos; pass ?override=true to complete it anyway",
+			"blockers": blockers,
+		})
+		return
+	}
+
+	// As on the page, completing a todo that needs approval asks an owner
+	// (202 Accepted), toggling it again withdraws the request, and an
+	// owner completing it approves a waiting request.
+	user, _ := currentUser(r)
+	if needsApproval(r, todo) || todo.AwaitingApproval {
+		status := http.StatusOK
+		if todo.AwaitingApproval && !needsApproval(r, todo) {
+			err = app.decideApproval(r.Context(), user, todo, true, 0, "")
+		} else {
+			if !todo.AwaitingApproval {
+				status = http.StatusAccepted
+			}
+			err = app.requestApproval(r.Context(), user, todo)
+		}
+		if err == nil {
+			todo, err = app.Queries.GetTodo(r.Context(), id)
+		}
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, status, todo)
+		return
+	}
+
+	if todo, err = app.Queries.ToggleTodo(r.Context(), id); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, todo)
+}
+
+func (app *Application) apiDeleteTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+
+	deleted, err := app.Queries.DeleteTodo(r.Context(), id)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
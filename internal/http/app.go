@@ -0,0 +1,691 @@
+// Package http is the web app: handlers, middleware, templates and routes.
+// New wires an Application up from its config and database, Handler
+// returns its routes and Schedule registers its background jobs; cmd/web
+// only runs the server around them.
+package http
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/announce"
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/captcha"
+	"github.com/Trailblazors/htmx-go-postgres/internal/changelog"
+	"github.com/Trailblazors/htmx-go-postgres/internal/clock"
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	"github.com/Trailblazors/htmx-go-postgres/internal/connector"
+	"github.com/Trailblazors/htmx-go-postgres/internal/errreport"
+	"github.com/Trailblazors/htmx-go-postgres/internal/flags"
+	"github.com/Trailblazors/htmx-go-postgres/internal/geocode"
+	"github.com/Trailblazors/htmx-go-postgres/internal/linkpreview"
+	"github.com/Trailblazors/htmx-go-postgres/internal/llm"
+	"github.com/Trailblazors/htmx-go-postgres/internal/mail"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/metrics"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/notify"
+	"github.com/Trailblazors/htmx-go-postgres/internal/oauth"
+	"github.com/Trailblazors/htmx-go-postgres/internal/presence"
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+	"github.com/Trailblazors/htmx-go-postgres/internal/ratelimit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/replay"
+	"github.com/Trailblazors/htmx-go-postgres/internal/secretbox"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+	"github.com/Trailblazors/htmx-go-postgres/internal/transcribe"
+	"github.com/Trailblazors/htmx-go-postgres/internal/usage"
+	"github.com/Trailblazors/htmx-go-postgres/internal/worker"
+)
+
+type Application struct {
+	Config        config.Config
+	DB            *sql.DB
+	Queries       *store.Queries
+	Templates     *Templates
+	Assets        *assets.Pipeline
+	Flags         *flags.Store
+	Maintenance   *maintenance.Store
+	Errors        errreport.Reporter
+	Limiter       *ratelimit.Limiter
+	TrigramSearch bool
+	Emails        EmailTemplates
+	Mailer        mail.Mailer
+	Push          *push.Service
+	ServiceWorker []byte
+	Usage         *usage.Recorder
+	Metrics       *metrics.Recorder
+	Sessions      *session.Store
+	Audit         *audit.Log
+	Ready         *readiness
+	Outage        *outage
+	Presence      *presence.Registry
+	// Notifications wakes event streams when their list changes.
+	Notifications *notify.Listener
+	Previews      *linkpreview.Fetcher
+	// Captcha is nil unless CAPTCHA_PROVIDER is set.
+	Captcha *captcha.Provider
+	// Geocoder is nil unless GEOCODER is set.
+	Geocoder geocode.Provider
+	// Transcriber is nil unless TRANSCRIBER is set.
+	Transcriber transcribe.Transcriber
+	// Assistant is nil unless LLM_PROVIDER is set.
+	Assistant llm.Provider
+	// OAuth are the sign-in providers with a client configured.
+	OAuth         []*oauth.Provider
+	Announcements *announce.Store
+	Changelog     *changelog.Store
+	Tokens        *apitoken.Store
+	Connectors    *connector.Store
+	// Secrets is nil unless ENCRYPTION_KEY is set, and with it scheduled
+	// exports, whose credentials it seals. See sealedColumns.
+	Secrets *secretbox.Box
+	// Clock is what date logic and the ages of todos take the time from;
+	// serve -freeze-time swaps it for a frozen one with SetClock.
+	Clock clock.Clock
+	Cache *cache.Cache
+	// Replays are recent requests kept for /admin/requests, which serves
+	// them again through routes.
+	Replays *replay.Buffer
+	routes  http.Handler
+
+	// accessLog is the access log's settings and running the config
+	// Reload last applied, both changed by Reload while serving.
+	accessLog atomic.Pointer[config.AccessLog]
+	reloadMu  sync.Mutex
+	running   config.Config
+}
+
+// Page is the data passed to full-page templates.
+type Page struct {
+	Flags       map[string]bool
+	Maintenance maintenance.Mode
+	User        *model.User
+	CSRFToken   string
+	// Impersonation is set while an admin is acting as User.
+	Impersonation *impersonation
+	// Announcements are the banners showing that User hasn't dismissed.
+	Announcements []announce.Announcement
+	// ChangelogUnread is set when a release has come out since User last
+	// read the changelog.
+	ChangelogUnread bool
+	// Demo is set when the app runs as a public demo.
+	Demo bool
+	// Outage is set on a cached copy of a page served while the database
+	// is down.
+	Outage bool
+}
+
+// New sets up the app against db, which store.Migrate has already brought
+// up to date: it creates the tables of the internal packages, builds the
+// assets and parses the templates.
+func New(ctx context.Context, cfg config.Config, db *sql.DB) (*Application, error) {
+	// Report errors to Sentry when a DSN is configured, otherwise just log them
+	var reporter errreport.Reporter = errreport.Log{}
+	if cfg.SentryDSN != "" {
+		sentryReporter, err := errreport.NewSentry(cfg.SentryDSN, cfg.SentryEnvironment)
+		if err != nil {
+			return nil, fmt.Errorf("configure Sentry: %w", err)
+		}
+		reporter = sentryReporter
+	}
+
+	trigramSearch, err := store.TrigramSearch(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	flagStore := flags.NewStore(db)
+	flagStore.SetOverrides(cfg.FeatureFlags)
+	if err := flagStore.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create flags table: %w", err)
+	}
+
+	maintenanceStore := maintenance.NewStore(db, cfg.MaintenanceMode)
+	if err := maintenanceStore.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create settings table: %w", err)
+	}
+
+	// Web push; VAPID keys come from the environment or are generated and
+	// stored in the settings table created above
+	pushService, err := push.New(ctx, db, cfg.Push.Subject, cfg.Push.PublicKey, cfg.Push.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("set up web push: %w", err)
+	}
+
+	// Request counts per visitor and route, flushed to Postgres every minute
+	usageRecorder := usage.NewRecorder(db)
+	if err := usageRecorder.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create usage table: %w", err)
+	}
+
+	// Request counts, latencies and the job backlog for the status page,
+	// sampled every minute
+	metricsRecorder := metrics.New(db, statusInterval)
+	if err := metricsRecorder.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create status samples table: %w", err)
+	}
+
+	// ADMIN_EMAILS decides who is an admin; it's applied to existing accounts
+	// on every boot and to new ones at signup
+	if _, err := db.ExecContext(ctx, "UPDATE users SET is_admin = COALESCE(lower(email) = ANY($1), FALSE)", pq.Array(cfg.AdminEmails)); err != nil {
+		return nil, fmt.Errorf("apply ADMIN_EMAILS: %w", err)
+	}
+
+	sessionStore := session.NewStore(db, cfg.SessionIdleTimeout, cfg.SessionMaxLifetime)
+	if err := sessionStore.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create sessions table: %w", err)
+	}
+
+	// Security events for settings and the admin area
+	auditLog := audit.New(db)
+	if err := auditLog.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create audit table: %w", err)
+	}
+
+	// Banners admins publish, and who has dismissed them
+	announcements := announce.New(db)
+	if err := announcements.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create announcements tables: %w", err)
+	}
+
+	// Release notes for /changelog, and when each user last read them
+	releases := changelog.New(db)
+	if err := releases.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create changelog tables: %w", err)
+	}
+
+	// Personal access tokens for the JSON API
+	tokens := apitoken.New(db)
+	if err := tokens.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create API tokens table: %w", err)
+	}
+
+	// Sealing for the credentials and secrets the app keeps, with the keys
+	// being rotated out
+	var secrets *secretbox.Box
+	if cfg.EncryptionKey != nil {
+		if secrets, err = secretbox.New(cfg.EncryptionKey, cfg.OldEncryptionKeys...); err != nil {
+			return nil, err
+		}
+	}
+
+	// Lists synced with trackers like GitHub Issues, their credentials and
+	// webhook secrets sealed when there's a key
+	connectors := connector.New(db, secrets)
+	if err := connectors.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create sync tables: %w", err)
+	}
+
+	// OpenGraph previews of links in todo descriptions, cached in Postgres
+	previews := linkpreview.New(db)
+	if err := previews.Migrate(ctx); err != nil {
+		return nil, fmt.Errorf("create link previews table: %w", err)
+	}
+
+	// A CAPTCHA for logins and signups from IPs that keep failing
+	var captchaProvider *captcha.Provider
+	if cfg.Captcha.Provider != "" {
+		if captchaProvider, err = captcha.New(cfg.Captcha.Provider, cfg.Captcha.SiteKey, cfg.Captcha.Secret); err != nil {
+			return nil, err
+		}
+	}
+
+	// Coordinates for the places typed into todos
+	var geocoder geocode.Provider
+	if cfg.Geocoder != "" {
+		if geocoder, err = geocode.New(cfg.Geocoder, cfg.GeocoderURL, "htmx-go-postgres ("+cfg.BaseURL+")"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Text for voice notes
+	var transcriber transcribe.Transcriber
+	if t := cfg.Transcriber; t.Provider != "" {
+		if transcriber, err = transcribe.New(t.Provider, t.URL, t.APIKey, t.Model); err != nil {
+			return nil, err
+		}
+	}
+
+	// Suggestions for breaking todos down
+	var assistant llm.Provider
+	if l := cfg.LLM; l.Provider != "" {
+		if assistant, err = llm.New(l.Provider, l.URL, l.APIKey, l.Model); err != nil {
+			return nil, err
+		}
+	}
+
+	// Sign-in with Google and GitHub, for those with an app registered
+	var oauthProviders []*oauth.Provider
+	for _, c := range []struct {
+		name   string
+		client config.OAuthClient
+	}{{"google", cfg.Google}, {"github", cfg.GitHub}} {
+		if c.client.ID == "" {
+			continue
+		}
+		p, err := oauth.New(c.name, c.client.ID, c.client.Secret)
+		if err != nil {
+			return nil, err
+		}
+		oauthProviders = append(oauthProviders, p)
+	}
+
+	// Rate limits per route group and plan, counted in memory or Postgres
+	var limitStore ratelimit.Store = ratelimit.NewMemory()
+	if cfg.RateLimitStore == "postgres" {
+		pgLimits := ratelimit.NewPostgres(db)
+		if err := pgLimits.Migrate(ctx); err != nil {
+			return nil, fmt.Errorf("create rate limit table: %w", err)
+		}
+		limitStore = pgLimits
+	}
+
+	// Cache hot data in memory or Redis
+	var cacheStore cache.Store = cache.NewMemory(cfg.CacheSize)
+	if cfg.CacheStore == "redis" {
+		if cacheStore, err = cache.NewRedis(cfg.RedisURL); err != nil {
+			return nil, err
+		}
+	}
+
+	// Bundle and fingerprint scripts and styles
+	staticAssets, err := assets.Build(bundles)
+	if err != nil {
+		return nil, fmt.Errorf("build assets: %w", err)
+	}
+
+	// Parse templates
+	tmpl := parseTemplates("templates", staticAssets)
+	emails := parseEmailTemplates("templates/email")
+	sw, err := buildServiceWorker("templates/sw.js", appShell(staticAssets))
+	if err != nil {
+		return nil, fmt.Errorf("build service worker: %w", err)
+	}
+
+	// Send email over SMTP when configured, otherwise log it
+	var mailer mail.Mailer = mail.Log{}
+	if cfg.SMTP.Host != "" {
+		mailer = mail.SMTP{
+			Addr:     cfg.SMTP.Host + ":" + cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.MailFrom,
+		}
+	}
+
+	app := &Application{
+		Config:        cfg,
+		DB:            db,
+		Queries:       store.NewQueries(db, clock.System{}),
+		Templates:     tmpl,
+		Assets:        staticAssets,
+		Flags:         flagStore,
+		Maintenance:   maintenanceStore,
+		Errors:        reporter,
+		Limiter:       ratelimit.New(cfg.RateLimits, limitStore),
+		TrigramSearch: trigramSearch,
+		Emails:        emails,
+		Mailer:        mailer,
+		Push:          pushService,
+		ServiceWorker: sw,
+		Usage:         usageRecorder,
+		Metrics:       metricsRecorder,
+		Sessions:      sessionStore,
+		Audit:         auditLog,
+		Ready:         &readiness{},
+		Outage:        newOutage(),
+		Presence:      presence.New(presenceTTL),
+		Notifications: notify.New(listenURL(cfg), "todo_events"),
+		Previews:      previews,
+		Captcha:       captchaProvider,
+		Geocoder:      geocoder,
+		Transcriber:   transcriber,
+		Assistant:     assistant,
+		OAuth:         oauthProviders,
+		Announcements: announcements,
+		Changelog:     releases,
+		Tokens:        tokens,
+		Connectors:    connectors,
+		Secrets:       secrets,
+		Clock:         clock.System{},
+		Cache:         cache.New(cacheStore, cfg.CacheTTL),
+		Replays:       replay.New(cfg.Replay.Size),
+		running:       cfg,
+	}
+	app.accessLog.Store(&cfg.AccessLog)
+	return app, nil
+}
+
+// SetClock runs the app's date logic, and the queries that age todos, by
+// c's time.
+func (app *Application) SetClock(c clock.Clock) {
+	app.Clock = c
+	app.Queries = store.NewQueries(app.DB, c)
+}
+
+// Handler returns the app's routes.
+func (app *Application) Handler() http.Handler {
+	r := chi.NewRouter()
+	r.Use(realIP(app.Config.HTTP.TrustedProxies, app.Config.Socket != ""))
+	r.Use(headAsGet)
+	r.Use(scoped)
+	r.Use(accessLog(&app.accessLog))
+	r.Use(visitor)
+	r.Use(app.databaseOutage)
+	r.Use(app.recordRequests)
+	r.Use(app.recoverer)
+	r.Use(app.loadSession)
+	r.Use(app.csrfProtect)
+	r.Use(app.auditImpersonation)
+	r.Use(app.trackUsage)
+	r.Use(app.maintenanceMode)
+	r.NotFound(app.notFound)
+	r.MethodNotAllowed(app.methodNotAllowed(r))
+
+	// Serve static files
+	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	r.Handle(assets.Prefix+"*", app.Assets)
+	r.Get("/sw.js", app.serviceWorker)
+	r.Get("/manifest.webmanifest", manifestHandler)
+
+	r.Get("/health", healthHandler)
+	r.Get("/readyz", app.readyz)
+	r.With(app.adminOnly).Post("/readyz", app.setReadiness)
+
+	// Trackers a list is synced with report changes here; deliveries are
+	// checked against the connection's secret rather than a login.
+	r.With(app.rateLimit("api")).Post("/sync/{id}/webhook", app.syncWebhook)
+
+	// Routes
+	r.Group(func(r chi.Router) {
+		r.Use(app.rateLimit("ui"))
+		r.Get("/login", app.loginPage)
+		r.Post("/login", app.login)
+		r.Get("/signup", app.signupPage)
+		r.With(app.notInDemo).Post("/signup", app.signup)
+		r.Post("/demo", app.startDemo)
+		r.Post("/logout", app.logout)
+		r.Get("/verify", app.verifyEmail)
+		r.With(app.notInDemo).Post("/verify/resend", app.resendVerification)
+		r.Post("/impersonation/stop", app.stopImpersonation)
+		r.Group(func(r chi.Router) {
+			r.Use(app.notImpersonating)
+			r.Use(app.notInDemo)
+			r.Get("/auth/link", app.linkConfirmPage)
+			r.Post("/auth/link", app.confirmLink)
+			r.Post("/auth/link/cancel", app.cancelLink)
+			r.Get("/auth/{provider}", app.startOAuth)
+			r.Get("/auth/{provider}/callback", app.oauthCallback)
+		})
+		r.Get("/digest/confirm", app.confirmDigest)
+		r.Get("/digest/unsubscribe", app.unsubscribeDigest)
+		r.Get("/exports/{token}", app.serveDownload)
+		r.Get("/status", app.statusHandler)
+		r.Route("/status/embed", func(r chi.Router) {
+			r.Use(statusEmbed)
+			r.Get("/", app.statusEmbedHandler)
+		})
+		r.Get("/push/key", app.pushKey)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(app.rateLimit("ui"))
+		r.Use(app.requireLogin)
+		r.Get("/", app.homeHandler)
+		r.Get("/stats", app.statsHandler)
+		r.Get("/stats/aging", app.getAgingReport)
+		r.Get("/settings", app.settingsHandler)
+		r.Get("/support", app.supportHandler)
+		r.With(app.notImpersonating, app.rateLimit("support")).Post("/support", app.createSupportTicket)
+		r.With(app.notImpersonating).Delete("/settings/sessions/{id}", app.revokeSession)
+		r.With(app.notImpersonating, app.notInDemo).Post("/settings/password", app.changePassword)
+		r.With(app.notImpersonating).Delete("/settings/identities/{provider}", app.unlinkIdentity)
+		r.With(app.notImpersonating).Post("/settings/tokens", app.createToken)
+		r.With(app.notImpersonating).Delete("/settings/tokens/{id}", app.revokeToken)
+		r.Post("/settings/preferences", app.savePreferences)
+		r.Post("/settings/list-view", app.saveListView)
+		r.Delete("/settings/alerts/{id}", app.deleteSearchAlert)
+		r.With(app.notImpersonating, app.notInDemo).Post("/settings/exports", app.createExportSchedule)
+		r.With(app.notImpersonating).Delete("/settings/exports/{id}", app.deleteExportSchedule)
+		r.With(app.notImpersonating, app.notInDemo).Post("/settings/exports/{id}/run", app.runExportNow)
+		r.With(app.notImpersonating, app.notInDemo).Post("/settings/downloads", app.requestDownload)
+		r.Get("/settings/downloads/{id}", app.downloadStatus)
+		r.Get("/settings/downloads/{id}/file", app.serveOwnDownload)
+		r.Post("/announcements/{id}/dismiss", app.dismissAnnouncement)
+		r.Get("/changelog", app.changelogHandler)
+		r.Get("/my-day", app.myDayHandler)
+		r.Get("/assigned", app.assignedToMe)
+		r.Get("/team/workload", app.workloadHandler)
+		r.Patch("/team/workload", app.reassignWorkload)
+		r.Get("/nearby", app.nearbyHandler)
+		r.Post("/nearby", app.nearbyTodos)
+		r.With(app.todoRole, app.requireRole(model.Viewer)).Post("/my-day/{id}", app.addToMyDay)
+		r.With(app.todoRole, app.requireRole(model.Viewer)).Delete("/my-day/{id}", app.removeFromMyDay)
+		r.Get("/digest", app.digestHandler)
+		r.With(app.notInDemo).Post("/digest", app.subscribeDigest)
+		r.With(app.notInDemo).Post("/push/subscriptions", app.subscribePush)
+		r.Delete("/push/subscriptions", app.unsubscribePush)
+		r.Get("/todos/effort", app.effortHandler)
+		r.Get("/mentions", app.mentionsHandler)
+		r.Post("/lists", app.newList)
+		r.Post("/undo", app.undo)
+		r.Get("/onboarding", app.getOnboarding)
+		r.Post("/onboarding/steps/{step}/skip", app.skipOnboardingStep)
+		r.Post("/onboarding/dismiss", app.dismissOnboarding)
+		r.Post("/redo", app.redo)
+
+		r.With(app.listRole, app.requireRole(model.Viewer)).Get("/lists/{listID}.pdf", app.listPDF)
+		r.Route("/lists/{listID}", func(r chi.Router) {
+			r.Use(app.listRole)
+			r.Use(app.requireRole(model.Viewer))
+			r.Get("/", app.listHandler)
+			r.Get("/todos", app.getTodos)
+			r.Get("/search", app.searchTodos)
+			r.With(app.notInDemo).Post("/alerts", app.createSearchAlert)
+			r.Get("/archived", app.getArchivedTodos)
+			r.Get("/selection", app.selectRange)
+			r.Get("/presence", app.presenceHandler)
+			r.Post("/presence", app.heartbeat)
+			r.Get("/presence/stream", app.presenceStream)
+			r.Get("/members", app.membersHandler)
+			r.Get("/print", app.printList)
+			r.Get("/export.md", app.exportMarkdown)
+			r.Group(func(r chi.Router) {
+				r.Use(app.invalidateCache)
+				r.Post("/duplicate", app.duplicateListHandler)
+				r.With(app.requireRole(model.Editor)).Post("/move", app.bulkMoveTodos)
+				r.With(app.requireRole(model.Editor)).Post("/todos/archive", app.archiveSelected)
+				r.With(app.requireRole(model.Editor)).Post("/todos", app.createTodo)
+				r.With(app.requireRole(model.Editor)).Get("/due-suggestion", app.suggestDueDate)
+				r.With(app.requireRole(model.Owner), app.requireVerified, app.notInDemo).Post("/members", app.shareList)
+				r.With(app.requireRole(model.Owner)).Delete("/members/{userID}", app.removeMember)
+				r.With(app.requireRole(model.Owner)).Post("/archive", app.setListArchived(true))
+				r.With(app.requireRole(model.Owner)).Post("/unarchive", app.setListArchived(false))
+				r.With(app.requireRole(model.Owner)).Post("/retention", app.setRetention)
+				r.With(app.requireRole(model.Owner)).Post("/approvals", app.setApprovals)
+				r.With(app.requireRole(model.Owner)).Post("/aging", app.setAging)
+				r.With(app.requireRole(model.Owner)).Post("/statuses", app.createStatus)
+				r.With(app.requireRole(model.Owner)).Delete("/statuses/{statusID}", app.deleteStatus)
+				r.With(app.requireRole(model.Owner)).Post("/fields", app.createField)
+				r.With(app.requireRole(model.Owner)).Delete("/fields/{fieldID}", app.deleteField)
+				r.With(app.requireRole(model.Owner), app.notInDemo).Post("/connections", app.createConnection)
+				r.With(app.requireRole(model.Owner)).Post("/connections/{connectionID}/sync", app.syncConnectionNow)
+				r.With(app.requireRole(model.Owner)).Delete("/connections/{connectionID}", app.deleteConnection)
+			})
+		})
+
+		r.Route("/attachments/{id}", func(r chi.Router) {
+			r.Use(app.attachmentRole)
+			r.Use(app.requireRole(model.Viewer))
+			r.Get("/", app.downloadAttachment)
+			r.With(app.requireRole(model.Editor)).Delete("/", app.deleteAttachment)
+		})
+		r.With(app.attachmentRole, app.requireRole(model.Viewer)).Get("/thumbnails/{id}", app.getThumbnail)
+
+		r.Route("/todos/{id}", func(r chi.Router) {
+			r.Use(app.todoRole)
+			r.Use(app.requireRole(model.Viewer))
+			r.Get("/", app.todoPermalink)
+			r.Get("/timer", app.getTimer)
+			r.Get("/dependencies", app.getDependencies)
+			r.Get("/details", app.getDetails)
+			r.Get("/history", app.getHistory)
+			r.Get("/previews", app.getPreviews)
+			r.Get("/assignee", app.getAssignee)
+			r.Get("/approval", app.getApproval)
+			r.Get("/status", app.getStatusPicker)
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireRole(model.Editor))
+				r.Use(app.invalidateCache)
+				r.Get("/edit", app.getEditForm)
+				r.Put("/", app.updateTodo)
+				r.Get("/delete", app.getDeleteConfirm)
+				r.Delete("/", app.deleteTodo)
+				r.Put("/toggle", app.toggleTodo)
+				r.Put("/assignee", app.assignTodo)
+				r.Put("/status", app.setTodoStatus)
+				r.With(app.requireRole(model.Owner)).Post("/approve", app.approveTodo)
+				r.With(app.requireRole(model.Owner)).Post("/reject", app.rejectTodo)
+				r.Post("/restore", app.restoreTodo)
+				r.Post("/duplicate", app.duplicateTodoHandler)
+				r.Post("/history/{revisionID}/revert", app.revertTodo)
+				r.Post("/breakdown", app.breakDownTodo)
+				r.Post("/steps", app.addSteps)
+				r.Get("/move", app.getMoveForm)
+				r.Post("/move", app.moveTodo)
+				r.Post("/timer/start", app.startTimer)
+				r.Post("/timer/stop", app.stopTimer)
+				r.Post("/dependencies", app.createDependency)
+				r.With(app.notInDemo).Post("/attachments", app.uploadAttachment)
+				r.Delete("/dependencies/{blockerID}", app.deleteDependency)
+			})
+		})
+	})
+
+	// JSON API, authenticated with an API token or the session cookie
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(cors(app.Config.CORS))
+		r.Use(app.loadToken)
+		r.Use(app.rateLimit("api"))
+		r.Use(app.requireLogin)
+		r.Get("/me", app.apiMe)
+		r.Get("/lists", app.apiListLists)
+		r.With(app.listRole, app.requireRole(model.Viewer)).Get("/lists/{listID}/events", app.apiListEvents)
+		r.With(app.listRole, app.requireRole(model.Viewer)).Get("/lists/{listID}/statuses", app.apiListStatuses)
+		r.Get("/todos", app.apiListTodos)
+		r.Post("/todos", app.apiCreateTodo)
+		r.With(app.todoRole, app.requireRole(model.Editor), app.invalidateCache).Put("/todos/{id}/toggle", app.apiToggleTodo)
+		r.With(app.todoRole, app.requireRole(model.Editor), app.invalidateCache).Post("/todos/{id}/complete", app.apiCompleteTodo)
+		r.With(app.todoRole, app.requireRole(model.Editor), app.invalidateCache).Put("/todos/{id}/status", app.apiSetTodoStatus)
+		r.Get("/triggers/new-todos", app.newTodosTrigger)
+		r.Get("/triggers/completed-todos", app.completedTodosTrigger)
+		r.With(app.todoRole, app.requireRole(model.Editor), app.invalidateCache).Delete("/todos/{id}", app.apiDeleteTodo)
+		r.With(requireToken, app.todoRole, app.requireRole(model.Viewer)).Get("/todos/{id}/metadata", app.apiGetMetadata)
+		r.With(requireToken, app.todoRole, app.requireRole(model.Editor)).Put("/todos/{id}/metadata/{key}", app.apiSetMetadata)
+		r.With(requireToken, app.todoRole, app.requireRole(model.Editor)).Delete("/todos/{id}/metadata/{key}", app.apiDeleteMetadata)
+	})
+
+	// Admin
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(app.adminOnly)
+		r.Get("/", app.adminDashboard)
+		r.Get("/audit", app.adminAudit)
+		r.Get("/flags", app.adminFlags)
+		r.Post("/flags/{name}", app.updateFlag)
+		r.Get("/maintenance", app.adminMaintenance)
+		r.Post("/maintenance", app.updateMaintenance)
+		r.Post("/impersonate", app.startImpersonation)
+		r.Get("/announcements", app.adminAnnouncements)
+		r.Post("/announcements", app.createAnnouncement)
+		r.Delete("/announcements/{id}", app.deleteAnnouncement)
+		r.Get("/changelog", app.adminChangelog)
+		r.Post("/changelog", app.createRelease)
+		r.Get("/changelog/{id}", app.editRelease)
+		r.Put("/changelog/{id}", app.updateRelease)
+		r.Delete("/changelog/{id}", app.deleteRelease)
+		r.Get("/requests", app.adminRequests)
+		r.Get("/requests/{id}", app.adminRequest)
+		r.Post("/requests/{id}/replay", app.replayRequest)
+		r.Get("/performance", app.adminPerformance)
+	})
+
+	app.routes = r
+	return r
+}
+
+// Schedule registers the app's background jobs with s.
+func (app *Application) Schedule(s *worker.Scheduler) {
+	s.Go(func(ctx context.Context) { app.Usage.Run(ctx, time.Minute) })
+	s.Go(func(ctx context.Context) { app.Presence.Run(ctx, 5*time.Second) })
+	s.Go(app.Notifications.Run)
+	s.Daily("clear-my-day", 5*time.Minute, app.clearMyDay)
+	s.Daily("purge-idempotency-keys", 30*time.Minute, app.purgeIdempotencyKeys)
+	s.Daily("archive-completed", 45*time.Minute, app.archiveCompleted)
+	s.Daily("purge-link-previews", 50*time.Minute, app.Previews.Purge)
+	s.Daily("purge-todo-events", 52*time.Minute, app.purgeTodoEvents)
+	s.Daily("purge-outbox", 53*time.Minute, app.purgeOutbox)
+	s.Daily("purge-export-downloads", 54*time.Minute, app.purgeDownloads)
+	s.Daily("purge-status-samples", 56*time.Minute, app.purgeStatusSamples)
+	s.Daily(purgeTodosJob, 55*time.Minute, app.purgeArchivedTodos)
+	s.Daily(anonymizeUsersJob, time.Hour, app.anonymizeInactiveAccounts)
+	s.Daily(purgeAuditJob, time.Hour+5*time.Minute, app.purgeAuditEvents)
+	s.Daily("create-partitions", time.Hour+10*time.Minute, app.createPartitions)
+	s.Daily("aggregate-due-patterns", 2*time.Hour, app.aggregateDuePatterns)
+	s.Every("weekly-digest", 15*time.Minute, app.sendDigests)
+	s.Every("due-reminders", 15*time.Minute, app.sendDueReminders)
+	s.Every("search-alerts", 15*time.Minute, app.sendSearchAlerts)
+	s.Every("check-database", 5*time.Second, app.checkDatabase)
+	s.Every("relay-outbox", 2*time.Second, app.relayOutbox)
+	s.Every("purge-sessions", time.Hour, app.Sessions.Cleanup)
+	s.Every("reset-demo", 5*time.Minute, app.resetDemo)
+	s.Every("sync-connections", time.Minute, app.syncConnections)
+	s.Every("push-sync-changes", 30*time.Second, app.pushSyncChanges)
+	s.Every("transcribe-voice-notes", 15*time.Second, app.transcribeVoiceNotes)
+	s.Every("scheduled-exports", time.Minute, app.runScheduledExports)
+	s.Every("generate-exports", 5*time.Second, app.generateExports)
+	s.Every("sample-status", statusInterval, app.sampleStatus)
+}
+
+func (app *Application) page(r *http.Request) Page {
+	user, _ := currentUser(r)
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+	announcements, err := app.Announcements.For(r.Context(), userID)
+	if err != nil {
+		// A page without its banners beats no page.
+		requestLog(r.Context()).Printf("announcements: %v", err)
+	}
+	unread, err := app.Changelog.Unread(r.Context(), userID)
+	if err != nil {
+		requestLog(r.Context()).Printf("changelog: %v", err)
+	}
+	return Page{
+		Flags:           app.Flags.Evaluate(r.Context(), flagSubject(r)),
+		Maintenance:     app.Maintenance.Mode(r.Context()),
+		User:            user,
+		CSRFToken:       csrfToken(r),
+		Impersonation:   impersonationOf(r),
+		Announcements:   announcements,
+		ChangelogUnread: unread,
+		Demo:            app.Config.Demo,
+	}
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK")
+}
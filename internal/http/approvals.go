@@ -0,0 +1,221 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+)
+
+type approvalsForm struct {
+	List    model.List
+	Message string
+}
+
+func (p membersPage) Approvals() approvalsForm {
+	return approvalsForm{List: p.List}
+}
+
+// setApprovals turns the list's approval workflow on or off. Todos keep
+// whether they need approval, and any requests waiting on an owner, while
+// it's off, for when it's turned back on.
+func (app *Application) setApprovals(w http.ResponseWriter, r *http.Request) {
+	a := listAccess(r)
+	_, err := app.DB.ExecContext(r.Context(), "UPDATE lists SET approvals = $2 WHERE id = $1", a.ListID, r.FormValue("approvals") != "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l, err := app.loadList(r.Context(), a)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "approvals-form", approvalsForm{List: l, Message: "Saved."})
+}
+
+// needsApproval reports whether the caller completing todo only asks an
+// owner to approve it.
+func needsApproval(r *http.Request, todo model.Todo) bool {
+	return todo.NeedsApproval && !todo.Completed && listAccess(r).Role < model.Owner
+}
+
+// requestApproval asks the list's owners to approve user completing a
+// todo, as an undoable change. Asking again while they're still deciding
+// withdraws the request.
+func (app *Application) requestApproval(ctx context.Context, user *model.User, todo model.Todo) error {
+	verb := "ask to complete"
+	if todo.AwaitingApproval {
+		verb = "withdraw the request to complete"
+	}
+	return app.undoable(ctx, user.ID, verb, []int{todo.ID}, func(ctx context.Context) ([]int, error) {
+		_, err := app.db(ctx).ExecContext(ctx, `
+			UPDATE todos SET
+				approval_requested_by = CASE WHEN $3 THEN NULL ELSE $2::integer END,
+				approval_requested_at = CASE WHEN $3 THEN NULL ELSE NOW() END
+			WHERE id = $1`,
+			todo.ID, user.ID, todo.AwaitingApproval,
+		)
+		return nil, err
+	})
+}
+
+// decideApproval approves or rejects the request to complete a todo, as
+// an undoable change by owner. Approving completes it, into the done
+// status into or else the list's first. Whoever asked hears about it
+// either way, with the owner's reason when there is one.
+func (app *Application) decideApproval(ctx context.Context, owner *model.User, todo model.Todo, approve bool, into int, reason string) error {
+	verb := "approve"
+	if !approve {
+		verb = "reject"
+	}
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		var requester sql.NullInt64
+		err := app.undoable(ctx, owner.ID, verb, []int{todo.ID}, func(ctx context.Context) ([]int, error) {
+			db := app.db(ctx)
+			err := db.QueryRowContext(ctx, "SELECT approval_requested_by FROM todos WHERE id = $1", todo.ID).Scan(&requester)
+			if err != nil {
+				return nil, err
+			}
+			_, err = db.ExecContext(ctx, `
+				UPDATE todos SET
+					completed = completed OR $2,
+					completed_at = CASE WHEN $2 THEN COALESCE(completed_at, NOW()) ELSE completed_at END,
+					status_id = CASE WHEN $2 AND $3 > 0 THEN $3::integer ELSE status_id END,
+					approval_requested_by = NULL, approval_requested_at = NULL
+				WHERE id = $1`,
+				todo.ID, approve, into,
+			)
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+		if requester.Valid && int(requester.Int64) != owner.ID {
+			return app.notifyRequester(ctx, int(requester.Int64), todo, owner.Email, approve, reason)
+		}
+		return nil
+	})
+}
+
+// approvalPanel is who asked to complete a todo, and when, for the panel
+// under it.
+type approvalPanel struct {
+	Todo        model.Todo
+	RequestedBy string
+	RequestedAt time.Time
+	CanDecide   bool
+}
+
+// getApproval shows the request to complete a todo, with buttons to
+// approve or reject it for owners.
+func (app *Application) getApproval(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	p := approvalPanel{Todo: todo, CanDecide: listAccess(r).Role >= model.Owner}
+	if todo.AwaitingApproval {
+		var at sql.NullTime
+		err := app.DB.QueryRowContext(r.Context(), `
+			SELECT COALESCE(u.email, ''), t.approval_requested_at FROM todos t
+			LEFT JOIN users u ON u.id = t.approval_requested_by
+			WHERE t.id = $1`,
+			todo.ID,
+		).Scan(&p.RequestedBy, &at)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.RequestedAt = at.Time
+	}
+	app.Templates.ExecuteTemplate(w, "approval-panel", p)
+}
+
+// approveTodo and rejectTodo are an owner's answer to a request to
+// complete a todo.
+func (app *Application) approveTodo(w http.ResponseWriter, r *http.Request) {
+	app.decide(w, r, true)
+}
+
+func (app *Application) rejectTodo(w http.ResponseWriter, r *http.Request) {
+	app.decide(w, r, false)
+}
+
+func (app *Application) decide(w http.ResponseWriter, r *http.Request, approve bool) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !todo.AwaitingApproval {
+		http.Error(w, "Nobody is waiting on approval for this todo", http.StatusConflict)
+		return
+	}
+	user, _ := currentUser(r)
+	if err := app.decideApproval(r.Context(), user, todo, approve, 0, strings.TrimSpace(r.FormValue("reason"))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
+
+type approvalEmail struct {
+	Todo      model.Todo
+	ListName  string
+	DecidedBy string
+	Approved  bool
+	Reason    string
+	TodoURL   string
+	BaseURL   string
+}
+
+// notifyRequester tells whoever asked to complete a todo what an owner
+// decided, by push notification and email, through the outbox in ctx's
+// transaction, so they hear about it once the decision commits.
+func (app *Application) notifyRequester(ctx context.Context, requesterID int, todo model.Todo, by string, approved bool, reason string) error {
+	list, err := app.Queries.GetList(ctx, todo.ListID)
+	if err != nil {
+		return err
+	}
+	var email string
+	if err := app.db(ctx).QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", requesterID).Scan(&email); err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("%s approved completing a todo in %s", by, list.Name)
+	subject := "Approved: " + todo.Title
+	if !approved {
+		title = fmt.Sprintf("%s didn't approve completing a todo in %s", by, list.Name)
+		subject = "Not approved: " + todo.Title
+	}
+	body := todo.Title
+	if reason != "" {
+		body += ": " + reason
+	}
+	todoURL := fmt.Sprintf("%s/todos/%d", app.Config.BaseURL, todo.ID)
+	err = app.queuePush(ctx, requesterID, push.Notification{
+		Title: title,
+		Body:  body,
+		URL:   todoURL,
+		Tag:   fmt.Sprintf("approval-%d", todo.ID),
+	})
+	if err != nil {
+		return err
+	}
+	return app.queueEmail(ctx, email, subject, "approval", approvalEmail{
+		Todo:      todo,
+		ListName:  list.Name,
+		DecidedBy: by,
+		Approved:  approved,
+		Reason:    reason,
+		TodoURL:   todoURL,
+		BaseURL:   app.Config.BaseURL,
+	})
+}
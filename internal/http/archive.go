@@ -0,0 +1,170 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// archiveCompleted applies each list's retention policy, archiving todos
+// that were completed more than auto_archive_days ago. Archived todos stay in
+// the database (and in stats) but drop out of the list and search.
+func (app *Application) archiveCompleted(ctx context.Context) error {
+	res, err := app.DB.ExecContext(ctx, `
+		UPDATE todos t SET archived_at = NOW()
+		FROM lists l
+		WHERE l.id = t.list_id AND l.auto_archive_days IS NOT NULL
+		AND t.completed AND t.archived_at IS NULL
+		AND t.completed_at < NOW() - make_interval(days => l.auto_archive_days)`,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("archived %d completed todos", n)
+	}
+	return nil
+}
+
+// setListArchived archives or restores a whole list. Archived lists keep
+// their todos and members but leave the sidebar and the cross-list views.
+func (app *Application) setListArchived(archived bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := listAccess(r).ListID
+		if err := app.Queries.SetListArchived(r.Context(), id, archived); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/lists/%d", id), http.StatusSeeOther)
+	}
+}
+
+// setRetention updates the list's auto-archive policy; a blank value turns
+// it off.
+func (app *Application) setRetention(w http.ResponseWriter, r *http.Request) {
+	a := listAccess(r)
+	var days *int
+	if v := strings.TrimSpace(r.FormValue("auto_archive_days")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Days must be a positive number", http.StatusBadRequest)
+			return
+		}
+		days = &n
+	}
+
+	if err := app.Queries.SetAutoArchiveDays(r.Context(), a.ListID, days); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	l, err := app.loadList(r.Context(), a)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "retention-form", retentionForm{List: l, Message: "Saved."})
+}
+
+type retentionForm struct {
+	List    model.List
+	Message string
+}
+
+type archivedTodos struct {
+	List  model.List
+	Todos []model.Todo
+}
+
+func (app *Application) getArchivedTodos(w http.ResponseWriter, r *http.Request) {
+	data := archivedTodos{}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Todos, err = app.Queries.ListArchivedTodos(r.Context(), data.List.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Todos == nil {
+		data.Todos = []model.Todo{}
+	}
+
+	app.render(w, r, view{Fragment: "archived-todos", Data: data, JSON: data.Todos})
+}
+
+// restoreTodo brings an archived todo back onto its list. It stays
+// completed, so the retention job will archive it again unless it's
+// reopened or the policy changes.
+func (app *Application) restoreTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "restore", []int{id}, func(ctx context.Context) ([]int, error) {
+		return nil, app.Queries.RestoreTodo(ctx, id)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	htmx.Trigger(w, "todosChanged")
+}
+
+// archiveSelected archives the selected todos on a list, done or not, as
+// one change for undo. Rather than reloading the list, the response removes
+// their rows out of band, so the rest of the page keeps its focus and
+// selection.
+func (app *Application) archiveSelected(w http.ResponseWriter, r *http.Request) {
+	ids, ok := selectedTodos(w, r)
+	if !ok {
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "Select some todos to archive", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := currentUser(r)
+	var archived []int
+	err := app.undoable(r.Context(), user.ID, "archive", ids, func(ctx context.Context) ([]int, error) {
+		rows, err := app.db(ctx).QueryContext(ctx, `
+			UPDATE todos SET archived_at = NOW()
+			WHERE list_id = $1 AND id = ANY($2) AND archived_at IS NULL
+			RETURNING id`,
+			listAccess(r).ListID, pq.Array(ids),
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			archived = append(archived, id)
+		}
+		return nil, rows.Err()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	htmx.Trigger(w, "todosChanged")
+	app.Templates.ExecuteTemplate(w, "archived-rows", archived)
+	app.Templates.RenderComponent(w, "toast", toast{Message: "Archived " + pluralize(len(archived), "todo", "todos") + "."})
+}
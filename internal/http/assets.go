@@ -0,0 +1,17 @@
+package http
+
+import "github.com/Trailblazors/htmx-go-postgres/internal/assets"
+
+// bundles are the scripts and styles templates load through {{asset}}.
+// htmx 1.9.10 is vendored in static/vendor and pinned to the integrity
+// hash htmx publishes for it; scripts/vendor.sh fetches and checks a new
+// version. There's no Alpine.js to vendor: what htmx doesn't cover is in
+// the small scripts under static/js. Tailwind is built ahead of time into
+// static/css/tailwind.css by scripts/tailwind.sh, and bundled ahead of any
+// other CSS there so that CSS can override it.
+var bundles = []assets.Bundle{
+	{Name: "htmx.js", Files: []string{"static/vendor/htmx.min.js"}, Integrity: "sha384-D1Kt99CQMDuVetoL1lrYwg5t+9QdHe7NLX/SoJYkXDFfX37iInKRy5xLSi8nO7UC"},
+	{Name: "app.js", Files: []string{"static/js/alerts.js", "static/js/modal.js", "static/js/presence.js", "static/js/undo.js", "static/js/keyboard.js", "static/js/nearby.js", "static/js/workload.js"}},
+	{Name: "pwa.js", Files: []string{"static/js/pwa.js", "static/js/push.js"}},
+	{Name: "app.css", Files: []string{"static/css/tailwind.css", "static/css/*.css"}},
+}
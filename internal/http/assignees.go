@@ -0,0 +1,216 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+type assigneePicker struct {
+	Todo    model.Todo
+	Members []model.Member
+	CanEdit bool
+	// Changed is set once the assignee has just been changed, so the
+	// todo's row is updated to match.
+	Changed bool
+}
+
+// Assigned is the assignee's user ID, or 0 when nobody is.
+func (p assigneePicker) Assigned() int {
+	if p.Todo.AssigneeID == nil {
+		return 0
+	}
+	return *p.Todo.AssigneeID
+}
+
+// getAssignee shows who a todo is assigned to, with a dropdown of the
+// list's members to change it for editors.
+func (app *Application) getAssignee(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	app.renderAssignee(w, r, todo, false)
+}
+
+func (app *Application) renderAssignee(w http.ResponseWriter, r *http.Request, todo model.Todo, changed bool) {
+	members, err := app.Queries.ListMembers(r.Context(), todo.ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "assignee-picker", assigneePicker{
+		Todo:    todo,
+		Members: members,
+		CanEdit: listAccess(r).Role >= model.Editor,
+		Changed: changed,
+	})
+}
+
+// errNotAssignable is why an assignee_id is turned down.
+var errNotAssignable = errors.New("Only people the list is shared with can be assigned its todos.")
+
+// assigneeFrom reads the assignee_id posted for a todo on listID: one of
+// the list's members, or nil for nobody.
+func (app *Application) assigneeFrom(r *http.Request, listID int) (*int, error) {
+	v := r.FormValue("assignee_id")
+	if v == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, errNotAssignable
+	}
+	role, err := app.Queries.MemberRole(r.Context(), listID, id)
+	if err != nil {
+		return nil, err
+	}
+	if role < model.Viewer {
+		return nil, errNotAssignable
+	}
+	return &id, nil
+}
+
+// reassign gives a todo to assignee, or to nobody, as an undoable change
+// by user, and returns it as it is now. The new assignee hears about it
+// unless they assigned it to themselves or already had it.
+func (app *Application) reassign(ctx context.Context, user *model.User, todo model.Todo, assignee *int) (model.Todo, error) {
+	previous := todo.AssigneeID
+	updated := todo
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		err := app.undoable(ctx, user.ID, "assign", []int{todo.ID}, func(ctx context.Context) ([]int, error) {
+			_, err := app.db(ctx).ExecContext(ctx, "UPDATE todos SET assignee_id = $2 WHERE id = $1", todo.ID, assignee)
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+		if updated, err = app.Queries.GetTodo(ctx, todo.ID); err != nil {
+			return err
+		}
+		if assignee != nil && *assignee != user.ID && (previous == nil || *previous != *assignee) {
+			return app.notifyAssignee(ctx, updated, user.Email)
+		}
+		return nil
+	})
+	if err != nil {
+		return todo, err
+	}
+	return updated, nil
+}
+
+// assignTodo gives a todo to one of its list's members, or to nobody with
+// an empty assignee_id, from the picker in its 📝 panel.
+func (app *Application) assignTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	assignee, err := app.assigneeFrom(r, todo.ListID)
+	if errors.Is(err, errNotAssignable) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, _ := currentUser(r)
+	if todo, err = app.reassign(r.Context(), user, todo, assignee); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderAssignee(w, r, todo, true)
+}
+
+type assignedEmail struct {
+	Todo       model.Todo
+	ListName   string
+	AssignedBy string
+	TodoURL    string
+	BaseURL    string
+}
+
+// notifyAssignee tells someone a todo has been assigned to them, by push
+// notification and email, through the outbox in ctx's transaction, so
+// they hear about it once the assignment commits.
+func (app *Application) notifyAssignee(ctx context.Context, todo model.Todo, by string) error {
+	list, err := app.Queries.GetList(ctx, todo.ListID)
+	if err != nil {
+		return err
+	}
+	todoURL := fmt.Sprintf("%s/todos/%d", app.Config.BaseURL, todo.ID)
+	err = app.queuePush(ctx, *todo.AssigneeID, push.Notification{
+		Title: fmt.Sprintf("%s assigned you a todo in %s", by, list.Name),
+		Body:  todo.Title,
+		URL:   todoURL,
+		Tag:   fmt.Sprintf("assigned-%d", todo.ID),
+	})
+	if err != nil {
+		return err
+	}
+	return app.queueEmail(ctx, todo.Assignee, "Assigned to you: "+todo.Title, "assigned", assignedEmail{
+		Todo:       todo,
+		ListName:   list.Name,
+		AssignedBy: by,
+		TodoURL:    todoURL,
+		BaseURL:    app.Config.BaseURL,
+	})
+}
+
+type assignedTodo struct {
+	model.Todo
+	ListName string `json:"list_name"`
+}
+
+type assignedPage struct {
+	Page
+	Todos []assignedTodo
+}
+
+// assignedToMe lists the open todos assigned to the user on their lists,
+// soonest due first.
+func (app *Application) assignedToMe(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	rows, err := app.DB.QueryContext(r.Context(), `
+		SELECT `+store.TodoColumns(2)+`, (SELECT name FROM lists WHERE lists.id = todos.list_id)
+		FROM todos
+		WHERE assignee_id = $1 AND NOT completed AND archived_at IS NULL
+		  AND list_id IN `+store.MemberLists(1)+`
+		ORDER BY due_date NULLS LAST, id DESC`,
+		user.ID, app.Clock.Now(),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	todos := []assignedTodo{}
+	for rows.Next() {
+		var t assignedTodo
+		if err := rows.Scan(append(store.TodoFields(&t.Todo), &t.ListName)...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		todos = append(todos, t)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.render(w, r, view{
+		Fragment: "assigned-todos",
+		Data:     todos,
+		Page:     "assigned.html",
+		PageData: func() (any, error) { return assignedPage{Page: app.page(r), Todos: todos}, nil },
+		JSON:     todos,
+	})
+}
@@ -0,0 +1,176 @@
+package http
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/thumbnail"
+)
+
+const (
+	// maxAttachmentSize is the largest file that can be uploaded.
+	maxAttachmentSize = 10 << 20
+	// thumbnailSize is the longest side of a thumbnail, in pixels.
+	thumbnailSize = 320
+	// immutable is the Cache-Control of responses whose URL never serves
+	// anything else: attachments can't be edited, only deleted.
+	immutable = "private, max-age=31536000, immutable"
+)
+
+func (app *Application) attachmentFromURL(w http.ResponseWriter, r *http.Request) (model.Attachment, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return model.Attachment{}, false
+	}
+	a, err := app.Queries.GetAttachment(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return model.Attachment{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return model.Attachment{}, false
+	}
+	return a, true
+}
+
+// uploadAttachment adds a file to a todo and re-renders its details. The
+// content type is sniffed from the file rather than trusted from the
+// browser. A voice note is queued for transcribeVoiceNotes when there's a
+// transcriber.
+func (app *Application) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.renderDetail(w, r, todo, "Choose a file of up to 10 MB")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		app.renderDetail(w, r, todo, "Files can be up to 10 MB")
+		return
+	}
+
+	filename := filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+	if filename == "." || filename == "/" {
+		filename = "file"
+	}
+	attachment := model.Attachment{
+		TodoID:      todo.ID,
+		Filename:    filename,
+		ContentType: uploadedType(http.DetectContentType(data), header.Header.Get("Content-Type")),
+	}
+	if attachment.IsAudio() && app.Transcriber != nil {
+		attachment.TranscriptStatus = model.TranscriptPending
+	}
+	if _, err := app.Queries.CreateAttachment(r.Context(), attachment, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderDetail(w, r, todo, "")
+}
+
+func (app *Application) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	a, ok := app.attachmentFromURL(w, r)
+	if !ok {
+		return
+	}
+	if err := app.Queries.DeleteAttachment(r.Context(), a.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	todo, err := app.Queries.GetTodo(r.Context(), a.TodoID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderDetail(w, r, todo, "")
+}
+
+// downloadAttachment serves an attachment as it was uploaded. Images and
+// recordings open in the browser; anything else downloads, so an uploaded
+// page can't run as part of the app.
+func (app *Application) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	a, ok := app.attachmentFromURL(w, r)
+	if !ok {
+		return
+	}
+	data, err := app.Queries.AttachmentData(r.Context(), a.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	disposition := "attachment"
+	if a.IsImage() || a.IsAudio() {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", immutable)
+	http.ServeContent(w, r, "", a.CreatedAt, bytes.NewReader(data))
+}
+
+// getThumbnail serves a small version of an image attachment for the
+// details panel. It's made the first time it's asked for and kept with the
+// attachment; browsers cache it for good, since it never changes.
+func (app *Application) getThumbnail(w http.ResponseWriter, r *http.Request) {
+	a, ok := app.attachmentFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !a.IsImage() {
+		app.notFound(w, r)
+		return
+	}
+
+	thumb, contentType, err := app.Queries.AttachmentThumbnail(r.Context(), a.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if thumb == nil {
+		data, err := app.Queries.AttachmentData(r.Context(), a.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if thumb, contentType, err = thumbnail.Make(data, thumbnailSize); err != nil {
+			http.Error(w, "Can't make a thumbnail of this image: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err := app.Queries.SetAttachmentThumbnail(r.Context(), a.ID, thumb, contentType); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"thumbnail-%d-%d"`, a.ID, thumbnailSize))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", immutable)
+	http.ServeContent(w, r, "", a.CreatedAt, bytes.NewReader(thumb))
+}
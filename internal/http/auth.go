@@ -0,0 +1,314 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/captcha"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/oauth"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+)
+
+// SessionCookie is the name of the cookie holding the session token.
+const SessionCookie = "sid"
+
+type authPage struct {
+	Page
+	Email  string
+	Error  string
+	Notice string
+	// Captcha is set when the form has to show the challenge.
+	Captcha *captcha.Provider
+	// OAuth are the providers to offer signing in with.
+	OAuth []*oauth.Provider
+}
+
+// dummyHash is compared against when an email is unknown, so a failed login
+// takes as long whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// audit records a security event for the request. A failure to record is
+// logged rather than failing the request it describes.
+func (app *Application) audit(r *http.Request, e audit.Event) {
+	e.IP, e.UserAgent = clientIP(r), r.UserAgent()
+	if err := app.Audit.Record(r.Context(), e); err != nil {
+		requestLog(r.Context()).Printf("audit %s for %q failed: %v", e.Action, e.Email, err)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func (app *Application) loginPage(w http.ResponseWriter, r *http.Request) {
+	app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, "", ""))
+}
+
+func (app *Application) signupPage(w http.ResponseWriter, r *http.Request) {
+	app.Templates.ExecuteTemplate(w, "signup.html", app.authPage(r, "", ""))
+}
+
+func (app *Application) login(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+
+	if msg, err := app.checkHuman(r, email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if msg != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, email, msg))
+		return
+	}
+
+	var id int
+	var hash string
+	var verified bool
+	err := app.DB.QueryRowContext(r.Context(),
+		"SELECT id, password_hash, email_verified_at IS NOT NULL FROM users WHERE lower(email) = lower($1)", email,
+	).Scan(&id, &hash, &verified)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		app.audit(r, audit.Event{UserID: id, Email: email, Action: audit.LoginFailed})
+		w.WriteHeader(http.StatusUnauthorized)
+		app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, email, "Wrong email or password."))
+		return
+	}
+	if !verified && app.Config.VerifyEmailToLogin {
+		w.WriteHeader(http.StatusForbidden)
+		app.verifyPage(w, r, email, "", "Confirm your email address before logging in. Follow the link we emailed you, or get a new one below.")
+		return
+	}
+
+	if err := app.startSession(w, r, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, audit.Event{UserID: id, Email: email, Action: audit.Login})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *Application) signup(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		app.Templates.ExecuteTemplate(w, "signup.html", app.authPage(r, email, msg))
+	}
+	if msg, err := app.checkHuman(r, email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if msg != "" {
+		fail(msg)
+		return
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		fail("Enter a valid email address.")
+		return
+	}
+	if len(password) < 8 {
+		fail("Use a password of at least 8 characters.")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var id int
+	err = app.DB.QueryRowContext(r.Context(), `
+		INSERT INTO users (email, password_hash, is_admin) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		email, string(hash), slices.Contains(app.Config.AdminEmails, strings.ToLower(email)),
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		fail("An account with that email already exists.")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, audit.Event{UserID: id, Email: email, Action: audit.Login, Detail: "signed up"})
+	if err := app.sendVerification(r.Context(), id, email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if app.Config.VerifyEmailToLogin {
+		app.verifyPage(w, r, email, "Check your inbox: we've sent a link to "+email+" to confirm your account. 📬", "")
+		return
+	}
+
+	if err := app.startSession(w, r, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *Application) logout(w http.ResponseWriter, r *http.Request) {
+	if user, _ := currentUser(r); user != nil {
+		app.audit(r, audit.Event{UserID: user.ID, Email: user.Email, Action: audit.Logout})
+	}
+	app.Outage.forget(r)
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		if err := app.Sessions.Destroy(r.Context(), c.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	app.clearSessionCookie(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+type passwordForm struct {
+	// HasPassword is false for accounts made by signing in with a
+	// provider, which set a password without giving the current one.
+	HasPassword bool
+	Error       string
+	Message     string
+}
+
+// changePassword sets a new password after checking the current one, and
+// signs out every other device in case the old password leaked.
+func (app *Application) changePassword(w http.ResponseWriter, r *http.Request) {
+	user, current := currentUser(r)
+	next := r.FormValue("new_password")
+
+	var hash string
+	err := app.DB.QueryRowContext(r.Context(), "SELECT password_hash FROM users WHERE id = $1", user.ID).Scan(&hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Accounts made by signing in with a provider have no password to check.
+	hasPassword := hash != ""
+	if hasPassword && bcrypt.CompareHashAndPassword([]byte(hash), []byte(r.FormValue("current_password"))) != nil {
+		app.Templates.ExecuteTemplate(w, "password-form", passwordForm{HasPassword: true, Error: "Your current password is wrong."})
+		return
+	}
+	if len(next) < 8 {
+		app.Templates.ExecuteTemplate(w, "password-form", passwordForm{HasPassword: hasPassword, Error: "Use a password of at least 8 characters."})
+		return
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(next), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := app.DB.ExecContext(r.Context(), "UPDATE users SET password_hash = $1 WHERE id = $2", string(newHash), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := app.Sessions.RevokeOthers(r.Context(), user.ID, current.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, audit.Event{UserID: user.ID, Email: user.Email, Action: audit.PasswordChanged})
+
+	app.Templates.ExecuteTemplate(w, "password-form", passwordForm{HasPassword: true, Message: "Password changed. Other devices have been signed out."})
+}
+
+// revokeSession signs one of the current user's other devices out.
+func (app *Application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	user, current := currentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id == current.ID {
+		http.Error(w, "Invalid session", http.StatusBadRequest)
+		return
+	}
+
+	err = app.Sessions.Revoke(r.Context(), user.ID, id)
+	if errors.Is(err, session.ErrNotFound) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *Application) startSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	token, err := app.Sessions.Create(r.Context(), userID, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(app.Sessions.MaxLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.Config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (app *Application) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// currentUser returns the signed-in user and their session, or nil for
+// anonymous requests.
+func currentUser(r *http.Request) (*model.User, session.Session) {
+	s := scopeOf(r.Context())
+	if s.User == nil {
+		return nil, session.Session{}
+	}
+	return s.User, s.Session
+}
+
+func (app *Application) loadUser(ctx context.Context, id int) (model.User, error) {
+	u := model.User{ID: id}
+	var demoEnds, viewUpdated sql.NullTime
+	err := app.DB.QueryRowContext(ctx, `
+		SELECT email, is_admin, created_at, email_verified_at IS NOT NULL, confirm_deletes, demo_expires_at,
+			list_density, hidden_columns, list_view_updated_at
+		FROM users WHERE id = $1`, id,
+	).Scan(&u.Email, &u.IsAdmin, &u.CreatedAt, &u.EmailVerified, &u.ConfirmDeletes, &demoEnds,
+		&u.ListView.Density, pq.Array(&u.ListView.Hidden), &viewUpdated)
+	u.DemoEnds = demoEnds.Time
+	u.ListView.UpdatedAt = viewUpdated.Time
+	return u, err
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// access is the caller's standing on the list a request is about.
+type access struct {
+	ListID int
+	Role   model.Role
+}
+
+const accessKey contextKey = "access"
+
+func listAccess(r *http.Request) access {
+	a, _ := r.Context().Value(accessKey).(access)
+	return a
+}
+
+// roleFor looks up what user may do on a list. Admins own every list.
+func (app *Application) roleFor(ctx context.Context, user *model.User, listID int) (model.Role, error) {
+	if user == nil {
+		return model.NoRole, nil
+	}
+	if user.IsAdmin {
+		return model.Owner, nil
+	}
+	return app.Queries.MemberRole(ctx, listID, user.ID)
+}
+
+func (app *Application) withAccess(w http.ResponseWriter, r *http.Request, next http.Handler, listID int) {
+	user, _ := currentUser(r)
+	role, err := app.roleFor(r.Context(), user, listID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx := context.WithValue(r.Context(), accessKey, access{ListID: listID, Role: role})
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// listRole loads the caller's role on the list in the URL.
+func (app *Application) listRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listID, err := strconv.Atoi(chi.URLParam(r, "listID"))
+		if err != nil {
+			app.notFound(w, r)
+			return
+		}
+		exists, err := app.Queries.ListExists(r.Context(), listID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			app.notFound(w, r)
+			return
+		}
+		app.withAccess(w, r, next, listID)
+	})
+}
+
+// todoRole loads the caller's role on the list the todo in the URL belongs
+// to.
+func (app *Application) todoRole(next http.Handler) http.Handler {
+	return app.roleVia(app.Queries.TodoListID, next)
+}
+
+// attachmentRole loads the caller's role on the list of the todo the
+// attachment in the URL is on.
+func (app *Application) attachmentRole(next http.Handler) http.Handler {
+	return app.roleVia(app.Queries.AttachmentListID, next)
+}
+
+// roleVia loads the caller's role on the list that listOf finds for the ID
+// in the URL.
+func (app *Application) roleVia(listOf func(context.Context, int) (int, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			app.notFound(w, r)
+			return
+		}
+		listID, err := listOf(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		app.withAccess(w, r, next, listID)
+	})
+}
+
+// requireRole lets a request through only if the role loaded by listRole or
+// todoRole is at least min.
+func (app *Application) requireRole(min model.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if listAccess(r).Role < min {
+				app.forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireLogin sends anonymous visitors to the login page. htmx requests
+// are redirected with HX-Redirect so the whole page navigates, and API calls
+// get a JSON 401.
+func (app *Application) requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := currentUser(r); user != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			jsonError(w, http.StatusUnauthorized, "Log in to use the API")
+		case htmx.IsRequest(r):
+			htmx.Redirect(w, "/login")
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		}
+	})
+}
+
+// forbidden answers a request the caller lacks the role for. htmx requests
+// get a notice swapped into the page's #alerts container rather than
+// wherever the request was aimed.
+func (app *Application) forbidden(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		jsonError(w, http.StatusForbidden, "You don't have permission to do that")
+	case htmx.IsRequest(r):
+		htmx.Retarget(w, "#alerts")
+		htmx.Reswap(w, htmx.InnerHTML)
+		w.WriteHeader(http.StatusForbidden)
+		app.Templates.ExecuteTemplate(w, "forbidden", nil)
+	default:
+		w.WriteHeader(http.StatusForbidden)
+		app.Templates.ExecuteTemplate(w, "forbidden.html", app.page(r))
+	}
+}
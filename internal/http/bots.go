@@ -0,0 +1,79 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/captcha"
+)
+
+// honeypotField is a form input people never see, so never fill in. Bots
+// that fill in every input do.
+const honeypotField = "website"
+
+// botFailures are the audit actions that count towards the CAPTCHA
+// thresholds.
+var botFailures = []string{audit.LoginFailed, audit.BotBlocked}
+
+// needsCaptcha reports whether a login or signup from r has to solve the
+// challenge: when a provider is configured and failures within the window,
+// from the client's IP or from everyone, have reached their thresholds.
+func (app *Application) needsCaptcha(r *http.Request) (bool, error) {
+	if app.Captcha == nil {
+		return false, nil
+	}
+	cfg := app.Config.Captcha
+	if cfg.Threshold == 0 {
+		return true, nil
+	}
+
+	since := time.Now().Add(-cfg.Window)
+	n, err := app.Audit.Count(r.Context(), botFailures, clientIP(r), since)
+	if err != nil || n >= cfg.Threshold {
+		return err == nil, err
+	}
+	if cfg.GlobalThreshold == 0 {
+		return false, nil
+	}
+	n, err = app.Audit.Count(r.Context(), botFailures, "", since)
+	return n >= cfg.GlobalThreshold, err
+}
+
+// checkHuman turns away form posts that look automated. It returns the
+// message to show on the form when the post should be rejected, having
+// recorded why in the audit log. Errors are for when it can't tell.
+func (app *Application) checkHuman(r *http.Request, email string) (string, error) {
+	if r.PostFormValue(honeypotField) != "" {
+		app.audit(r, audit.Event{Email: email, Action: audit.BotBlocked, Detail: "honeypot"})
+		return "Something went wrong. Please try again.", nil
+	}
+
+	need, err := app.needsCaptcha(r)
+	if err != nil || !need {
+		return "", err
+	}
+	err = app.Captcha.Verify(r.Context(), r.PostFormValue(app.Captcha.Field), clientIP(r))
+	if errors.Is(err, captcha.ErrFailed) {
+		app.audit(r, audit.Event{Email: email, Action: audit.BotBlocked, Detail: "captcha"})
+		return "Complete the challenge below to continue.", nil
+	}
+	return "", err
+}
+
+// authPage is the login or signup form, with the challenge when the next
+// post will need it.
+func (app *Application) authPage(r *http.Request, email, message string) authPage {
+	p := authPage{Page: app.page(r), Email: email, Error: message, OAuth: app.OAuth}
+	need, err := app.needsCaptcha(r)
+	if err != nil {
+		// Show the challenge anyway; the post checks again.
+		requestLog(r.Context()).Printf("captcha threshold: %v", err)
+		need = app.Captcha != nil
+	}
+	if need {
+		p.Captcha = app.Captcha
+	}
+	return p
+}
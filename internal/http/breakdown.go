@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+const (
+	// maxSteps is how many steps a todo can be broken into at once.
+	maxSteps = 10
+	// maxStepLength is the longest a suggested step can be, in characters.
+	maxStepLength = 200
+)
+
+// breakdownPrompt tells the model what a good breakdown looks like and how
+// to reply, so the reply can be read a line at a time.
+const breakdownPrompt = `You help people plan their work. You'll be given a todo, and maybe notes about it. ` +
+	`Break it down into between 3 and 7 concrete steps that together get it done, in the order they'd be done. ` +
+	`Write each step as a short todo title starting with a verb, in the language the todo is written in. ` +
+	`Reply with the steps only, one per line, without numbering, bullets or commentary.`
+
+// listMarker matches the bullets, numbers and checkboxes a model puts
+// before a line even when asked not to.
+var listMarker = regexp.MustCompile(`^(?:(?:[-*•]|\d+[.)]|\[[ xX]?\])\s+)+`)
+
+type breakdownForm struct {
+	Todo  model.Todo
+	Steps []string
+	Error string
+}
+
+// breakDownTodo asks the assistant how to break a todo down and shows what
+// it suggests in a form, where the steps can be edited, dropped or added
+// to before addSteps saves them. Nothing is saved until then.
+func (app *Application) breakDownTodo(w http.ResponseWriter, r *http.Request) {
+	if app.Assistant == nil {
+		app.notFound(w, r)
+		return
+	}
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := breakdownForm{Todo: todo}
+	prompt := "Todo: " + todo.Title
+	if todo.Description != "" {
+		prompt += "\n\nNotes:\n" + todo.Description
+	}
+	reply, err := app.Assistant.Complete(r.Context(), breakdownPrompt, prompt)
+	if err != nil {
+		requestLog(r.Context()).Printf("break down todo %d: %v", todo.ID, err)
+		form.Error = "The assistant couldn't be reached. Try again in a moment."
+	} else if form.Steps = parseSteps(reply); len(form.Steps) == 0 {
+		form.Error = "The assistant didn't suggest any steps. Try adding a description to the todo."
+	}
+	app.renderModal(w, "Break down", "breakdown-form", form)
+}
+
+// parseSteps reads one step per line of a model's reply, without list
+// markers, blank lines, repeats and lead-ins like "Here are the steps:".
+func parseSteps(reply string) []string {
+	var steps []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(listMarker.ReplaceAllString(strings.TrimSpace(line), ""))
+		line = strings.Trim(line, `"*`)
+		if line == "" || strings.HasSuffix(line, ":") || seen[strings.ToLower(line)] {
+			continue
+		}
+		seen[strings.ToLower(line)] = true
+		if r := []rune(line); len(r) > maxStepLength {
+			line = string(r[:maxStepLength])
+		}
+		steps = append(steps, line)
+		if len(steps) == maxSteps {
+			break
+		}
+	}
+	return steps
+}
+
+// addSteps adds the steps from the breakdown form to the todo's list as
+// todos of their own, each blocking the todo, so it's ready to complete
+// once they're done. Steps left empty are skipped. It can be undone as one
+// change.
+func (app *Application) addSteps(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	r.ParseForm()
+	var steps []string
+	for _, s := range r.PostForm["step"] {
+		if s = strings.TrimSpace(s); s != "" {
+			steps = append(steps, s)
+		}
+	}
+	message := ""
+	switch {
+	case len(steps) == 0:
+		message = "Add at least one step."
+	case len(steps) > maxSteps:
+		message = fmt.Sprintf("A todo can be broken into up to %d steps at once.", maxSteps)
+	}
+	if message != "" {
+		htmx.Retarget(w, "#modal")
+		htmx.Reswap(w, htmx.InnerHTML)
+		app.renderModal(w, "Break down", "breakdown-form", breakdownForm{Todo: todo, Steps: steps, Error: message})
+		return
+	}
+
+	user, _ := currentUser(r)
+	err := app.undoable(r.Context(), user.ID, "break down", []int{todo.ID}, func(ctx context.Context) ([]int, error) {
+		var ids []int
+		for _, step := range steps {
+			id, err := app.insertTodo(ctx, "", todo.ListID, step, 0, nil)
+			if err != nil {
+				return nil, err
+			}
+			// A new todo can't be waiting on anything yet, so this can't
+			// make a cycle.
+			_, err = app.db(ctx).ExecContext(ctx,
+				"INSERT INTO todo_dependencies (todo_id, blocker_id) VALUES ($1, $2)",
+				todo.ID, id,
+			)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	closeModal(w)
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// Cached data is keyed by who it's for. Effort and streaks depend on the
+// date too, so a new day starts with fresh keys.
+func effortKey(userID int, today string) string  { return fmt.Sprintf("effort:%d:%s", userID, today) }
+func historyKey(userID int, today string) string { return fmt.Sprintf("history:%d:%s", userID, today) }
+func listsKey(userID int) string                 { return fmt.Sprintf("lists:%d", userID) }
+func fieldsKey(listID int) string                { return fmt.Sprintf("fields:%d", listID) }
+
+// todoListKey is where userID's rendered todo list for listID is cached,
+// by the filters in query and the version of everything it shows: the
+// list, which its todos touch when they change, the user's layout and the
+// day, for how long todos have been open. A change makes a new key, and
+// the old one ages out.
+func todoListKey(userID, listID int, query url.Values, list, view time.Time, today string) string {
+	return fmt.Sprintf("fragment:todo-list:%d:%d:%s:%d.%d.%s",
+		userID, listID, query.Encode(), list.UnixNano(), view.UnixNano(), today)
+}
+
+// userLists is the sidebar: the lists userID is a member of.
+func (app *Application) userLists(ctx context.Context, userID int) ([]model.List, error) {
+	return cache.Load(ctx, app.Cache, listsKey(userID), func() ([]model.List, error) {
+		return app.Queries.ListUserLists(ctx, userID)
+	})
+}
+
+func (app *Application) listFields(ctx context.Context, listID int) ([]model.Field, error) {
+	return cache.Load(ctx, app.Cache, fieldsKey(listID), func() ([]model.Field, error) {
+		return app.Queries.ListFields(ctx, listID)
+	})
+}
+
+// forgetUsers drops what's cached for each of userIDs.
+func (app *Application) forgetUsers(ctx context.Context, userIDs ...int) {
+	today := app.todayDate()
+	var keys []string
+	for _, id := range userIDs {
+		keys = append(keys, effortKey(id, today), historyKey(id, today), listsKey(id))
+	}
+	app.Cache.Delete(ctx, keys...)
+}
+
+// forgetList drops what's cached about a list after it changed: its
+// fields, and the counts and sidebar of everyone on it.
+func (app *Application) forgetList(ctx context.Context, listID int) {
+	members, err := app.Queries.ListMembers(ctx, listID)
+	if err != nil {
+		// The TTL will catch up with what can't be dropped now.
+		log.Printf("cache: members of list %d: %v", listID, err)
+	}
+	ids := make([]int, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	app.forgetUsers(ctx, ids...)
+	app.Cache.Delete(ctx, fieldsKey(listID))
+}
+
+// invalidateCache forgets the cache for the list loaded by listRole or
+// todoRole once a request that changes it succeeds.
+func (app *Application) invalidateCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if ww.Status() < http.StatusBadRequest {
+			app.forgetList(r.Context(), listAccess(r).ListID)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package http
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/changelog"
+)
+
+type changelogPage struct {
+	Page
+	Releases []changelog.Release
+}
+
+// changelogHandler shows the releases out so far and marks them read, which
+// clears the dot on the link. An admin impersonating someone leaves theirs.
+func (app *Application) changelogHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	releases, err := app.Changelog.Published(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := changelogPage{Page: app.page(r), Releases: releases}
+	if data.Impersonation == nil {
+		if err := app.Changelog.MarkRead(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.ChangelogUnread = false
+	}
+	app.Templates.ExecuteTemplate(w, "changelog.html", data)
+}
+
+type changelogAdminPage struct {
+	Page
+	Releases []changelog.Release
+	// Editing is the release the form edits; a zero one means the form
+	// adds a new one.
+	Editing changelog.Release
+	Error   string
+	Now     time.Time
+}
+
+func (app *Application) renderChangelogAdmin(w http.ResponseWriter, r *http.Request, data changelogAdminPage) {
+	var err error
+	if data.Releases, err = app.Changelog.List(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Page = app.page(r)
+	data.Now = time.Now()
+	app.render(w, r, view{Fragment: "changelog-admin", Page: "admin-changelog.html", Data: data})
+}
+
+func (app *Application) adminChangelog(w http.ResponseWriter, r *http.Request) {
+	app.renderChangelogAdmin(w, r, changelogAdminPage{})
+}
+
+// release returns the release in the URL, rendering not found when
+// there's none.
+func (app *Application) release(w http.ResponseWriter, r *http.Request) (changelog.Release, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return changelog.Release{}, false
+	}
+	release, err := app.Changelog.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return release, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return release, false
+	}
+	return release, true
+}
+
+// editRelease fills the form with a release to change.
+func (app *Application) editRelease(w http.ResponseWriter, r *http.Request) {
+	release, ok := app.release(w, r)
+	if !ok {
+		return
+	}
+	app.renderChangelogAdmin(w, r, changelogAdminPage{Editing: release})
+}
+
+// releaseForm reads a release from the form, returning what's wrong with
+// it if anything. The publication time comes from a datetime-local input,
+// in the admin's timezone; left empty, the release comes out now.
+func releaseForm(r *http.Request) (changelog.Release, string) {
+	release := changelog.Release{
+		Version: strings.TrimSpace(r.FormValue("version")),
+		Title:   strings.TrimSpace(r.FormValue("title")),
+		Body:    strings.TrimSpace(r.FormValue("body")),
+	}
+	if release.Title == "" {
+		return release, "Give the release a title."
+	}
+	if v := r.FormValue("published_at"); v != "" {
+		loc, err := time.LoadLocation(r.FormValue("timezone"))
+		if err != nil {
+			loc = time.UTC
+		}
+		if release.PublishedAt, err = time.ParseInLocation(announcementTime, v, loc); err != nil {
+			return release, "Enter the publication time as a date and time."
+		}
+	}
+	return release, ""
+}
+
+func (app *Application) createRelease(w http.ResponseWriter, r *http.Request) {
+	release, message := releaseForm(r)
+	if message != "" {
+		app.renderChangelogAdmin(w, r, changelogAdminPage{Editing: release, Error: message})
+		return
+	}
+	if _, err := app.Changelog.Create(r.Context(), release); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderChangelogAdmin(w, r, changelogAdminPage{})
+}
+
+func (app *Application) updateRelease(w http.ResponseWriter, r *http.Request) {
+	existing, ok := app.release(w, r)
+	if !ok {
+		return
+	}
+	release, message := releaseForm(r)
+	release.ID = existing.ID
+	if message != "" {
+		app.renderChangelogAdmin(w, r, changelogAdminPage{Editing: release, Error: message})
+		return
+	}
+	err := app.Changelog.Update(r.Context(), release)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderChangelogAdmin(w, r, changelogAdminPage{})
+}
+
+func (app *Application) deleteRelease(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if err := app.Changelog.Delete(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderChangelogAdmin(w, r, changelogAdminPage{})
+}
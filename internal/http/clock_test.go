@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/clock"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// frozenApp is an app whose clock is stopped at 18:30 UTC on Thursday 15
+// October 2026, with listID's fields already cached so searches on it
+// don't need the database.
+func frozenApp(t *testing.T, listID int, fields ...model.Field) *Application {
+	t.Helper()
+	app := &Application{
+		Clock: clock.Frozen(time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC)),
+		Cache: cache.New(cache.NewMemory(10), time.Minute),
+	}
+	_, err := cache.Load(context.Background(), app.Cache, fieldsKey(listID), func() ([]model.Field, error) {
+		return fields, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestToday(t *testing.T) {
+	app := frozenApp(t, 1)
+	if got, want := app.today(), time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("today() = %v, want %v", got, want)
+	}
+	if got := app.todayDate(); got != "2026-10-15" {
+		t.Errorf("todayDate() = %q, want 2026-10-15", got)
+	}
+}
+
+func TestParseSearchDates(t *testing.T) {
+	app := frozenApp(t, 1, model.Field{ID: 4, Name: "Ship", Kind: model.DateField})
+	tests := []struct {
+		query string
+		args  []any
+	}{
+		{"is:overdue", []any{"2026-10-15", 1}},
+		{"due:today", []any{"2026-10-15", 1}},
+		{"due:<tomorrow", []any{"2026-10-16", 1}},
+		{"due:thursday", []any{"2026-10-15", 1}},
+		{"due:monday", []any{"2026-10-19", 1}},
+		{"ship:>=friday", []any{"Ship", model.DateField, "2026-10-16", 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, filter, notes, err := app.parseSearch(context.Background(), 7, 1, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(notes) > 0 {
+				t.Errorf("notes: %q", notes)
+			}
+			if !reflect.DeepEqual(filter.args, tt.args) {
+				t.Errorf("args = %v, want %v", filter.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestDigestDueAt(t *testing.T) {
+	// Thursday 15 October 2026, 18:30 UTC: 20:30 in Berlin, 14:30 in New York.
+	now := time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		sub  digestSubscription
+		want bool
+	}{
+		{"UTC", digestSubscription{Weekday: int(time.Thursday), Hour: 18, Timezone: "UTC"}, true},
+		{"Berlin", digestSubscription{Weekday: int(time.Thursday), Hour: 20, Timezone: "Europe/Berlin"}, true},
+		{"New York", digestSubscription{Weekday: int(time.Thursday), Hour: 14, Timezone: "America/New_York"}, true},
+		{"wrong hour", digestSubscription{Weekday: int(time.Thursday), Hour: 9, Timezone: "UTC"}, false},
+		{"wrong day", digestSubscription{Weekday: int(time.Friday), Hour: 18, Timezone: "UTC"}, false},
+		// Already Friday there.
+		{"Auckland", digestSubscription{Weekday: int(time.Friday), Hour: 7, Timezone: "Pacific/Auckland"}, true},
+		{"unknown zone", digestSubscription{Weekday: int(time.Thursday), Hour: 18, Timezone: "Mars/Olympus"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sub.dueAt(now); got != tt.want {
+				t.Errorf("dueAt(%v) in %s = %v, want %v", now, tt.sub.Timezone, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/announce"
+	"github.com/Trailblazors/htmx-go-postgres/internal/captcha"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/oauth"
+	"github.com/Trailblazors/htmx-go-postgres/internal/presence"
+)
+
+func TestComponents(t *testing.T) {
+	ann := &model.User{ID: 2, Email: "ann@example.com"}
+	priority := model.Field{ID: 3, Name: "Priority", Kind: model.SelectField, Options: []string{"Low", "High"}}
+
+	tests := []struct {
+		name      string
+		component string
+		data      any
+		want      []string
+		notWant   []string
+	}{
+		{
+			name:      "announcement to a user",
+			component: "announcements",
+			data:      Page{User: ann, Announcements: []announce.Announcement{{ID: 3, Message: "**Down** at 6", Kind: announce.Warning}}},
+			want:      []string{"⚠️", "<strong>Down</strong> at 6", `hx-post="/announcements/3/dismiss"`, "bg-yellow-100"},
+		},
+		{
+			name:      "announcement to a visitor",
+			component: "announcements",
+			data:      Page{Announcements: []announce.Announcement{{ID: 3, Message: "New lists", Kind: announce.Info}}},
+			want:      []string{"📣", "New lists", "bg-blue-50"},
+			notWant:   []string{"dismiss"},
+		},
+		{
+			name:      "bot check with a CAPTCHA",
+			component: "bot-check",
+			data:      authPage{Captcha: &captcha.Provider{SiteKey: "site-key", Class: "h-captcha"}},
+			want:      []string{`name="website"`, `tabindex="-1"`, `<div class="h-captcha" data-sitekey="site-key"></div>`},
+		},
+		{
+			name:      "bot check without a CAPTCHA",
+			component: "bot-check",
+			data:      authPage{},
+			want:      []string{`name="website"`},
+			notWant:   []string{"data-sitekey"},
+		},
+		{
+			name:      "CAPTCHA script",
+			component: "captcha-script",
+			data:      authPage{Captcha: &captcha.Provider{Script: "https://js.hcaptcha.com/1/api.js"}},
+			want:      []string{`<script src="https://js.hcaptcha.com/1/api.js" async defer></script>`},
+		},
+		{
+			name:      "select field as a filter",
+			component: "field-input",
+			data:      fieldInput{Field: priority, Name: "filter_3", Value: "High", Filter: true},
+			want:      []string{`<select name="filter_3" title="Priority"`, `<option value="">Any Priority</option>`, `<option value="High" selected>High</option>`, `<option value="Low" >Low</option>`},
+		},
+		{
+			name:      "number field in a form",
+			component: "field-input",
+			data:      fieldInput{Field: model.Field{ID: 2, Name: "Points", Kind: model.NumberField}, Name: "field_2", Value: "5"},
+			want:      []string{`type="number"`, `name="field_2" value="5"`, `step="any"`, `placeholder="Points"`},
+		},
+		{
+			name:      "field values",
+			component: "field-values",
+			data:      model.FieldValues{{Name: "Ship", Kind: model.DateField, Value: "2026-10-15"}, {Name: "Points", Kind: model.NumberField, Value: 2.5}},
+			want:      []string{`title="Ship">Ship: Oct 15</span>`, "Points: 2.5"},
+		},
+		{
+			name:      "demo sign-up",
+			component: "demo-start",
+			data:      authPage{Page: Page{Demo: true}},
+			want:      []string{`action="/demo"`, "Try the demo"},
+		},
+		{
+			name:      "no demo",
+			component: "demo-start",
+			data:      authPage{},
+			notWant:   []string{"/demo"},
+		},
+		{
+			name:      "demo banner",
+			component: "demo-banner",
+			data:      Page{User: &model.User{ID: 2, DemoEnds: time.Now().Add(time.Hour)}, CSRFToken: "tok"},
+			want:      []string{"You're trying the demo", `action="/logout"`, `value="tok"`, "Start over"},
+		},
+		{
+			name:      "no demo banner for a real account",
+			component: "demo-banner",
+			data:      Page{User: ann},
+			notWant:   []string{"demo"},
+		},
+		{
+			name:      "impersonation banner",
+			component: "impersonation-banner",
+			data: Page{User: ann, CSRFToken: "tok", Impersonation: &impersonation{
+				Admin: &model.User{ID: 1, Email: "admin@example.com"},
+				Ends:  time.Date(2026, 10, 15, 15, 30, 0, 0, time.UTC),
+			}},
+			want: []string{"admin@example.com, you're acting as <strong>ann@example.com</strong> until 15:30", `action="/impersonation/stop"`, `value="tok"`},
+		},
+		{
+			name:      "list sidebar",
+			component: "list-sidebar",
+			data: listPage{List: model.List{ID: 1}, Page: Page{CSRFToken: "tok"}, Lists: []model.List{
+				{ID: 1, Name: "Inbox", Role: model.Owner, Counts: &model.TodoCounts{Open: 3}},
+				{ID: 2, Name: "Team", Role: model.Viewer},
+				{ID: 3, Name: "Old", Role: model.Owner, Archived: true},
+			}},
+			want: []string{
+				`href="/lists/1"
+           class="flex justify-between px-3 py-1 rounded bg-blue-50 text-blue-700 font-medium"`,
+				`title="3 open todos">3</span>`,
+				`<span class="text-xs text-gray-400">viewer</span>`,
+				"Archived (1)",
+				`action="/lists"`,
+			},
+		},
+		{
+			name:      "mention suggestions",
+			component: "mention-options",
+			data:      mentionOptions{Query: "a", Members: []model.Member{{UserID: 2, Email: "ann@example.com", Role: model.Editor}}},
+			want:      []string{`role="listbox"`, `data-mention="ann@example.com"`, "<span>@ann@example.com</span>", `<span class="text-gray-400">editor</span>`},
+		},
+		{
+			name:      "no mention suggestions",
+			component: "mention-options",
+			data:      mentionOptions{Query: "zed"},
+			want:      []string{"Nobody matches “zed”"},
+			notWant:   []string{"data-mention"},
+		},
+		{
+			name:      "modal",
+			component: "modal",
+			data:      modal{Title: "Edit todo", Body: "<p>body</p>"},
+			want:      []string{`role="dialog" aria-modal="true" aria-labelledby="modal-title"`, `id="modal-title"`, ">Edit todo</h2>", "<p>body</p>", "data-modal-close"},
+		},
+		{
+			name:      "modal container",
+			component: "modal-container",
+			want:      []string{`<div id="modal"></div>`},
+		},
+		{
+			name:      "sign-in providers",
+			component: "oauth-buttons",
+			data:      authPage{OAuth: []*oauth.Provider{{Name: "github", Label: "GitHub"}}},
+			want:      []string{`href="/auth/github"`, "Continue with GitHub"},
+		},
+		{
+			name:      "no sign-in providers",
+			component: "oauth-buttons",
+			data:      authPage{},
+			notWant:   []string{"/auth/"},
+		},
+		{
+			name:      "presence",
+			component: "presence-strip",
+			data:      presenceStrip{ListID: 1, Viewers: []presence.Viewer{{UserID: 2, Email: "ann@example.com"}}},
+			want:      []string{`aria-label="Also viewing"`, `title="ann@example.com"`, ">A</span>"},
+		},
+		{
+			name:      "nobody else present",
+			component: "presence-strip",
+			data:      presenceStrip{ListID: 1},
+			notWant:   []string{"Also viewing"},
+		},
+		{
+			name:      "error toast",
+			component: "toast",
+			data:      toast{Message: "Couldn't save", Error: true},
+			want:      []string{`hx-swap-oob="beforeend:#toasts"`, `role="status"`, "bg-red-600", "<span>Couldn&#39;t save</span>"},
+		},
+		{
+			name:      "toast container",
+			component: "toast-container",
+			want:      []string{`id="toasts" aria-live="polite"`},
+		},
+		{
+			name:      "stylesheet",
+			component: "stylesheet",
+			want:      []string{`<link rel="stylesheet" href="/assets/app.`},
+			notWant:   []string{"cdn.tailwindcss.com"},
+		},
+		{
+			name:      "running timer",
+			component: "timer",
+			data:      model.Todo{ID: 7, TimerRunning: true, TrackedSeconds: 90},
+			want:      []string{`hx-get="/todos/7/timer" hx-trigger="every 10s"`, `hx-post="/todos/7/timer/stop"`, "text-green-600"},
+			notWant:   []string{"timer/start"},
+		},
+		{
+			name:      "stopped timer",
+			component: "timer",
+			data:      model.Todo{ID: 7},
+			want:      []string{`hx-post="/todos/7/timer/start"`},
+			notWant:   []string{"every 10s"},
+		},
+		{
+			name:      "assignee",
+			component: "assignee-name",
+			data:      "ann@example.com",
+			want:      []string{`title="Assigned to ann@example.com">👤 ann@example.com</span>`},
+		},
+		{
+			name:      "completed todo",
+			component: "todo-item",
+			data:      todoRow{Todo: model.Todo{ID: 5, Title: "Ship **it**", Completed: true, Blocked: true}},
+			want:      []string{`id="todo-5" data-todo="5"`, "checked", `hx-put="/todos/5/toggle"`, "line-through", "Ship <strong>it</strong>", `id="todo-5-panel"`},
+			notWant:   []string{"Blocked"},
+		},
+		{
+			name:      "open, blocked and aging todo, compact",
+			component: "todo-item",
+			data: todoRow{
+				Todo: model.Todo{ID: 6, Title: "Renew", Blocked: true, OpenDays: 3, Aging: model.AgingAlert, Assignee: "ann@example.com"},
+				View: model.ListView{Density: model.Compact, Hidden: []string{"assignee"}},
+			},
+			want:    []string{"px-4 py-1 text-sm", "border-l-4 border-red-400", "⛔ Blocked", `title="Open for 3 days">🕰 3d</span>`},
+			notWant: []string{"checked", "Assigned to"},
+		},
+	}
+
+	tmpl := testTemplates(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := tmpl.ExecuteTemplate(&out, tt.component, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("missing %q in:\n%s", want, out.String())
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out.String(), notWant) {
+					t.Errorf("unexpected %q in:\n%s", notWant, out.String())
+				}
+			}
+		})
+	}
+}
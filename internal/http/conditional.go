@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// notModified handles conditional GET and HEAD requests. It sets
+// Last-Modified to modified and, if the request's If-Modified-Since shows
+// the client already has that version, answers 304 Not Modified and
+// reports true, leaving the handler nothing to render.
+//
+// A zero modified opts out, for content that changes by the second. So
+// does a version less than a second old: HTTP dates have whole seconds, so
+// it couldn't be told apart from another change later in the same second.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if modified.IsZero() || time.Since(modified) < time.Second {
+		return false
+	}
+
+	modified = modified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	// Pages are per user, and browsers should check back every time rather
+	// than guess how long they stay fresh.
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.Header().Add("Vary", "Accept, HX-Request")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// headAsGet serves HEAD requests with the route's GET handler; the server
+// drops the body. chi's GetHead can't be used because it doesn't look into
+// subrouters.
+func headAsGet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			chi.RouteContext(r.Context()).RouteMethod = http.MethodGet
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listModified is when what the list pages show last changed: the list
+// and its todos, the sidebar of the caller's lists, the session, whose
+// CSRF token is in every form, how the caller likes lists laid out, and
+// the day, since todos show how many days they've been open.
+func (app *Application) listModified(r *http.Request) (time.Time, error) {
+	list, err := app.Queries.ListModified(r.Context(), listAccess(r).ListID)
+	if err != nil || list.IsZero() {
+		return time.Time{}, err
+	}
+	user, sess := currentUser(r)
+	lists, err := app.Queries.UserListsModified(r.Context(), user.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return latest(list, lists, sess.CreatedAt, user.ListView.UpdatedAt, app.today()), nil
+}
+
+func latest(times ...time.Time) time.Time {
+	var t time.Time
+	for _, u := range times {
+		if u.After(t) {
+			t = u
+		}
+	}
+	return t
+}
@@ -0,0 +1,161 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// sampleTodo is a todo that new demo and real accounts start with.
+type sampleTodo struct {
+	Title       string
+	Description string
+	Estimate    int
+	// DueIn is how many days from today it's due; zero for no due date.
+	DueIn int
+	Done  bool
+}
+
+// demoLists are what every demo account starts with, each list's todos
+// from the top.
+var demoLists = []struct {
+	Name  string
+	Todos []sampleTodo
+}{
+	{"Welcome 👋", []sampleTodo{
+		{Title: "Check this one off to see it move"},
+		{Title: "Plan the week", Estimate: 30, DueIn: 1, Description: "Drag todos into **My Day**, or add a due date and an estimate to see how full each day is."},
+		{Title: "Write the launch post", Estimate: 90, DueIn: 3, Description: "Outline:\n\n- what's new\n- who it's for\n- [htmx.org](https://htmx.org) for the curious"},
+		{Title: "Review the `draft` with the team", Estimate: 45, DueIn: 2},
+		{Title: "Try searching, duplicating and moving todos between lists"},
+		{Title: "Open the demo", Done: true},
+	}},
+	{"Groceries 🛒", []sampleTodo{
+		{Title: "Coffee beans"},
+		{Title: "Oat milk"},
+		{Title: "Sourdough", Done: true},
+	}},
+}
+
+// startDemo gives the visitor an account of their own, filled with
+// sample data, that's deleted after DEMO_TTL. It's a POST from the login
+// page rather than automatic, so crawlers don't make accounts.
+func (app *Application) startDemo(w http.ResponseWriter, r *http.Request) {
+	if !app.Config.Demo {
+		app.notFound(w, r)
+		return
+	}
+	if user, _ := currentUser(r); user != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	id, err := app.createDemoAccount(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := app.startSession(w, r, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *Application) createDemoAccount(ctx context.Context) (int, error) {
+	var userID int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		err := app.db(ctx).QueryRowContext(ctx, `
+			INSERT INTO users (email, password_hash, email_verified_at, demo_expires_at)
+			VALUES ('demo-' || $1 || '@demo.invalid', '', NOW(), NOW() + make_interval(secs => $2))
+			RETURNING id`,
+			newToken()[:12], app.Config.DemoTTL.Seconds(),
+		).Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range demoLists {
+			if _, err := app.seedList(ctx, userID, l.Name, l.Todos); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return userID, err
+}
+
+// seedList makes a list owned by userID holding todos, which run from the
+// top of the list down.
+func (app *Application) seedList(ctx context.Context, userID int, name string, todos []sampleTodo) (int, error) {
+	var listID int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if listID, err = app.createList(ctx, userID, name); err != nil {
+			return err
+		}
+		today := app.Clock.Now()
+		// New todos go on top, so the last one in goes in first.
+		for i := len(todos) - 1; i >= 0; i-- {
+			t := todos[i]
+			var due *time.Time
+			if t.DueIn != 0 {
+				d := today.AddDate(0, 0, t.DueIn)
+				due = &d
+			}
+			_, err := app.db(ctx).ExecContext(ctx, `
+				INSERT INTO todos (list_id, title, description, estimate_minutes, due_date, completed, completed_at, position)
+				VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $6 THEN NOW() END, `+store.NextPosition(1)+`)`,
+				listID, t.Title, t.Description, t.Estimate, due, t.Done,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return listID, err
+}
+
+// resetDemo deletes demo accounts past their time, with their lists. A
+// visitor whose account goes mid-visit is sent back to the login page,
+// where they can start afresh.
+func (app *Application) resetDemo(ctx context.Context) error {
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		_, err := app.db(ctx).ExecContext(ctx, `
+			DELETE FROM lists WHERE id IN (
+				SELECT m.list_id FROM list_members m JOIN users u ON u.id = m.user_id
+				WHERE u.demo_expires_at < NOW()
+			)`)
+		if err != nil {
+			return err
+		}
+		res, err := app.db(ctx).ExecContext(ctx, "DELETE FROM users WHERE demo_expires_at < NOW()")
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("deleted %d demo accounts", n)
+		}
+		return nil
+	})
+}
+
+// notInDemo switches off what could be abused on a public demo: anything
+// that emails people, stores files or makes a real account.
+func (app *Application) notInDemo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.Config.Demo {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if htmx.IsRequest(r) {
+			htmx.Retarget(w, "#alerts")
+			htmx.Reswap(w, htmx.InnerHTML)
+		}
+		http.Error(w, "Not available in the demo", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+var (
+	errDependencyCycle = errors.New("that would create a dependency cycle")
+	errDependencyList  = errors.New("blockers must be on the same list")
+)
+
+type dependencyEditor struct {
+	Todo      model.Todo
+	BlockedBy []model.Todo
+	Blocks    []model.Todo
+	Available []model.Todo
+	Error     string
+}
+
+type blockedNotice struct {
+	Todo     model.Todo
+	Blockers []model.Todo
+}
+
+// openBlockers returns the incomplete todos blocking id.
+func (app *Application) openBlockers(ctx context.Context, id int) ([]model.Todo, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(2)+` FROM todos
+		WHERE NOT completed AND id IN (SELECT blocker_id FROM todo_dependencies WHERE todo_id = $1)
+		ORDER BY id DESC`,
+		id, app.Clock.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return store.ScanTodos(rows)
+}
+
+func (app *Application) addDependency(ctx context.Context, todoID, blockerID int) error {
+	if todoID == blockerID {
+		return errDependencyCycle
+	}
+
+	// The checks and the insert run serializably, so two people linking the
+	// same pair of todos in opposite directions at once can't both succeed
+	// and leave a cycle behind; the loser is retried and sees the winner.
+	return app.withTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context) error {
+		db := app.db(ctx)
+		var sameList bool
+		err := db.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM todos t JOIN todos b ON b.list_id = t.list_id
+				WHERE t.id = $1 AND b.id = $2
+			)`,
+			todoID, blockerID,
+		).Scan(&sameList)
+		if err != nil {
+			return err
+		}
+		if !sameList {
+			return errDependencyList
+		}
+
+		// Walk everything the blocker (transitively) waits on; if that
+		// includes the todo itself, linking them would deadlock both.
+		var cycle bool
+		err = db.QueryRowContext(ctx, `
+			WITH RECURSIVE chain AS (
+				SELECT blocker_id FROM todo_dependencies WHERE todo_id = $1
+				UNION
+				SELECT d.blocker_id FROM todo_dependencies d JOIN chain c ON d.todo_id = c.blocker_id
+			)
+			SELECT EXISTS (SELECT 1 FROM chain WHERE blocker_id = $2)`,
+			blockerID, todoID,
+		).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return errDependencyCycle
+		}
+
+		_, err = db.ExecContext(ctx,
+			"INSERT INTO todo_dependencies (todo_id, blocker_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			todoID, blockerID,
+		)
+		return err
+	})
+}
+
+func (app *Application) dependencyEditor(ctx context.Context, id int) (dependencyEditor, error) {
+	var ed dependencyEditor
+	var err error
+	if ed.Todo, err = app.Queries.GetTodo(ctx, id); err != nil {
+		return ed, err
+	}
+
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(2)+` FROM todos
+		WHERE id IN (SELECT blocker_id FROM todo_dependencies WHERE todo_id = $1)
+		ORDER BY id DESC`,
+		id, app.Clock.Now(),
+	)
+	if err != nil {
+		return ed, err
+	}
+	if ed.BlockedBy, err = store.ScanTodos(rows); err != nil {
+		return ed, err
+	}
+
+	rows, err = app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(2)+` FROM todos
+		WHERE id IN (SELECT todo_id FROM todo_dependencies WHERE blocker_id = $1)
+		ORDER BY id DESC`,
+		id, app.Clock.Now(),
+	)
+	if err != nil {
+		return ed, err
+	}
+	if ed.Blocks, err = store.ScanTodos(rows); err != nil {
+		return ed, err
+	}
+
+	rows, err = app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(3)+` FROM todos
+		WHERE id <> $1 AND list_id = $2 AND NOT completed
+		  AND id NOT IN (SELECT blocker_id FROM todo_dependencies WHERE todo_id = $1)
+		ORDER BY id DESC`,
+		id, ed.Todo.ListID, app.Clock.Now(),
+	)
+	if err != nil {
+		return ed, err
+	}
+	ed.Available, err = store.ScanTodos(rows)
+	return ed, err
+}
+
+func (app *Application) renderDependencyEditor(w http.ResponseWriter, r *http.Request, id int, message string) {
+	ed, err := app.dependencyEditor(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ed.Error = message
+
+	app.Templates.ExecuteTemplate(w, "dependencies.html", ed)
+}
+
+func (app *Application) getDependencies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	app.renderDependencyEditor(w, r, id, "")
+}
+
+func (app *Application) createDependency(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	blockerID, err := strconv.Atoi(r.FormValue("blocker_id"))
+	if err != nil {
+		http.Error(w, "Pick a todo", http.StatusBadRequest)
+		return
+	}
+
+	message := ""
+	if err := app.addDependency(r.Context(), id, blockerID); errors.Is(err, errDependencyCycle) || errors.Is(err, errDependencyList) {
+		message = err.Error()
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.renderDependencyEditor(w, r, id, message)
+}
+
+func (app *Application) deleteDependency(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	_, err = app.DB.ExecContext(r.Context(),
+		"DELETE FROM todo_dependencies WHERE todo_id = $1 AND blocker_id = $2",
+		id, chi.URLParam(r, "blockerID"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.renderDependencyEditor(w, r, id, "")
+}
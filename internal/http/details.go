@@ -0,0 +1,87 @@
+package http
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/linkpreview"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// maxPreviews is how many links in a description get a preview card.
+const maxPreviews = 3
+
+type todoDetail struct {
+	Todo        model.Todo
+	Links       []string
+	Attachments []model.Attachment
+	// SyncedURL is the tracker item the todo is synced with, if any.
+	SyncedURL string
+	CanEdit   bool
+	// Assistant is whether the todo can be broken down into steps.
+	Assistant bool
+	Error     string
+}
+
+// getDetails shows a todo's description and attachments in its panel.
+func (app *Application) getDetails(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	app.renderDetail(w, r, todo, "")
+}
+
+func (app *Application) renderDetail(w http.ResponseWriter, r *http.Request, todo model.Todo, message string) {
+	attachments, err := app.Queries.ListAttachments(r.Context(), todo.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	syncedURL, err := app.syncedURL(r.Context(), todo.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := todoDetail{
+		Todo:        todo,
+		Links:       linkpreview.URLs(todo.Description, maxPreviews),
+		Attachments: attachments,
+		SyncedURL:   syncedURL,
+		CanEdit:     listAccess(r).Role >= model.Editor,
+		Assistant:   app.Assistant != nil,
+		Error:       message,
+	}
+	app.render(w, r, view{Fragment: "todo-detail", Data: data, JSON: todo})
+}
+
+// getPreviews renders preview cards for the links in a todo's description.
+// Links not in the cache are fetched side by side, so one slow site costs
+// the fetcher's timeout rather than one per link.
+func (app *Application) getPreviews(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	links := linkpreview.URLs(todo.Description, maxPreviews)
+	previews := make([]linkpreview.Preview, len(links))
+	errs := make([]error, len(links))
+	var wg sync.WaitGroup
+	for i, link := range links {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			previews[i], errs[i] = app.Previews.Get(r.Context(), link)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.render(w, r, view{Fragment: "link-previews", Data: previews, JSON: previews})
+}
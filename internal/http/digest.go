@@ -0,0 +1,241 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+type digestSubscription struct {
+	ID         int
+	UserID     int
+	Email      string
+	Weekday    int
+	Hour       int
+	Timezone   string
+	Token      string
+	LastSentAt *time.Time
+}
+
+// dueAt reports whether now is the weekday and hour the subscriber asked
+// for, in their time zone.
+func (s digestSubscription) dueAt(now time.Time) bool {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	return int(local.Weekday()) == s.Weekday && local.Hour() == s.Hour
+}
+
+type digest struct {
+	BaseURL        string
+	Token          string
+	CompletedCount int
+	Overdue        []model.Todo
+	Upcoming       []model.Todo
+}
+
+type digestPage struct {
+	Page
+	Weekdays []time.Weekday
+	Hours    []int
+	Message  string
+}
+
+func newToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (app *Application) digestPage(r *http.Request, message string) digestPage {
+	d := digestPage{Page: app.page(r), Message: message}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		d.Weekdays = append(d.Weekdays, wd)
+	}
+	for h := 0; h < 24; h++ {
+		d.Hours = append(d.Hours, h)
+	}
+	return d
+}
+
+func (app *Application) digestHandler(w http.ResponseWriter, r *http.Request) {
+	app.Templates.ExecuteTemplate(w, "digest.html", app.digestPage(r, ""))
+}
+
+// subscribeDigest records a pending subscription and emails a confirmation
+// link; nothing is sent on schedule until the address is confirmed.
+func (app *Application) subscribeDigest(w http.ResponseWriter, r *http.Request) {
+	addr, err := mail.ParseAddress(r.FormValue("email"))
+	if err != nil {
+		http.Error(w, "Enter a valid email address", http.StatusBadRequest)
+		return
+	}
+	weekday, err := strconv.Atoi(r.FormValue("weekday"))
+	if err != nil || weekday < 0 || weekday > 6 {
+		http.Error(w, "Pick a day of the week", http.StatusBadRequest)
+		return
+	}
+	hour, err := strconv.Atoi(r.FormValue("hour"))
+	if err != nil || hour < 0 || hour > 23 {
+		http.Error(w, "Pick an hour", http.StatusBadRequest)
+		return
+	}
+	tz := strings.TrimSpace(r.FormValue("timezone"))
+	if _, err := time.LoadLocation(tz); err != nil || tz == "" {
+		tz = "UTC"
+	}
+
+	user, _ := currentUser(r)
+	var token string
+	err = app.DB.QueryRowContext(r.Context(), `
+		INSERT INTO digest_subscriptions (email, weekday, hour, timezone, token, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (email) DO UPDATE SET weekday = $2, hour = $3, timezone = $4, user_id = $6
+		RETURNING token`,
+		strings.ToLower(addr.Address), weekday, hour, tz, newToken(), user.ID,
+	).Scan(&token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.sendEmail(r.Context(), addr.Address, "Confirm your weekly digest", "digest-confirm", map[string]string{
+		"BaseURL": app.Config.BaseURL,
+		"Token":   token,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "digest-message", "Check your inbox for a confirmation link. 📬")
+}
+
+func (app *Application) confirmDigest(w http.ResponseWriter, r *http.Request) {
+	res, err := app.DB.ExecContext(r.Context(),
+		"UPDATE digest_subscriptions SET confirmed = TRUE WHERE token = $1",
+		r.URL.Query().Get("token"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	message := "You're subscribed to the weekly digest. ✅"
+	if n, _ := res.RowsAffected(); n == 0 {
+		message = "That confirmation link is invalid or has been used to unsubscribe."
+	}
+	app.Templates.ExecuteTemplate(w, "digest.html", app.digestPage(r, message))
+}
+
+func (app *Application) unsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	_, err := app.DB.ExecContext(r.Context(),
+		"DELETE FROM digest_subscriptions WHERE token = $1",
+		r.URL.Query().Get("token"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "digest.html", app.digestPage(r, "You've been unsubscribed. 👋"))
+}
+
+// sendDigests emails every confirmed subscriber whose chosen weekday and hour
+// it currently is in their timezone. It runs several times an hour; the
+// last_sent_at check keeps each subscriber to one digest per slot.
+func (app *Application) sendDigests(ctx context.Context) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT id, user_id, email, weekday, hour, timezone, token, last_sent_at
+		FROM digest_subscriptions
+		WHERE confirmed AND user_id IS NOT NULL AND (last_sent_at IS NULL OR last_sent_at < NOW() - INTERVAL '1 day')`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var due []digestSubscription
+	now := app.Clock.Now()
+	for rows.Next() {
+		var s digestSubscription
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Email, &s.Weekday, &s.Hour, &s.Timezone, &s.Token, &s.LastSentAt); err != nil {
+			return err
+		}
+		if s.dueAt(now) {
+			due = append(due, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	for _, s := range due {
+		d, err := app.buildDigest(ctx, s.UserID)
+		if err != nil {
+			return err
+		}
+		d.Token = s.Token
+		if err := app.sendEmail(ctx, s.Email, "Your week in todos", "digest", d); err != nil {
+			log.Printf("digest to %s failed: %v", s.Email, err)
+			continue
+		}
+		_, err = app.DB.ExecContext(ctx, "UPDATE digest_subscriptions SET last_sent_at = NOW() WHERE id = $1", s.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDigest summarizes the todos on userID's lists.
+func (app *Application) buildDigest(ctx context.Context, userID int) (digest, error) {
+	d := digest{BaseURL: app.Config.BaseURL}
+
+	err := app.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM todos WHERE completed AND completed_at >= NOW() - INTERVAL '7 days' AND list_id IN "+store.MemberLists(1),
+		userID,
+	).Scan(&d.CompletedCount)
+	if err != nil {
+		return d, err
+	}
+
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(3)+` FROM todos
+		WHERE NOT completed AND due_date < $2::date AND list_id IN `+store.MemberLists(1)+`
+		ORDER BY due_date`,
+		userID, app.todayDate(), app.Clock.Now(),
+	)
+	if err != nil {
+		return d, err
+	}
+	if d.Overdue, err = store.ScanTodos(rows); err != nil {
+		return d, err
+	}
+
+	rows, err = app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(3)+` FROM todos
+		WHERE NOT completed AND due_date BETWEEN $2::date AND $2::date + 7 AND list_id IN `+store.MemberLists(1)+`
+		ORDER BY due_date`,
+		userID, app.todayDate(), app.Clock.Now(),
+	)
+	if err != nil {
+		return d, err
+	}
+	d.Upcoming, err = store.ScanTodos(rows)
+	return d, err
+}
@@ -0,0 +1,289 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// downloadsPerDay is how many exports to download one account can ask
+	// for in a day. Only one is made at a time.
+	downloadsPerDay = 5
+	// downloadTTL is how long an export can be downloaded once it's ready.
+	downloadTTL = 24 * time.Hour
+	// downloadClaimTimeout is how long the job that claimed an export has
+	// to make it before another takes it over.
+	downloadClaimTimeout = 10 * time.Minute
+	// downloadAttempts is how many times an export is tried before it's
+	// marked failed.
+	downloadAttempts = 3
+)
+
+// Where an export to download is at.
+const (
+	downloadPending = "pending"
+	downloadReady   = "ready"
+	downloadFailed  = "failed"
+)
+
+// exportDownload is an export of a user's todos made in the background for
+// them to download.
+type exportDownload struct {
+	ID        int
+	Format    string
+	Status    string
+	Error     string
+	Size      int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (d exportDownload) Pending() bool { return d.Status == downloadPending }
+func (d exportDownload) Ready() bool   { return d.Status == downloadReady }
+
+// downloadsForm is the settings section for exports to download.
+type downloadsForm struct {
+	Downloads []exportDownload
+	Error     string
+}
+
+const exportDownloadColumns = "id, format, status, error, COALESCE(length(data), 0), created_at, COALESCE(expires_at, created_at)"
+
+func scanExportDownload(row interface{ Scan(...any) error }) (exportDownload, error) {
+	var d exportDownload
+	err := row.Scan(&d.ID, &d.Format, &d.Status, &d.Error, &d.Size, &d.CreatedAt, &d.ExpiresAt)
+	return d, err
+}
+
+// exportDownloads returns userID's exports that are being made or can
+// still be downloaded, newest first.
+func (app *Application) exportDownloads(ctx context.Context, userID int) ([]exportDownload, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT `+exportDownloadColumns+` FROM export_downloads
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var downloads []exportDownload
+	for rows.Next() {
+		d, err := scanExportDownload(rows)
+		if err != nil {
+			return nil, err
+		}
+		downloads = append(downloads, d)
+	}
+	return downloads, rows.Err()
+}
+
+func (app *Application) renderDownloads(w http.ResponseWriter, r *http.Request, form downloadsForm) {
+	user, _ := currentUser(r)
+	var err error
+	if form.Downloads, err = app.exportDownloads(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "export-downloads", form)
+}
+
+// requestDownload queues an export of the caller's todos, in the format
+// posted, for the generate-exports job to make. A user can have one being
+// made at a time and downloadsPerDay a day, so nobody can keep the job busy
+// for everyone else.
+func (app *Application) requestDownload(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	format := r.FormValue("format")
+	if format != "json" && format != "csv" {
+		app.renderDownloads(w, r, downloadsForm{Error: "Pick JSON or CSV."})
+		return
+	}
+	var pending, today int
+	err := app.DB.QueryRowContext(r.Context(), `
+		SELECT COUNT(*) FILTER (WHERE status = 'pending'), COUNT(*)
+		FROM export_downloads WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 day'`,
+		user.ID,
+	).Scan(&pending, &today)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case pending > 0:
+		app.renderDownloads(w, r, downloadsForm{Error: "Your last export is still being made. You can ask for another once it's ready."})
+		return
+	case today >= downloadsPerDay:
+		app.renderDownloads(w, r, downloadsForm{Error: fmt.Sprintf("You can export up to %d times a day. Try again tomorrow.", downloadsPerDay)})
+		return
+	}
+	// Two requests at once can both get past the counts; the limits are to
+	// keep the job fair, not exact.
+	if _, err := app.DB.ExecContext(r.Context(),
+		"INSERT INTO export_downloads (user_id, format) VALUES ($1, $2)", user.ID, format,
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderDownloads(w, r, downloadsForm{})
+}
+
+// downloadStatus renders one of the caller's exports, which polls while
+// it's being made. The poll that finds it done brings a toast with it.
+func (app *Application) downloadStatus(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	d, err := scanExportDownload(app.DB.QueryRowContext(r.Context(),
+		"SELECT "+exportDownloadColumns+" FROM export_downloads WHERE id = $1 AND user_id = $2", id, user.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "export-download", d)
+	switch d.Status {
+	case downloadReady:
+		app.Templates.RenderComponent(w, "toast", toast{Message: "Your export is ready to download."})
+	case downloadFailed:
+		app.Templates.RenderComponent(w, "toast", toast{Message: "Your export failed: " + d.Error, Error: true})
+	}
+}
+
+// serveDownload sends a ready export to whoever has its link, until it
+// expires. The link is emailed, so it works without logging in, like the
+// digest's.
+func (app *Application) serveDownload(w http.ResponseWriter, r *http.Request) {
+	app.sendDownload(w, r, "token_hash = $1", hashToken(chi.URLParam(r, "token")))
+}
+
+// serveOwnDownload sends one of the caller's ready exports, for the link
+// on the settings page, which can't know the emailed token.
+func (app *Application) serveOwnDownload(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	app.sendDownload(w, r, "id = $1 AND user_id = $2", id, user.ID)
+}
+
+// sendDownload sends the ready, unexpired export where holds.
+func (app *Application) sendDownload(w http.ResponseWriter, r *http.Request, where string, args ...any) {
+	var format, contentType string
+	var created time.Time
+	var data []byte
+	err := app.DB.QueryRowContext(r.Context(), `
+		SELECT format, content_type, created_at, data FROM export_downloads
+		WHERE `+where+` AND status = 'ready' AND expires_at > NOW()`,
+		args...,
+	).Scan(&format, &contentType, &created, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "This download link has expired. Ask for a new export in Settings.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := "todos-" + created.Format("2006-01-02") + "." + format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(data)
+}
+
+// exportReadyEmail is the data for the export-ready email.
+type exportReadyEmail struct {
+	URL     string
+	Format  string
+	Expires time.Time
+}
+
+// generateExports makes the exports waiting to be downloaded, one at a
+// time. Each is claimed before it's made, so with several instances
+// running the job each is made by one; one whose instance died partway is
+// taken over once its claim times out. A finished export's email goes
+// through the outbox in the same transaction that saves it.
+func (app *Application) generateExports(ctx context.Context) error {
+	for {
+		var id, userID, attempts int
+		var format, email string
+		err := app.DB.QueryRowContext(ctx, `
+			UPDATE export_downloads d SET claimed_at = NOW(), attempts = attempts + 1
+			FROM users u
+			WHERE u.id = d.user_id AND d.id = (
+				SELECT id FROM export_downloads
+				WHERE status = 'pending'
+				  AND (claimed_at IS NULL OR claimed_at < NOW() - make_interval(secs => $1::float8))
+				ORDER BY created_at FOR UPDATE SKIP LOCKED LIMIT 1
+			)
+			RETURNING d.id, d.user_id, d.format, d.attempts, u.email`,
+			downloadClaimTimeout.Seconds(),
+		).Scan(&id, &userID, &format, &attempts, &email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := app.generateExport(ctx, id, userID, format, attempts, email); err != nil {
+			return err
+		}
+	}
+}
+
+func (app *Application) generateExport(ctx context.Context, id, userID int, format string, attempts int, email string) error {
+	body, contentType, err := app.exportTodos(ctx, userID, format)
+	if err != nil {
+		requestLog(ctx).Printf("export download %d for user %d (attempt %d): %v", id, userID, attempts, err)
+		if attempts < downloadAttempts {
+			// Left claimed, to be tried again once the claim times out.
+			return nil
+		}
+		_, err = app.DB.ExecContext(ctx,
+			"UPDATE export_downloads SET status = 'failed', error = $2, expires_at = NOW() + make_interval(secs => $3::float8) WHERE id = $1",
+			id, truncate(500, err.Error()), downloadTTL.Seconds())
+		return err
+	}
+
+	// The token is only in the email; the row keeps its hash.
+	token := newToken()
+	expires := time.Now().Add(downloadTTL)
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		if _, err := app.db(ctx).ExecContext(ctx, `
+			UPDATE export_downloads SET status = 'ready', data = $2, content_type = $3, expires_at = $4, token_hash = $5
+			WHERE id = $1`,
+			id, body, contentType, expires, hashToken(token),
+		); err != nil {
+			return err
+		}
+		return app.queueEmail(ctx, email, "Your todo export is ready", "export-ready", exportReadyEmail{
+			URL:     app.Config.BaseURL + "/exports/" + token,
+			Format:  format,
+			Expires: expires,
+		})
+	})
+}
+
+// purgeDownloads deletes the exports whose links have expired.
+func (app *Application) purgeDownloads(ctx context.Context) error {
+	_, err := app.DB.ExecContext(ctx, "DELETE FROM export_downloads WHERE expires_at < NOW()")
+	return err
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// minDueSamples is how many of a user's todos with a word in their
+	// title must have been done before it's used to suggest a due date.
+	minDueSamples = 3
+	// duePatternsWindow is how far back completed todos are learned from.
+	duePatternsWindow = "1 year"
+)
+
+// stopwords are too common in titles to say anything about how long a todo
+// takes.
+var stopwords = []string{
+	"the", "and", "for", "with", "from", "into", "onto", "about", "this", "that",
+	"some", "new", "get", "got", "make", "all", "out", "off", "our", "your",
+	"their", "his", "her", "its", "not", "can", "will", "then", "than",
+}
+
+// titleKeywords are the words in a title that due dates are learned by:
+// lowercase, at least three letters long and not stopwords. It splits the
+// way aggregateDuePatterns does in SQL.
+func titleKeywords(title string) []string {
+	var keywords []string
+	seen := make(map[string]bool)
+	isSeparator := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), isSeparator) {
+		if utf8.RuneCountInString(w) < 3 || seen[w] || slices.Contains(stopwords, w) {
+			continue
+		}
+		seen[w] = true
+		keywords = append(keywords, w)
+	}
+	return keywords
+}
+
+// aggregateDuePatterns works out, for each user and each word in the titles
+// of todos done on their lists in the last year, the median number of days
+// those todos took from being added to being done. Todos from before
+// creation times were recorded are left out. It replaces the whole table
+// each night.
+func (app *Application) aggregateDuePatterns(ctx context.Context) error {
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		db := app.db(ctx)
+		if _, err := db.ExecContext(ctx, "DELETE FROM due_date_patterns"); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(ctx, `
+			WITH done AS (
+				SELECT m.user_id, t.id, t.title, t.completed_at::date - t.created_at::date AS days
+				FROM todos t JOIN list_members m ON m.list_id = t.list_id
+				WHERE t.completed AND t.created_at IS NOT NULL AND t.completed_at >= t.created_at
+				  AND t.completed_at >= NOW() - $1::interval
+			), words AS (
+				SELECT DISTINCT d.user_id, d.id, d.days, w AS keyword
+				FROM done d, regexp_split_to_table(lower(d.title), '[^[:alnum:]]+') AS w
+				WHERE length(w) >= 3 AND w <> ALL($2::text[])
+			)
+			INSERT INTO due_date_patterns (user_id, keyword, days, samples)
+			SELECT user_id, keyword, percentile_disc(0.5) WITHIN GROUP (ORDER BY days), COUNT(*)
+			FROM words
+			GROUP BY user_id, keyword
+			HAVING COUNT(*) >= $3`,
+			duePatternsWindow, pq.Array(stopwords), minDueSamples,
+		)
+		return err
+	})
+}
+
+// dueSuggestion is a due date offered for a todo being added, from how long
+// the user's todos with Keyword in their title have usually taken.
+type dueSuggestion struct {
+	Date    time.Time
+	Days    int
+	Keyword string
+	Samples int
+}
+
+// When is the suggested date as it reads best next to the form.
+func (s dueSuggestion) When() string {
+	switch s.Days {
+	case 0:
+		return "today"
+	case 1:
+		return "tomorrow"
+	}
+	return s.Date.Format("Mon, Jan 2")
+}
+
+// suggestDueDate offers a due date for the title being typed into the add
+// form, as a chip that fills in the date when clicked. The word of the
+// title the user has finished the most todos with decides it. A title with
+// nothing to go on gets an empty response, clearing any earlier chip.
+func (app *Application) suggestDueDate(w http.ResponseWriter, r *http.Request) {
+	keywords := titleKeywords(r.FormValue("title"))
+	if len(keywords) == 0 {
+		return
+	}
+	user, _ := currentUser(r)
+	var s dueSuggestion
+	err := app.DB.QueryRowContext(r.Context(), `
+		SELECT keyword, days, samples FROM due_date_patterns
+		WHERE user_id = $1 AND keyword = ANY($2::text[])
+		ORDER BY samples DESC, keyword
+		LIMIT 1`,
+		user.ID, pq.Array(keywords),
+	).Scan(&s.Keyword, &s.Days, &s.Samples)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.Date = app.today().AddDate(0, 0, s.Days)
+	app.Templates.ExecuteTemplate(w, "due-suggestion", s)
+}
@@ -0,0 +1,226 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// duplicateTodo copies a todo's fields and blocker links into a new open
+// todo on the same list, with copies of its attachments. Tracked time stays
+// with the original.
+func (app *Application) duplicateTodo(ctx context.Context, id int) (int, error) {
+	var newID int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		db := app.db(ctx)
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO todos (list_id, title, description, estimate_minutes, due_date, place, latitude, longitude, custom_fields, position)
+			SELECT list_id, title, description, estimate_minutes, due_date, place, latitude, longitude, custom_fields,
+				(SELECT MAX(p.position) + 1 FROM todos p WHERE p.list_id = todos.list_id)
+			FROM todos WHERE id = $1
+			RETURNING id`,
+			id,
+		).Scan(&newID)
+		if err != nil {
+			return err
+		}
+		if err := app.Queries.CopyAttachments(ctx, id, newID); err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO todo_dependencies (todo_id, blocker_id)
+			SELECT $2, blocker_id FROM todo_dependencies WHERE todo_id = $1`,
+			id, newID,
+		)
+		return err
+	})
+	return newID, err
+}
+
+// duplicateList copies a list, its custom fields and all its todos, completed
+// or not, into a new list owned by userID. Dependencies between the copied
+// todos are re-pointed at the copies, and field values at the new fields.
+// Statuses are copied too. Members and tracked time aren't.
+func (app *Application) duplicateList(ctx context.Context, listID, userID int) (int, error) {
+	var newList int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		newList, err = app.copyList(ctx, listID, userID)
+		return err
+	})
+	return newList, err
+}
+
+func (app *Application) copyList(ctx context.Context, listID, userID int) (int, error) {
+	db := app.db(ctx)
+	var newList int
+	err := db.QueryRowContext(ctx,
+		"INSERT INTO lists (name) SELECT name || ' (copy)' FROM lists WHERE id = $1 RETURNING id",
+		listID,
+	).Scan(&newList)
+	if err != nil {
+		return 0, err
+	}
+	if err := app.Queries.AddMember(ctx, newList, userID, model.Owner); err != nil {
+		return 0, err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO list_fields (list_id, name, kind, options)
+		SELECT $2, name, kind, options FROM list_fields WHERE list_id = $1 ORDER BY id`,
+		listID, newList,
+	)
+	if err != nil {
+		return 0, err
+	}
+	// The copy has the list's statuses rather than the defaults. Copied
+	// todos bring their status along, and sync_todo_status swaps it for
+	// the copy's status of the same name.
+	if _, err := db.ExecContext(ctx, "DELETE FROM list_statuses WHERE list_id = $1", newList); err != nil {
+		return 0, err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO list_statuses (list_id, name, done, position)
+		SELECT $2, name, done, position FROM list_statuses WHERE list_id = $1 ORDER BY position, id`,
+		listID, newList,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id FROM todos WHERE list_id = $1 ORDER BY id", listID)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	copies := make(map[int]int, len(ids))
+	var newIDs []int64
+	for _, id := range ids {
+		var newID int
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO todos (list_id, title, description, completed, completed_at, estimate_minutes, due_date, place, latitude, longitude, custom_fields, position, status_id)
+			SELECT $2, title, description, completed, completed_at, estimate_minutes, due_date, place, latitude, longitude, custom_fields, position, status_id FROM todos WHERE id = $1
+			RETURNING id`,
+			id, newList,
+		).Scan(&newID)
+		if err != nil {
+			return 0, err
+		}
+		if err := app.Queries.CopyAttachments(ctx, id, newID); err != nil {
+			return 0, err
+		}
+		copies[id] = newID
+		newIDs = append(newIDs, int64(newID))
+	}
+	if _, err := db.ExecContext(ctx, remapFields, pq.Array(newIDs)); err != nil {
+		return 0, err
+	}
+
+	if err := copyDependencies(ctx, db, listID, copies); err != nil {
+		return 0, err
+	}
+	return newList, nil
+}
+
+// copyDependencies recreates the links between todos on listID for their
+// copies.
+func copyDependencies(ctx context.Context, db store.DBTX, listID int, copies map[int]int) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.todo_id, d.blocker_id FROM todo_dependencies d
+		JOIN todos t ON t.id = d.todo_id
+		WHERE t.list_id = $1`,
+		listID,
+	)
+	if err != nil {
+		return err
+	}
+	var links [][2]int
+	for rows.Next() {
+		var todoID, blockerID int
+		if err := rows.Scan(&todoID, &blockerID); err != nil {
+			rows.Close()
+			return err
+		}
+		links = append(links, [2]int{todoID, blockerID})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		todoID, blockerID := copies[l[0]], copies[l[1]]
+		if todoID == 0 || blockerID == 0 {
+			continue
+		}
+		_, err := db.ExecContext(ctx,
+			"INSERT INTO todo_dependencies (todo_id, blocker_id) VALUES ($1, $2)",
+			todoID, blockerID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *Application) duplicateTodoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	var newID int
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "duplicate", nil, func(ctx context.Context) ([]int, error) {
+		var err error
+		newID, err = app.duplicateTodo(ctx, id)
+		return []int{newID}, err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	todo, err := app.Queries.GetTodo(r.Context(), newID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	htmx.Trigger(w, "todosChanged")
+	app.Templates.RenderComponent(w, "todo-item", todoRow{Todo: todo, View: listView(r)})
+}
+
+// duplicateListHandler copies a list the caller can see and opens the copy.
+func (app *Application) duplicateListHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	newList, err := app.duplicateList(r.Context(), listAccess(r).ListID, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	htmx.RedirectOrSeeOther(w, r, fmt.Sprintf("/lists/%d", newList))
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// parseEffort reads the optional estimate (in minutes) and due date fields
+// shared by the todo forms.
+func parseEffort(estimate, due string) (int, *time.Time, error) {
+	minutes := 0
+	if estimate = strings.TrimSpace(estimate); estimate != "" {
+		var err error
+		minutes, err = strconv.Atoi(estimate)
+		if err != nil || minutes < 0 {
+			return 0, nil, errors.New("Estimate must be a whole number of minutes")
+		}
+	}
+
+	var dueDate *time.Time
+	if due = strings.TrimSpace(due); due != "" {
+		d, err := time.Parse("2006-01-02", due)
+		if err != nil {
+			return 0, nil, errors.New("Due date must be YYYY-MM-DD")
+		}
+		dueDate = &d
+	}
+	return minutes, dueDate, nil
+}
+
+type DayEffort struct {
+	Day      time.Time
+	Minutes  int
+	Count    int
+	Capacity int
+	Today    bool
+}
+
+func (d DayEffort) Total() string {
+	return model.FormatDuration(int64(d.Minutes) * 60)
+}
+
+func (d DayEffort) Overbooked() bool {
+	return d.Minutes > d.Capacity
+}
+
+type effortSummary struct {
+	OpenMinutes    int
+	OpenCount      int
+	Unestimated    int
+	OverdueMinutes int
+	OverdueCount   int
+	Days           []DayEffort
+}
+
+func (e effortSummary) Open() string {
+	return model.FormatDuration(int64(e.OpenMinutes) * 60)
+}
+
+func (e effortSummary) Overdue() string {
+	return model.FormatDuration(int64(e.OverdueMinutes) * 60)
+}
+
+func (app *Application) effortHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	summary, err := cache.Load(r.Context(), app.Cache, effortKey(user.ID, app.todayDate()), func() (effortSummary, error) {
+		return app.effortSummary(r.Context(), user.ID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "effort.html", summary)
+}
+
+// effortSummary totals estimates for open todos across the user's lists:
+// overall, for anything overdue, and for each of the next seven days.
+func (app *Application) effortSummary(ctx context.Context, userID int) (effortSummary, error) {
+	var sum effortSummary
+	err := app.DB.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(estimate_minutes), 0),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE estimate_minutes = 0),
+			COALESCE(SUM(estimate_minutes) FILTER (WHERE due_date < $2::date), 0),
+			COUNT(*) FILTER (WHERE due_date < $2::date)
+		FROM todos WHERE NOT completed AND list_id IN `+store.MemberLists(1),
+		userID, app.todayDate(),
+	).Scan(&sum.OpenMinutes, &sum.OpenCount, &sum.Unestimated, &sum.OverdueMinutes, &sum.OverdueCount)
+	if err != nil {
+		return sum, err
+	}
+
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT d.day, COALESCE(SUM(t.estimate_minutes), 0), COUNT(t.id), d.day = $2::date
+		FROM generate_series($2::date, $2::date + 6, INTERVAL '1 day') AS d(day)
+		LEFT JOIN todos t ON t.due_date = d.day::DATE AND NOT t.completed AND t.list_id IN `+store.MemberLists(1)+`
+		GROUP BY d.day
+		ORDER BY d.day`,
+		userID, app.todayDate(),
+	)
+	if err != nil {
+		return sum, err
+	}
+	defer rows.Close()
+
+	capacity := app.Config.DailyCapacityMinutes
+	for rows.Next() {
+		day := DayEffort{Capacity: capacity}
+		if err := rows.Scan(&day.Day, &day.Minutes, &day.Count, &day.Today); err != nil {
+			return sum, err
+		}
+		sum.Days = append(sum.Days, day)
+	}
+	return sum, rows.Err()
+}
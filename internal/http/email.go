@@ -0,0 +1,49 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	texttemplate "text/template"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/mail"
+)
+
+// EmailTemplates holds the HTML and plain-text versions of each email in
+// templates/email, named like digest.html and digest.txt.
+type EmailTemplates struct {
+	HTML *template.Template
+	Text *texttemplate.Template
+}
+
+func parseEmailTemplates(dir string) EmailTemplates {
+	return EmailTemplates{
+		HTML: template.Must(template.ParseGlob(dir + "/*.html")),
+		Text: texttemplate.Must(texttemplate.ParseGlob(dir + "/*.txt")),
+	}
+}
+
+// sendEmail renders the named email template in both formats and sends it.
+func (app *Application) sendEmail(ctx context.Context, to, subject, name string, data any) error {
+	msg, err := app.renderEmail(to, subject, name, data)
+	if err != nil {
+		return err
+	}
+	return app.Mailer.Send(ctx, msg)
+}
+
+func (app *Application) renderEmail(to, subject, name string, data any) (mail.Message, error) {
+	var html, text bytes.Buffer
+	if err := app.Emails.HTML.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := app.Emails.Text.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return mail.Message{}, err
+	}
+	return mail.Message{
+		To:      to,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
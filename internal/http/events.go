@@ -0,0 +1,167 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+const (
+	// eventPoll is how often a stream checks for new events while
+	// notifications aren't arriving. Events are written by a trigger on
+	// whichever instance made the change, which also NOTIFYs todo_events
+	// with the list's ID, and streams read them back from the table when
+	// told.
+	eventPoll = 2 * time.Second
+	// eventPollListening is how often a stream checks anyway while
+	// notifications are arriving, in case one is lost.
+	eventPollListening = 30 * time.Second
+	// eventBatch is the most events read at a time, so catching up on a
+	// busy list doesn't load its whole history at once.
+	eventBatch = 500
+)
+
+// apiListEvents streams the changes to a list's todos as they happen:
+// server-sent events when asked for text/event-stream, otherwise one JSON
+// object per line. A stream starts after the list's latest event, or after
+// the one named by Last-Event-ID or ?after=, so a client that reconnects
+// picks up where it left off. Events are kept for 30 days; resuming from
+// before that answers 410, and the client should load the list afresh.
+func (app *Application) apiListEvents(w http.ResponseWriter, r *http.Request) {
+	listID := listAccess(r).ListID
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("after")
+	}
+	first, last, err := app.Queries.EventRange(r.Context(), listID)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	after := last
+	if cursor != "" {
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil || after < 0 || after > last {
+			jsonError(w, http.StatusBadRequest, "No such event on this list")
+			return
+		}
+		if after < last && after+1 < first {
+			jsonError(w, http.StatusGone, fmt.Sprintf("Events up to %d are no longer kept; load the list again and stream from %d", first-1, last))
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	// send writes every event after the cursor, a batch at a time.
+	send := func() error {
+		for {
+			events, err := app.Queries.ListEvents(r.Context(), listID, after, eventBatch)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				if err := writeEvent(w, e, sse); err != nil {
+					return err
+				}
+				after = e.ID
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+			if len(events) < eventBatch {
+				return nil
+			}
+		}
+	}
+
+	// Subscribed before the first send, so a change made in between still
+	// wakes the stream.
+	changed, unsubscribe := app.Notifications.Subscribe(strconv.Itoa(listID))
+	defer unsubscribe()
+	poll := time.NewTimer(eventPoll)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(presenceKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		if err := send(); err != nil {
+			if r.Context().Err() == nil {
+				requestLog(r.Context()).Printf("event stream: %v", err)
+			}
+			return
+		}
+		if app.Notifications.Listening() {
+			poll.Reset(eventPollListening)
+		} else {
+			poll.Reset(eventPoll)
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-poll.C:
+		case <-keepAlive.C:
+			if app.Ready.draining.Load() {
+				return
+			}
+			// A comment in an event stream, a blank line in NDJSON.
+			if sse {
+				fmt.Fprint(w, ": keep-alive\n\n")
+			} else {
+				fmt.Fprint(w, "\n")
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e model.TodoEvent, sse bool) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if sse {
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+	} else {
+		_, err = fmt.Fprintf(w, "%s\n", b)
+	}
+	return err
+}
+
+// listenURL is the connection notifications are listened for on:
+// DATABASE_DIRECT_URL if set, as LISTEN needs a session of its own,
+// otherwise DATABASE_URL unless that goes through a transaction pooler,
+// where streams can only poll.
+func listenURL(cfg config.Config) string {
+	if cfg.DirectDatabaseURL != "" {
+		return cfg.DirectDatabaseURL
+	}
+	if cfg.TransactionPooling {
+		return ""
+	}
+	return cfg.DatabaseURL
+}
+
+// purgeTodoEvents drops events older than a stream may resume from.
+func (app *Application) purgeTodoEvents(ctx context.Context) error {
+	_, err := app.purgeBefore(ctx, todoEventsTable, time.Now().AddDate(0, 0, -30))
+	return err
+}
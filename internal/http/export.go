@@ -0,0 +1,73 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// exportMarkdown writes a list as a GitHub task list, ready to paste into
+// an issue or wiki page: one "- [ ]" or "- [x]" item per todo with its due
+// date, and its description indented underneath so it stays part of the
+// item. Titles are already Markdown, so they go in as they are. Todos are
+// written as they're read, up to exportRowLimit.
+func (app *Application) exportMarkdown(w http.ResponseWriter, r *http.Request) {
+	list, err := app.loadList(r.Context(), listAccess(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	counts, err := app.Queries.TodoCounts(r.Context(), list.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": list.Name + ".md"}))
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "# %s\n\n", list.Name)
+	stream := newRowStream(w)
+	err = app.Queries.EachTodo(r.Context(), list.ID, exportRowLimit, func(t model.Todo) error {
+		_, err := io.WriteString(w, taskItem(t))
+		stream.row()
+		return err
+	})
+	// The response has started, so all that's left is to say it's cut
+	// short.
+	if err != nil {
+		requestLog(r.Context()).Printf("export %d: %v", list.ID, err)
+		fmt.Fprintf(w, "\n_The export stopped after %s: %v_\n", pluralize(stream.rows, "todo", "todos"), err)
+	} else if total := counts.Open + counts.Completed; stream.rows == exportRowLimit && total > stream.rows {
+		fmt.Fprintf(w, "\n_Only the first %d of %d todos are exported._\n", stream.rows, total)
+	}
+}
+
+// taskItem is a todo as a task list item.
+func taskItem(t model.Todo) string {
+	var b strings.Builder
+	box := " "
+	if t.Completed {
+		box = "x"
+	}
+	fmt.Fprintf(&b, "- [%s] %s", box, strings.TrimSpace(t.Title))
+	if t.DueDate != nil {
+		fmt.Fprintf(&b, " (due %s)", t.DueDate.Format("2006-01-02"))
+	}
+	b.WriteString("\n")
+	if t.Description != "" {
+		for _, line := range strings.Split(strings.TrimRight(t.Description, "\n"), "\n") {
+			if line = strings.TrimRight(line, " \r"); line != "" {
+				b.WriteString("  " + line)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
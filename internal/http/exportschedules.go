@@ -0,0 +1,370 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/backup"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// maxExportSchedules is how many scheduled exports one account can have.
+const maxExportSchedules = 5
+
+// Where a scheduled export can be delivered.
+const (
+	exportToS3  = "s3"
+	exportToGCS = "gcs"
+)
+
+// gcsEndpoint is Cloud Storage's XML API, which speaks S3 to HMAC keys.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// nextExportRun is when a schedule claimed now runs next: the coming 2am
+// in its timezone.
+const nextExportRun = `(date_trunc('day', NOW() AT TIME ZONE timezone - INTERVAL '2 hours') + INTERVAL '1 day 2 hours') AT TIME ZONE timezone`
+
+// exportSchedule is a nightly export of a user's todos to their bucket,
+// with how the last delivery went.
+type exportSchedule struct {
+	ID        int
+	UserID    int
+	Provider  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	Endpoint  string
+	Format    string
+	Timezone  string
+	NextRunAt time.Time
+	LastRunAt time.Time
+	LastError string
+	LastKey   string
+	LastSize  int64
+	// credentials are the sealed exportCredentials.
+	credentials string
+}
+
+// Destination is the bucket and prefix as a URL, like s3://bucket/todos/.
+func (s exportSchedule) Destination() string {
+	scheme := "s3://"
+	if s.Provider == exportToGCS {
+		scheme = "gs://"
+	}
+	return scheme + s.Bucket + "/" + s.Prefix
+}
+
+// exportCredentials are the keys a schedule uploads with: an access key
+// for S3, or an HMAC key for Cloud Storage.
+type exportCredentials struct {
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// exportsForm is the scheduled exports section of the settings page.
+// Enabled is false when there's no ENCRYPTION_KEY to seal credentials with.
+type exportsForm struct {
+	Schedules []exportSchedule
+	Enabled   bool
+	Message   string
+	Error     string
+}
+
+const exportScheduleColumns = `id, user_id, provider, bucket, prefix, region, endpoint, format, timezone,
+	next_run_at, last_run_at, last_error, last_key, last_size, credentials`
+
+func scanExportSchedule(row interface{ Scan(...any) error }) (exportSchedule, error) {
+	var s exportSchedule
+	var lastRun sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.Provider, &s.Bucket, &s.Prefix, &s.Region, &s.Endpoint, &s.Format, &s.Timezone,
+		&s.NextRunAt, &lastRun, &s.LastError, &s.LastKey, &s.LastSize, &s.credentials)
+	s.LastRunAt = lastRun.Time
+	return s, err
+}
+
+func (app *Application) exportSchedules(ctx context.Context, userID int) ([]exportSchedule, error) {
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT "+exportScheduleColumns+" FROM export_schedules WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var schedules []exportSchedule
+	for rows.Next() {
+		s, err := scanExportSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+func (app *Application) renderExports(w http.ResponseWriter, r *http.Request, form exportsForm) {
+	user, _ := currentUser(r)
+	form.Enabled = app.Secrets != nil
+	var err error
+	if form.Schedules, err = app.exportSchedules(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "export-schedules", form)
+}
+
+// createExportSchedule saves a nightly export to the bucket posted, with
+// its credentials sealed. The first export runs right away rather than
+// that night, so a mistyped key shows up while it's still fresh.
+func (app *Application) createExportSchedule(w http.ResponseWriter, r *http.Request) {
+	if app.Secrets == nil {
+		http.Error(w, "Scheduled exports aren't set up on this server", http.StatusNotFound)
+		return
+	}
+	user, _ := currentUser(r)
+	s := exportSchedule{
+		Provider: r.FormValue("provider"),
+		Bucket:   strings.TrimSpace(r.FormValue("bucket")),
+		Prefix:   strings.Trim(strings.TrimSpace(r.FormValue("prefix")), "/"),
+		Region:   strings.TrimSpace(r.FormValue("region")),
+		Endpoint: strings.TrimSuffix(strings.TrimSpace(r.FormValue("endpoint")), "/"),
+		Format:   r.FormValue("format"),
+		Timezone: strings.TrimSpace(r.FormValue("timezone")),
+	}
+	creds := exportCredentials{
+		AccessKey: strings.TrimSpace(r.FormValue("access_key")),
+		SecretKey: strings.TrimSpace(r.FormValue("secret_key")),
+	}
+	if s.Prefix != "" {
+		s.Prefix += "/"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+
+	var problem string
+	switch {
+	case s.Provider != exportToS3 && s.Provider != exportToGCS:
+		problem = "Pick S3 or Cloud Storage"
+	case s.Format != "json" && s.Format != "csv":
+		problem = "Pick JSON or CSV"
+	case s.Bucket == "":
+		problem = "Bucket required"
+	case s.Provider == exportToS3 && s.Region == "" && s.Endpoint == "":
+		problem = "Region required for S3"
+	case s.Endpoint != "" && !strings.HasPrefix(s.Endpoint, "https://"):
+		problem = "The endpoint must be an https:// URL"
+	case creds.AccessKey == "" || creds.SecretKey == "":
+		problem = "Both keys are required"
+	}
+	if problem != "" {
+		app.renderExports(w, r, exportsForm{Error: problem})
+		return
+	}
+
+	plain, err := json.Marshal(creds)
+	if err == nil {
+		s.credentials, err = app.Secrets.Seal(plain)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := app.DB.ExecContext(r.Context(), `
+		INSERT INTO export_schedules (user_id, provider, bucket, prefix, region, endpoint, format, timezone, credentials)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+		WHERE (SELECT COUNT(*) FROM export_schedules WHERE user_id = $1) < $10`,
+		user.ID, s.Provider, s.Bucket, s.Prefix, s.Region, s.Endpoint, s.Format, s.Timezone, s.credentials, maxExportSchedules,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		app.renderExports(w, r, exportsForm{Error: "You can have up to " + strconv.Itoa(maxExportSchedules) + " scheduled exports."})
+		return
+	}
+	app.renderExports(w, r, exportsForm{Message: "Saved. The first export runs within a minute."})
+}
+
+func (app *Application) deleteExportSchedule(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if _, err := app.DB.ExecContext(r.Context(), "DELETE FROM export_schedules WHERE id = $1 AND user_id = $2", id, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderExports(w, r, exportsForm{})
+}
+
+// runExportNow queues an export to run on the job's next pass, instead of
+// waiting for the night.
+func (app *Application) runExportNow(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	res, err := app.DB.ExecContext(r.Context(),
+		"UPDATE export_schedules SET next_run_at = NOW() WHERE id = $1 AND user_id = $2", id, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		app.notFound(w, r)
+		return
+	}
+	app.renderExports(w, r, exportsForm{Message: "Queued. It runs within a minute."})
+}
+
+// runScheduledExports delivers the exports that are due. Each is moved on
+// to its next night as it's claimed, so with several instances running
+// the job each is run by one, and a failed delivery is tried again the
+// next night unless its owner runs it sooner.
+func (app *Application) runScheduledExports(ctx context.Context) error {
+	if app.Secrets == nil {
+		return nil
+	}
+	rows, err := app.DB.QueryContext(ctx, `
+		UPDATE export_schedules SET next_run_at = `+nextExportRun+`
+		WHERE id IN (
+			SELECT id FROM export_schedules WHERE next_run_at <= NOW()
+			ORDER BY next_run_at FOR UPDATE SKIP LOCKED LIMIT 20
+		)
+		RETURNING `+exportScheduleColumns,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var due []exportSchedule
+	for rows.Next() {
+		s, err := scanExportSchedule(rows)
+		if err != nil {
+			return err
+		}
+		due = append(due, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range due {
+		key, size, err := app.runExport(ctx, s)
+		message := ""
+		if err != nil {
+			requestLog(ctx).Printf("export %d for user %d to %s: %v", s.ID, s.UserID, s.Destination(), err)
+			message = truncate(500, err.Error())
+		}
+		_, err = app.DB.ExecContext(ctx, `
+			UPDATE export_schedules SET last_run_at = NOW(), last_error = $2,
+				last_key = CASE WHEN $2 = '' THEN $3 ELSE last_key END,
+				last_size = CASE WHEN $2 = '' THEN $4 ELSE last_size END
+			WHERE id = $1`,
+			s.ID, message, key, size,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runExport uploads the schedule owner's todos, named for today in the
+// schedule's timezone, and returns the key and size they were uploaded as.
+func (app *Application) runExport(ctx context.Context, s exportSchedule) (string, int64, error) {
+	plain, err := app.Secrets.Open(s.credentials)
+	if err != nil {
+		return "", 0, errors.New("the credentials can't be read; if ENCRYPTION_KEY was changed, the old key belongs in ENCRYPTION_OLD_KEYS")
+	}
+	var creds exportCredentials
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return "", 0, err
+	}
+	body, contentType, err := app.exportTodos(ctx, s.UserID, s.Format)
+	if err != nil {
+		return "", 0, err
+	}
+
+	client := backup.S3{Endpoint: s.Endpoint, Region: s.Region, AccessKey: creds.AccessKey, SecretKey: creds.SecretKey}
+	if s.Provider == exportToGCS {
+		client.Endpoint, client.Region = gcsEndpoint, "auto"
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	key := s.Prefix + "todos-" + time.Now().In(loc).Format("2006-01-02") + "." + s.Format
+	if err := client.Upload(ctx, s.Bucket, key, contentType, bytes.NewReader(body)); err != nil {
+		return "", 0, err
+	}
+	return key, int64(len(body)), nil
+}
+
+// exportCSVHeader names the columns of a CSV export.
+var exportCSVHeader = []string{"id", "list", "title", "description", "status", "completed", "due_date", "estimate_minutes", "place", "assignee", "open_days"}
+
+// exportTodos returns userID's unarchived todos on their lists, in format,
+// and the content type to upload them as.
+func (app *Application) exportTodos(ctx context.Context, userID int, format string) ([]byte, string, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(2)+`, (SELECT name FROM lists WHERE lists.id = todos.list_id)
+		FROM todos
+		WHERE archived_at IS NULL AND list_id IN `+store.MemberLists(1)+`
+		ORDER BY list_id, position DESC, id`,
+		userID, app.Clock.Now(),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	todos := []assignedTodo{}
+	for rows.Next() {
+		var t assignedTodo
+		if err := rows.Scan(append(store.TodoFields(&t.Todo), &t.ListName)...); err != nil {
+			return nil, "", err
+		}
+		todos = append(todos, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	if format == "json" {
+		err := json.NewEncoder(&buf).Encode(map[string]any{
+			"exported_at": time.Now().UTC(),
+			"todos":       todos,
+		})
+		return buf.Bytes(), "application/json", err
+	}
+	cw := csv.NewWriter(&buf)
+	cw.Write(exportCSVHeader)
+	for _, t := range todos {
+		due := ""
+		if t.DueDate != nil {
+			due = t.DueDate.Format("2006-01-02")
+		}
+		cw.Write([]string{
+			strconv.Itoa(t.ID), t.ListName, t.Title, t.Description, t.Status,
+			strconv.FormatBool(t.Completed), due, strconv.Itoa(t.EstimateMinutes),
+			t.Place, t.Assignee, strconv.Itoa(t.OpenDays),
+		})
+	}
+	cw.Flush()
+	return buf.Bytes(), "text/csv; charset=utf-8", cw.Error()
+}
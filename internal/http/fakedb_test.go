@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database for handler tests, so they can run the real router
+// without Postgres. Each query gets the canned result of the first
+// expectation whose text it contains; a query nothing expects fails the
+// test, which is also how to find out what a handler runs.
+type fakeDB struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	results []*fakeResult
+}
+
+// fakeResult is what a query containing match returns: rows for a query,
+// or for an Exec, as many rows affected as there are rows.
+type fakeResult struct {
+	match string
+	rows  [][]driver.Value
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	f := &fakeDB{t: t}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+	return f, db
+}
+
+// on answers queries containing match with rows, each holding a value for
+// every column the query selects.
+func (f *fakeDB) on(match string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, &fakeResult{match: match, rows: rows})
+}
+
+func (f *fakeDB) result(query string, args []driver.NamedValue) (*fakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.results {
+		if strings.Contains(query, r.match) {
+			return r, nil
+		}
+	}
+	values := make([]any, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.t.Errorf("unexpected query %v:\n%s", values, strings.TrimSpace(query))
+	return nil, errors.New("fakeDB: unexpected query")
+}
+
+// Connect and Driver make fakeDB a driver.Connector.
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{f} }
+
+type fakeDriver struct{ f *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn(d), nil }
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.f, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// CheckNamedValue takes arguments as they are, such as pq.Array's.
+func (c fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.f.result(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: r}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r, err := c.f.result(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(r.rows)), nil
+}
+
+type fakeStmt struct {
+	f     *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return fakeConn{s.f}.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeConn{s.f}.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	n := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		n[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return n
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	result *fakeResult
+	next   int
+}
+
+// Columns only needs to be as long as a row: database/sql scans by position.
+func (r *fakeRows) Columns() []string {
+	if len(r.result.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.result.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,203 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// remapFields rekeys the custom field values of todos $1 after they've
+// been moved or copied to another list: each value goes to the field there
+// with the same name and kind, and is dropped if there isn't one or it's
+// not one of its options.
+const remapFields = `
+	UPDATE todos t SET custom_fields = COALESCE((
+		SELECT jsonb_object_agg(nf.id::text, e.value)
+		FROM jsonb_each(t.custom_fields) e
+		JOIN list_fields f ON f.id::text = e.key
+		JOIN list_fields nf ON nf.list_id = t.list_id AND lower(nf.name) = lower(f.name) AND nf.kind = f.kind
+		WHERE nf.kind <> 'select' OR e.value #>> '{}' = ANY(nf.options)
+	), '{}')
+	WHERE t.id = ANY($1) AND t.custom_fields <> '{}'`
+
+// fieldInput is one custom field in a form: the add and edit forms name
+// their inputs field_<id>, the filter bar filter_<id>.
+type fieldInput struct {
+	Field model.Field
+	Name  string
+	Value string
+	// Filter offers "any" for select fields and matches text by substring.
+	Filter bool
+}
+
+func fieldInputs(fields []model.Field, todo model.Todo) []fieldInput {
+	inputs := make([]fieldInput, len(fields))
+	for i, f := range fields {
+		inputs[i] = fieldInput{Field: f, Name: "field_" + strconv.Itoa(f.ID), Value: todo.Value(f.ID)}
+	}
+	return inputs
+}
+
+// FieldInputs are the inputs for a new todo's custom fields.
+func (p listPage) FieldInputs() []fieldInput {
+	return fieldInputs(p.Fields, model.Todo{})
+}
+
+// FieldFilters are the filter bar's inputs.
+func (p listPage) FieldFilters() []fieldInput {
+	inputs := make([]fieldInput, len(p.Fields))
+	for i, f := range p.Fields {
+		inputs[i] = fieldInput{Field: f, Name: "filter_" + strconv.Itoa(f.ID), Filter: true}
+	}
+	return inputs
+}
+
+func (f editForm) FieldInputs() []fieldInput {
+	return fieldInputs(f.Fields, f.Todo)
+}
+
+// parseFields reads a todo's custom field values from the field_<id> form
+// values, keyed by field ID the way they're stored. Blank ones are left
+// out.
+func parseFields(r *http.Request, fields []model.Field) (map[string]any, error) {
+	values := map[string]any{}
+	for _, f := range fields {
+		v, err := f.Parse(r.FormValue("field_" + strconv.Itoa(f.ID)))
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			values[strconv.Itoa(f.ID)] = v
+		}
+	}
+	return values, nil
+}
+
+// enteredFields keeps what was typed into the field inputs, valid or not, so
+// a form with an error can be shown again as it was.
+func enteredFields(r *http.Request, fields []model.Field) model.FieldValues {
+	var values model.FieldValues
+	for _, f := range fields {
+		values = append(values, model.FieldValue{FieldID: f.ID, Name: f.Name, Kind: f.Kind, Value: r.FormValue("field_" + strconv.Itoa(f.ID))})
+	}
+	return values
+}
+
+// fieldFilters reads the filter_<id> form values into what FilterTodos
+// takes. Text fields match anything containing the filter; the rest match
+// exactly. Values that don't parse are ignored, like blank ones. ok is
+// false when nothing is being filtered on.
+func (app *Application) fieldFilters(ctx context.Context, r *http.Request, listID int) (equal map[string]any, contains map[string]string, ok bool, err error) {
+	r.ParseForm()
+	filtering := false
+	for k := range r.Form {
+		if strings.HasPrefix(k, "filter_") && strings.TrimSpace(r.Form.Get(k)) != "" {
+			filtering = true
+			break
+		}
+	}
+	if !filtering {
+		return nil, nil, false, nil
+	}
+	fields, err := app.listFields(ctx, listID)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	equal, contains = map[string]any{}, map[string]string{}
+	for _, f := range fields {
+		key := strconv.Itoa(f.ID)
+		raw := r.Form.Get("filter_" + key)
+		if f.Kind == model.TextField {
+			if raw = strings.TrimSpace(raw); raw != "" {
+				contains[key] = raw
+			}
+			continue
+		}
+		if v, err := f.Parse(raw); err == nil && v != nil {
+			equal[key] = v
+		}
+	}
+	return equal, contains, len(equal)+len(contains) > 0, nil
+}
+
+type fieldsForm struct {
+	List   model.List
+	Fields []model.Field
+	Kinds  []string
+	Error  string
+}
+
+func (app *Application) renderFields(w http.ResponseWriter, r *http.Request, message string) {
+	data := fieldsForm{Kinds: model.FieldKinds, Error: message}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Fields, err = app.Queries.ListFields(r.Context(), data.List.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "fields-form", data)
+}
+
+// createField adds a custom field to the list. Select fields take their
+// options comma-separated.
+func (app *Application) createField(w http.ResponseWriter, r *http.Request) {
+	f := model.Field{
+		ListID: listAccess(r).ListID,
+		Name:   strings.TrimSpace(r.FormValue("name")),
+		Kind:   r.FormValue("kind"),
+	}
+	if f.Name == "" {
+		app.renderFields(w, r, "Name required")
+		return
+	}
+	if !slices.Contains(model.FieldKinds, f.Kind) {
+		app.renderFields(w, r, "Pick text, number, select or date")
+		return
+	}
+	if f.Kind == model.SelectField {
+		for _, o := range strings.Split(r.FormValue("options"), ",") {
+			if o = strings.TrimSpace(o); o != "" && !slices.Contains(f.Options, o) {
+				f.Options = append(f.Options, o)
+			}
+		}
+		if len(f.Options) == 0 {
+			app.renderFields(w, r, "A select field needs at least one option")
+			return
+		}
+	}
+
+	created, err := app.Queries.CreateField(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		app.renderFields(w, r, "This list already has a field called "+f.Name+".")
+		return
+	}
+	app.renderFields(w, r, "")
+}
+
+// deleteField removes a custom field, and every todo's value for it.
+func (app *Application) deleteField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "fieldID"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	if err := app.Queries.DeleteField(r.Context(), listAccess(r).ListID, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderFields(w, r, "")
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/announce"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// templateFuncs are available in every page, fragment and component.
+var templateFuncs = template.FuncMap{
+	"humanize":      humanizeTime,
+	"humanizeBytes": humanizeBytes,
+	"pluralize":     pluralize,
+	"truncate":      truncate,
+	"markdown":      markdown,
+	"csrfField":     csrfField,
+	"csrfToken":     func(data any) string { return pageOf(data).CSRFToken },
+	"currentUser":   func(data any) *model.User { return pageOf(data).User },
+	"impersonation": func(data any) *impersonation { return pageOf(data).Impersonation },
+	"announcements": func(data any) []announce.Announcement { return pageOf(data).Announcements },
+}
+
+// pageOf digs the Page out of template data that embeds one, so helpers
+// like currentUser work from any page's dot. Fragments rendered without a
+// Page get the zero value.
+func pageOf(data any) Page {
+	if p, ok := data.(interface{ page() Page }); ok {
+		return p.page()
+	}
+	return Page{}
+}
+
+func (p Page) page() Page { return p }
+
+// humanizeTime describes t relative to now: "just now", "5 minutes ago",
+// "in 3 days", falling back to a date beyond a week. It takes a time.Time
+// or a *time.Time, which is blank when nil.
+func humanizeTime(v any) string {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		t = *v
+	default:
+		return fmt.Sprint(v)
+	}
+
+	d := time.Since(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var s string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		s = pluralize(int(d/time.Minute), "minute", "minutes")
+	case d < 24*time.Hour:
+		s = pluralize(int(d/time.Hour), "hour", "hours")
+	case d < 7*24*time.Hour:
+		s = pluralize(int(d/(24*time.Hour)), "day", "days")
+	default:
+		if t.Year() == time.Now().Year() {
+			return t.Format("Jan 2")
+		}
+		return t.Format("Jan 2, 2006")
+	}
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
+
+// humanizeBytes formats a size like 1.5 MB.
+func humanizeBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	exp := min(int(math.Log(float64(n))/math.Log(1024)), 4)
+	return fmt.Sprintf("%.1f %cB", float64(n)/math.Pow(1024, float64(exp)), "KMGT"[exp-1])
+}
+
+// pluralize prefixes the right form of a noun with its count: "1 todo",
+// "3 todos".
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// truncate shortens s to at most n characters, ending in an ellipsis. The
+// length comes first so it reads well in a pipeline: {{.Title | truncate 40}}.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:max(n-1, 0)])) + "…"
+}
+
+var (
+	mdCode   = regexp.MustCompile("`([^`]+)`")
+	mdBold   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdItalic = regexp.MustCompile(`\*([^*]+)\*`)
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^\s)]+)\)`)
+)
+
+// markdown renders the inline subset of Markdown that fits a todo title:
+// `code`, **bold**, *italic* and [links](https://...). The text is escaped
+// first, so nothing else the user typed can become markup.
+func markdown(s string) template.HTML {
+	s = template.HTMLEscapeString(s)
+	s = mdCode.ReplaceAllString(s, "<code class=\"px-1 bg-gray-100 rounded\">$1</code>")
+	s = mdBold.ReplaceAllString(s, "<strong>$1</strong>")
+	s = mdItalic.ReplaceAllString(s, "<em>$1</em>")
+	s = mdLink.ReplaceAllString(s, `<a href="$2" rel="nofollow noopener" target="_blank" class="text-blue-500 hover:underline">$1</a>`)
+	return template.HTML(s)
+}
+
+// plainText strips the Markdown that markdown renders, for places that
+// can't show it: a link keeps its text and URL.
+func plainText(s string) string {
+	s = mdCode.ReplaceAllString(s, "$1")
+	s = mdBold.ReplaceAllString(s, "$1")
+	s = mdItalic.ReplaceAllString(s, "$1")
+	return mdLink.ReplaceAllString(s, "$1 ($2)")
+}
+
+// csrfField is the hidden input plain (non-htmx) forms need to pass
+// csrfProtect. htmx requests send the token as a header instead.
+func csrfField(data any) template.HTML {
+	token := pageOf(data).CSRFToken
+	if token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
@@ -0,0 +1,341 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/announce"
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/changelog"
+	"github.com/Trailblazors/htmx-go-postgres/internal/clock"
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	"github.com/Trailblazors/htmx-go-postgres/internal/errreport"
+	"github.com/Trailblazors/htmx-go-postgres/internal/flags"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/metrics"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/presence"
+	"github.com/Trailblazors/htmx-go-postgres/internal/ratelimit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/replay"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+	"github.com/Trailblazors/htmx-go-postgres/internal/usage"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenDir is where rendered fragments are kept to compare against, from
+// the repository root the tests run in.
+const goldenDir = "internal/http/testdata"
+
+// golden compares got with testdata/name.golden, or writes it there with
+// -update. Run go test ./internal/http -update after changing a template
+// on purpose, and review the diff.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(goldenDir, name+".golden")
+	if *update {
+		if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s doesn't match %s:\n%s", name, path, got)
+	}
+}
+
+// newTestApp is an Application with just enough to render: handlers that
+// go to the database can't be run with it.
+func newTestApp(t *testing.T) *Application {
+	return &Application{
+		Templates: testTemplates(t),
+		Config:    config.Config{BaseURL: "https://todo.example"},
+	}
+}
+
+// htmxRequest is a request as htmx sends it, from the page at /lists/1.
+func htmxRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("HX-Request", "true")
+	r.Header.Set("HX-Current-URL", "https://todo.example/lists/1")
+	return r
+}
+
+// routerTime is when requests in router tests are made, for timestamps
+// the fake database hands back.
+var routerTime = time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC)
+
+// newRouterApp returns the routes of an Application put together as New
+// does, middleware and all, but over a fakeDB rather than Postgres, and the
+// fakeDB to answer its queries with.
+func newRouterApp(t *testing.T) (*fakeDB, http.Handler) {
+	t.Helper()
+	f, db := newFakeDB(t)
+	app := &Application{
+		Config:        config.Config{BaseURL: "https://todo.example"},
+		DB:            db,
+		Queries:       store.NewQueries(db, clock.System{}),
+		Templates:     testTemplates(t),
+		Flags:         flags.NewStore(db),
+		Maintenance:   maintenance.NewStore(db, maintenance.Off),
+		Errors:        errreport.Log{},
+		Limiter:       ratelimit.New(ratelimit.Config{}, ratelimit.NewMemory()),
+		Usage:         usage.NewRecorder(db),
+		Metrics:       metrics.New(db, statusInterval),
+		Sessions:      session.NewStore(db, time.Hour, 24*time.Hour),
+		Audit:         audit.New(db),
+		Ready:         &readiness{},
+		Outage:        newOutage(),
+		Presence:      presence.New(presenceTTL),
+		Announcements: announce.New(db),
+		Changelog:     changelog.New(db),
+		Tokens:        apitoken.New(db),
+		Clock:         clock.System{},
+		Cache:         cache.New(cache.NewMemory(10), time.Minute),
+		Replays:       replay.New(10),
+	}
+	app.accessLog.Store(&config.AccessLog{})
+	var err error
+	if app.Assets, err = assets.Build(bundles); err != nil {
+		t.Fatal(err)
+	}
+	return f, app.Handler()
+}
+
+// signIn has the fake database know a session for user, and returns the
+// cookie to send it with.
+func signIn(f *fakeDB, user model.User) *http.Cookie {
+	f.on("FROM sessions WHERE token_hash", []driver.Value{int64(1), int64(user.ID), routerTime, time.Now(), routerTime.Add(time.Hour), "", "", int64(0), time.Unix(0, 0)})
+	f.on("FROM users WHERE id = $1", []driver.Value{user.Email, user.IsAdmin, routerTime, true, user.ConfirmDeletes, nil, "comfortable", "{}", nil})
+	return &http.Cookie{Name: SessionCookie, Value: "session-token"}
+}
+
+// routerRequest is req from the signed-in browser cookie belongs to, with
+// the CSRF token it would send.
+func routerRequest(req *http.Request, cookie *http.Cookie) *http.Request {
+	req.AddCookie(cookie)
+	req.AddCookie(&http.Cookie{Name: "vid", Value: "visitor-1"})
+	sum := sha256.Sum256([]byte("csrf:" + cookie.Value))
+	req.Header.Set(csrfHeader, hex.EncodeToString(sum[:]))
+	return req
+}
+
+// fakeTodo is a todo as store.TodoColumns selects it, open and with
+// nothing but a title.
+func fakeTodo(id, listID int, title string) []driver.Value {
+	return []driver.Value{
+		int64(id), int64(listID), title, "", false, int64(0), nil, "", nil, nil, false, int64(0), false, []byte("[]"),
+		nil, "", false, false, int64(0), "", int64(0), "",
+	}
+}
+
+// onTodo5 has the fake database put todo 5 on list 1, where the signed-in
+// user has role.
+func onTodo5(f *fakeDB, role model.Role) {
+	f.on("SELECT list_id FROM todos WHERE id = $1", []driver.Value{int64(1)})
+	f.on("SELECT role FROM list_members WHERE list_id = $1 AND user_id = $2", []driver.Value{role.String()})
+}
+
+func TestHandlerFragments(t *testing.T) {
+	ann := model.User{ID: 2, Email: "ann@example.com"}
+	bob := model.User{ID: 3, Email: "bob@example.com"}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		// user is who's signed in.
+		user model.User
+		// expect has the fake database answer what the route runs.
+		expect func(f *fakeDB)
+		status int
+		// headers are response headers to check, besides the body.
+		headers map[string]string
+	}{
+		{
+			name: "not-found",
+			req:  htmxRequest(http.MethodGet, "/lists/9/todos"),
+			user: ann,
+			expect: func(f *fakeDB) {
+				f.on("SELECT EXISTS", []driver.Value{false})
+			},
+			status:  http.StatusNotFound,
+			headers: map[string]string{"HX-Retarget": "#alerts", "HX-Reswap": "innerHTML"},
+		},
+		{
+			name: "forbidden",
+			req:  htmxRequest(http.MethodDelete, "/todos/5"),
+			user: ann,
+			expect: func(f *fakeDB) {
+				onTodo5(f, model.Viewer)
+			},
+			status:  http.StatusForbidden,
+			headers: map[string]string{"HX-Retarget": "#alerts", "HX-Reswap": "innerHTML"},
+		},
+		{
+			name: "mentions",
+			req:  htmxRequest(http.MethodGet, "/mentions?q=an&list=1"),
+			user: bob,
+			expect: func(f *fakeDB) {
+				f.on("SELECT role FROM list_members WHERE list_id = $1 AND user_id = $2", []driver.Value{"editor"})
+				f.on("strpos(lower(u.email), lower($2)) = 1 AND u.id <> $3", []driver.Value{int64(ann.ID), ann.Email, "editor"})
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "mentions-none",
+			req:  htmxRequest(http.MethodGet, "/mentions?q=zed"),
+			user: ann,
+			expect: func(f *fakeDB) {
+				f.on("SELECT DISTINCT u.id, u.email FROM list_members")
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "delete-confirm",
+			req:  htmxRequest(http.MethodGet, "/todos/5/delete"),
+			user: model.User{ID: 2, Email: "ann@example.com", ConfirmDeletes: true},
+			expect: func(f *fakeDB) {
+				onTodo5(f, model.Editor)
+				f.on("FROM todos WHERE id = $1", fakeTodo(5, 1, "Renew **passport**"))
+			},
+			status:  http.StatusOK,
+			headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			name: "delete-now",
+			req:  htmxRequest(http.MethodGet, "/todos/5/delete"),
+			user: ann,
+			expect: func(f *fakeDB) {
+				onTodo5(f, model.Editor)
+				f.on("FROM todos WHERE id = $1", fakeTodo(5, 1, "Renew **passport**"))
+			},
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, h := newRouterApp(t)
+			cookie := signIn(f, tt.user)
+			if tt.expect != nil {
+				tt.expect(f)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, routerRequest(tt.req, cookie))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			for name, want := range tt.headers {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			golden(t, tt.name, w.Body.Bytes())
+		})
+	}
+}
+
+func TestHandlerJSON(t *testing.T) {
+	ann := model.User{ID: 2, Email: "ann@example.com"}
+	tests := []struct {
+		name   string
+		target string
+		expect func(f *fakeDB)
+		status int
+		want   string
+	}{
+		{
+			name:   "not found",
+			target: "/lists/9",
+			expect: func(f *fakeDB) {
+				f.on("SELECT EXISTS", []driver.Value{false})
+			},
+			status: http.StatusNotFound,
+			want:   `{"error":"There's nothing here. It may have been deleted or moved."}`,
+		},
+		{
+			name:   "forbidden API call",
+			target: "/api/v1/lists/1/statuses",
+			expect: func(f *fakeDB) {
+				f.on("SELECT EXISTS", []driver.Value{true})
+				f.on("SELECT role FROM list_members WHERE list_id = $1 AND user_id = $2")
+			},
+			status: http.StatusForbidden,
+			want:   `{"error":"You don't have permission to do that"}`,
+		},
+		{
+			name:   "no mentions",
+			target: "/mentions?q=zed",
+			expect: func(f *fakeDB) {
+				f.on("SELECT DISTINCT u.id, u.email FROM list_members")
+			},
+			status: http.StatusOK,
+			want:   `[]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, h := newRouterApp(t)
+			cookie := signIn(f, ann)
+			tt.expect(f)
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, routerRequest(r, cookie))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := string(bytes.TrimSpace(w.Body.Bytes())); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		header string
+		want   string
+	}{
+		{"browser", httptest.NewRequest(http.MethodGet, "/settings", nil), http.StatusSeeOther, "Location", "/login"},
+		{"htmx", htmxRequest(http.MethodGet, "/lists/1/todos"), http.StatusUnauthorized, "HX-Redirect", "/login"},
+		{"API", httptest.NewRequest(http.MethodGet, "/api/v1/lists", nil), http.StatusUnauthorized, "Content-Type", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing is expected of the database: a signed-out request
+			// doesn't get as far as a query.
+			_, h := newRouterApp(t)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get(tt.header); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
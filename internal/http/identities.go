@@ -0,0 +1,563 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/oauth"
+)
+
+const (
+	// oauthStateCookie ties a provider's redirect back to the browser that
+	// was sent to it, so a sign-in started elsewhere can't be slipped in.
+	oauthStateCookie = "oauth_state"
+	// pendingLinkCookie carries the token of a link waiting to be
+	// confirmed, for pendingLinkTTL.
+	pendingLinkCookie = "pending_link"
+	pendingLinkTTL    = 15 * time.Minute
+)
+
+// linkedIdentity is a sign-in provider connected to the current account.
+type linkedIdentity struct {
+	Provider string
+	Label    string
+	Email    string
+}
+
+type identitiesForm struct {
+	Identities []linkedIdentity
+	// Available are the configured providers not connected yet.
+	Available []*oauth.Provider
+	Error     string
+}
+
+// pendingLink is an identity the user has signed in with but not yet
+// agreed to connect to UserID. MergeUserID is the account the identity
+// points to now, or one with the same email, which is merged into UserID
+// on confirmation.
+type pendingLink struct {
+	UserID      int
+	Identity    oauth.Identity
+	MergeUserID int
+}
+
+type linkPage struct {
+	Page
+	Provider string
+	Email    string
+	// Into is the email of the account being linked to, and Merge that of
+	// the account that will be merged into it, if any.
+	Into  string
+	Merge string
+}
+
+func (app *Application) oauthProvider(name string) *oauth.Provider {
+	for _, p := range app.OAuth {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (app *Application) oauthRedirectURI(p *oauth.Provider) string {
+	return app.Config.BaseURL + "/auth/" + p.Name + "/callback"
+}
+
+// setShortCookie sets a cookie that lives for a sign-in flow, or clears it
+// when maxAge is negative.
+func (app *Application) setShortCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/auth/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.Config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startOAuth sends the browser to the provider's consent page.
+func (app *Application) startOAuth(w http.ResponseWriter, r *http.Request) {
+	p := app.oauthProvider(chi.URLParam(r, "provider"))
+	if p == nil {
+		app.notFound(w, r)
+		return
+	}
+	state := newToken()
+	app.setShortCookie(w, oauthStateCookie, state, 10*time.Minute)
+	http.Redirect(w, r, p.AuthURL(state, app.oauthRedirectURI(p)), http.StatusSeeOther)
+}
+
+// oauthCallback handles the provider sending the browser back. Signed out,
+// a known identity logs in and a new one signs up, unless its email
+// already has an account, which the user is asked to link it to. Signed
+// in, the identity is connected to the current account; when it belonged
+// to another account, or another account has its email, the user is asked
+// whether to merge that account in. During maintenance only the first of
+// those is allowed.
+func (app *Application) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	p := app.oauthProvider(chi.URLParam(r, "provider"))
+	if p == nil {
+		app.notFound(w, r)
+		return
+	}
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusUnauthorized)
+		app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, "", msg))
+	}
+
+	c, err := r.Cookie(oauthStateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		fail("That sign-in link has expired. Please try again.")
+		return
+	}
+	app.setShortCookie(w, oauthStateCookie, "", -1)
+	if r.URL.Query().Get("error") != "" {
+		fail("Signing in with " + p.Label + " was cancelled.")
+		return
+	}
+
+	id, err := p.Exchange(r.Context(), r.URL.Query().Get("code"), app.oauthRedirectURI(p))
+	if errors.Is(err, oauth.ErrNoEmail) {
+		fail("Your " + p.Label + " account has no verified email address.")
+		return
+	}
+	if err != nil {
+		requestLog(r.Context()).Printf("oauth %s: %v", p.Name, err)
+		fail("Signing in with " + p.Label + " didn't work. Please try again.")
+		return
+	}
+
+	owner, err := app.identityOwner(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sameEmail, err := app.verifiedAccount(r.Context(), id.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, _ := currentUser(r)
+	if user != nil || owner == 0 {
+		// Anything but logging in to an existing account writes to it, or
+		// makes one.
+		if app.Maintenance.Mode(r.Context()) != maintenance.Off {
+			w.Header().Set("Retry-After", "300")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, "", "Signing up and connecting accounts are paused during maintenance. Please try again later."))
+			return
+		}
+	}
+	switch {
+	case user == nil && owner != 0:
+		app.finishLogin(w, r, owner, id.Email, "via "+p.Label)
+	case user == nil && sameEmail != 0:
+		app.startLink(w, r, pendingLink{UserID: sameEmail, Identity: id})
+	case user == nil:
+		userID, err := app.signupWith(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			fail("An account with " + id.Email + " exists but hasn't confirmed its email. Log in with its password, then connect " + p.Label + " from settings.")
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		app.finishLogin(w, r, userID, id.Email, "signed up via "+p.Label)
+	case owner == user.ID:
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+	case owner != 0:
+		app.startLink(w, r, pendingLink{UserID: user.ID, Identity: id, MergeUserID: owner})
+	case sameEmail != 0 && sameEmail != user.ID:
+		app.startLink(w, r, pendingLink{UserID: user.ID, Identity: id, MergeUserID: sameEmail})
+	default:
+		if err := app.linkIdentity(r.Context(), user.ID, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		app.audit(r, audit.Event{UserID: user.ID, Email: user.Email, Action: audit.IdentityLinked, Detail: p.Label})
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+	}
+}
+
+// identityOwner returns the account id signs in to, or 0.
+func (app *Application) identityOwner(ctx context.Context, id oauth.Identity) (int, error) {
+	var userID int
+	err := app.DB.QueryRowContext(ctx,
+		"SELECT user_id FROM identities WHERE provider = $1 AND subject = $2", id.Provider, id.Subject,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return userID, err
+}
+
+// verifiedAccount returns the account with a confirmed email address of
+// email, or 0. Unconfirmed accounts are left out: whoever made one may not
+// own the address.
+func (app *Application) verifiedAccount(ctx context.Context, email string) (int, error) {
+	var userID int
+	err := app.DB.QueryRowContext(ctx,
+		"SELECT id FROM users WHERE lower(email) = lower($1) AND email_verified_at IS NOT NULL", email,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return userID, err
+}
+
+func (app *Application) linkIdentity(ctx context.Context, userID int, id oauth.Identity) error {
+	_, err := app.db(ctx).ExecContext(ctx, `
+		INSERT INTO identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO UPDATE SET user_id = $3, email = $4`,
+		id.Provider, id.Subject, userID, id.Email,
+	)
+	return err
+}
+
+func (app *Application) finishLogin(w http.ResponseWriter, r *http.Request, userID int, email, detail string) {
+	if err := app.startSession(w, r, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, audit.Event{UserID: userID, Email: email, Action: audit.Login, Detail: detail})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// signupWith makes an account for an identity no one has used yet. It has
+// no password, and its email is confirmed since the provider vouched for
+// it. It returns sql.ErrNoRows if the email is taken by an account that
+// hasn't confirmed it.
+func (app *Application) signupWith(ctx context.Context, id oauth.Identity) (int, error) {
+	var userID int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		err := app.db(ctx).QueryRowContext(ctx, `
+			INSERT INTO users (email, password_hash, is_admin, email_verified_at) VALUES ($1, '', $2, NOW())
+			ON CONFLICT DO NOTHING
+			RETURNING id`,
+			id.Email, slices.Contains(app.Config.AdminEmails, strings.ToLower(id.Email)),
+		).Scan(&userID)
+		if err != nil {
+			return err
+		}
+		return app.linkIdentity(ctx, userID, id)
+	})
+	return userID, err
+}
+
+// startLink saves l and sends the browser to confirm it.
+func (app *Application) startLink(w http.ResponseWriter, r *http.Request, l pendingLink) {
+	var merge sql.NullInt64
+	if l.MergeUserID != 0 {
+		merge = sql.NullInt64{Int64: int64(l.MergeUserID), Valid: true}
+	}
+	token := newToken()
+	_, err := app.DB.ExecContext(r.Context(), `
+		INSERT INTO pending_links (token_hash, user_id, provider, subject, email, merge_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		hashToken(token), l.UserID, l.Identity.Provider, l.Identity.Subject, l.Identity.Email, merge,
+		time.Now().Add(pendingLinkTTL),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.setShortCookie(w, pendingLinkCookie, token, pendingLinkTTL)
+	http.Redirect(w, r, "/auth/link", http.StatusSeeOther)
+}
+
+// pendingLinkFor loads the link the request's cookie points to. It returns
+// sql.ErrNoRows when there's none or it has expired.
+func (app *Application) pendingLinkFor(r *http.Request) (pendingLink, string, error) {
+	c, err := r.Cookie(pendingLinkCookie)
+	if err != nil {
+		return pendingLink{}, "", sql.ErrNoRows
+	}
+	hash := hashToken(c.Value)
+	var l pendingLink
+	err = app.DB.QueryRowContext(r.Context(), `
+		SELECT user_id, provider, subject, email, COALESCE(merge_user_id, 0)
+		FROM pending_links WHERE token_hash = $1 AND expires_at > NOW()`, hash,
+	).Scan(&l.UserID, &l.Identity.Provider, &l.Identity.Subject, &l.Identity.Email, &l.MergeUserID)
+	return l, hash, err
+}
+
+func (app *Application) userEmail(ctx context.Context, userID int) (string, error) {
+	var email string
+	err := app.DB.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", userID).Scan(&email)
+	return email, err
+}
+
+// linkConfirmPage asks whether to go ahead with the pending link.
+func (app *Application) linkConfirmPage(w http.ResponseWriter, r *http.Request) {
+	l, _, err := app.pendingLinkFor(r)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := linkPage{Page: app.page(r), Email: l.Identity.Email}
+	if p := app.oauthProvider(l.Identity.Provider); p != nil {
+		data.Provider = p.Label
+	}
+	if data.Into, err = app.userEmail(r.Context(), l.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if l.MergeUserID != 0 {
+		if data.Merge, err = app.userEmail(r.Context(), l.MergeUserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	app.Templates.ExecuteTemplate(w, "link.html", data)
+}
+
+// confirmLink connects the pending identity, merges in the other account
+// if there is one, and logs in if the user wasn't already.
+func (app *Application) confirmLink(w http.ResponseWriter, r *http.Request) {
+	l, hash, err := app.pendingLinkFor(r)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, "", "That request has expired. Please sign in again."))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, _ := currentUser(r)
+	if user != nil && user.ID != l.UserID {
+		app.forbidden(w, r)
+		return
+	}
+
+	var merged string
+	err = app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		res, err := app.db(ctx).ExecContext(ctx, "DELETE FROM pending_links WHERE token_hash = $1", hash)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		if err := app.linkIdentity(ctx, l.UserID, l.Identity); err != nil {
+			return err
+		}
+		merged = ""
+		if l.MergeUserID != 0 {
+			if err := app.db(ctx).QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", l.MergeUserID).Scan(&merged); err != nil {
+				return err
+			}
+			if err := app.mergeAccounts(ctx, l.MergeUserID, l.UserID); err != nil {
+				return err
+			}
+		}
+		// The provider vouched for the address, so if it's the account's
+		// own, it's confirmed.
+		_, err = app.db(ctx).ExecContext(ctx, `
+			UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+			WHERE id = $1 AND lower(email) = lower($2)`, l.UserID, l.Identity.Email)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.Templates.ExecuteTemplate(w, "login.html", app.authPage(r, "", "That request has expired. Please sign in again."))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.setShortCookie(w, pendingLinkCookie, "", -1)
+
+	into, err := app.userEmail(r.Context(), l.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	label := l.Identity.Provider
+	if p := app.oauthProvider(label); p != nil {
+		label = p.Label
+	}
+	app.audit(r, audit.Event{UserID: l.UserID, Email: into, Action: audit.IdentityLinked, Detail: label})
+	if merged != "" {
+		app.audit(r, audit.Event{UserID: l.UserID, Email: into, Action: audit.AccountsMerged, Detail: merged})
+	}
+
+	if user == nil {
+		app.finishLogin(w, r, l.UserID, into, "via "+label)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// cancelLink drops the pending link.
+func (app *Application) cancelLink(w http.ResponseWriter, r *http.Request) {
+	if _, hash, err := app.pendingLinkFor(r); err == nil {
+		if _, err := app.DB.ExecContext(r.Context(), "DELETE FROM pending_links WHERE token_hash = $1", hash); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	app.setShortCookie(w, pendingLinkCookie, "", -1)
+	if user, _ := currentUser(r); user != nil {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// mergeStatements move what account $1 has to account $2: every column
+// referencing users, except those in mergeDropped. Where both are members
+// of a list, the higher role is kept; where both have a todo in My Day, a
+// due date pattern for a word, or an announcement dismissed, $2's stays.
+// TestMergeStatements fails for a column referencing users that's in
+// neither.
+var mergeStatements = []string{
+	`INSERT INTO list_members (list_id, user_id, role, created_at)
+	 SELECT list_id, $2, role, created_at FROM list_members WHERE user_id = $1
+	 ON CONFLICT (list_id, user_id) DO UPDATE SET role = CASE
+		WHEN 'owner' IN (list_members.role, EXCLUDED.role) THEN 'owner'
+		WHEN 'editor' IN (list_members.role, EXCLUDED.role) THEN 'editor'
+		ELSE 'viewer'
+	 END`,
+	`UPDATE my_day SET user_id = $2 WHERE user_id = $1 AND NOT EXISTS (
+		SELECT 1 FROM my_day mine WHERE mine.user_id = $2 AND mine.todo_id = my_day.todo_id AND mine.day = my_day.day)`,
+	"UPDATE digest_subscriptions SET user_id = $2 WHERE user_id = $1",
+	"UPDATE push_subscriptions SET user_id = $2 WHERE user_id = $1",
+	"UPDATE identities SET user_id = $2 WHERE user_id = $1",
+	"UPDATE audit_events SET user_id = $2 WHERE user_id = $1",
+	"UPDATE api_tokens SET user_id = $2 WHERE user_id = $1",
+	"UPDATE sync_connections SET user_id = $2 WHERE user_id = $1",
+	// Their credentials are sealed without the user in them, so they
+	// open the same after the move.
+	"UPDATE export_schedules SET user_id = $2 WHERE user_id = $1",
+	"UPDATE export_downloads SET user_id = $2 WHERE user_id = $1",
+	`UPDATE due_date_patterns SET user_id = $2 WHERE user_id = $1 AND NOT EXISTS (
+		SELECT 1 FROM due_date_patterns mine WHERE mine.user_id = $2 AND mine.keyword = due_date_patterns.keyword)`,
+	`UPDATE announcement_dismissals SET user_id = $2 WHERE user_id = $1 AND NOT EXISTS (
+		SELECT 1 FROM announcement_dismissals mine WHERE mine.user_id = $2 AND mine.announcement_id = announcement_dismissals.announcement_id)`,
+	"UPDATE support_tickets SET user_id = $2 WHERE user_id = $1",
+	"UPDATE saved_searches SET user_id = $2 WHERE user_id = $1",
+	"UPDATE todos SET assignee_id = $2 WHERE assignee_id = $1",
+	"UPDATE todos SET approval_requested_by = $2 WHERE approval_requested_by = $1",
+	// Metadata namespaces start with their user's ID; see metadataNamespace.
+	`UPDATE todos SET metadata = (
+		SELECT jsonb_object_agg(CASE WHEN key LIKE $1::text || ':%' THEN $2::text || substr(key, length($1::text) + 1) ELSE key END, value)
+		FROM jsonb_each(metadata)
+	 ) WHERE EXISTS (SELECT 1 FROM jsonb_object_keys(metadata) k WHERE k LIKE $1::text || ':%')`,
+}
+
+// mergeDropped are the columns referencing users that a merge leaves
+// behind, to go with the deleted account, and why.
+var mergeDropped = map[string]string{
+	"sessions.user_id":            "its sessions are signed out",
+	"email_verifications.user_id": "they confirm the old account's address",
+	"pending_links.user_id":       "the link being confirmed is done with",
+	"pending_links.merge_user_id": "the link being confirmed is done with",
+	"undo_log.user_id":            "undo is for what the user just did in their session",
+	"onboarding.user_id":          "the account merged into has its own checklist",
+	"changelog_reads.user_id":     "the account merged into has read what it has",
+}
+
+// mergeAccounts folds account from into account into and deletes it. It
+// must run in a transaction.
+func (app *Application) mergeAccounts(ctx context.Context, from, into int) error {
+	for _, stmt := range mergeStatements {
+		if _, err := app.db(ctx).ExecContext(ctx, stmt, from, into); err != nil {
+			return err
+		}
+	}
+	_, err := app.db(ctx).ExecContext(ctx, "DELETE FROM users WHERE id = $1", from)
+	return err
+}
+
+// identities returns the providers connected to userID and those that
+// could be.
+func (app *Application) identities(ctx context.Context, userID int) (identitiesForm, error) {
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT provider, email FROM identities WHERE user_id = $1 ORDER BY provider", userID)
+	if err != nil {
+		return identitiesForm{}, err
+	}
+	defer rows.Close()
+
+	var form identitiesForm
+	linked := map[string]bool{}
+	for rows.Next() {
+		var id linkedIdentity
+		if err := rows.Scan(&id.Provider, &id.Email); err != nil {
+			return identitiesForm{}, err
+		}
+		id.Label = id.Provider
+		if p := app.oauthProvider(id.Provider); p != nil {
+			id.Label = p.Label
+		}
+		linked[id.Provider] = true
+		form.Identities = append(form.Identities, id)
+	}
+	for _, p := range app.OAuth {
+		if !linked[p.Name] {
+			form.Available = append(form.Available, p)
+		}
+	}
+	return form, rows.Err()
+}
+
+// unlinkIdentity disconnects a provider from the current account, unless
+// it's the only way left to log in.
+func (app *Application) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	provider := chi.URLParam(r, "provider")
+
+	var message string
+	err := app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		message = ""
+		var others int
+		var hasPassword bool
+		err := app.db(ctx).QueryRowContext(ctx, `
+			SELECT (SELECT COUNT(*) FROM identities WHERE user_id = $1 AND provider <> $2),
+			       password_hash <> ''
+			FROM users WHERE id = $1 FOR UPDATE`, user.ID, provider,
+		).Scan(&others, &hasPassword)
+		if err != nil {
+			return err
+		}
+		if others == 0 && !hasPassword {
+			message = "Set a password or connect another provider first, or you won't be able to log in."
+			return nil
+		}
+		_, err = app.db(ctx).ExecContext(ctx, "DELETE FROM identities WHERE user_id = $1 AND provider = $2", user.ID, provider)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	form, err := app.identities(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form.Error = message
+	app.Templates.ExecuteTemplate(w, "identities", form)
+}
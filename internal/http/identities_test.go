@@ -0,0 +1,91 @@
+package http
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	createTable = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+	userColumn  = regexp.MustCompile(`^\s*(\w+) INTEGER\b[^,]*REFERENCES users\(id\)`)
+	addColumn   = regexp.MustCompile(`ALTER TABLE (\w+) ADD COLUMN IF NOT EXISTS (\w+) INTEGER\b[^;]*REFERENCES users\(id\)`)
+)
+
+// userColumns finds the columns referencing users(id) in the schema the
+// app's packages create, as table.column.
+func userColumns(t *testing.T) []string {
+	t.Helper()
+	var columns []string
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		table := ""
+		for _, line := range strings.Split(string(src), "\n") {
+			if m := createTable.FindStringSubmatch(line); m != nil {
+				table = m[1]
+			}
+			if m := addColumn.FindStringSubmatch(line); m != nil {
+				columns = append(columns, m[1]+"."+m[2])
+			} else if m := userColumn.FindStringSubmatch(line); m != nil && table != "" {
+				columns = append(columns, table+"."+m[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return columns
+}
+
+// TestMergeStatements checks that merging accounts moves every column
+// referencing users, or leaves it behind on purpose, so a table added
+// later can't be emptied by a merge unnoticed.
+func TestMergeStatements(t *testing.T) {
+	columns := userColumns(t)
+	if len(columns) < 10 {
+		t.Fatalf("found only %v referencing users; is the schema still written the same way?", columns)
+	}
+	for _, column := range columns {
+		table, name, _ := strings.Cut(column, ".")
+		moved := false
+		for _, stmt := range mergeStatements {
+			stmt = strings.Join(strings.Fields(stmt), " ")
+			if strings.HasPrefix(stmt, "UPDATE "+table+" SET "+name+" = $2 ") ||
+				strings.HasPrefix(stmt, "INSERT INTO "+table+" (") && strings.Contains(stmt, name) {
+				moved = true
+			}
+		}
+		_, dropped := mergeDropped[column]
+		switch {
+		case moved && dropped:
+			t.Errorf("%s is both moved and in mergeDropped", column)
+		case !moved && !dropped:
+			t.Errorf("merging accounts loses %s: move it in mergeStatements, or add it to mergeDropped saying why not", column)
+		}
+	}
+	for column := range mergeDropped {
+		found := false
+		for _, c := range columns {
+			found = found || c == column
+		}
+		if !found {
+			t.Errorf("mergeDropped has %s, which doesn't reference users", column)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// impersonation is an admin acting as the current user, for the banner.
+type impersonation struct {
+	Admin *model.User
+	Ends  time.Time
+}
+
+type impersonateForm struct {
+	Email string
+	Error string
+}
+
+func impersonationOf(r *http.Request) *impersonation {
+	s := scopeOf(r.Context())
+	if s.Impersonator == nil {
+		return nil
+	}
+	return &impersonation{Admin: s.Impersonator, Ends: s.Session.ImpersonationEnds}
+}
+
+// impersonate switches the scope from the session's admin to the user they
+// are acting as. A session whose user is no longer an admin, or whose
+// target has been deleted, goes back to being its own.
+func (app *Application) impersonate(r *http.Request, s *requestScope) error {
+	if !s.User.IsAdmin {
+		return app.Sessions.StopImpersonating(r.Context(), s.Session.ID)
+	}
+	target, err := app.loadUser(r.Context(), s.Session.Impersonating)
+	if errors.Is(err, sql.ErrNoRows) {
+		return app.Sessions.StopImpersonating(r.Context(), s.Session.ID)
+	}
+	if err != nil {
+		return err
+	}
+	s.Impersonator, s.User = s.User, &target
+	return nil
+}
+
+// auditImpersonation records every change an admin makes while acting as
+// someone else, whether or not it goes on to succeed.
+func (app *Application) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := scopeOf(r.Context())
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if s.Impersonator != nil {
+				app.audit(r, audit.Event{
+					UserID: s.User.ID,
+					Email:  s.User.Email,
+					Action: audit.Impersonated,
+					Detail: s.Impersonator.Email + ": " + r.Method + " " + r.URL.Path,
+				})
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notImpersonating keeps admins acting as someone from changing how that
+// person logs in.
+func (app *Application) notImpersonating(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scopeOf(r.Context()).Impersonator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if htmx.IsRequest(r) {
+			htmx.Retarget(w, "#alerts")
+			htmx.Reswap(w, htmx.InnerHTML)
+		}
+		http.Error(w, "Not available while impersonating", http.StatusForbidden)
+	})
+}
+
+// startImpersonation has the admin's session act as the user with the
+// posted email for IMPERSONATION_TTL. Other admins can't be impersonated.
+func (app *Application) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	form := impersonateForm{Email: strings.TrimSpace(r.FormValue("email"))}
+	admin, sess := currentUser(r)
+	if admin == nil {
+		form.Error = "Log in with an admin account to impersonate someone."
+		app.Templates.ExecuteTemplate(w, "impersonate-form", form)
+		return
+	}
+
+	var targetID int
+	err := app.DB.QueryRowContext(r.Context(),
+		"SELECT id FROM users WHERE lower(email) = lower($1)", form.Email,
+	).Scan(&targetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Error = "There's no account with that email."
+		app.Templates.ExecuteTemplate(w, "impersonate-form", form)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	target, err := app.loadUser(r.Context(), targetID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if target.IsAdmin {
+		form.Error = "Admins can't be impersonated."
+		app.Templates.ExecuteTemplate(w, "impersonate-form", form)
+		return
+	}
+
+	if err := app.Sessions.Impersonate(r.Context(), sess.ID, target.ID, time.Now().Add(app.Config.ImpersonationTTL)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, audit.Event{UserID: target.ID, Email: target.Email, Action: audit.ImpersonationStarted, Detail: admin.Email})
+	htmx.Redirect(w, "/")
+}
+
+// stopImpersonation returns the admin to their own account.
+func (app *Application) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	s := scopeOf(r.Context())
+	if s.Impersonator == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err := app.Sessions.StopImpersonating(r.Context(), s.Session.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, audit.Event{UserID: s.User.ID, Email: s.User.Email, Action: audit.ImpersonationEnded, Detail: s.Impersonator.Email})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,281 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/connector"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// listPage is the main page: one list's todos with the user's lists in a
+// sidebar.
+type listPage struct {
+	Page
+	List  model.List
+	Lists []model.List
+	// Shared lists show who else is viewing them.
+	Shared bool
+	Fields []model.Field
+}
+
+func (p listPage) CanEdit() bool {
+	return p.List.CanEdit()
+}
+
+// ActiveLists and ArchivedLists split the sidebar.
+func (p listPage) ActiveLists() []model.List {
+	var lists []model.List
+	for _, l := range p.Lists {
+		if !l.Archived {
+			lists = append(lists, l)
+		}
+	}
+	return lists
+}
+
+func (p listPage) ArchivedLists() []model.List {
+	var lists []model.List
+	for _, l := range p.Lists {
+		if l.Archived {
+			lists = append(lists, l)
+		}
+	}
+	return lists
+}
+
+// MoveTargets are the other lists todos here can be bulk moved to.
+func (p listPage) MoveTargets() []model.List {
+	if !p.CanEdit() {
+		return nil
+	}
+	return moveTargets(p.Lists, p.List.ID)
+}
+
+type membersPage struct {
+	Page
+	List    model.List
+	Members []model.Member
+	Fields  []model.Field
+	// Statuses, like Connections, are only shown to owners.
+	Statuses    []model.Status
+	Connections connectionsForm
+	Error       string
+}
+
+func (p membersPage) Retention() retentionForm {
+	return retentionForm{List: p.List}
+}
+
+func (p membersPage) FieldsForm() fieldsForm {
+	return fieldsForm{List: p.List, Fields: p.Fields, Kinds: model.FieldKinds}
+}
+
+// createList makes a new list owned by userID.
+func (app *Application) createList(ctx context.Context, userID int, name string) (int, error) {
+	var id int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if id, err = app.Queries.CreateList(ctx, name); err != nil {
+			return err
+		}
+		return app.Queries.AddMember(ctx, id, userID, model.Owner)
+	})
+	return id, err
+}
+
+// defaultList returns the first list userID owns. An account that has none
+// is new, or has given all its lists away, and is set up with an Inbox and
+// the onboarding checklist.
+func (app *Application) defaultList(ctx context.Context, userID int) (int, error) {
+	id, err := app.Queries.FirstOwnedList(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return app.setUpAccount(ctx, userID)
+	}
+	return id, err
+}
+
+func (app *Application) homeHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := app.defaultList(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/lists/%d", id), http.StatusSeeOther)
+}
+
+// loadList loads the list a request has access to, with the caller's role.
+func (app *Application) loadList(ctx context.Context, a access) (model.List, error) {
+	l, err := app.Queries.GetList(ctx, a.ListID)
+	l.Role = a.Role
+	return l, err
+}
+
+// listPage loads the page for the list a request has access to.
+func (app *Application) listPage(r *http.Request) (listPage, error) {
+	user, _ := currentUser(r)
+	data := listPage{Page: app.page(r)}
+
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		return data, err
+	}
+	if data.Lists, err = app.userLists(r.Context(), user.ID); err != nil {
+		return data, err
+	}
+	if data.Fields, err = app.listFields(r.Context(), data.List.ID); err != nil {
+		return data, err
+	}
+	members, err := app.Queries.CountMembers(r.Context(), data.List.ID)
+	data.Shared = members > 1
+	return data, err
+}
+
+func (app *Application) listHandler(w http.ResponseWriter, r *http.Request) {
+	modified, err := app.listModified(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, modified) {
+		return
+	}
+
+	data, err := app.listPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Outage.savePage(r, data)
+	app.render(w, r, view{Fragment: "index.html", Data: data, JSON: data.List})
+}
+
+func (app *Application) newList(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name required", http.StatusBadRequest)
+		return
+	}
+
+	id, err := app.createList(r.Context(), user.ID, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.forgetUsers(r.Context(), user.ID)
+	app.advanceOnboarding(r.Context(), user.ID, stepList)
+	http.Redirect(w, r, fmt.Sprintf("/lists/%d", id), http.StatusSeeOther)
+}
+
+func (app *Application) membersPage(r *http.Request, message string) (membersPage, error) {
+	data := membersPage{Page: app.page(r), Error: message}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		return data, err
+	}
+	if data.Members, err = app.Queries.ListMembers(r.Context(), data.List.ID); err != nil {
+		return data, err
+	}
+	if data.Fields, err = app.Queries.ListFields(r.Context(), data.List.ID); err != nil {
+		return data, err
+	}
+	if data.List.Role == model.Owner {
+		if data.Statuses, err = app.Queries.ListStatuses(r.Context(), data.List.ID); err != nil {
+			return data, err
+		}
+		data.Connections = connectionsForm{List: data.List, Providers: connector.Providers(), BaseURL: app.Config.BaseURL}
+		data.Connections.Connections, err = app.Connectors.ForList(r.Context(), data.List.ID)
+	}
+	return data, err
+}
+
+func (app *Application) renderMembers(w http.ResponseWriter, r *http.Request, name, message string) {
+	data, err := app.membersPage(r, message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	members := data.Members
+	if members == nil {
+		members = []model.Member{}
+	}
+	app.render(w, r, view{Fragment: name, Data: data, JSON: members})
+}
+
+func (app *Application) membersHandler(w http.ResponseWriter, r *http.Request) {
+	app.renderMembers(w, r, "members.html", "")
+}
+
+// shareList adds a member by email, or changes the role of an existing one.
+func (app *Application) shareList(w http.ResponseWriter, r *http.Request) {
+	role, err := model.ParseRole(r.FormValue("role"))
+	if err != nil {
+		app.renderMembers(w, r, "member-list", err.Error())
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	found, err := app.Queries.ShareList(r.Context(), listAccess(r).ListID, email, role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		app.renderMembers(w, r, "member-list", "No account with that email. Ask them to sign up first.")
+		return
+	}
+	user, _ := currentUser(r)
+	app.audit(r, audit.Event{
+		UserID: user.ID,
+		Email:  user.Email,
+		Action: audit.ListShared,
+		Detail: fmt.Sprintf("list %d with %s as %s", listAccess(r).ListID, email, role),
+	})
+	app.advanceOnboarding(r.Context(), user.ID, stepShare)
+
+	app.renderMembers(w, r, "member-list", "")
+}
+
+// removeMember takes someone off a list. The last owner can't be removed,
+// so every list keeps someone who can manage it.
+func (app *Application) removeMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	var removed bool
+	err = app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		listID := listAccess(r).ListID
+		if removed, err = app.Queries.RemoveMember(ctx, listID, userID); err != nil || !removed {
+			return err
+		}
+		// Someone who's left a list can't be doing its todos.
+		_, err := app.db(ctx).ExecContext(ctx,
+			"UPDATE todos SET assignee_id = NULL WHERE list_id = $1 AND assignee_id = $2", listID, userID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	message := ""
+	if !removed {
+		message = "A list needs at least one owner."
+	} else {
+		app.forgetUsers(r.Context(), userID)
+	}
+
+	app.renderMembers(w, r, "member-list", message)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// todoList is what todo-list.html renders: a list's todos laid out the way
+// the user viewing them likes.
+type todoList struct {
+	Todos []model.Todo
+	View  model.ListView
+}
+
+// Rows pairs each todo with the layout, for todo-item.
+func (l todoList) Rows() []todoRow {
+	rows := make([]todoRow, len(l.Todos))
+	for i, t := range l.Todos {
+		rows[i] = todoRow{Todo: t, View: l.View}
+	}
+	return rows
+}
+
+// todoRow is what todo-item renders: one todo and the layout it's shown in.
+type todoRow struct {
+	model.Todo
+	View model.ListView
+}
+
+// listView is how the user behind r likes lists laid out, or the default
+// layout for anyone else.
+func listView(r *http.Request) model.ListView {
+	if user, _ := currentUser(r); user != nil {
+		return user.ListView
+	}
+	return model.ListView{}
+}
+
+type listViewForm struct {
+	View    model.ListView
+	Columns []model.Column
+	Message string
+}
+
+// saveListView sets how the user's lists are laid out: compact or
+// comfortable, and which columns are shown. Unknown columns are ignored.
+func (app *Application) saveListView(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	r.ParseForm()
+	view := model.ListView{Density: model.Comfortable}
+	if r.PostForm.Get("density") == model.Compact {
+		view.Density = model.Compact
+	}
+	view.Hidden = []string{}
+	for _, c := range model.Columns {
+		if !slices.Contains(r.PostForm["column"], c.Key) {
+			view.Hidden = append(view.Hidden, c.Key)
+		}
+	}
+
+	_, err := app.DB.ExecContext(r.Context(), `
+		UPDATE users SET list_density = $2, hidden_columns = $3, list_view_updated_at = NOW()
+		WHERE id = $1`,
+		user.ID, view.Density, pq.Array(view.Hidden),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "list-view-form", listViewForm{View: view, Columns: model.Columns, Message: "Saved."})
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+)
+
+// TestMain runs the tests from the repository root, where the app runs
+// from, so templates and static files are found at the same paths.
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+var (
+	parseOnce sync.Once
+	parsed    *Templates
+	parseErr  error
+)
+
+// testTemplates parses the templates once for every test that needs them.
+func testTemplates(t *testing.T) *Templates {
+	t.Helper()
+	parseOnce.Do(func() {
+		var staticAssets *assets.Pipeline
+		if staticAssets, parseErr = assets.Build(bundles); parseErr == nil {
+			parsed = parseTemplates("templates", staticAssets)
+		}
+	})
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return parsed
+}
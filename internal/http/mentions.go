@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+)
+
+// maxMentions is how many suggestions the @-mention typeahead shows.
+const maxMentions = 8
+
+// mentionPattern finds @-mentions in a description: an @ followed by the
+// email the typeahead inserts, at the start or after a space.
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([^\s@]+@[^\s@]+)`)
+
+// mentionedEmails returns the emails mentioned in text, lower-cased,
+// without punctuation that ends the sentence they're in.
+func mentionedEmails(text string) map[string]bool {
+	emails := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		emails[strings.ToLower(strings.TrimRight(m[1], ".,;:!?)"))] = true
+	}
+	return emails
+}
+
+// notifyMentioned tells the list's members who are newly @-mentioned in a
+// todo's description, by push notification through the outbox in ctx's
+// transaction, so they hear about it once the edit commits. Mentions that
+// were already in before don't notify again, and nobody is told about
+// mentioning themselves or about a list they aren't on.
+func (app *Application) notifyMentioned(ctx context.Context, todo model.Todo, before, after string, by *model.User) error {
+	mentioned, already := mentionedEmails(after), mentionedEmails(before)
+	if len(mentioned) == 0 {
+		return nil
+	}
+	members, err := app.Queries.ListMembers(ctx, todo.ListID)
+	if err != nil {
+		return err
+	}
+	list, err := app.Queries.GetList(ctx, todo.ListID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		email := strings.ToLower(m.Email)
+		if !mentioned[email] || already[email] || m.UserID == by.ID {
+			continue
+		}
+		err := app.queuePush(ctx, m.UserID, push.Notification{
+			Title: fmt.Sprintf("%s mentioned you in %s", by.Email, list.Name),
+			Body:  todo.Title,
+			URL:   fmt.Sprintf("%s/todos/%d", app.Config.BaseURL, todo.ID),
+			Tag:   fmt.Sprintf("mention-%d", todo.ID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mentionOptions struct {
+	Query   string
+	Members []model.Member
+}
+
+// mentionsHandler suggests people to @-mention. With ?list= it offers that
+// list's members, so a comment can only mention people who can see it;
+// without, everyone the caller shares a list with. q matches the start of
+// an email, with or without the @ typed in the comment box.
+func (app *Application) mentionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	q := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@")
+
+	list := r.URL.Query().Get("list")
+	if list == "" {
+		members, err := app.Queries.MentionTeammates(r.Context(), user.ID, q, maxMentions)
+		app.renderMentions(w, r, q, members, err)
+		return
+	}
+
+	listID, err := strconv.Atoi(list)
+	if err != nil {
+		http.Error(w, "list must be a list ID", http.StatusBadRequest)
+		return
+	}
+	role, err := app.roleFor(r.Context(), user, listID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if role < model.Viewer {
+		app.forbidden(w, r)
+		return
+	}
+	members, err := app.Queries.MentionMembers(r.Context(), listID, user.ID, q, maxMentions)
+	app.renderMentions(w, r, q, members, err)
+}
+
+func (app *Application) renderMentions(w http.ResponseWriter, r *http.Request, q string, members []model.Member, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []model.Member{}
+	}
+	app.render(w, r, view{Fragment: "mention-options", Data: mentionOptions{Query: q, Members: members}, JSON: members})
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// Limits on what an integration can keep on a todo: metadata is for IDs
+// and small bits of sync state, not documents.
+const (
+	maxMetadataValue = 2 << 10
+	maxMetadataKeys  = 50
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// metadataNamespace is where a token's metadata is kept in a todo's
+// metadata column. It includes the user, so two people syncing a shared
+// list with the same tool don't overwrite each other.
+func metadataNamespace(t *apitoken.Token) string {
+	return strconv.Itoa(t.UserID) + ":" + t.Namespace
+}
+
+// apiTodo is a todo as the API returns it to a token: with the metadata
+// the token's namespace holds on it.
+type apiTodo struct {
+	model.Todo
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// requireToken turns away metadata requests made without an API token,
+// since there's no namespace to use.
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiToken(r) == nil {
+			jsonError(w, http.StatusForbidden, "Metadata is kept per API token; send one in the Authorization header")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withMetadata pairs todos with the metadata token keeps on them.
+func (app *Application) withMetadata(r *http.Request, token *apitoken.Token, todos []model.Todo) ([]apiTodo, error) {
+	ids := make([]int64, len(todos))
+	for i, t := range todos {
+		ids[i] = int64(t.ID)
+	}
+	metadata, err := app.Queries.TodosMetadata(r.Context(), ids, metadataNamespace(token))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]apiTodo, len(todos))
+	for i, t := range todos {
+		out[i] = apiTodo{Todo: t, Metadata: metadata[t.ID]}
+	}
+	return out, nil
+}
+
+// metadataFilter reads a "metadata.<key>=<value>" query parameter, which
+// narrows the todo list to those whose metadata key holds value.
+func metadataFilter(r *http.Request) (key, value string, ok bool, err error) {
+	for param, values := range r.URL.Query() {
+		k, found := strings.CutPrefix(param, "metadata.")
+		if !found {
+			continue
+		}
+		if ok {
+			return "", "", false, errors.New("Filter on one metadata key at a time")
+		}
+		if !metadataKeyPattern.MatchString(k) {
+			return "", "", false, fmt.Errorf("Invalid metadata key %q", k)
+		}
+		key, value, ok = k, values[0], true
+	}
+	return key, value, ok, nil
+}
+
+func (app *Application) apiGetMetadata(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	metadata, err := app.Queries.GetMetadata(r.Context(), id, metadataNamespace(apiToken(r)))
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// apiSetMetadata sets one key from the request body, which can be any JSON
+// value up to maxMetadataValue bytes.
+func (app *Application) apiSetMetadata(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	key := chi.URLParam(r, "key")
+	if !metadataKeyPattern.MatchString(key) {
+		jsonError(w, http.StatusBadRequest, "Keys are up to 64 letters, digits, dots, dashes and underscores")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMetadataValue+1))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var value bytes.Buffer
+	if err := json.Compact(&value, body); err != nil {
+		jsonError(w, http.StatusBadRequest, "The body must be a JSON value")
+		return
+	}
+	if value.Len() > maxMetadataValue {
+		jsonError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Metadata values are limited to %d bytes", maxMetadataValue))
+		return
+	}
+
+	metadata, err := app.Queries.SetMetadata(r.Context(), id, metadataNamespace(apiToken(r)), key, value.Bytes(), maxMetadataKeys)
+	if errors.Is(err, store.ErrTooManyKeys) {
+		jsonError(w, http.StatusUnprocessableEntity, fmt.Sprintf("A todo holds at most %d metadata keys per namespace", maxMetadataKeys))
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+func (app *Application) apiDeleteMetadata(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	deleted, err := app.Queries.DeleteMetadata(r.Context(), id, metadataNamespace(apiToken(r)), chi.URLParam(r, "key"))
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		jsonError(w, http.StatusNotFound, "No such metadata key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,333 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+	"github.com/Trailblazors/htmx-go-postgres/internal/errreport"
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/querylog"
+	"github.com/Trailblazors/htmx-go-postgres/internal/ratelimit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+)
+
+type contextKey string
+
+const visitorKey contextKey = "visitor"
+
+// visitor assigns every browser a long-lived random ID so per-user features
+// like percentage rollouts stay stable across requests.
+func visitor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		if c, err := r.Cookie("vid"); err == nil && c.Value != "" {
+			id = c.Value
+		} else {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     "vid",
+				Value:    id,
+				Path:     "/",
+				MaxAge:   365 * 24 * 60 * 60,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), visitorKey, id)))
+	})
+}
+
+func visitorID(r *http.Request) string {
+	id, _ := r.Context().Value(visitorKey).(string)
+	return id
+}
+
+// loadSession resolves the session cookie to a user. Unknown, expired and
+// revoked tokens are cleared so the browser stops sending them. A replayed
+// request runs as the user who made it, without a session.
+func (app *Application) loadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rp := replayOf(r); rp != nil {
+			scopeOf(r.Context()).User = rp.User
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie(SessionCookie)
+		if err != nil || c.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sess, err := app.Sessions.Load(r.Context(), c.Value)
+		if errors.Is(err, session.ErrNotFound) {
+			app.clearSessionCookie(w, r)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user, err := app.loadUser(r.Context(), sess.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s := scopeOf(r.Context())
+		s.User, s.Session = &user, sess
+		if sess.Impersonating != 0 {
+			if err := app.impersonate(r, s); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly lets admin accounts into the admin area. Without one, HTTP basic
+// auth against ADMIN_PASSWORD still works, so an instance can be managed
+// before anyone has signed up. With neither, the admin area doesn't exist.
+func (app *Application) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := currentUser(r); user != nil && user.IsAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if app.Config.AdminPassword == "" {
+			app.notFound(w, r)
+			return
+		}
+		_, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(app.Config.AdminPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceMode turns requests away while the app is under maintenance.
+// Health and readiness checks, static files, asset bundles and the admin area always get through so the
+// platform keeps the instance alive and an admin can switch it back. So do
+// logging in and out, with a password or a provider, or an admin who isn't
+// signed in couldn't get to the admin area. Of the provider routes only the
+// GETs get through, and oauthCallback only logs in to existing accounts
+// while maintenance is on; confirming a link, which can merge accounts, is
+// turned away like any other write.
+func (app *Application) maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/readyz" ||
+			r.URL.Path == "/login" || r.URL.Path == "/logout" ||
+			(strings.HasPrefix(r.URL.Path, "/auth/") && r.Method == http.MethodGet) ||
+			strings.HasPrefix(r.URL.Path, "/static/") ||
+			strings.HasPrefix(r.URL.Path, assets.Prefix) ||
+			strings.HasPrefix(r.URL.Path, "/admin") ||
+			strings.HasPrefix(r.URL.Path, "/status") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch app.Maintenance.Mode(r.Context()) {
+		case maintenance.Full:
+			// A fragment swap can't show the maintenance page, so have htmx
+			// reload into it instead.
+			if htmx.IsRequest(r) {
+				htmx.Refresh(w)
+			}
+			w.Header().Set("Retry-After", "300")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			app.Templates.ExecuteTemplate(w, "maintenance.html", nil)
+			return
+		case maintenance.ReadOnly:
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Retry-After", "300")
+				http.Error(w, "The app is read-only during maintenance", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverer replaces chi's Recoverer: panics and 5xx responses are reported
+// with their stack trace and request context instead of only being logged.
+// A panic gets the friendly error page, or in development and when an
+// admin replays a request, a page with the stack trace, the request and
+// the SQL it ran.
+func (app *Application) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debugging := app.Config.Dev || replayOf(r) != nil
+		queries := querylog.FromContext(r.Context())
+		var form url.Values
+		if debugging {
+			if queries == nil {
+				var ctx context.Context
+				ctx, queries = querylog.NewContext(r.Context())
+				r = r.WithContext(ctx)
+			}
+			form = readForm(r)
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		body := &bytes.Buffer{}
+		ww.Tee(&limitedWriter{buf: body, max: 1024})
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				stack := debug.Stack()
+				app.Errors.Report(errreport.Event{
+					Message: fmt.Sprint(rec),
+					Stack:   stack,
+					Status:  http.StatusInternalServerError,
+					Request: r,
+					UserID:  reportedUser(r),
+				})
+				if ww.Status() != 0 {
+					return
+				}
+				if debugging {
+					app.renderPanic(w, r, rec, stack, form, queries)
+					return
+				}
+				app.renderError(w, r, errorPage{
+					Status:  http.StatusInternalServerError,
+					Heading: "Something went wrong",
+					Message: "Sorry, something went wrong on our end. Please try again in a little while.",
+				})
+				return
+			}
+			if ww.Status() >= 500 {
+				app.Errors.Report(errreport.Event{
+					Message: strings.TrimSpace(body.String()),
+					Status:  ww.Status(),
+					Request: r,
+					UserID:  reportedUser(r),
+				})
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// reportedUser is who an error report is tied to: the signed-in user's
+// ID, or the visitor ID of someone signed out. loadSession runs after
+// recoverer, but fills in the same request scope, so the user is known
+// here by the time a handler fails.
+func reportedUser(r *http.Request) string {
+	if user, _ := currentUser(r); user != nil {
+		return strconv.Itoa(user.ID)
+	}
+	return visitorID(r)
+}
+
+// limitedWriter keeps the first max bytes written to it, so error responses
+// can be reported without buffering whole pages.
+type limitedWriter struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if room := lw.max - lw.buf.Len(); room > 0 {
+		if len(p) > room {
+			lw.buf.Write(p[:room])
+		} else {
+			lw.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// rateLimit applies the limiter's policies for group. Signed-in users are
+// on the free plan, counted per API token or per user, so people sharing
+// an address don't share a quota; everyone else is anonymous and counted
+// per client IP. Nobody is on the paid plan until there's billing.
+func (app *Application) rateLimit(group string) func(http.Handler) http.Handler {
+	return app.Limiter.Middleware(group, ratePlan, rateKey)
+}
+
+func ratePlan(r *http.Request) ratelimit.Plan {
+	if user, _ := currentUser(r); user != nil {
+		return ratelimit.Free
+	}
+	return ratelimit.Anonymous
+}
+
+func rateKey(r *http.Request) string {
+	if token := apiToken(r); token != nil {
+		return fmt.Sprintf("token:%d", token.ID)
+	}
+	if user, _ := currentUser(r); user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// usageSubject identifies who a request is counted against, as rate
+// limits count them: the API token or user, so an account's usage adds up
+// across devices, or the client IP for someone signed out.
+func usageSubject(r *http.Request) string {
+	return rateKey(r)
+}
+
+// userSubjects are the usage subjects that are userID's: the user, and
+// each of their API tokens.
+func userSubjects(userID int, tokens []apitoken.Token) []string {
+	subjects := []string{fmt.Sprintf("user:%d", userID)}
+	for _, t := range tokens {
+		subjects = append(subjects, fmt.Sprintf("token:%d", t.ID))
+	}
+	return subjects
+}
+
+// trackUsage counts requests per subject and route pattern, and times them
+// for the status page. It records after the handler has run, so a token
+// checked further in counts.
+func (app *Application) trackUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/static/") || strings.HasPrefix(r.URL.Path, assets.Prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		app.Usage.Record(usageSubject(r), r.Method, route, status)
+		app.Metrics.Observe(status, time.Since(start))
+	})
+}
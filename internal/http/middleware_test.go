@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+func TestReportedUser(t *testing.T) {
+	tests := []struct {
+		name string
+		user *model.User
+		want string
+	}{
+		{"signed in", &model.User{ID: 42}, "42"},
+		{"signed out", nil, "visitor-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			// As loadSession does, further in than recoverer.
+			h := scoped(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				scopeOf(r.Context()).User = tt.user
+				got = reportedUser(r)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(context.WithValue(r.Context(), visitorKey, "visitor-1"))
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("reportedUser = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageSubjects(t *testing.T) {
+	var got string
+	h := scoped(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopeOf(r.Context()).User = &model.User{ID: 7}
+		got = usageSubject(r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got != "user:7" {
+		t.Errorf("usageSubject = %q, want user:7", got)
+	}
+
+	// The settings page reads back what the user's requests and tokens
+	// were counted under.
+	subjects := userSubjects(7, []apitoken.Token{{ID: 3}, {ID: 4}})
+	if want := []string{"user:7", "token:3", "token:4"}; !slices.Equal(subjects, want) {
+		t.Errorf("userSubjects = %v, want %v", subjects, want)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	tests := []struct {
+		mode        maintenance.Mode
+		method      string
+		target      string
+		getsThrough bool
+	}{
+		{maintenance.Full, http.MethodGet, "/lists/1", false},
+		{maintenance.Full, http.MethodGet, "/login", true},
+		{maintenance.Full, http.MethodGet, "/auth/github", true},
+		{maintenance.Full, http.MethodGet, "/auth/github/callback", true},
+		{maintenance.Full, http.MethodPost, "/auth/link", false},
+		{maintenance.ReadOnly, http.MethodGet, "/lists/1", true},
+		{maintenance.ReadOnly, http.MethodPost, "/lists/1/todos", false},
+		{maintenance.ReadOnly, http.MethodPost, "/auth/link", false},
+		{maintenance.Off, http.MethodPost, "/auth/link", true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+" "+tt.method+" "+tt.target, func(t *testing.T) {
+			app := newTestApp(t)
+			app.Maintenance = maintenance.NewStore(nil, tt.mode)
+			var through bool
+			h := app.maintenanceMode(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				through = true
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if through != tt.getsThrough {
+				t.Errorf("got through = %v, want %v", through, tt.getsThrough)
+			}
+			if !through && w.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
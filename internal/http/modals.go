@@ -0,0 +1,205 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// renderModal renders the named fragment and shows it in the layout's
+// modal container. Buttons that open a modal target #modal; handlers that
+// complete one call closeModal.
+func (app *Application) renderModal(w http.ResponseWriter, title, name string, data any) {
+	var body bytes.Buffer
+	if err := app.Templates.ExecuteTemplate(&body, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.RenderComponent(w, "modal", modal{Title: title, Body: template.HTML(body.String())})
+}
+
+// closeModal tells the page to close whatever modal is open once this
+// response is swapped in.
+func closeModal(w http.ResponseWriter) {
+	htmx.Trigger(w, "closeModal")
+}
+
+// todoFromURL loads the todo named by the {id} URL parameter, answering 404
+// itself when there's no such todo.
+func (app *Application) todoFromURL(w http.ResponseWriter, r *http.Request) (model.Todo, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return model.Todo{}, false
+	}
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return model.Todo{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return model.Todo{}, false
+	}
+	return todo, true
+}
+
+type editForm struct {
+	Todo   model.Todo
+	Fields []model.Field
+	// Approval offers owners the choice of whether the todo needs their
+	// approval to complete, on lists with approvals turned on.
+	Approval bool
+	Error    string
+}
+
+// canRequireApproval reports whether the caller decides whether todos on
+// the list need approval: its owners do, when it has approvals on.
+func (app *Application) canRequireApproval(r *http.Request, listID int) (bool, error) {
+	if listAccess(r).Role < model.Owner {
+		return false, nil
+	}
+	list, err := app.Queries.GetList(r.Context(), listID)
+	return list.Approvals, err
+}
+
+func (app *Application) getEditForm(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	fields, err := app.listFields(r.Context(), todo.ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	approval, err := app.canRequireApproval(r, todo.ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderModal(w, "Edit todo", "edit-form", editForm{Todo: todo, Fields: fields, Approval: approval})
+}
+
+// updateTodo saves the edit modal. Validation errors re-render the modal
+// in place rather than replacing the list.
+func (app *Application) updateTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	description := strings.TrimSpace(r.FormValue("description"))
+	fields, err := app.listFields(r.Context(), todo.ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	approval, err := app.canRequireApproval(r, todo.ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	needsApproval := r.FormValue("needs_approval") != ""
+	estimate, due, err := parseEffort(r.FormValue("estimate"), r.FormValue("due_date"))
+	values, fieldsErr := parseFields(r, fields)
+	if err == nil {
+		err = fieldsErr
+	}
+	if title == "" {
+		err = errors.New("Title required")
+	}
+	place := strings.TrimSpace(r.FormValue("place"))
+	latitude, longitude := todo.Latitude, todo.Longitude
+	if err == nil && place != todo.Place {
+		latitude, longitude, err = app.geocode(r.Context(), place)
+	}
+	if err != nil {
+		todo.Title = title
+		todo.Description = description
+		todo.Place = place
+		todo.Fields = enteredFields(r, fields)
+		if approval {
+			todo.NeedsApproval = needsApproval
+		}
+		htmx.Retarget(w, "#modal")
+		htmx.Reswap(w, htmx.InnerHTML)
+		app.renderModal(w, "Edit todo", "edit-form", editForm{Todo: todo, Fields: fields, Approval: approval, Error: err.Error()})
+		return
+	}
+
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "edit", []int{todo.ID}, func(ctx context.Context) ([]int, error) {
+		err := app.Queries.UpdateTodo(ctx, store.UpdateTodoParams{
+			ID:              todo.ID,
+			Title:           title,
+			Description:     description,
+			EstimateMinutes: estimate,
+			DueDate:         due,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := app.Queries.SetTodoPlace(ctx, todo.ID, place, latitude, longitude); err != nil {
+			return nil, err
+		}
+		edited := todo
+		edited.Title = title
+		if err := app.notifyMentioned(ctx, edited, todo.Description, description, user); err != nil {
+			return nil, err
+		}
+		if approval {
+			// No longer needing approval drops a request waiting on it.
+			_, err := app.db(ctx).ExecContext(ctx, `
+				UPDATE todos SET needs_approval = $2,
+					approval_requested_by = CASE WHEN $2 THEN approval_requested_by END,
+					approval_requested_at = CASE WHEN $2 THEN approval_requested_at END
+				WHERE id = $1`,
+				todo.ID, needsApproval,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, app.Queries.SetTodoFields(ctx, todo.ID, values)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if due != nil {
+		app.advanceOnboarding(r.Context(), user.ID, stepDue)
+	}
+
+	closeModal(w)
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
+
+// getDeleteConfirm is the first step of deleting a todo. Users who have
+// turned confirmations off get a fragment that fires the DELETE as soon as
+// it's swapped in, so the delete button works the same way for everyone and
+// GET never changes anything.
+func (app *Application) getDeleteConfirm(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	if user, _ := currentUser(r); !user.ConfirmDeletes {
+		app.Templates.ExecuteTemplate(w, "delete-now", todo)
+		return
+	}
+	app.renderModal(w, "Delete todo", "delete-confirm", todo)
+}
@@ -0,0 +1,203 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+type moveForm struct {
+	Todo    model.Todo
+	Targets []model.List
+}
+
+// moveTodos moves the given todos from one list to the top of another,
+// keeping their relative order, and closes the gap they leave behind.
+// Dependency links that would cross lists are dropped, since blockers have to
+// share a list, custom field values follow their field's name, and an
+// assignee who isn't on the new list is unassigned. Todos in ids that aren't
+// on from are left alone.
+func (app *Application) moveTodos(ctx context.Context, from, to int, ids []int) error {
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		db := app.db(ctx)
+		_, err := db.ExecContext(ctx, `
+			UPDATE todos t SET list_id = $2, position = n.base + n.rn
+			FROM (
+				SELECT id, row_number() OVER (ORDER BY position, id) AS rn,
+					(SELECT COALESCE(MAX(position), 0) FROM todos WHERE list_id = $2) AS base
+				FROM todos WHERE list_id = $1 AND id = ANY($3)
+			) n
+			WHERE t.id = n.id`,
+			from, to, pq.Array(ids),
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `
+			UPDATE todos t SET position = n.rn
+			FROM (SELECT id, row_number() OVER (ORDER BY position, id) AS rn FROM todos WHERE list_id = $1) n
+			WHERE t.id = n.id AND t.position <> n.rn`,
+			from,
+		)
+		if err != nil {
+			return err
+		}
+
+		if _, err := db.ExecContext(ctx, remapFields, pq.Array(ids)); err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `
+			UPDATE todos t SET assignee_id = NULL
+			WHERE t.id = ANY($1) AND t.assignee_id IS NOT NULL AND NOT EXISTS (
+				SELECT 1 FROM list_members m WHERE m.list_id = t.list_id AND m.user_id = t.assignee_id
+			)`,
+			pq.Array(ids),
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `
+			DELETE FROM todo_dependencies d
+			USING todos a, todos b
+			WHERE a.id = d.todo_id AND b.id = d.blocker_id AND a.list_id <> b.list_id
+			  AND (d.todo_id = ANY($1) OR d.blocker_id = ANY($1))`,
+			pq.Array(ids),
+		)
+		return err
+	})
+}
+
+// moveTargets picks the lists, other than from, that todos can be moved into.
+func moveTargets(lists []model.List, from int) []model.List {
+	var targets []model.List
+	for _, l := range lists {
+		if l.ID != from && l.Role >= model.Editor && !l.Archived {
+			targets = append(targets, l)
+		}
+	}
+	return targets
+}
+
+// destination reads the list_id form value and checks the caller may add to
+// that list. It answers the request itself and returns false when not.
+func (app *Application) destination(w http.ResponseWriter, r *http.Request) (int, bool) {
+	to, err := strconv.Atoi(r.FormValue("list_id"))
+	if err != nil || to == listAccess(r).ListID {
+		http.Error(w, "Pick a list", http.StatusBadRequest)
+		return 0, false
+	}
+	user, _ := currentUser(r)
+	role, err := app.roleFor(r.Context(), user, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if role < model.Editor {
+		app.forbidden(w, r)
+		return 0, false
+	}
+	return to, true
+}
+
+// getMoveForm shows a dropdown of lists to move a todo to, in a modal.
+func (app *Application) getMoveForm(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+
+	form := moveForm{Todo: todo}
+	lists, err := app.userLists(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form.Targets = moveTargets(lists, form.Todo.ListID)
+
+	app.renderModal(w, "Move to list", "move-form", form)
+}
+
+func (app *Application) moveTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	to, ok := app.destination(w, r)
+	if !ok {
+		return
+	}
+
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "move", []int{id}, func(ctx context.Context) ([]int, error) {
+		return nil, app.moveTodos(ctx, listAccess(r).ListID, to, []int{id})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.forgetList(r.Context(), to)
+
+	closeModal(w)
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
+
+// selectedTodos reads the ids of the todos ticked on a list's page. It
+// answers the request itself and returns false when they don't parse.
+func selectedTodos(w http.ResponseWriter, r *http.Request) ([]int, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	var ids []int
+	for _, v := range r.Form["todo_ids"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid todo", http.StatusBadRequest)
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// bulkMoveTodos moves the selected todos on a list to another list.
+func (app *Application) bulkMoveTodos(w http.ResponseWriter, r *http.Request) {
+	ids, ok := selectedTodos(w, r)
+	if !ok {
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "Select some todos to move", http.StatusBadRequest)
+		return
+	}
+	to, ok := app.destination(w, r)
+	if !ok {
+		return
+	}
+
+	user, _ := currentUser(r)
+	err := app.undoable(r.Context(), user.ID, "move", ids, func(ctx context.Context) ([]int, error) {
+		return nil, app.moveTodos(ctx, listAccess(r).ListID, to, ids)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.forgetList(r.Context(), to)
+
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+	app.Templates.RenderComponent(w, "toast", toast{Message: "Moved " + pluralize(len(ids), "todo", "todos") + "."})
+}
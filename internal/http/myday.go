@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/clock"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+type myDay struct {
+	Plan        []model.Todo
+	Suggestions []model.Todo
+	Others      []model.Todo
+}
+
+type myDayPage struct {
+	Page
+	Day myDay
+}
+
+func (d myDay) PlannedMinutes() int {
+	total := 0
+	for _, t := range d.Plan {
+		if !t.Completed {
+			total += t.EstimateMinutes
+		}
+	}
+	return total
+}
+
+func (d myDay) Planned() string {
+	return model.FormatDuration(int64(d.PlannedMinutes()) * 60)
+}
+
+// loadMyDay returns today's plan, suggestions (open todos that are overdue or
+// due today) and everything else still open on the user's lists that could be
+// pulled in.
+func (app *Application) loadMyDay(ctx context.Context, userID int) (myDay, error) {
+	var d myDay
+	today := app.today()
+	day := today.Format(time.DateOnly)
+
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(3)+` FROM todos
+		JOIN my_day m ON m.todo_id = todos.id AND m.day = $2::date AND m.user_id = $1
+		ORDER BY todos.completed, m.added_at`,
+		userID, day, app.Clock.Now(),
+	)
+	if err != nil {
+		return d, err
+	}
+	if d.Plan, err = store.ScanTodos(rows); err != nil {
+		return d, err
+	}
+
+	rows, err = app.DB.QueryContext(ctx, `
+		SELECT `+store.TodoColumns(3)+` FROM todos
+		WHERE NOT completed AND list_id IN `+store.MemberLists(1)+`
+		  AND id NOT IN (SELECT todo_id FROM my_day WHERE day = $2::date AND user_id = $1)
+		ORDER BY due_date <= $2::date DESC NULLS LAST, due_date NULLS LAST, id DESC`,
+		userID, day, app.Clock.Now(),
+	)
+	if err != nil {
+		return d, err
+	}
+	open, err := store.ScanTodos(rows)
+	if err != nil {
+		return d, err
+	}
+
+	for _, t := range open {
+		if t.DueDate != nil && !t.DueDate.After(today) {
+			d.Suggestions = append(d.Suggestions, t)
+		} else {
+			d.Others = append(d.Others, t)
+		}
+	}
+	return d, nil
+}
+
+// today is the app clock's date as DATE columns scan: midnight UTC.
+func (app *Application) today() time.Time {
+	return clock.Today(app.Clock)
+}
+
+// todayDate is today as a query argument, to cast with ::date rather than
+// leave to the database's CURRENT_DATE.
+func (app *Application) todayDate() string {
+	return app.today().Format(time.DateOnly)
+}
+
+// clearMyDay drops plans from previous days. It runs overnight, but plans are
+// always looked up by date, so a missed run never leaks yesterday's plan.
+func (app *Application) clearMyDay(ctx context.Context) error {
+	_, err := app.DB.ExecContext(ctx, "DELETE FROM my_day WHERE day < $1::date", app.todayDate())
+	return err
+}
+
+func (app *Application) myDayHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	d, err := app.loadMyDay(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "my-day.html", myDayPage{Page: app.page(r), Day: d})
+}
+
+func (app *Application) renderMyDay(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	d, err := app.loadMyDay(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "my-day-content", d)
+}
+
+func (app *Application) addToMyDay(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	_, err = app.DB.ExecContext(r.Context(),
+		"INSERT INTO my_day (user_id, todo_id, day) VALUES ($1, $2, $3::date) ON CONFLICT DO NOTHING",
+		user.ID, id, app.todayDate(),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.renderMyDay(w, r)
+}
+
+func (app *Application) removeFromMyDay(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	_, err = app.DB.ExecContext(r.Context(),
+		"DELETE FROM my_day WHERE user_id = $1 AND todo_id = $2 AND day = $3::date",
+		user.ID, id, app.todayDate(),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.renderMyDay(w, r)
+}
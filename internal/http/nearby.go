@@ -0,0 +1,133 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/geocode"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// maxNearby is how many todos the nearby view lists.
+const maxNearby = 50
+
+// geocode finds the coordinates of a place typed into a todo. A place the
+// geocoder doesn't know is an error to show the user. Without a geocoder,
+// or when it can't be reached, the place is kept without coordinates.
+func (app *Application) geocode(ctx context.Context, place string) (latitude, longitude *float64, err error) {
+	if place == "" || app.Geocoder == nil {
+		return nil, nil, nil
+	}
+	p, err := app.Geocoder.Search(ctx, place)
+	if errors.Is(err, geocode.ErrNotFound) {
+		return nil, nil, fmt.Errorf("Couldn't find “%s” on the map. Try adding the town or postcode.", place)
+	}
+	if err != nil {
+		requestLog(ctx).Printf("geocode %q: %v", place, err)
+		return nil, nil, nil
+	}
+	return &p.Latitude, &p.Longitude, nil
+}
+
+type nearbyPage struct {
+	Page
+	Geocoder bool
+}
+
+type nearbyResults struct {
+	Todos []nearbyTodo
+	Error string
+}
+
+type nearbyTodo struct {
+	model.Todo
+	ListName string
+	// Distance is in kilometres.
+	Distance float64
+}
+
+// Away is the distance as it reads best: metres close by, kilometres
+// further off.
+func (t nearbyTodo) Away() string {
+	metres := int(t.Distance*100+0.5) * 10
+	switch {
+	case metres < 1000:
+		return fmt.Sprintf("%d m", metres)
+	case t.Distance < 10:
+		return fmt.Sprintf("%.1f km", t.Distance)
+	default:
+		return fmt.Sprintf("%.0f km", t.Distance)
+	}
+}
+
+// nearbyHandler is the nearby view. The page asks the browser where it is
+// and posts that to nearbyTodos.
+func (app *Application) nearbyHandler(w http.ResponseWriter, r *http.Request) {
+	app.Templates.ExecuteTemplate(w, "nearby.html", nearbyPage{Page: app.page(r), Geocoder: app.Geocoder != nil})
+}
+
+// nearbyTodos lists the user's open todos that have coordinates, nearest
+// first, from the latitude and longitude posted or, failing those, from a
+// place typed in instead.
+func (app *Application) nearbyTodos(w http.ResponseWriter, r *http.Request) {
+	var results nearbyResults
+	lat, latErr := strconv.ParseFloat(r.FormValue("latitude"), 64)
+	lng, lngErr := strconv.ParseFloat(r.FormValue("longitude"), 64)
+	if place := strings.TrimSpace(r.FormValue("place")); place != "" {
+		pLat, pLng, err := app.geocode(r.Context(), place)
+		if err == nil && pLat == nil {
+			err = errors.New("Places can't be looked up right now. Use your location instead.")
+		}
+		if err != nil {
+			results.Error = err.Error()
+			app.Templates.ExecuteTemplate(w, "nearby-results", results)
+			return
+		}
+		lat, lng, latErr, lngErr = *pLat, *pLng, nil, nil
+	}
+	if latErr != nil || lngErr != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		results.Error = "Your location didn't come through. Try again, or type a place."
+		app.Templates.ExecuteTemplate(w, "nearby-results", results)
+		return
+	}
+
+	user, _ := currentUser(r)
+	// The haversine distance, on a sphere the Earth's mean radius.
+	rows, err := app.DB.QueryContext(r.Context(), `
+		SELECT `+store.TodoColumns(5)+`,
+			(SELECT name FROM lists WHERE lists.id = todos.list_id),
+			6371 * 2 * ASIN(LEAST(1, SQRT(
+				POWER(SIN(RADIANS(latitude - $2::float8) / 2), 2) +
+				COS(RADIANS($2::float8)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - $3::float8) / 2), 2)
+			))) AS distance
+		FROM todos
+		WHERE NOT completed AND archived_at IS NULL AND latitude IS NOT NULL
+		  AND list_id IN `+store.MemberLists(1)+`
+		ORDER BY distance, id DESC
+		LIMIT $4`,
+		user.ID, lat, lng, maxNearby, app.Clock.Now(),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t nearbyTodo
+		if err := rows.Scan(append(store.TodoFields(&t.Todo), &t.ListName, &t.Distance)...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results.Todos = append(results.Todos, t)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "nearby-results", results)
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+type errorPage struct {
+	Page
+	Status  int
+	Heading string
+	Message string
+	// Suggestions are todos the visitor might have been looking for.
+	Suggestions []model.Todo
+}
+
+// renderError answers with status the way the request expects: JSON for the
+// API and clients that ask for it, a dismissible notice in #alerts for htmx
+// (the request's own target would end up holding an error page), and a full
+// page otherwise.
+func (app *Application) renderError(w http.ResponseWriter, r *http.Request, data errorPage) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		jsonError(w, data.Status, data.Message)
+		return
+	}
+	if negotiate(r) == formatFragment {
+		htmx.Retarget(w, "#alerts")
+		htmx.Reswap(w, htmx.InnerHTML)
+	}
+	app.render(w, r, view{
+		Status:   data.Status,
+		Fragment: "error-notice",
+		Data:     data,
+		Page:     "error.html",
+		PageData: func() (any, error) {
+			data.Page = app.page(r)
+			return data, nil
+		},
+		JSON: map[string]string{"error": data.Message},
+	})
+}
+
+// todoPermalink is a todo's own address. Browsers are sent to the list it's
+// on, scrolled to the todo; it's where 404 suggestions link to. htmx gets
+// the todo's row and JSON clients the todo.
+func (app *Application) todoPermalink(w http.ResponseWriter, r *http.Request) {
+	if negotiate(r) == formatPage {
+		url := "/lists/" + strconv.Itoa(listAccess(r).ListID) + "#todo-" + chi.URLParam(r, "id")
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	modified, err := app.Queries.TodoModified(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !modified.IsZero() {
+		// The row is laid out the way the caller likes lists, and shows
+		// how many days the todo has been open.
+		modified = latest(modified, listView(r).UpdatedAt, app.today())
+	}
+	if notModified(w, r, modified) {
+		return
+	}
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.render(w, r, view{Fragment: "todo-item", Data: todoRow{Todo: todo, View: listView(r)}, JSON: todo})
+}
+
+// todoPath matches /todos/{id} and anything under it.
+var todoPath = regexp.MustCompile(`^/todos/([^/]+)`)
+
+// notFound is the router's 404 handler, and what the authz middleware uses
+// for a list or todo that doesn't exist. A missing todo page suggests
+// todos the visitor can see that might be the one they wanted.
+func (app *Application) notFound(w http.ResponseWriter, r *http.Request) {
+	data := errorPage{
+		Status:  http.StatusNotFound,
+		Heading: "🤷 Not found",
+		Message: "There's nothing here. It may have been deleted or moved.",
+	}
+	if m := todoPath.FindStringSubmatch(r.URL.Path); m != nil && !htmx.IsRequest(r) {
+		if user, _ := currentUser(r); user != nil {
+			data.Message = "That todo doesn't exist anymore, or it's on a list you can't see."
+			suggestions, err := app.similarTodos(r.Context(), user.ID, m[1])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data.Suggestions = suggestions
+		}
+	}
+	app.renderError(w, r, data)
+}
+
+// similarTodos guesses what a dead /todos/{ref} link pointed at. A numeric
+// ref finds the todos created around the same time, i.e. with the closest
+// IDs; anything else is treated as words from the title.
+func (app *Application) similarTodos(ctx context.Context, userID int, ref string) ([]model.Todo, error) {
+	query := `SELECT ` + store.TodoColumns(3) + ` FROM todos
+		WHERE archived_at IS NULL AND list_id IN ` + store.MemberLists(1) + `
+		ORDER BY ABS(id - $2), id DESC LIMIT 5`
+	var arg any = ref
+	if id, err := strconv.Atoi(ref); err == nil {
+		arg = id
+	} else {
+		query = `SELECT ` + store.TodoColumns(3) + ` FROM todos
+			WHERE archived_at IS NULL AND list_id IN ` + store.MemberLists(1) + `
+			AND to_tsvector('english', title) @@ websearch_to_tsquery('english', $2)
+			ORDER BY ts_rank(to_tsvector('english', title), websearch_to_tsquery('english', $2)) DESC, id DESC
+			LIMIT 5`
+		arg = strings.NewReplacer("-", " ", "_", " ", "+", " ").Replace(ref)
+	}
+
+	rows, err := app.DB.QueryContext(ctx, query, userID, arg, app.Clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	return store.ScanTodos(rows)
+}
+
+// methodNotAllowed is the router's 405 handler. chi only fills in the Allow
+// header for its default handler, and its Match misreports the root of a
+// subrouter, so the handler flattens the routes into a scratch router on
+// first use and asks that instead.
+func (app *Application) methodNotAllowed(routes chi.Routes) http.HandlerFunc {
+	var (
+		once sync.Once
+		flat = chi.NewRouter()
+	)
+	noop := func(http.ResponseWriter, *http.Request) {}
+	methods := []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+				if route != "/" {
+					route = strings.TrimSuffix(route, "/")
+				}
+				flat.MethodFunc(method, route, noop)
+				return nil
+			})
+		})
+
+		path := r.URL.Path
+		if path != "/" {
+			path = strings.TrimSuffix(path, "/")
+		}
+		var allowed []string
+		for _, m := range methods {
+			if flat.Match(chi.NewRouteContext(), m, path) {
+				allowed = append(allowed, m)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+		app.renderError(w, r, errorPage{
+			Status:  http.StatusMethodNotAllowed,
+			Heading: "✋ Not like that",
+			Message: r.Method + " isn't supported here. Try " + strings.Join(allowed, " or ") + ".",
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// onboardingStep is one thing a new user is shown how to do. A step is
+// done when they do it, wherever in the app that is, or when they skip it.
+type onboardingStep struct {
+	Key   string
+	Title string
+	Hint  string
+}
+
+// Steps a new user is guided through, in order.
+const (
+	stepComplete = "complete"
+	stepAdd      = "add"
+	stepDue      = "due"
+	stepList     = "list"
+	stepShare    = "share"
+)
+
+var onboardingSteps = []onboardingStep{
+	{stepComplete, "Check off a todo", "Tick the box next to any todo in Getting started."},
+	{stepAdd, "Add a todo of your own", "Type it into the Add New Todo box and press Add."},
+	{stepDue, "Give a todo a due date", "Pick a date as you add one, or ✏️ edit one you have."},
+	{stepList, "Make a list", "Name one in the New list box under Lists, for work, home or a project."},
+	{stepShare, "Share a list", "Open 👥 Members on a list and add someone by email."},
+}
+
+// sampleTodos fill the Getting started list every new account gets, from
+// the top.
+var sampleTodos = []sampleTodo{
+	{Title: "Check this one off to see it move"},
+	{Title: "Open 📝 on a todo for its notes", Description: "Descriptions take **Markdown**, and links get a preview. You can attach files here too."},
+	{Title: "Plan the week", Estimate: 30, DueIn: 1, Description: "Add estimates and due dates to see how full each day is, then pick what to do today in **My Day**."},
+	{Title: "Delete a todo, then press Ctrl+Z to bring it back"},
+	{Title: "Sign up", Done: true},
+}
+
+// onboarding is where a user has got to in the checklist.
+type onboarding struct {
+	// ListID is their Getting started list, if they still have it.
+	ListID  int
+	Done    []string
+	Skipped []string
+}
+
+type onboardingStepView struct {
+	onboardingStep
+	Done    bool
+	Skipped bool
+	Current bool
+}
+
+// Steps are every step with how far the user has got: the first one
+// neither done nor skipped is current.
+func (o onboarding) Steps() []onboardingStepView {
+	var steps []onboardingStepView
+	current := false
+	for _, s := range onboardingSteps {
+		v := onboardingStepView{
+			onboardingStep: s,
+			Done:           slices.Contains(o.Done, s.Key),
+			Skipped:        slices.Contains(o.Skipped, s.Key),
+		}
+		if !v.Done && !v.Skipped && !current {
+			v.Current, current = true, true
+		}
+		steps = append(steps, v)
+	}
+	return steps
+}
+
+// DoneCount is how many steps have been done or skipped, out of Total.
+func (o onboarding) DoneCount() int {
+	return len(o.Done) + len(o.Skipped)
+}
+
+func (o onboarding) Total() int {
+	return len(onboardingSteps)
+}
+
+// Percent is how far through the checklist the user is, for its progress
+// bar.
+func (o onboarding) Percent() int {
+	return o.DoneCount() * 100 / o.Total()
+}
+
+// Finished reports whether every step has been done or skipped.
+func (o onboarding) Finished() bool {
+	return o.DoneCount() >= o.Total()
+}
+
+// setUpAccount gives a user who has no lists yet an Inbox, a Getting
+// started list of sample todos and the onboarding checklist. It returns
+// the Inbox, which stays their default list.
+func (app *Application) setUpAccount(ctx context.Context, userID int) (int, error) {
+	var inboxID int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if inboxID, err = app.createList(ctx, userID, "Inbox"); err != nil {
+			return err
+		}
+		sampleID, err := app.seedList(ctx, userID, "Getting started 👋", sampleTodos)
+		if err != nil {
+			return err
+		}
+		_, err = app.db(ctx).ExecContext(ctx,
+			"INSERT INTO onboarding (user_id, list_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			userID, sampleID,
+		)
+		return err
+	})
+	return inboxID, err
+}
+
+// loadOnboarding returns a user's checklist, or sql.ErrNoRows if they have
+// none or have dismissed it.
+func (app *Application) loadOnboarding(ctx context.Context, userID int) (onboarding, error) {
+	var o onboarding
+	var listID sql.NullInt64
+	err := app.DB.QueryRowContext(ctx, `
+		SELECT list_id, done, skipped FROM onboarding
+		WHERE user_id = $1 AND dismissed_at IS NULL`,
+		userID,
+	).Scan(&listID, pq.Array(&o.Done), pq.Array(&o.Skipped))
+	o.ListID = int(listID.Int64)
+	return o, err
+}
+
+// advanceOnboarding marks a step done for userID, if they're still going
+// through the checklist. It's called wherever the step's action happens,
+// and a failure is only logged, since it mustn't fail the action itself.
+func (app *Application) advanceOnboarding(ctx context.Context, userID int, step string) {
+	_, err := app.DB.ExecContext(ctx, `
+		UPDATE onboarding SET done = array_append(done, $2::text)
+		WHERE user_id = $1 AND dismissed_at IS NULL AND NOT $2::text = ANY(done) AND NOT $2::text = ANY(skipped)`,
+		userID, step,
+	)
+	if err != nil {
+		requestLog(ctx).Printf("onboarding step %s for user %d: %v", step, userID, err)
+	}
+}
+
+// getOnboarding renders the checklist, or nothing once it's dismissed. The
+// list page loads it, and again whenever its todos change, so steps tick
+// themselves off as they're done.
+func (app *Application) getOnboarding(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	o, err := app.loadOnboarding(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "onboarding", o)
+}
+
+// skipOnboardingStep moves the checklist past a step without doing it.
+func (app *Application) skipOnboardingStep(w http.ResponseWriter, r *http.Request) {
+	step := chi.URLParam(r, "step")
+	if !slices.ContainsFunc(onboardingSteps, func(s onboardingStep) bool { return s.Key == step }) {
+		app.notFound(w, r)
+		return
+	}
+	user, _ := currentUser(r)
+	_, err := app.DB.ExecContext(r.Context(), `
+		UPDATE onboarding SET skipped = array_append(skipped, $2::text)
+		WHERE user_id = $1 AND NOT $2::text = ANY(done) AND NOT $2::text = ANY(skipped)`,
+		user.ID, step,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.getOnboarding(w, r)
+}
+
+// dismissOnboarding hides the checklist for good.
+func (app *Application) dismissOnboarding(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	_, err := app.DB.ExecContext(r.Context(), "UPDATE onboarding SET dismissed_at = NOW() WHERE user_id = $1", user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,242 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+)
+
+// maxOutagePages is how many lists the outage cache keeps, across every
+// session. The least recently saved go first.
+const maxOutagePages = 1000
+
+// outage notices when the database can't be reached and keeps the last
+// copy of each list each session loaded, so that while it can't, people
+// can still read their lists instead of getting errors.
+type outage struct {
+	down atomic.Bool
+
+	mu    sync.Mutex
+	pages map[outageKey]*cachedList
+}
+
+// outageKey is a session's list. Sessions are keyed by a hash of their
+// token, and a page is only served back to the session that loaded it, so
+// no one sees a list they couldn't already.
+type outageKey struct {
+	session [sha256.Size]byte
+	listID  int
+}
+
+type cachedList struct {
+	page    *listPage
+	todos   *todoList
+	savedAt time.Time
+}
+
+func newOutage() *outage {
+	return &outage{pages: map[outageKey]*cachedList{}}
+}
+
+func sessionKey(r *http.Request) ([sha256.Size]byte, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil || c.Value == "" {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256([]byte(c.Value)), true
+}
+
+// save updates the cached copy of the list r loaded with fn.
+func (o *outage) save(r *http.Request, listID int, fn func(*cachedList)) {
+	session, ok := sessionKey(r)
+	if !ok {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := outageKey{session, listID}
+	c := o.pages[key]
+	if c == nil {
+		if len(o.pages) >= maxOutagePages {
+			o.evictOldest()
+		}
+		c = &cachedList{}
+		o.pages[key] = c
+	}
+	fn(c)
+	c.savedAt = time.Now()
+}
+
+func (o *outage) evictOldest() {
+	var oldest outageKey
+	var at time.Time
+	for k, c := range o.pages {
+		if at.IsZero() || c.savedAt.Before(at) {
+			oldest, at = k, c.savedAt
+		}
+	}
+	delete(o.pages, oldest)
+}
+
+func (o *outage) savePage(r *http.Request, p listPage) {
+	o.save(r, p.List.ID, func(c *cachedList) { c.page = &p })
+}
+
+func (o *outage) saveTodos(r *http.Request, listID int, todos todoList) {
+	o.save(r, listID, func(c *cachedList) { c.todos = &todos })
+}
+
+// lookup returns r's session's copy of a list, or with listID 0 the one it
+// loaded last.
+func (o *outage) lookup(r *http.Request, listID int) (cachedList, bool) {
+	session, ok := sessionKey(r)
+	if !ok {
+		return cachedList{}, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if listID != 0 {
+		c, ok := o.pages[outageKey{session, listID}]
+		if !ok {
+			return cachedList{}, false
+		}
+		return *c, true
+	}
+	var latest *cachedList
+	for k, c := range o.pages {
+		if k.session == session && c.page != nil && (latest == nil || c.savedAt.After(latest.savedAt)) {
+			latest = c
+		}
+	}
+	if latest == nil {
+		return cachedList{}, false
+	}
+	return *latest, true
+}
+
+// forget drops what r's session has cached, when it logs out.
+func (o *outage) forget(r *http.Request) {
+	session, ok := sessionKey(r)
+	if !ok {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for k := range o.pages {
+		if k.session == session {
+			delete(o.pages, k)
+		}
+	}
+}
+
+// checkDatabase pings the database, switching outage mode on when it
+// can't be reached and back off when it can again.
+func (app *Application) checkDatabase(ctx context.Context) error {
+	ping, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	err := app.DB.PingContext(ping)
+	if ctx.Err() != nil {
+		// Shutting down, not an outage.
+		return nil
+	}
+	if was := app.Outage.down.Swap(err != nil); was != (err != nil) {
+		if err != nil {
+			log.Printf("database unreachable, serving cached lists read-only: %v", err)
+		} else {
+			log.Printf("database reachable again, leaving outage mode")
+		}
+	}
+	return nil
+}
+
+// databaseOutage answers requests while the database is down: reading a
+// list serves the session's cached copy of it, read-only with a banner,
+// and anything else gets a 503 rather than an error from each query.
+func (app *Application) databaseOutage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.Outage.down.Load() ||
+			r.URL.Path == "/health" || r.URL.Path == "/readyz" ||
+			r.URL.Path == "/sw.js" || r.URL.Path == "/manifest.webmanifest" ||
+			strings.HasPrefix(r.URL.Path, "/static/") ||
+			strings.HasPrefix(r.URL.Path, assets.Prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			listID, part, ok := outagePath(r.URL.Path)
+			if ok {
+				c, found := app.Outage.lookup(r, listID)
+				switch {
+				case found && part == "" && c.page != nil:
+					if listID == 0 {
+						http.Redirect(w, r, fmt.Sprintf("/lists/%d", c.page.List.ID), http.StatusSeeOther)
+						return
+					}
+					page := *c.page
+					page.Outage = true
+					page.Maintenance = maintenance.ReadOnly
+					w.Header().Set("Cache-Control", "no-store")
+					app.Templates.ExecuteTemplate(w, "index.html", page)
+					return
+				case found && part == "todos" && c.todos != nil && unfiltered(r):
+					w.Header().Set("Cache-Control", "no-store")
+					app.Templates.ExecuteTemplate(w, "todo-list.html", *c.todos)
+					return
+				}
+			}
+		}
+
+		w.Header().Set("Retry-After", "30")
+		if htmx.IsRequest(r) {
+			http.Error(w, "The database is unavailable. Try again in a moment.", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		app.Templates.ExecuteTemplate(w, "outage.html", nil)
+	})
+}
+
+// unfiltered is whether r asks for a list's todos without filtering them
+// by a custom field, as the list page first loads them.
+func unfiltered(r *http.Request) bool {
+	for _, values := range r.URL.Query() {
+		for _, v := range values {
+			if v != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// outagePath reads which list a path shows: "/" is the last one loaded
+// (ID 0), "/lists/{id}" a list's page and "/lists/{id}/todos" its todos.
+func outagePath(path string) (listID int, part string, ok bool) {
+	if path == "/" {
+		return 0, "", true
+	}
+	rest, found := strings.CutPrefix(path, "/lists/")
+	if !found {
+		return 0, "", false
+	}
+	id, part, _ := strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	listID, err := strconv.Atoi(id)
+	if err != nil || listID < 1 || (part != "" && part != "todos") {
+		return 0, "", false
+	}
+	return listID, part, true
+}
@@ -0,0 +1,182 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/mail"
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+)
+
+const (
+	outboxEmail = "email"
+	outboxPush  = "push"
+)
+
+const (
+	// outboxBatch is how many rows the relay claims at a time.
+	outboxBatch = 50
+	// outboxClaimTimeout is how long a claimed row is left to the instance
+	// that claimed it before another takes it over.
+	outboxClaimTimeout = 5 * time.Minute
+	// outboxAttempts is how many times a row is tried before the relay
+	// gives up on it. The waits between tries double from 30 seconds, up
+	// to an hour, so the last is about nine hours after the first.
+	outboxAttempts = 16
+	// outboxKeepFailed is how long rows given up on are kept, to look at.
+	outboxKeepFailed = 30 * 24 * time.Hour
+)
+
+// outboxPushPayload is a push notification waiting in the outbox.
+type outboxPushPayload struct {
+	UserID       int               `json:"user_id"`
+	Notification push.Notification `json:"notification"`
+}
+
+// queueEmail renders the named email template like sendEmail, and adds it
+// to the outbox in ctx's transaction, to be sent once that commits.
+func (app *Application) queueEmail(ctx context.Context, to, subject, name string, data any) error {
+	msg, err := app.renderEmail(to, subject, name, data)
+	if err != nil {
+		return err
+	}
+	return app.queue(ctx, outboxEmail, msg)
+}
+
+// queuePush adds a push notification to userID's browsers to the outbox in
+// ctx's transaction, to be sent once that commits.
+func (app *Application) queuePush(ctx context.Context, userID int, n push.Notification) error {
+	return app.queue(ctx, outboxPush, outboxPushPayload{UserID: userID, Notification: n})
+}
+
+func (app *Application) queue(ctx context.Context, kind string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = app.db(ctx).ExecContext(ctx, "INSERT INTO outbox (kind, payload) VALUES ($1, $2)", kind, string(b))
+	return err
+}
+
+// relayOutbox delivers what's waiting in the outbox. Rows are claimed
+// before they're delivered, so with several instances running the job
+// each is delivered by one, and deleted after, so one an instance dies
+// delivering is delivered again once its claim times out. A redelivered
+// email keeps its Message-ID and a push notification its tag, so they
+// replace the first rather than arriving twice.
+func (app *Application) relayOutbox(ctx context.Context) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		UPDATE outbox SET claimed_at = NOW(), attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE next_attempt_at <= NOW()
+			  AND (claimed_at IS NULL OR claimed_at < NOW() - make_interval(secs => $1::float8))
+			ORDER BY id FOR UPDATE SKIP LOCKED LIMIT $2
+		)
+		RETURNING id, kind, payload, attempts`,
+		outboxClaimTimeout.Seconds(), outboxBatch,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type row struct {
+		id       int64
+		kind     string
+		payload  []byte
+		attempts int
+	}
+	var claimed []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.kind, &r.payload, &r.attempts); err != nil {
+			return err
+		}
+		claimed = append(claimed, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range claimed {
+		sendErr := app.deliver(ctx, r.id, r.kind, r.payload)
+		if sendErr == nil {
+			_, err = app.DB.ExecContext(ctx, "DELETE FROM outbox WHERE id = $1", r.id)
+		} else {
+			if r.attempts >= outboxAttempts {
+				log.Printf("outbox %d (%s): giving up after %d attempts: %v", r.id, r.kind, r.attempts, sendErr)
+			}
+			_, err = app.DB.ExecContext(ctx, `
+				UPDATE outbox SET claimed_at = NULL, last_error = $2,
+					next_attempt_at = CASE WHEN attempts >= $3 THEN NULL
+						ELSE NOW() + make_interval(secs => LEAST(30 * power(2, attempts - 1), 3600)) END
+				WHERE id = $1`,
+				r.id, truncate(500, sendErr.Error()), outboxAttempts,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends one outbox row.
+func (app *Application) deliver(ctx context.Context, id int64, kind string, payload []byte) error {
+	switch kind {
+	case outboxEmail:
+		var msg mail.Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return err
+		}
+		msg.MessageID = fmt.Sprintf("outbox-%d@%s", id, app.mailHost())
+		return app.Mailer.Send(ctx, msg)
+	case outboxPush:
+		var p outboxPushPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return app.Push.SendTo(ctx, p.UserID, p.Notification)
+	}
+	return fmt.Errorf("unknown outbox kind %q", kind)
+}
+
+// mailHost is the domain Message-IDs are made in: BASE_URL's host.
+func (app *Application) mailHost() string {
+	if u, err := url.Parse(app.Config.BaseURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
+
+// purgeOutbox deletes rows the relay gave up on a while ago.
+func (app *Application) purgeOutbox(ctx context.Context) error {
+	_, err := app.DB.ExecContext(ctx,
+		"DELETE FROM outbox WHERE next_attempt_at IS NULL AND created_at < NOW() - make_interval(secs => $1::float8)",
+		outboxKeepFailed.Seconds(),
+	)
+	return err
+}
+
+// outboxStats is how the outbox stands, for the admin dashboard.
+type outboxStats struct {
+	Waiting int
+	Failed  int
+	// LastError is the most recent failure of a row still in the outbox.
+	LastError string
+}
+
+func (app *Application) outboxStats(ctx context.Context) (outboxStats, error) {
+	var s outboxStats
+	err := app.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE next_attempt_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE next_attempt_at IS NULL),
+			COALESCE((SELECT last_error FROM outbox WHERE last_error <> '' ORDER BY id DESC LIMIT 1), '')
+		FROM outbox`,
+	).Scan(&s.Waiting, &s.Failed, &s.LastError)
+	return s, err
+}
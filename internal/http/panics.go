@@ -0,0 +1,62 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/querylog"
+)
+
+// panicPage is what the development error page shows about a panic: where
+// it happened, the request that caused it and the SQL the request ran.
+type panicPage struct {
+	Panic     string
+	Stack     string
+	RequestID string
+	Method    string
+	URL       string
+	Route     string
+	User      *model.User
+	Header    http.Header
+	Form      url.Values
+	Queries   []querylog.Query
+	// Dropped is how many earlier queries didn't fit in the log.
+	Dropped int
+}
+
+// renderPanic answers a request that panicked with the development error
+// page. htmx requests get it in place of the whole page, since it won't fit
+// in the request's target.
+func (app *Application) renderPanic(w http.ResponseWriter, r *http.Request, rec any, stack []byte, form url.Values, queries *querylog.Log) {
+	data := panicPage{
+		Panic:     fmt.Sprint(rec),
+		Stack:     string(stack),
+		RequestID: scopeOf(r.Context()).ID,
+		Method:    r.Method,
+		URL:       r.URL.RequestURI(),
+		Header:    r.Header,
+		Form:      form,
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		data.Route = rctx.RoutePattern()
+	}
+	data.User, _ = currentUser(r)
+	if queries != nil {
+		data.Queries, data.Dropped = queries.Queries()
+	}
+
+	if htmx.IsRequest(r) {
+		htmx.Retarget(w, "body")
+		htmx.Reswap(w, htmx.InnerHTML)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	if err := app.Templates.ExecuteTemplate(w, "dev-error.html", data); err != nil {
+		fmt.Fprintf(w, "%s\n\n%s", data.Panic, data.Stack)
+	}
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/partition"
+)
+
+// The tables that only grow, which `partition` splits into a partition a
+// month.
+var (
+	todoEventsTable  = partition.Table{Name: "todo_events", Column: "created_at", Key: []string{"list_id", "id"}}
+	auditEventsTable = partition.Table{Name: "audit_events", Column: "created_at", Key: []string{"id"}}
+)
+
+var partitionedTables = []partition.Table{todoEventsTable, auditEventsTable}
+
+// partitionsAhead is how many months ahead partitions are made, so the
+// nightly job can miss a few runs before rows start landing in the default
+// partition.
+const partitionsAhead = 3
+
+// PartitionTables partitions by month whichever of the growing tables
+// aren't yet, and returns their names.
+func (app *Application) PartitionTables(ctx context.Context) ([]string, error) {
+	var converted []string
+	for _, t := range partitionedTables {
+		ok, err := partition.Convert(ctx, app.DB, t, time.Now(), partitionsAhead)
+		if err != nil {
+			return converted, fmt.Errorf("%s: %w", t.Name, err)
+		}
+		if ok {
+			converted = append(converted, t.Name)
+		}
+	}
+	return converted, nil
+}
+
+// createPartitions makes the coming months' partitions of the tables that
+// have been partitioned.
+func (app *Application) createPartitions(ctx context.Context) error {
+	for _, t := range partitionedTables {
+		partitioned, err := partition.Partitioned(ctx, app.DB, t)
+		if err != nil {
+			return err
+		}
+		if !partitioned {
+			continue
+		}
+		created, err := partition.Ensure(ctx, app.DB, t, time.Now(), partitionsAhead)
+		for _, name := range created {
+			log.Printf("create-partitions: created %s", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeBefore removes t's rows from before cutoff and returns how many
+// went. Once t is partitioned, only whole months are dropped, so rows
+// are kept up to a month longer.
+func (app *Application) purgeBefore(ctx context.Context, t partition.Table, cutoff time.Time) (int64, error) {
+	partitioned, err := partition.Partitioned(ctx, app.DB, t)
+	if err != nil {
+		return 0, err
+	}
+	if partitioned {
+		return partition.DropBefore(ctx, app.DB, t, cutoff)
+	}
+	res, err := app.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s < $1", t.Name, t.Column), cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// performanceReport is the admin dashboard's look at how the database is
+// holding up: its slowest statements, indexes it may be missing and how
+// much of each table is dead rows.
+type performanceReport struct {
+	// Statements are the ones that took the longest in all, when
+	// pg_stat_statements is installed; otherwise StatementsNote says why
+	// there are none.
+	Statements     []slowStatement `json:"statements"`
+	StatementsNote string          `json:"statements_note,omitempty"`
+	MissingIndexes []missingIndex  `json:"missing_indexes"`
+	Tables         []tableHealth   `json:"tables"`
+}
+
+type slowStatement struct {
+	Query string  `json:"query"`
+	Calls int64   `json:"calls"`
+	Rows  int64   `json:"rows"`
+	Total float64 `json:"total_ms"`
+	Mean  float64 `json:"mean_ms"`
+	Share float64 `json:"share_percent"`
+}
+
+type missingIndex struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Reason string `json:"reason"`
+}
+
+type tableHealth struct {
+	Table      string     `json:"table"`
+	Size       int64      `json:"size_bytes"`
+	Live       int64      `json:"live_rows"`
+	Dead       int64      `json:"dead_rows"`
+	SeqScans   int64      `json:"seq_scans"`
+	IndexScans int64      `json:"index_scans"`
+	Vacuumed   *time.Time `json:"vacuumed_at"`
+}
+
+// DeadPercent estimates the table's bloat: the share of its rows that are
+// dead, waiting for vacuum.
+func (t tableHealth) DeadPercent() float64 {
+	if t.Live+t.Dead == 0 {
+		return 0
+	}
+	return 100 * float64(t.Dead) / float64(t.Live+t.Dead)
+}
+
+// MostlySeqScanned is a table big enough to want indexes that's mostly
+// read without them.
+func (t tableHealth) MostlySeqScanned() bool {
+	return t.Live >= 10000 && t.SeqScans > t.IndexScans
+}
+
+// filteredColumns are the columns the app's filters and lookups lean on,
+// which should each lead an index. Custom fields are the app's tags.
+var filteredColumns = []missingIndex{
+	{"todos", "list_id", "every list page and search"},
+	{"todos", "due_date", "due: and is:overdue searches, reminders and the digest"},
+	{"todos", "custom_fields", "field operators like priority:high"},
+	{"todos", "assignee_id", "assigned todos and the assignee filter"},
+	{"list_members", "user_id", "finding a user's lists, on every page"},
+	{"my_day", "user_id", "My Day"},
+}
+
+const (
+	slowStatementsLimit = 10
+	tableHealthLimit    = 15
+)
+
+func (app *Application) performanceReport(ctx context.Context) (performanceReport, error) {
+	report := performanceReport{Statements: []slowStatement{}, MissingIndexes: []missingIndex{}, Tables: []tableHealth{}}
+
+	var installed bool
+	if err := app.DB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')",
+	).Scan(&installed); err != nil {
+		return report, err
+	}
+	if installed {
+		if err := app.slowStatements(ctx, &report); err != nil {
+			// Installed but not preloaded, or not readable by this role.
+			report.Statements, report.StatementsNote = []slowStatement{}, err.Error()
+		}
+	} else {
+		report.StatementsNote = "pg_stat_statements isn't installed. Add it to shared_preload_libraries, restart Postgres and run CREATE EXTENSION pg_stat_statements."
+	}
+
+	for _, f := range filteredColumns {
+		var indexed bool
+		if err := app.DB.QueryRowContext(ctx, `
+			SELECT to_regclass($1) IS NULL OR EXISTS (
+				SELECT 1 FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+				WHERE i.indrelid = to_regclass($1) AND a.attname = $2
+			)`, f.Table, f.Column,
+		).Scan(&indexed); err != nil {
+			return report, err
+		}
+		if !indexed {
+			report.MissingIndexes = append(report.MissingIndexes, f)
+		}
+	}
+	// Deleting the row a foreign key points at looks for rows pointing at
+	// it, which without an index is a scan of the whole table.
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT c.conrelid::regclass::text, a.attname, c.confrelid::regclass::text
+		FROM pg_constraint c
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		WHERE c.contype = 'f' AND cardinality(c.conkey) = 1 AND c.conparentid = 0
+		  AND c.connamespace = current_schema()::regnamespace
+		  AND NOT EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = c.conrelid AND i.indkey[0] = c.conkey[1])
+		ORDER BY 1, 2`,
+	)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m missingIndex
+		var references string
+		if err := rows.Scan(&m.Table, &m.Column, &references); err != nil {
+			return report, err
+		}
+		m.Reason = "foreign key to " + references + ", checked whenever one is deleted"
+		report.MissingIndexes = append(report.MissingIndexes, m)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	rows, err = app.DB.QueryContext(ctx, `
+		SELECT relname, pg_total_relation_size(relid), n_live_tup, n_dead_tup,
+			seq_scan, COALESCE(idx_scan, 0), GREATEST(last_vacuum, last_autovacuum)
+		FROM pg_stat_user_tables
+		WHERE schemaname = current_schema()
+		ORDER BY n_dead_tup DESC, pg_total_relation_size(relid) DESC
+		LIMIT $1`, tableHealthLimit,
+	)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t tableHealth
+		if err := rows.Scan(&t.Table, &t.Size, &t.Live, &t.Dead, &t.SeqScans, &t.IndexScans, &t.Vacuumed); err != nil {
+			return report, err
+		}
+		report.Tables = append(report.Tables, t)
+	}
+	return report, rows.Err()
+}
+
+// slowStatements fills in the statements that have taken the longest in
+// all on this database, with each one's percentage of the total.
+func (app *Application) slowStatements(ctx context.Context, report *performanceReport) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT COALESCE(query, ''), calls, rows, total_exec_time, mean_exec_time,
+			100 * total_exec_time / NULLIF(SUM(total_exec_time) OVER (), 0)
+		FROM pg_stat_statements
+		WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		ORDER BY total_exec_time DESC
+		LIMIT $1`, slowStatementsLimit,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s slowStatement
+		var share *float64
+		if err := rows.Scan(&s.Query, &s.Calls, &s.Rows, &s.Total, &s.Mean, &share); err != nil {
+			return err
+		}
+		if share != nil {
+			s.Share = *share
+		}
+		report.Statements = append(report.Statements, s)
+	}
+	return rows.Err()
+}
+
+// adminPerformance renders the performance report, which the dashboard
+// loads on its own since it reads Postgres's statistics views.
+func (app *Application) adminPerformance(w http.ResponseWriter, r *http.Request) {
+	report, err := app.performanceReport(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.render(w, r, view{Fragment: "performance-report", Data: report, JSON: report})
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/presence"
+)
+
+const (
+	// presenceTTL is how long a viewer stays listed after their last
+	// heartbeat. Pages send one every 20 seconds, so one lost beat doesn't
+	// make anybody flicker out.
+	presenceTTL = 45 * time.Second
+	// presenceKeepAlive is how often an idle stream sends a comment, so
+	// proxies don't time it out and draining instances close it.
+	presenceKeepAlive = 15 * time.Second
+)
+
+// presenceStrip is the avatar strip of who else is viewing a list.
+type presenceStrip struct {
+	ListID  int
+	Viewers []presence.Viewer
+}
+
+// Initials is what a viewer's avatar shows.
+func (presenceStrip) Initials(v presence.Viewer) string {
+	name, _, _ := strings.Cut(v.Email, "@")
+	if name == "" {
+		return "?"
+	}
+	return strings.ToUpper(name[:1])
+}
+
+// Color picks a viewer's avatar colour, the same one on every page.
+func (presenceStrip) Color(v presence.Viewer) string {
+	colors := []string{"bg-blue-500", "bg-green-500", "bg-purple-500", "bg-pink-500", "bg-yellow-500", "bg-indigo-500"}
+	return colors[v.UserID%len(colors)]
+}
+
+// presenceStrip lists the viewers of the request's list, leaving out the
+// user who's asking.
+func (app *Application) presenceStrip(r *http.Request) presenceStrip {
+	user, _ := currentUser(r)
+	listID := listAccess(r).ListID
+	strip := presenceStrip{ListID: listID}
+	for _, v := range app.Presence.Viewers(listID) {
+		if v.UserID != user.ID {
+			strip.Viewers = append(strip.Viewers, v)
+		}
+	}
+	return strip
+}
+
+func (app *Application) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	strip := app.presenceStrip(r)
+	viewers := strip.Viewers
+	if viewers == nil {
+		viewers = []presence.Viewer{}
+	}
+	app.render(w, r, view{Fragment: "presence-strip", Data: strip, JSON: viewers})
+}
+
+// heartbeat marks the user as viewing the list. The page sends one as it
+// loads and then every 20 seconds while it stays open.
+func (app *Application) heartbeat(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	app.Presence.Beat(listAccess(r).ListID, presence.Viewer{UserID: user.ID, Email: user.Email})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// presenceStream pushes the re-rendered strip as a server-sent event
+// whenever someone starts or stops viewing the list. It ends when the
+// client goes away or the instance starts draining, and the browser's
+// EventSource reconnects, to another instance if need be.
+func (app *Application) presenceStream(w http.ResponseWriter, r *http.Request) {
+	listID := listAccess(r).ListID
+	changes, unsubscribe := app.Presence.Subscribe(listID)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func() error {
+		var buf bytes.Buffer
+		if err := app.Templates.ExecuteTemplate(&buf, "presence-strip", app.presenceStrip(r)); err != nil {
+			return err
+		}
+		// Every line of an event's data needs its own prefix.
+		fmt.Fprint(w, "event: presence\n")
+		for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+		return rc.Flush()
+	}
+	if err := send(); err != nil {
+		requestLog(r.Context()).Printf("presence stream: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(presenceKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changes:
+			if err := send(); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if app.Ready.draining.Load() {
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
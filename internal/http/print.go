@@ -0,0 +1,212 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/pdf"
+)
+
+type printPage struct {
+	Page
+	List    model.List
+	Todos   []model.Todo
+	Open    int
+	Done    int
+	Printed time.Time
+}
+
+func (app *Application) printData(r *http.Request) (printPage, error) {
+	data := printPage{Page: app.page(r), Printed: app.Clock.Now()}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		return data, err
+	}
+	if data.Todos, err = app.Queries.ListTodos(r.Context(), data.List.ID); err != nil {
+		return data, err
+	}
+	for _, t := range data.Todos {
+		if t.Completed {
+			data.Done++
+		} else {
+			data.Open++
+		}
+	}
+	return data, nil
+}
+
+// printProgress is how far a streamed print view has got.
+type printProgress struct {
+	ListID int
+	Shown  int
+	Total  int
+	// Limited is set at the end if printRowLimit cut the list short.
+	Limited bool
+	Error   string
+}
+
+func (p printProgress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return min(100, 100*p.Shown/p.Total)
+}
+
+// printList is a list laid out for paper: no sidebar, forms or buttons,
+// just its todos as a checklist with their descriptions. Big lists would
+// take a while to render and more to hold, so the page is streamed: todos
+// are rendered as they're read, up to printRowLimit, and flushed a chunk at
+// a time with a progress bar for how many of them have arrived.
+func (app *Application) printList(w http.ResponseWriter, r *http.Request) {
+	data := printPage{Page: app.page(r), Printed: app.Clock.Now()}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	counts, err := app.Queries.TodoCounts(r.Context(), data.List.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Open, data.Done = counts.Open, counts.Completed
+
+	// The start is rendered whole, so an error in it is still a clean 500.
+	var start bytes.Buffer
+	if err := app.Templates.ExecuteTemplate(&start, "print-start", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	start.WriteTo(w)
+
+	progress := printProgress{ListID: data.List.ID, Total: min(data.Open+data.Done, printRowLimit)}
+	stream := newRowStream(w)
+	err = app.Queries.EachTodo(r.Context(), data.List.ID, printRowLimit, func(t model.Todo) error {
+		if err := app.Templates.ExecuteTemplate(w, "print-row", t); err != nil {
+			return err
+		}
+		if progress.Shown++; stream.row() {
+			return app.Templates.ExecuteTemplate(w, "print-progress", progress)
+		}
+		return nil
+	})
+	if err != nil {
+		requestLog(r.Context()).Printf("print %d: %v", data.List.ID, err)
+		progress.Error = err.Error()
+	}
+	progress.Total = data.Open + data.Done
+	progress.Limited = progress.Shown == printRowLimit && progress.Total > progress.Shown
+	app.Templates.ExecuteTemplate(w, "print-end", progress)
+}
+
+// PDF page layout, in points.
+const (
+	pdfMargin     = 50.0
+	pdfBox        = 10.0
+	pdfTitleSize  = 11.0
+	pdfDetailSize = 9.0
+)
+
+// listPDF is the print view as a PDF, on A4 paper or, with ?paper=letter,
+// US Letter.
+func (app *Application) listPDF(w http.ResponseWriter, r *http.Request) {
+	data, err := app.printData(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	size := pdf.A4
+	if r.URL.Query().Get("paper") == "letter" {
+		size = pdf.Letter
+	}
+
+	var buf bytes.Buffer
+	if _, err := checklistPDF(data, size).WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": data.List.Name + ".pdf"}))
+	buf.WriteTo(w)
+}
+
+// checklistPDF lays out a list's todos one after another, each with a box
+// to tick, breaking onto a new page before a todo that won't fit.
+func checklistPDF(data printPage, size pdf.Size) *pdf.Document {
+	doc := pdf.New(size)
+	doc.Title = data.List.Name
+	width := size.Width - 2*pdfMargin
+	textX := pdfMargin + pdfBox + 8
+
+	page := doc.AddPage()
+	y := pdfMargin + 18
+	for _, line := range pdf.Wrap(data.List.Name, 18, true, width) {
+		page.Text(pdfMargin, y, 18, 0, true, line)
+		y += 22
+	}
+	summary := fmt.Sprintf("%s open, %d done · Printed %s", pluralize(data.Open, "todo", "todos"),
+		data.Done, data.Printed.Format("Jan 2, 2006"))
+	page.Text(pdfMargin, y, pdfDetailSize, 0.4, false, summary)
+	y += 10
+	page.Line(pdfMargin, y, size.Width-pdfMargin, y, 0.7)
+	y += 22
+
+	if len(data.Todos) == 0 {
+		page.Text(pdfMargin, y, pdfTitleSize, 0.4, false, "This list has no todos.")
+	}
+	for _, t := range data.Todos {
+		title := pdf.Wrap(plainText(t.Title), pdfTitleSize, false, width-(textX-pdfMargin))
+		var details []string
+		if meta := todoMeta(t); meta != "" {
+			details = append(details, meta)
+		}
+		if t.Description != "" {
+			details = append(details, pdf.Wrap(t.Description, pdfDetailSize, false, width-(textX-pdfMargin))...)
+		}
+		height := float64(len(title))*14 + float64(len(details))*12 + 8
+		if y+height > size.Height-pdfMargin && y > pdfMargin+pdfTitleSize {
+			page = doc.AddPage()
+			y = pdfMargin + pdfTitleSize
+		}
+
+		gray := 0.0
+		page.Rect(pdfMargin, y-pdfBox+1, pdfBox, pdfBox, 0.3)
+		if t.Completed {
+			gray = 0.55
+			page.Line(pdfMargin+2, y-pdfBox/2+1, pdfMargin+pdfBox/2-1, y-1, 0.3)
+			page.Line(pdfMargin+pdfBox/2-1, y-1, pdfMargin+pdfBox-1, y-pdfBox+3, 0.3)
+		}
+		for _, line := range title {
+			page.Text(textX, y, pdfTitleSize, gray, false, line)
+			y += 14
+		}
+		for _, line := range details {
+			page.Text(textX, y-2, pdfDetailSize, 0.4, false, line)
+			y += 12
+		}
+		y += 8
+	}
+	return doc
+}
+
+// todoMeta is the line under a todo's title on paper: when it's due and
+// how long it should take.
+func todoMeta(t model.Todo) string {
+	var parts []string
+	if t.DueDate != nil {
+		parts = append(parts, "Due "+t.DueDate.Format("Jan 2"))
+	}
+	if t.EstimateMinutes > 0 {
+		parts = append(parts, "Estimate "+t.Estimate())
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// realIP sets RemoteAddr to the client's address from X-Real-IP or
+// X-Forwarded-For, for requests passed on by a trusted proxy. Anyone else
+// could send those headers to get round rate limits or put another address
+// in the access log, so their requests keep the connection's address. On
+// a Unix socket every request comes from the proxy in front.
+func realIP(proxies []netip.Prefix, socket bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if socket || trustedProxy(proxies, r.RemoteAddr) {
+				if ip := forwardedClient(r, proxies); ip.IsValid() {
+					r.RemoteAddr = ip.String()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedProxy says whether addr, a host and port or a bare host, is one
+// of proxies.
+func trustedProxy(proxies []netip.Prefix, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient is the client a proxy says it's forwarding for. In
+// X-Forwarded-For, each proxy appends the address it got the request from,
+// so it's read from the right, skipping the trusted proxies; addresses
+// further left were sent by the client and could be anything.
+func forwardedClient(r *http.Request, proxies []netip.Prefix) netip.Addr {
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = ip.Unmap()
+		if !trustedProxy(proxies, client.String()) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/push"
+)
+
+func (app *Application) pushKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, app.Push.PublicKey())
+}
+
+func (app *Application) subscribePush(w http.ResponseWriter, r *http.Request) {
+	var sub push.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, "Invalid subscription", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := currentUser(r)
+	if err := app.Push.Subscribe(r.Context(), sub, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (app *Application) unsubscribePush(w http.ResponseWriter, r *http.Request) {
+	var sub push.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, "Invalid subscription", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Push.Unsubscribe(r.Context(), sub.Endpoint); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendDueReminders pushes a reminder for each open todo due today or
+// tomorrow to everyone on the todo's list. Reminders are claimed per todo and
+// due date before sending, so each one goes out once even with several
+// instances running the job.
+func (app *Application) sendDueReminders(ctx context.Context) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		WITH claimed AS (
+			INSERT INTO push_reminders (todo_id, due_date)
+			SELECT id, due_date FROM todos
+			WHERE NOT completed AND due_date BETWEEN $1::date AND $1::date + 1
+			ON CONFLICT DO NOTHING
+			RETURNING todo_id
+		)
+		SELECT t.id, t.title, t.due_date, m.user_id FROM todos t
+		JOIN claimed c ON c.todo_id = t.id
+		JOIN lists l ON l.id = t.list_id AND l.archived_at IS NULL
+		JOIN list_members m ON m.list_id = t.list_id`,
+		app.todayDate(),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type reminder struct {
+		id     int
+		title  string
+		due    time.Time
+		userID int
+	}
+	var reminders []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.id, &r.title, &r.due, &r.userID); err != nil {
+			return err
+		}
+		reminders = append(reminders, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	today := app.today()
+	for _, r := range reminders {
+		when := "tomorrow"
+		if r.due.Equal(today) {
+			when = "today"
+		}
+		err := app.Push.SendTo(ctx, r.userID, push.Notification{
+			Title: "Due " + when,
+			Body:  r.title,
+			URL:   app.Config.BaseURL + "/",
+			Tag:   fmt.Sprintf("due-%d", r.id),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
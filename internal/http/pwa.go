@@ -0,0 +1,132 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// appShell is what the service worker caches so the app opens offline.
+func appShell(bundles *assets.Pipeline) []string {
+	return append([]string{
+		"/",
+		"/manifest.webmanifest",
+		"/static/icon.svg",
+	}, bundles.URLs()...)
+}
+
+// buildServiceWorker renders templates/sw.js. The cache name is versioned by
+// a hash of the worker and the shell, so a deploy that changes any of them
+// makes browsers fetch a fresh shell. Bundle URLs carry their own content
+// hash; local static files are hashed by content.
+func buildServiceWorker(path string, appShell []string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(src)
+	for _, url := range appShell {
+		h.Write([]byte(url))
+		if strings.HasPrefix(url, "/static/") {
+			if b, err := os.ReadFile(strings.TrimPrefix(url, "/")); err == nil {
+				h.Write(b)
+			}
+		}
+	}
+
+	shell, err := json.Marshal(appShell)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New("sw.js").Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, map[string]string{
+		"Version": hex.EncodeToString(h.Sum(nil))[:12],
+		"Shell":   string(shell),
+	})
+	return out.Bytes(), err
+}
+
+// serviceWorker serves the generated worker from the site root so its scope
+// covers the whole app.
+func (app *Application) serviceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(app.ServiceWorker)
+}
+
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"name":             "Htmx + Go + PostgreSQL Todos",
+		"short_name":       "Todos",
+		"start_url":        "/",
+		"display":          "standalone",
+		"background_color": "#f3f4f6",
+		"theme_color":      "#3b82f6",
+		"icons": []map[string]string{
+			{"src": "/static/icon.svg", "sizes": "any", "type": "image/svg+xml", "purpose": "any maskable"},
+		},
+	})
+}
+
+// insertTodo creates a todo. A non-empty idempotency key makes retries safe:
+// if the key was already used, the todo created then is returned instead of
+// a duplicate. Offline creates replayed by the service worker rely on this.
+func (app *Application) insertTodo(ctx context.Context, key string, listID int, title string, estimate int, due *time.Time) (int, error) {
+	var id int
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		db := app.db(ctx)
+		if key != "" {
+			res, err := db.ExecContext(ctx,
+				"INSERT INTO idempotency_keys (key) VALUES ($1) ON CONFLICT DO NOTHING",
+				key,
+			)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				var existing sql.NullInt64
+				err := db.QueryRowContext(ctx, "SELECT todo_id FROM idempotency_keys WHERE key = $1", key).Scan(&existing)
+				id = int(existing.Int64)
+				return err
+			}
+		}
+
+		err := db.QueryRowContext(ctx,
+			"INSERT INTO todos (list_id, title, estimate_minutes, due_date, position) VALUES ($1, $2, $3, $4, "+store.NextPosition(1)+") RETURNING id",
+			listID, title, estimate, due,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if key != "" {
+			_, err = db.ExecContext(ctx, "UPDATE idempotency_keys SET todo_id = $1 WHERE key = $2", id, key)
+		}
+		return err
+	})
+	return id, err
+}
+
+func (app *Application) purgeIdempotencyKeys(ctx context.Context) error {
+	_, err := app.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < NOW() - INTERVAL '7 days'")
+	return err
+}
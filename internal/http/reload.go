@@ -0,0 +1,47 @@
+package http
+
+import (
+	"reflect"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// reloadable are the Config fields Reload applies while serving. The rest
+// are wired into the app at boot and only change on a restart.
+var reloadable = map[string]bool{
+	"AccessLog":       true,
+	"RateLimits":      true,
+	"FeatureFlags":    true,
+	"MaintenanceMode": true,
+}
+
+// Reload applies cfg, a freshly loaded config, to the running app. It
+// returns the settings it changed and those that changed but need a
+// restart, by Config field name.
+func (app *Application) Reload(cfg config.Config) (applied, pending []string) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	next := app.running
+	old, updated := reflect.ValueOf(app.running), reflect.ValueOf(cfg)
+	for i := range old.NumField() {
+		name := old.Type().Field(i).Name
+		if reflect.DeepEqual(old.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+		if !reloadable[name] {
+			pending = append(pending, name)
+			continue
+		}
+		applied = append(applied, name)
+		reflect.ValueOf(&next).Elem().Field(i).Set(updated.Field(i))
+	}
+
+	logs := next.AccessLog
+	app.accessLog.Store(&logs)
+	app.Limiter.SetConfig(next.RateLimits)
+	app.Flags.SetOverrides(next.FeatureFlags)
+	app.Maintenance.SetOverride(next.MaintenanceMode)
+	app.running = next
+	return applied, pending
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+)
+
+// view is a response a handler can give in more than one form. htmx gets
+// the Fragment, a browser navigating to the URL gets the full Page, and a
+// client asking for JSON gets JSON. The same route serves all three, so a
+// form post without JavaScript, a swap and an API call share one handler.
+type view struct {
+	// Status defaults to 200.
+	Status int
+	// Fragment is rendered with Data for htmx requests, and for browsers
+	// when there's no Page.
+	Fragment string
+	Data     any
+	// Page is rendered with the result of PageData. It's only built when
+	// a browser asks, since a full page needs more than its fragment.
+	Page     string
+	PageData func() (any, error)
+	// JSON is encoded for clients that prefer application/json. Views
+	// without it answer them with HTML.
+	JSON any
+	// CacheKey, if set, caches the rendered Fragment under it. It has to
+	// change with anything the fragment shows, so nothing needs deleting.
+	CacheKey string
+}
+
+type format int
+
+const (
+	formatPage format = iota
+	formatFragment
+	formatJSON
+)
+
+// negotiate picks how to answer r. Accept decides between JSON and HTML;
+// among HTML requests, htmx gets fragments.
+func negotiate(r *http.Request) format {
+	if prefersJSON(r.Header.Get("Accept")) {
+		return formatJSON
+	}
+	if htmx.IsRequest(r) {
+		return formatFragment
+	}
+	return formatPage
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above
+// text/html. Browsers send text/html first; fetch and curl -H
+// 'Accept: application/json' don't.
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// render answers r with v in the form it asks for. HTML is rendered into a
+// buffer first, so a template error becomes a clean 500 rather than half a
+// page.
+func (app *Application) render(w http.ResponseWriter, r *http.Request, v view) {
+	status := v.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Add("Vary", "Accept, HX-Request")
+
+	f := negotiate(r)
+	if f == formatJSON && v.JSON != nil {
+		writeJSON(w, status, v.JSON)
+		return
+	}
+
+	// A HEAD response has no body, so there's nothing to render.
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		return
+	}
+
+	name, data := v.Fragment, v.Data
+	if f != formatFragment && v.Page != "" {
+		name = v.Page
+		if v.PageData != nil {
+			var err error
+			if data, err = v.PageData(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	var html string
+	execute := func() (string, error) {
+		var buf bytes.Buffer
+		err := app.Templates.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}
+	var err error
+	if name == v.Fragment && v.CacheKey != "" {
+		html, err = cache.Load(r.Context(), app.Cache, v.CacheKey, execute)
+	} else {
+		html, err = execute()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, html)
+}
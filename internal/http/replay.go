@@ -0,0 +1,259 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/querylog"
+	"github.com/Trailblazors/htmx-go-postgres/internal/replay"
+)
+
+const replayKey contextKey = "replay"
+
+// replayTimeout bounds a replayed request, so one that hangs, like the
+// event stream, doesn't tie up the admin's.
+const replayTimeout = 30 * time.Second
+
+// maxReplayBody is how much of a replayed response's body is shown.
+const maxReplayBody = 64 << 10
+
+// replaying is what a replayed request carries: who it runs as. It's only
+// ever set by replayRequest, so no request from outside can claim it.
+type replaying struct {
+	User *model.User
+}
+
+func replayOf(r *http.Request) *replaying {
+	rp, _ := r.Context().Value(replayKey).(*replaying)
+	return rp
+}
+
+// recordRequests keeps every request that fails with a 5xx, and a sample
+// of the rest, in app.Replays for /admin/requests. Secrets are left out:
+// the cookie and headers and form fields named like passwords, tokens or
+// keys, by the same rules as the access log. Other bodies than urlencoded
+// forms aren't kept.
+func (app *Application) recordRequests(next http.Handler) http.Handler {
+	if app.Config.Replay.Size == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if replayOf(r) != nil || r.URL.Path == "/health" || r.URL.Path == "/readyz" ||
+			strings.HasPrefix(r.URL.Path, "/admin") ||
+			strings.HasPrefix(r.URL.Path, "/static/") ||
+			strings.HasPrefix(r.URL.Path, assets.Prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		form := readForm(r)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 500 && rand.Float64() >= app.Config.Replay.SampleRate {
+			return
+		}
+
+		logs := app.accessLog.Load()
+		req := replay.Request{
+			At:        start,
+			RequestID: scopeOf(r.Context()).ID,
+			Method:    r.Method,
+			URL:       r.URL.RequestURI(),
+			Header:    http.Header{},
+			Status:    status,
+			Duration:  time.Since(start),
+		}
+		for name, values := range r.Header {
+			if name != "Cookie" && !sensitive(logs, name) {
+				req.Header[name] = values
+			}
+		}
+		if form != nil {
+			req.Form = url.Values{}
+			for name, values := range form {
+				if sensitive(logs, name) {
+					values = []string{redacted}
+				}
+				req.Form[name] = values
+			}
+		} else {
+			req.BodyDropped = r.ContentLength > 0
+		}
+		if user, _ := currentUser(r); user != nil {
+			req.UserID = user.ID
+		}
+		app.Replays.Add(req)
+	})
+}
+
+type requestsPage struct {
+	Requests []replay.Request
+	// Errors narrows the list to 5xx responses.
+	Errors bool
+	Size   int
+}
+
+// adminRequests lists the requests kept for replaying, newest first.
+func (app *Application) adminRequests(w http.ResponseWriter, r *http.Request) {
+	data := requestsPage{Errors: r.URL.Query().Get("errors") == "on", Size: app.Config.Replay.Size}
+	for _, req := range app.Replays.Recent() {
+		if !data.Errors || req.Status >= 500 {
+			data.Requests = append(data.Requests, req)
+		}
+	}
+	app.Templates.ExecuteTemplate(w, "admin-requests.html", data)
+}
+
+type requestPage struct {
+	Request replay.Request
+	// User is who the request was made by, if they still exist.
+	User *model.User
+}
+
+func (app *Application) adminRequest(w http.ResponseWriter, r *http.Request) {
+	req, ok := app.replayed(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+	data := requestPage{Request: req}
+	if req.UserID != 0 {
+		if user, err := app.loadUser(r.Context(), req.UserID); err == nil {
+			data.User = &user
+		}
+	}
+	app.Templates.ExecuteTemplate(w, "admin-request.html", data)
+}
+
+type replayResult struct {
+	Error     string
+	RequestID string
+	Status    int
+	Duration  time.Duration
+	Header    http.Header
+	Body      string
+	Truncated bool
+	Queries   []querylog.Query
+	Dropped   int
+}
+
+// replayRequest serves a kept request again through the app's routes, as
+// the user who made it, and shows the response and the SQL it ran. A panic
+// gets the development error page whatever DEV_MODE says. Requests that
+// change things have to be confirmed, since they change them again. Each
+// replay is audited against the user it ran as.
+func (app *Application) replayRequest(w http.ResponseWriter, r *http.Request) {
+	req, ok := app.replayed(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+	if !req.Safe() && r.FormValue("confirm") != "on" {
+		app.Templates.ExecuteTemplate(w, "replay-result", replayResult{Error: "Tick the box to run a " + req.Method + " again; it makes its changes again too."})
+		return
+	}
+
+	rp := &replaying{}
+	if req.UserID != 0 {
+		user, err := app.loadUser(r.Context(), req.UserID)
+		if err != nil {
+			app.Templates.ExecuteTemplate(w, "replay-result", replayResult{Error: fmt.Sprintf("Can't load user %d: %v", req.UserID, err)})
+			return
+		}
+		rp.User = &user
+	}
+
+	// A fresh context, so the admin's routing doesn't leak into the
+	// replay's.
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	ctx, queries := querylog.NewContext(context.WithValue(ctx, replayKey, rp))
+	replayed, err := req.NewRequest(ctx)
+	if err != nil {
+		app.Templates.ExecuteTemplate(w, "replay-result", replayResult{Error: err.Error()})
+		return
+	}
+
+	rw := &replayWriter{header: http.Header{}, body: &limitedWriter{buf: &bytes.Buffer{}, max: maxReplayBody}}
+	start := time.Now()
+	app.routes.ServeHTTP(rw, replayed)
+	res := replayResult{
+		RequestID: rw.header.Get("X-Request-ID"),
+		Status:    rw.status,
+		Duration:  time.Since(start),
+		Header:    rw.header,
+		Body:      rw.body.buf.String(),
+		Truncated: rw.written > maxReplayBody,
+	}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	res.Queries, res.Dropped = queries.Queries()
+
+	e := audit.Event{Action: audit.RequestReplayed, Detail: req.Method + " " + req.URL}
+	if rp.User != nil {
+		e.UserID, e.Email = rp.User.ID, rp.User.Email
+	}
+	if admin, _ := currentUser(r); admin != nil {
+		e.Detail += " by " + admin.Email
+	}
+	app.audit(r, e)
+
+	app.Templates.ExecuteTemplate(w, "replay-result", res)
+}
+
+// replayed returns the kept request the route's {id} names.
+func (app *Application) replayed(r *http.Request) (replay.Request, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return replay.Request{}, false
+	}
+	return app.Replays.Get(id)
+}
+
+// replayWriter collects a replayed response, keeping the start of the
+// body.
+type replayWriter struct {
+	header  http.Header
+	status  int
+	body    *limitedWriter
+	written int
+}
+
+func (rw *replayWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *replayWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *replayWriter) Write(p []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	rw.written += len(p)
+	return rw.body.Write(p)
+}
+
+// Flush is a no-op, for handlers that stream.
+func (rw *replayWriter) Flush() {}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// Retention jobs, by the name their runs are recorded under.
+const (
+	purgeTodosJob     = "purge-archived-todos"
+	anonymizeUsersJob = "anonymize-inactive-accounts"
+	purgeAuditJob     = "purge-audit-events"
+)
+
+// staleTodos, staleAccounts and staleAuditEvents select what the
+// retention policy removes, given its age in $1. Archiving is the app's
+// soft delete, so archived todos are the ones purged.
+const (
+	staleTodos    = "SELECT id FROM todos WHERE archived_at < NOW() - make_interval(days => $1)"
+	staleAccounts = `SELECT id FROM users
+		WHERE NOT is_admin AND anonymized_at IS NULL
+		AND last_active_at < NOW() - make_interval(months => $1)`
+	staleAuditEvents = "SELECT id FROM audit_events WHERE created_at < NOW() - make_interval(months => $1)"
+)
+
+// anonymizeStatements strip accounts $1 of everything that identifies
+// whoever owned them, and of any way to log back in. Their lists and
+// todos stay, as others may share them.
+var anonymizeStatements = []string{
+	"DELETE FROM sessions WHERE user_id = ANY($1)",
+	"DELETE FROM identities WHERE user_id = ANY($1)",
+	"DELETE FROM pending_links WHERE user_id = ANY($1)",
+	"DELETE FROM email_verifications WHERE user_id = ANY($1)",
+	"DELETE FROM digest_subscriptions WHERE user_id = ANY($1)",
+	"DELETE FROM push_subscriptions WHERE user_id = ANY($1)",
+	"DELETE FROM api_tokens WHERE user_id = ANY($1)",
+	"DELETE FROM sync_connections WHERE user_id = ANY($1)",
+	"DELETE FROM export_schedules WHERE user_id = ANY($1)",
+	"DELETE FROM saved_searches WHERE user_id = ANY($1)",
+	"DELETE FROM undo_log WHERE user_id = ANY($1)",
+	"UPDATE audit_events SET email = '', ip = '', user_agent = '' WHERE user_id = ANY($1)",
+	`UPDATE users SET email = 'anonymized-' || id || '@invalid', password_hash = '',
+		email_verified_at = NULL, anonymized_at = NOW()
+	 WHERE id = ANY($1)`,
+}
+
+type retentionRun struct {
+	Job      string
+	DryRun   bool
+	Affected int
+	RanAt    time.Time
+}
+
+// retentionReport is the admin dashboard's view of the policy: what the
+// next runs would remove, and what the last ones did.
+type retentionReport struct {
+	Policy      config.Retention
+	Todos       int
+	Accounts    int
+	AuditEvents int
+	Runs        []retentionRun
+}
+
+// purgeArchivedTodos deletes todos archived longer ago than
+// PURGE_ARCHIVED_AFTER_DAYS.
+func (app *Application) purgeArchivedTodos(ctx context.Context) error {
+	days := app.Config.Retention.ArchivedTodoDays
+	if days == 0 {
+		return nil
+	}
+	return app.retentionJob(ctx, purgeTodosJob, staleTodos, days, func(ctx context.Context, ids []int64) error {
+		_, err := app.db(ctx).ExecContext(ctx, "DELETE FROM todos WHERE id = ANY($1)", pq.Array(ids))
+		return err
+	})
+}
+
+// anonymizeInactiveAccounts anonymizes accounts nobody has used for
+// ANONYMIZE_INACTIVE_AFTER_MONTHS.
+func (app *Application) anonymizeInactiveAccounts(ctx context.Context) error {
+	months := app.Config.Retention.InactiveMonths
+	if months == 0 {
+		return nil
+	}
+	return app.retentionJob(ctx, anonymizeUsersJob, staleAccounts, months, func(ctx context.Context, ids []int64) error {
+		for _, stmt := range anonymizeStatements {
+			if _, err := app.db(ctx).ExecContext(ctx, stmt, pq.Array(ids)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// purgeAuditEvents deletes audit events older than
+// PURGE_AUDIT_AFTER_MONTHS. There can be far too many to select like the
+// other jobs, so they're deleted in one statement, or once audit_events is
+// partitioned, their months are dropped whole.
+func (app *Application) purgeAuditEvents(ctx context.Context) error {
+	months := app.Config.Retention.AuditMonths
+	if months == 0 {
+		return nil
+	}
+	dryRun := app.Config.Retention.DryRun
+	var affected int64
+	var err error
+	if dryRun {
+		err = app.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+staleAuditEvents+") stale", months).Scan(&affected)
+	} else {
+		affected, err = app.purgeBefore(ctx, auditEventsTable, time.Now().AddDate(0, -months, 0))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := app.DB.ExecContext(ctx,
+		"INSERT INTO retention_runs (job, dry_run, affected) VALUES ($1, $2, $3)", purgeAuditJob, dryRun, affected); err != nil {
+		return err
+	}
+	logRetentionRun(purgeAuditJob, dryRun, int(affected))
+	return nil
+}
+
+// retentionJob finds the rows stale selects at age and, unless
+// RETENTION_DRY_RUN is set, hands them to remove, recording the run either
+// way.
+func (app *Application) retentionJob(ctx context.Context, job, stale string, age int, remove func(ctx context.Context, ids []int64) error) error {
+	dryRun := app.Config.Retention.DryRun
+	var ids []int64
+	err := app.withTx(ctx, nil, func(ctx context.Context) error {
+		ids = nil
+		if err := app.db(ctx).QueryRowContext(ctx,
+			"SELECT COALESCE(array_agg(id), '{}') FROM ("+stale+" FOR UPDATE) stale", age,
+		).Scan(pq.Array(&ids)); err != nil {
+			return err
+		}
+		if len(ids) > 0 && !dryRun {
+			if err := remove(ctx, ids); err != nil {
+				return err
+			}
+		}
+		_, err := app.db(ctx).ExecContext(ctx,
+			"INSERT INTO retention_runs (job, dry_run, affected) VALUES ($1, $2, $3)", job, dryRun, len(ids))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logRetentionRun(job, dryRun, len(ids))
+	return nil
+}
+
+func logRetentionRun(job string, dryRun bool, affected int) {
+	if dryRun {
+		log.Printf("%s: dry run, would have affected %d rows", job, affected)
+	} else if affected > 0 {
+		log.Printf("%s: affected %d rows", job, affected)
+	}
+}
+
+// retentionReport counts what the retention jobs would remove if they ran
+// now, alongside their latest runs.
+func (app *Application) retentionReport(ctx context.Context) (retentionReport, error) {
+	report := retentionReport{Policy: app.Config.Retention}
+	counts := []struct {
+		age   int
+		stale string
+		dst   *int
+	}{
+		{report.Policy.ArchivedTodoDays, staleTodos, &report.Todos},
+		{report.Policy.InactiveMonths, staleAccounts, &report.Accounts},
+		{report.Policy.AuditMonths, staleAuditEvents, &report.AuditEvents},
+	}
+	for _, c := range counts {
+		if c.age == 0 {
+			continue
+		}
+		if err := app.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+c.stale+") stale", c.age).Scan(c.dst); err != nil {
+			return report, err
+		}
+	}
+
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT job, dry_run, affected, ran_at FROM retention_runs ORDER BY ran_at DESC LIMIT 10")
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var run retentionRun
+		if err := rows.Scan(&run.Job, &run.DryRun, &run.Affected, &run.RanAt); err != nil {
+			return report, err
+		}
+		report.Runs = append(report.Runs, run)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package http
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("REUSE_PORT isn't supported on this platform")
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/diff"
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+type todoHistory struct {
+	Todo      model.Todo
+	Revisions []revisionView
+	CanEdit   bool
+}
+
+// revisionView is an earlier version of a todo and what the edit that
+// replaced it changed.
+type revisionView struct {
+	model.Revision
+	Title       []diff.Chunk
+	Description []diff.Chunk
+}
+
+// getHistory lists the versions a todo's title and description have had,
+// newest first, each diffed against the one that came after it. The last
+// 20 are kept.
+func (app *Application) getHistory(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := app.Queries.ListRevisions(r.Context(), todo.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := todoHistory{Todo: todo, CanEdit: listAccess(r).Role >= model.Editor}
+	title, description := todo.Title, todo.Description
+	for _, v := range revisions {
+		data.Revisions = append(data.Revisions, revisionView{
+			Revision:    v,
+			Title:       diff.Words(v.Title, title),
+			Description: diff.Words(v.Description, description),
+		})
+		title, description = v.Title, v.Description
+	}
+	app.renderModal(w, "Edit history", "todo-history", data)
+}
+
+// revertTodo puts a todo's title and description back as they were in one
+// of its revisions. It can be undone like an edit, and the version it
+// replaces joins the history.
+func (app *Application) revertTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	revisionID, err := strconv.ParseInt(chi.URLParam(r, "revisionID"), 10, 64)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "revert", []int{id}, func(ctx context.Context) ([]int, error) {
+		return nil, app.Queries.RevertTodo(ctx, id, revisionID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	closeModal(w)
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+const scopeKey contextKey = "scope"
+
+// requestScope is what a request carries from the middleware into handlers:
+// its ID, who's asking and a logger tagged with the ID. Handlers get at it
+// through the accessors below (currentUser, requestLog) rather than through
+// globals, so what a handler works against can differ per request. The
+// transaction a request runs in, if one is open, travels in its context
+// too; see app.db.
+type requestScope struct {
+	ID      string
+	User    *model.User
+	Session session.Session
+	// Impersonator is the admin acting as User, if one is.
+	Impersonator *model.User
+	// Token is the API token the request was made with, if it was.
+	Token *apitoken.Token
+	Log   *log.Logger
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// scoped starts each request's scope. An X-Request-ID from the proxy in
+// front is kept so log lines can be matched up with its logs; otherwise a
+// new one is made. Either way it's echoed in the response.
+func scoped(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		s := &requestScope{
+			ID:  id,
+			Log: log.New(os.Stderr, "["+id+"] ", log.LstdFlags|log.Lmsgprefix),
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeKey, s)))
+	})
+}
+
+// scopeOf returns the scope of the request in ctx. Outside a request, as in
+// background jobs, it's an empty scope logging to the standard logger.
+func scopeOf(ctx context.Context) *requestScope {
+	if s, ok := ctx.Value(scopeKey).(*requestScope); ok {
+		return s
+	}
+	return &requestScope{Log: log.Default()}
+}
+
+// requestLog returns the logger for the request in ctx.
+func requestLog(ctx context.Context) *log.Logger {
+	return scopeOf(ctx).Log
+}
+
+// db returns what queries in ctx should run against: the transaction
+// withTx opened, or the pool.
+func (app *Application) db(ctx context.Context) store.DBTX {
+	return store.Conn(ctx, app.DB)
+}
@@ -0,0 +1,257 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/search"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// SearchResult is a todo with its title split into highlighted segments.
+type SearchResult struct {
+	model.Todo
+	Segments []search.Segment
+}
+
+type searchResults struct {
+	Query   string
+	Fuzzy   bool
+	Results []SearchResult
+	// Notes explain operators that were searched for as text or left
+	// out.
+	Notes []string
+}
+
+// searchFilter is the SQL condition a query's operators put on todos,
+// with its args.
+type searchFilter struct {
+	where []string
+	args  []any
+}
+
+// arg adds v to the filter's args and returns its placeholder.
+func (f *searchFilter) arg(v any) string {
+	f.args = append(f.args, v)
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+// matchText narrows the filter to titles matching text, returning the
+// placeholder of the text.
+func (f *searchFilter) matchText(text string) string {
+	q := f.arg(text)
+	f.where = append(f.where, "to_tsvector('english', title) @@ websearch_to_tsquery('english', "+q+")")
+	return q
+}
+
+func (f *searchFilter) String() string {
+	return strings.Join(f.where, " AND ")
+}
+
+// isConditions are what is: can filter on. %[1]s stands for today's date.
+var isConditions = map[string]string{
+	"open":      "NOT completed",
+	"done":      "completed",
+	"completed": "completed",
+	"overdue":   "NOT completed AND due_date < %[1]s",
+	"blocked": `NOT completed AND EXISTS (
+		SELECT 1 FROM todo_dependencies d JOIN todos b ON b.id = d.blocker_id
+		WHERE d.todo_id = todos.id AND NOT b.completed)`,
+}
+
+// fieldConditions compare a custom field's value, f.id on the todo's list,
+// with the operator's (%[4]s, by %[3]s), for each kind of field. Text fields match by
+// substring and select fields ignore case; number and date fields can be
+// compared with <, <=, > and >=.
+var fieldConditions = map[string]string{
+	model.TextField:   "strpos(lower(todos.custom_fields ->> f.id::text), lower(%[4]s)) > 0",
+	model.SelectField: "lower(todos.custom_fields ->> f.id::text) = lower(%[4]s)",
+	model.NumberField: "(todos.custom_fields ->> f.id::text)::numeric %[3]s %[4]s::numeric",
+	model.DateField:   "(todos.custom_fields ->> f.id::text)::date %[3]s %[4]s::date",
+}
+
+// parseSearch turns a query's operators into a filter on the list the
+// search is made from, or with list: on the caller's lists by that name.
+// Only the built-in operators and the list's custom fields are operators;
+// anything else with a colon is searched for as text.
+func (app *Application) parseSearch(ctx context.Context, userID, listID int, query string) (text string, filter searchFilter, notes []string, err error) {
+	list, err := app.listFields(ctx, listID)
+	if err != nil {
+		return "", filter, nil, err
+	}
+	fields := make(map[string]model.Field, len(list))
+	names := make([]string, len(list))
+	for i, f := range list {
+		fields[search.FieldKey(f.Name)] = f
+		names[i] = f.Name
+	}
+	q := search.Parse(query, names...)
+	text = q.Text
+	scoped := false
+
+	for _, op := range q.Operators {
+		switch op.Key {
+		case "is":
+			cond, ok := isConditions[strings.ToLower(op.Value)]
+			if !ok {
+				notes = append(notes, fmt.Sprintf("is:%s isn't something to filter on; try is:open, is:done, is:overdue or is:blocked.", op.Value))
+				continue
+			}
+			if strings.Contains(cond, "%[1]s") {
+				cond = fmt.Sprintf(cond, filter.arg(app.todayDate())+"::date")
+			}
+			filter.where = append(filter.where, cond)
+
+		case "due":
+			if strings.EqualFold(op.Value, "none") {
+				filter.where = append(filter.where, "due_date IS NULL")
+				continue
+			}
+			day, ok := search.Date(op.Value, app.today())
+			if !ok {
+				notes = append(notes, fmt.Sprintf("due:%s isn't a date; try a weekday, today, tomorrow or YYYY-MM-DD.", op.Value))
+				continue
+			}
+			filter.where = append(filter.where, "due_date "+cmp.Or(op.Op, "=")+" "+filter.arg(day.Format(time.DateOnly))+"::date")
+
+		case "list":
+			scoped = true
+			filter.where = append(filter.where, "list_id IN (SELECT l.id FROM lists l JOIN list_members m ON m.list_id = l.id"+
+				" WHERE m.user_id = "+filter.arg(userID)+" AND l.archived_at IS NULL AND strpos(lower(l.name), lower("+filter.arg(op.Value)+")) > 0)")
+
+		default:
+			f := fields[search.FieldKey(op.Key)]
+			compare := cmp.Or(op.Op, "=")
+			if compare != "=" && f.Kind != model.NumberField && f.Kind != model.DateField {
+				notes = append(notes, fmt.Sprintf("%s is a %s field, so it can only be matched, not compared.", f.Name, f.Kind))
+				compare = "="
+			}
+			value := any(op.Value)
+			switch f.Kind {
+			case model.NumberField:
+				if value, err = f.Parse(op.Value); err != nil {
+					notes = append(notes, err.Error()+".")
+					continue
+				}
+			case model.DateField:
+				// Dates can be relative here, like due:friday.
+				day, ok := search.Date(op.Value, app.today())
+				if !ok {
+					notes = append(notes, fmt.Sprintf("%s:%s isn't a date; try a weekday, today, tomorrow or YYYY-MM-DD.", op.Key, op.Value))
+					continue
+				}
+				value = day.Format(time.DateOnly)
+			}
+			// The field is matched by name and kind on each todo's own
+			// list, so list: searches across lists that share it.
+			filter.where = append(filter.where, fmt.Sprintf(
+				"EXISTS (SELECT 1 FROM list_fields f WHERE f.list_id = todos.list_id AND lower(f.name) = lower(%s) AND f.kind = %s AND "+fieldConditions[f.Kind]+")",
+				filter.arg(f.Name), filter.arg(f.Kind), compare, filter.arg(value),
+			))
+		}
+	}
+
+	if !scoped {
+		filter.where = append(filter.where, "list_id = "+filter.arg(listID))
+	}
+	// Clipped, so callers adding to their copies of the filter never
+	// write into each other's.
+	filter.where, filter.args = slices.Clip(filter.where), slices.Clip(filter.args)
+	return text, filter, notes, nil
+}
+
+// searchTodos searches the list, or with list: others, by the text of the
+// query and its operators; see parseSearch.
+func (app *Application) searchTodos(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		app.getTodos(w, r)
+		return
+	}
+
+	user, _ := currentUser(r)
+	text, filter, notes, err := app.parseSearch(r.Context(), user.ID, listAccess(r).ListID, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	todos, err := app.fullTextSearch(r.Context(), filter, text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fuzzy := false
+	if len(todos) == 0 && text != "" && app.TrigramSearch {
+		fuzzy = true
+		if todos, err = app.fuzzySearch(r.Context(), filter, text); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if todos == nil {
+		todos = []model.Todo{}
+	}
+	terms := search.Terms(text)
+	data := searchResults{Query: q, Fuzzy: fuzzy, Notes: notes}
+	for _, todo := range todos {
+		data.Results = append(data.Results, SearchResult{
+			Todo:     todo,
+			Segments: search.Highlight(todo.Title, terms),
+		})
+	}
+
+	app.render(w, r, view{Fragment: "search-results.html", Data: data, JSON: todos})
+}
+
+// fullTextSearch returns the todos matching filter whose titles match text,
+// best match first, or all of them, in list order, when there's no text.
+func (app *Application) fullTextSearch(ctx context.Context, filter searchFilter, text string) ([]model.Todo, error) {
+	order := "position DESC, id DESC"
+	if text != "" {
+		q := filter.matchText(text)
+		order = "ts_rank(to_tsvector('english', title), websearch_to_tsquery('english', " + q + ")) DESC, id DESC"
+	}
+	filter.arg(app.Clock.Now())
+	query := "SELECT " + store.TodoColumns(len(filter.args)) + " FROM todos WHERE archived_at IS NULL AND " + filter.String() + " ORDER BY " + order + " LIMIT 50"
+	rows, err := app.DB.QueryContext(ctx, query, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	return store.ScanTodos(rows)
+}
+
+// fuzzySearch matches titles containing a word similar to text. The
+// threshold is loosened for this transaction only, so single typos in short
+// words still match while the <% operator can use the trigram index.
+func (app *Application) fuzzySearch(ctx context.Context, filter searchFilter, text string) ([]model.Todo, error) {
+	filter.arg(app.Clock.Now())
+	columns := store.TodoColumns(len(filter.args))
+	q := filter.arg(text)
+	query := "SELECT " + columns + " FROM todos WHERE archived_at IS NULL AND " + filter.String() +
+		" AND " + q + " <% title ORDER BY word_similarity(" + q + ", title) DESC, id DESC LIMIT 20"
+
+	var todos []model.Todo
+	err := app.withTx(ctx, &sql.TxOptions{ReadOnly: true}, func(ctx context.Context) error {
+		db := app.db(ctx)
+		if _, err := db.ExecContext(ctx, "SET LOCAL pg_trgm.word_similarity_threshold = 0.4"); err != nil {
+			return err
+		}
+		rows, err := db.QueryContext(ctx, query, filter.args...)
+		if err != nil {
+			return err
+		}
+		todos, err = store.ScanTodos(rows)
+		return err
+	})
+	return todos, err
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/connector"
+	"github.com/Trailblazors/htmx-go-postgres/internal/secretbox"
+)
+
+// sealedColumns are every column the app seals with its Secrets. API
+// tokens and sessions aren't among them: only their hashes are stored, so
+// there's nothing to open. Sign-in providers' tokens aren't kept at all.
+var sealedColumns = append([]secretbox.Column{
+	{Table: "export_schedules", Key: "id", Name: "credentials"},
+}, connector.SealedColumns...)
+
+// KeyRotation is how many values of a column RotateKeys resealed.
+type KeyRotation struct {
+	Column   secretbox.Column
+	Resealed int
+}
+
+// RotateKeys reseals every sealed value, and any a column still holds in
+// plaintext, under the current ENCRYPTION_KEY, so the keys it replaced can
+// be dropped from ENCRYPTION_OLD_KEYS. Each column is resealed in its own
+// transaction; running it again carries on where a failed run stopped.
+func (app *Application) RotateKeys(ctx context.Context) ([]KeyRotation, error) {
+	if app.Secrets == nil {
+		return nil, errors.New("rotating keys needs ENCRYPTION_KEY")
+	}
+	var out []KeyRotation
+	for _, c := range sealedColumns {
+		n, err := app.Secrets.Rotate(ctx, app.DB, c)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, KeyRotation{Column: c, Resealed: n})
+	}
+	return out, nil
+}
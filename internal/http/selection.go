@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// selectRange ticks every todo between two on a list's page, both
+// included, in the order the page shows them with the same field filters,
+// for shift+x (see static/js/keyboard.js). The response is nothing but
+// checkboxes swapped in out of band, so the list isn't reloaded and
+// anything already ticked stays ticked.
+func (app *Application) selectRange(w http.ResponseWriter, r *http.Request) {
+	from, err1 := strconv.Atoi(r.FormValue("from"))
+	to, err2 := strconv.Atoi(r.FormValue("to"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid todo", http.StatusBadRequest)
+		return
+	}
+	todos, _, err := app.visibleTodos(r, listAccess(r).ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	i := slices.IndexFunc(todos, func(t model.Todo) bool { return t.ID == from })
+	j := slices.IndexFunc(todos, func(t model.Todo) bool { return t.ID == to })
+	if i < 0 || j < 0 {
+		// One of them has gone since the page loaded. A reload will show
+		// what's there now.
+		htmx.Trigger(w, "todosReplayed")
+		return
+	}
+	if i > j {
+		i, j = j, i
+	}
+	var ids []int
+	for _, t := range todos[i : j+1] {
+		ids = append(ids, t.ID)
+	}
+	app.Templates.ExecuteTemplate(w, "selected-range", ids)
+}
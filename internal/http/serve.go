@@ -0,0 +1,174 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// readiness is what /readyz reports. An instance is ready until it's told
+// to drain, through POST /readyz or by SIGTERM, so the load balancer stops
+// sending it new requests before it shuts down.
+type readiness struct {
+	draining atomic.Bool
+}
+
+// readyz answers 200 while the instance is ready and can reach the
+// database, and 503 otherwise. Unlike /health, which only says the process
+// is alive, it's meant for routing traffic.
+func (app *Application) readyz(w http.ResponseWriter, r *http.Request) {
+	if app.Ready.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := app.DB.PingContext(ctx); err != nil {
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ready")
+}
+
+// setReadiness lets a deploy system take an instance out of rotation before
+// stopping it (state=draining), or put it back (state=ready).
+func (app *Application) setReadiness(w http.ResponseWriter, r *http.Request) {
+	switch r.FormValue("state") {
+	case "draining":
+		app.Ready.draining.Store(true)
+	case "ready":
+		app.Ready.draining.Store(false)
+	default:
+		http.Error(w, "state must be draining or ready", http.StatusBadRequest)
+		return
+	}
+	fmt.Fprint(w, r.FormValue("state"))
+}
+
+// Drain fails /readyz from now on, so the load balancer stops sending new
+// requests before the server shuts down.
+func (app *Application) Drain() {
+	app.Ready.draining.Store(true)
+}
+
+// Server returns the server for handler: on PORT, or on :443 with
+// certificates from Let's Encrypt when TLS domains are configured (see
+// TLSServer), with the configured timeouts. With TLS, redirect is the
+// server for :80, and nil otherwise. HTTP/2 is negotiated over TLS; with
+// H2C it's also accepted in cleartext, from a trusted proxy that speaks it
+// (see h2cFromProxies).
+func Server(cfg config.Config, handler http.Handler) (srv, redirect *http.Server, err error) {
+	srv = &http.Server{Addr: ":" + cfg.Port, Handler: handler}
+	if cfg.TLS.Enabled() {
+		srv, redirect = TLSServer(cfg.TLS, handler)
+		redirect.ReadHeaderTimeout = cfg.HTTP.ReadHeaderTimeout
+		redirect.IdleTimeout = cfg.HTTP.IdleTimeout
+	}
+	srv.ReadHeaderTimeout = cfg.HTTP.ReadHeaderTimeout
+	srv.IdleTimeout = cfg.HTTP.IdleTimeout
+
+	if cfg.HTTP.H2C {
+		h2s := &http2.Server{IdleTimeout: cfg.HTTP.IdleTimeout}
+		// Upgraded connections are hijacked from srv; this has Shutdown
+		// close them gracefully too.
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			return nil, nil, err
+		}
+		srv.Handler = h2cFromProxies(handler, h2c.NewHandler(handler, h2s), cfg.HTTP.TrustedProxies, cfg.Socket != "")
+	}
+	return srv, redirect, nil
+}
+
+// h2cFromProxies serves requests from trusted proxies, or all of them on
+// a Unix socket, with h2, which takes HTTP/2 in cleartext, and everyone
+// else with handler over HTTP/1.1. Cleartext HTTP/2 skips the limits the
+// proxy puts on clients, so a client that reaches the port directly can't
+// use it: its Upgrade: h2c header goes unanswered, and a connection that
+// opens with the HTTP/2 preface is turned away.
+func h2cFromProxies(handler, h2 http.Handler, proxies []netip.Prefix, socket bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if socket || trustedProxy(proxies, r.RemoteAddr) {
+			h2.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == "PRI" && r.ProtoMajor == 2 {
+			http.Error(w, "HTTP/2 without TLS is only accepted from the proxy in front", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Listen opens the server's socket. Under systemd socket activation the
+// socket is inherited instead, so it stays open, queueing connections,
+// while the service restarts. With reusePort the new process binds
+// alongside the old one and the kernel spreads connections between them
+// until the old one has drained.
+func Listen(addr string, reuse bool) (net.Listener, error) {
+	if ln, ok, err := inherited(); ok {
+		return ln, err
+	}
+
+	var lc net.ListenConfig
+	if reuse {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// ListenUnix opens a Unix domain socket at path with the given
+// permissions, for a proxy on the same machine. A socket left behind by a
+// server that didn't shut down cleanly is replaced, and the listener
+// removes the socket when it's closed. A socket inherited from systemd
+// takes precedence, as with Listen.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if ln, ok, err := inherited(); ok {
+		return ln, err
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == os.ModeSocket {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// inherited returns the socket systemd passed in, TCP or Unix, when the
+// process was started by socket activation.
+func inherited() (net.Listener, bool, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, false, nil
+	}
+	if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n < 1 {
+		return nil, false, nil
+	}
+	// Inherited descriptors start at 3, after stdin, stdout and stderr.
+	f := os.NewFile(3, "listen")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	return ln, true, err
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+func TestH2COnlyFromTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []netip.Prefix
+		want    bool
+	}{
+		{"from a trusted proxy", []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, true},
+		{"from anyone else", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{HTTP: config.HTTP{H2C: true, TrustedProxies: tt.proxies}}
+			srv, _, err := Server(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, r.Proto)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(ln)
+			defer srv.Close()
+
+			// HTTP/2 with prior knowledge, as a proxy speaking h2c sends it.
+			client := &http.Client{Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, addr)
+				},
+			}}
+			resp, err := client.Get("http://" + ln.Addr().String() + "/")
+			if err != nil {
+				if tt.want {
+					t.Fatal(err)
+				}
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if got := resp.StatusCode == http.StatusOK && string(body) == "HTTP/2.0"; got != tt.want {
+				t.Errorf("got %s %q over h2c, want served = %v", resp.Status, body, tt.want)
+			}
+
+			// HTTP/1.1 works either way.
+			resp, err = http.Get("http://" + ln.Addr().String() + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/1.1" {
+				t.Errorf("got %q over HTTP/1.1", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+	"github.com/Trailblazors/htmx-go-postgres/internal/usage"
+)
+
+type settingsPage struct {
+	Page
+	Usage          []usage.RouteUsage
+	Sessions       []session.Session
+	CurrentSession int64
+	Activity       []audit.Event
+	PasswordForm   passwordForm
+	Identities     identitiesForm
+	Preferences    preferencesForm
+	ListView       listViewForm
+	Tokens         tokensForm
+	Alerts         []savedSearch
+	Exports        exportsForm
+	Downloads      downloadsForm
+}
+
+type preferencesForm struct {
+	ConfirmDeletes bool
+	Message        string
+}
+
+func (app *Application) settingsHandler(w http.ResponseWriter, r *http.Request) {
+	data := settingsPage{Page: app.page(r)}
+
+	var err error
+	if user, current := currentUser(r); user != nil {
+		data.CurrentSession = current.ID
+		data.Preferences.ConfirmDeletes = user.ConfirmDeletes
+		data.ListView = listViewForm{View: user.ListView, Columns: model.Columns}
+		if data.Sessions, err = app.Sessions.List(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Activity, err = app.Audit.ForUser(r.Context(), user.ID, 20); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Identities, err = app.identities(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Tokens.Tokens, err = app.Tokens.List(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Usage, err = app.Usage.ForSubjects(r.Context(), userSubjects(user.ID, data.Tokens.Tokens), 30); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Alerts, err = app.savedSearches(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Downloads.Downloads, err = app.exportDownloads(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Exports.Enabled = app.Secrets != nil
+		if data.Exports.Schedules, err = app.exportSchedules(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = app.DB.QueryRowContext(r.Context(),
+			"SELECT password_hash <> '' FROM users WHERE id = $1", user.ID,
+		).Scan(&data.PasswordForm.HasPassword)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.Templates.ExecuteTemplate(w, "settings.html", data)
+}
+
+func (app *Application) setConfirmDeletes(ctx context.Context, userID int, confirm bool) error {
+	_, err := app.DB.ExecContext(ctx, "UPDATE users SET confirm_deletes = $2 WHERE id = $1", userID, confirm)
+	return err
+}
+
+func (app *Application) savePreferences(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	form := preferencesForm{ConfirmDeletes: r.FormValue("confirm_deletes") != "", Message: "Saved."}
+	if err := app.setConfirmDeletes(r.Context(), user.ID, form.ConfirmDeletes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "preferences-form", form)
+}
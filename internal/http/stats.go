@@ -0,0 +1,226 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+type WeekTotal struct {
+	Week    time.Time
+	Seconds int64
+}
+
+func (w WeekTotal) Total() string {
+	return model.FormatDuration(w.Seconds)
+}
+
+type TodoTotal struct {
+	Title   string
+	Seconds int64
+}
+
+func (t TodoTotal) Total() string {
+	return model.FormatDuration(t.Seconds)
+}
+
+// DayCount is one square of the completion heatmap.
+type DayCount struct {
+	Day   time.Time
+	Count int
+	// Future pads the current week out to Sunday.
+	Future bool
+}
+
+// Level buckets the count into the heatmap's five shades.
+func (d DayCount) Level() int {
+	switch {
+	case d.Count == 0:
+		return 0
+	case d.Count == 1:
+		return 1
+	case d.Count <= 3:
+		return 2
+	case d.Count <= 6:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// completionHistory is a year of completions, laid out as Monday-first
+// weeks, with the streaks found in it.
+type completionHistory struct {
+	Weeks         [][]DayCount
+	Total         int
+	CurrentStreak int
+	LongestStreak int
+}
+
+type statsPage struct {
+	Page
+	// Todos totals the counters of the user's unarchived lists.
+	Todos     model.TodoCounts
+	Weeks     []WeekTotal
+	ThisWeek  []TodoTotal
+	Completed completionHistory
+}
+
+func (app *Application) statsHandler(w http.ResponseWriter, r *http.Request) {
+	data := statsPage{Page: app.page(r)}
+
+	var err error
+	user, _ := currentUser(r)
+	lists, err := app.userLists(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, l := range lists {
+		if l.Counts != nil && !l.Archived {
+			data.Todos.Open += l.Counts.Open
+			data.Todos.Completed += l.Counts.Completed
+		}
+	}
+	if data.Weeks, err = app.weeklyTotals(r.Context(), user.ID, 8); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.ThisWeek, err = app.thisWeekByTodo(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Completed, err = cache.Load(r.Context(), app.Cache, historyKey(user.ID, app.todayDate()), func() (completionHistory, error) {
+		return app.completionHistory(r.Context(), user.ID, 53)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.ExecuteTemplate(w, "stats.html", data)
+}
+
+// weeklyTotals sums tracked time per week for the last n weeks, including
+// weeks with nothing tracked. Entries count towards the week they started in.
+// Only todos on the user's lists are counted.
+func (app *Application) weeklyTotals(ctx context.Context, userID, n int) ([]WeekTotal, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT w.week, COALESCE(SUM(EXTRACT(EPOCH FROM COALESCE(e.stopped_at, NOW()) - e.started_at)), 0)::BIGINT
+		FROM generate_series(
+			date_trunc('week', NOW()) - ($2 - 1) * INTERVAL '1 week',
+			date_trunc('week', NOW()),
+			INTERVAL '1 week'
+		) AS w(week)
+		LEFT JOIN time_entries e
+			ON e.started_at >= w.week AND e.started_at < w.week + INTERVAL '1 week'
+			AND e.todo_id IN (SELECT id FROM todos WHERE list_id IN `+store.MemberLists(1)+`)
+		GROUP BY w.week
+		ORDER BY w.week DESC`,
+		userID, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var weeks []WeekTotal
+	for rows.Next() {
+		var wt WeekTotal
+		if err := rows.Scan(&wt.Week, &wt.Seconds); err != nil {
+			return nil, err
+		}
+		weeks = append(weeks, wt)
+	}
+	return weeks, rows.Err()
+}
+
+func (app *Application) thisWeekByTodo(ctx context.Context, userID int) ([]TodoTotal, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT t.title, SUM(EXTRACT(EPOCH FROM COALESCE(e.stopped_at, NOW()) - e.started_at))::BIGINT AS seconds
+		FROM time_entries e JOIN todos t ON t.id = e.todo_id
+		WHERE e.started_at >= date_trunc('week', NOW()) AND t.list_id IN `+store.MemberLists(1)+`
+		GROUP BY t.id, t.title
+		ORDER BY seconds DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []TodoTotal
+	for rows.Next() {
+		var tt TodoTotal
+		if err := rows.Scan(&tt.Title, &tt.Seconds); err != nil {
+			return nil, err
+		}
+		totals = append(totals, tt)
+	}
+	return totals, rows.Err()
+}
+
+// completionHistory counts completed todos per day over the last n weeks,
+// including empty days, in a single query over completed_at. Streaks are
+// consecutive days with at least one completion; today doesn't break the
+// current streak until it's over.
+func (app *Application) completionHistory(ctx context.Context, userID, n int) (completionHistory, error) {
+	var h completionHistory
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT d.day, COUNT(t.id), d.day > $3::date
+		FROM generate_series(
+			date_trunc('week', $3::date) - ($2 - 1) * INTERVAL '1 week',
+			date_trunc('week', $3::date) + INTERVAL '6 days',
+			INTERVAL '1 day'
+		) AS d(day)
+		LEFT JOIN todos t
+			ON t.completed AND t.completed_at::DATE = d.day::DATE
+			AND t.list_id IN `+store.MemberLists(1)+`
+		GROUP BY d.day
+		ORDER BY d.day`,
+		userID, n, app.todayDate(),
+	)
+	if err != nil {
+		return h, err
+	}
+	defer rows.Close()
+
+	var days []DayCount
+	for rows.Next() {
+		var d DayCount
+		if err := rows.Scan(&d.Day, &d.Count, &d.Future); err != nil {
+			return h, err
+		}
+		days = append(days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return h, err
+	}
+
+	run := 0
+	for i, d := range days {
+		if len(days[i:])%7 == 0 {
+			h.Weeks = append(h.Weeks, nil)
+		}
+		h.Weeks[len(h.Weeks)-1] = append(h.Weeks[len(h.Weeks)-1], d)
+		if d.Future {
+			continue
+		}
+		h.Total += d.Count
+		if d.Count > 0 {
+			run++
+			h.LongestStreak = max(h.LongestStreak, run)
+			h.CurrentStreak = run
+		} else if i+1 < len(days) && !days[i+1].Future {
+			// An empty day before today ends the streak; an empty today
+			// leaves yesterday's streak standing.
+			run = 0
+			h.CurrentStreak = 0
+		}
+	}
+	return h, nil
+}
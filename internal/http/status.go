@@ -0,0 +1,160 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/cache"
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+	"github.com/Trailblazors/htmx-go-postgres/internal/maintenance"
+)
+
+const (
+	// statusInterval is how often each instance writes a status sample.
+	statusInterval = time.Minute
+	// statusRetention is how long samples are kept, which is also the
+	// longest window uptime is shown for.
+	statusRetention = 30 * 24 * time.Hour
+	// statusRecent is the window the error rate, latency and backlog are
+	// shown for.
+	statusRecent = time.Hour
+)
+
+// Past these the status page reports the app degraded.
+const (
+	degradedErrorRate = 5.0
+	degradedP95       = 2500 * time.Millisecond
+	degradedBacklog   = 1000
+)
+
+// Overall states on the status page.
+const (
+	statusOperational = "operational"
+	statusDegraded    = "degraded"
+	statusMaintenance = "maintenance"
+)
+
+type uptimeWindow struct {
+	Label   string  `json:"label"`
+	Percent float64 `json:"percent"`
+	// Known is false before there are any samples in the window.
+	Known bool `json:"known"`
+}
+
+// statusReport is what /status shows, worked out from the samples every
+// instance writes. See the metrics package.
+type statusReport struct {
+	// URL is the page's, for the embedded summary to link to.
+	URL       string         `json:"url"`
+	State     string         `json:"state"`
+	Uptime    []uptimeWindow `json:"uptime"`
+	Requests  int            `json:"requests"`
+	ErrorRate float64        `json:"error_rate_percent"`
+	// P95 is the bound 95% of requests finished within, in milliseconds;
+	// with Slow set, they took longer than that.
+	P95        int64     `json:"p95_ms"`
+	Slow       bool      `json:"p95_over,omitempty"`
+	Backlog    int       `json:"backlog"`
+	MaxBacklog int       `json:"max_backlog"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+func (s statusReport) Operational() bool { return s.State == statusOperational }
+func (s statusReport) Degraded() bool    { return s.State == statusDegraded }
+
+type statusPage struct {
+	Page
+	Report statusReport
+}
+
+// statusReport is cached under the minute it's for, since samples come a
+// minute apart and the page is public.
+func (app *Application) statusReport(ctx context.Context) (statusReport, error) {
+	now := time.Now().Truncate(statusInterval)
+	return cache.Load(ctx, app.Cache, "status:"+now.UTC().Format(time.RFC3339), func() (statusReport, error) {
+		report := statusReport{URL: app.Config.BaseURL + "/status", State: statusOperational, CheckedAt: now}
+		for _, w := range []struct {
+			label  string
+			window time.Duration
+		}{{"24 hours", 24 * time.Hour}, {"7 days", 7 * 24 * time.Hour}, {"30 days", statusRetention}} {
+			percent, known, err := app.Metrics.Uptime(ctx, w.window)
+			if err != nil {
+				return report, err
+			}
+			report.Uptime = append(report.Uptime, uptimeWindow{Label: w.label, Percent: percent, Known: known})
+		}
+
+		t, err := app.Metrics.Traffic(ctx, statusRecent)
+		if err != nil {
+			return report, err
+		}
+		report.Requests, report.ErrorRate = int(t.Requests), t.ErrorRate()
+		report.P95, report.Slow = t.P95.Milliseconds(), t.Slow
+		report.Backlog, report.MaxBacklog = t.Backlog, t.MaxBacklog
+
+		switch {
+		case app.Maintenance.Mode(ctx) != maintenance.Off:
+			report.State = statusMaintenance
+		case report.ErrorRate > degradedErrorRate, t.P95 > degradedP95, t.Slow, t.Backlog > degradedBacklog:
+			report.State = statusDegraded
+		}
+		return report, nil
+	})
+}
+
+// statusHandler is the public status page. htmx gets the summary alone and
+// clients asking for JSON get the report.
+func (app *Application) statusHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := app.statusReport(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.render(w, r, view{
+		Fragment: "status-summary",
+		Data:     report,
+		Page:     "status.html",
+		PageData: func() (any, error) { return statusPage{Page: app.page(r), Report: report}, nil },
+		JSON:     report,
+	})
+}
+
+// statusEmbed is the summary alone for other sites to show, with
+// hx-get from their pages or in an iframe. It carries no cookies or user
+// data, so any origin may fetch it.
+var statusEmbed = cors(config.CORS{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{http.MethodGet},
+	AllowedHeaders: []string{"HX-Request", "HX-Current-URL", "HX-Target", "HX-Trigger", "HX-Trigger-Name"},
+})
+
+func (app *Application) statusEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := app.statusReport(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	app.Templates.ExecuteTemplate(w, "status-embed", report)
+}
+
+// sampleStatus writes this instance's status sample for the last minute,
+// with how many background jobs are waiting: emails and pushes due in the
+// outbox, exports to download and voice notes to transcribe.
+func (app *Application) sampleStatus(ctx context.Context) error {
+	var backlog int
+	if err := app.DB.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM outbox WHERE next_attempt_at <= NOW())
+			+ (SELECT COUNT(*) FROM export_downloads WHERE status = 'pending')
+			+ (SELECT COUNT(*) FROM attachments WHERE transcript_status IN ('pending', 'running'))`,
+	).Scan(&backlog); err != nil {
+		return err
+	}
+	return app.Metrics.Flush(ctx, backlog)
+}
+
+// purgeStatusSamples deletes samples older than the longest uptime window.
+func (app *Application) purgeStatusSamples(ctx context.Context) error {
+	return app.Metrics.Purge(ctx, time.Now().Add(-statusRetention))
+}
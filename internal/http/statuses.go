@@ -0,0 +1,301 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+type statusesForm struct {
+	List     model.List
+	Statuses []model.Status
+	Error    string
+}
+
+func (p membersPage) StatusesForm() statusesForm {
+	return statusesForm{List: p.List, Statuses: p.Statuses}
+}
+
+func (app *Application) renderStatuses(w http.ResponseWriter, r *http.Request, message string) {
+	data := statusesForm{Error: message}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Statuses, err = app.Queries.ListStatuses(r.Context(), data.List.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.render(w, r, view{Fragment: "statuses-form", Data: data, JSON: data.Statuses})
+}
+
+// createStatus adds a status to the end of the list's. Todos moved into a
+// done one are completed.
+func (app *Application) createStatus(w http.ResponseWriter, r *http.Request) {
+	s := model.Status{
+		ListID: listAccess(r).ListID,
+		Name:   strings.TrimSpace(r.FormValue("name")),
+		Done:   r.FormValue("done") != "",
+	}
+	if s.Name == "" {
+		app.renderStatuses(w, r, "Name required")
+		return
+	}
+	created, err := app.Queries.CreateStatus(r.Context(), s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		app.renderStatuses(w, r, "This list already has a status called "+s.Name+".")
+		return
+	}
+	app.renderStatuses(w, r, "")
+}
+
+// errLastStatus is why a list's only open or only done status can't be
+// deleted: completing and reopening todos needs somewhere to put them.
+var errLastStatus = errors.New("A list needs at least one status for open todos and one for done ones.")
+
+// deleteStatus removes a status, moving its todos, archived ones too, to
+// the first other status that's open or done like it.
+func (app *Application) deleteStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "statusID"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	listID := listAccess(r).ListID
+	found := false
+	err = app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		statuses, err := app.Queries.ListStatuses(ctx, listID)
+		if err != nil {
+			return err
+		}
+		var deleted, into *model.Status
+		for i, s := range statuses {
+			if s.ID == id {
+				deleted = &statuses[i]
+			}
+		}
+		if deleted == nil {
+			return nil
+		}
+		found = true
+		for i, s := range statuses {
+			if s.ID != id && s.Done == deleted.Done {
+				into = &statuses[i]
+				break
+			}
+		}
+		if into == nil {
+			return errLastStatus
+		}
+		db := app.db(ctx)
+		if _, err := db.ExecContext(ctx, "UPDATE todos SET status_id = $2 WHERE status_id = $1", id, into.ID); err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, "DELETE FROM list_statuses WHERE id = $1", id)
+		return err
+	})
+	if errors.Is(err, errLastStatus) {
+		app.renderStatuses(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		app.notFound(w, r)
+		return
+	}
+	app.renderStatuses(w, r, "")
+}
+
+// errNoSuchStatus is why a status a todo is moved to is turned down.
+var errNoSuchStatus = errors.New("That isn't one of the list's statuses.")
+
+// findStatus returns the status on listID with id, or if id is 0 the one
+// called name, in any case.
+func (app *Application) findStatus(ctx context.Context, listID, id int, name string) (model.Status, error) {
+	statuses, err := app.Queries.ListStatuses(ctx, listID)
+	if err != nil {
+		return model.Status{}, err
+	}
+	for _, s := range statuses {
+		if id != 0 && s.ID == id || id == 0 && strings.EqualFold(s.Name, strings.TrimSpace(name)) {
+			return s, nil
+		}
+	}
+	return model.Status{}, errNoSuchStatus
+}
+
+// transition moves a todo to status as user. Moving it to a done status
+// goes the way completing it does: when it needs approval it only asks an
+// owner, and an owner moving one that's been asked about approves it. It
+// reports whether approval was only asked for.
+func (app *Application) transition(r *http.Request, user *model.User, todo model.Todo, status model.Status) (asked bool, err error) {
+	ctx := r.Context()
+	switch {
+	case status.ID == todo.StatusID:
+		return false, nil
+	case status.Done && needsApproval(r, todo):
+		if todo.AwaitingApproval {
+			return true, nil
+		}
+		return true, app.requestApproval(ctx, user, todo)
+	case status.Done && todo.AwaitingApproval:
+		return false, app.decideApproval(ctx, user, todo, true, status.ID, "")
+	}
+	err = app.undoable(ctx, user.ID, "change the status of", []int{todo.ID}, func(ctx context.Context) ([]int, error) {
+		_, err := app.db(ctx).ExecContext(ctx, "UPDATE todos SET status_id = $2 WHERE id = $1", todo.ID, status.ID)
+		return nil, err
+	})
+	if err == nil && status.Done && !todo.Completed {
+		app.advanceOnboarding(ctx, user.ID, stepComplete)
+	}
+	return false, err
+}
+
+type statusPicker struct {
+	Todo     model.Todo
+	Statuses []model.Status
+	CanEdit  bool
+}
+
+// getStatusPicker shows a todo's status among its list's, in the panel
+// under it, with buttons to move it for editors.
+func (app *Application) getStatusPicker(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	statuses, err := app.Queries.ListStatuses(r.Context(), todo.ListID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "status-picker", statusPicker{
+		Todo:     todo,
+		Statuses: statuses,
+		CanEdit:  listAccess(r).Role >= model.Editor,
+	})
+}
+
+// setTodoStatus moves a todo to the status_id given. Like ticking it off,
+// moving a blocked todo to a done status needs override.
+func (app *Application) setTodoStatus(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.todoFromURL(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("status_id"))
+	status, err := app.findStatus(r.Context(), todo.ListID, id, "")
+	if errors.Is(err, errNoSuchStatus) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status.Done && app.showBlockers(w, r, todo) {
+		return
+	}
+
+	user, _ := currentUser(r)
+	if _, err := app.transition(r, user, todo, status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
+
+// apiListStatuses lists a list's statuses in order.
+func (app *Application) apiListStatuses(w http.ResponseWriter, r *http.Request) {
+	statuses, err := app.Queries.ListStatuses(r.Context(), listAccess(r).ListID)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if statuses == nil {
+		statuses = []model.Status{}
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// apiSetTodoStatus moves a todo to the status given by status_id, or by
+// name as status. It answers 202 Accepted with the todo when moving it to
+// a done status only asked an owner to approve it.
+func (app *Application) apiSetTodoStatus(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		StatusID int    `json:"status_id"`
+		Status   string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status, err := app.findStatus(r.Context(), todo.ListID, input.StatusID, input.Status)
+	if errors.Is(err, errNoSuchStatus) {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if status.Done && !todo.Completed && todo.Blocked && r.URL.Query().Get("override") != "true" {
+		blockers, err := app.openBlockers(r.Context(), todo.ID)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":    "Todo is blocked by open todos; pass ?override=true to complete it anyway",
+			"blockers": blockers,
+		})
+		return
+	}
+
+	user, _ := currentUser(r)
+	asked, err := app.transition(r, user, todo, status)
+	if err == nil {
+		todo, err = app.Queries.GetTodo(r.Context(), todo.ID)
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	code := http.StatusOK
+	if asked {
+		code = http.StatusAccepted
+	}
+	writeJSON(w, code, todo)
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"net/http"
+)
+
+// streamChunk is how many rows a streamed response writes between flushes.
+const streamChunk = 200
+
+// Row limits for the responses that stream a whole list. Streaming keeps
+// memory flat however long the list, but a browser still has to lay out
+// every row, so the print view stops well before an export does.
+const (
+	printRowLimit  = 5000
+	exportRowLimit = 100000
+)
+
+// rowStream sends a long response as it's written rather than all at the
+// end, flushing every streamChunk rows, so the client can show it arriving
+// and the server never holds more than a chunk of it.
+type rowStream struct {
+	rc   *http.ResponseController
+	rows int
+}
+
+func newRowStream(w http.ResponseWriter) *rowStream {
+	return &rowStream{rc: http.NewResponseController(w)}
+}
+
+// row counts a row written and flushes when it ends a chunk, reporting
+// whether it did. A writer that can't flush sends the response at the end
+// as usual.
+func (s *rowStream) row() bool {
+	s.rows++
+	if s.rows%streamChunk != 0 {
+		return false
+	}
+	s.rc.Flush()
+	return true
+}
@@ -0,0 +1,187 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/captcha"
+)
+
+const (
+	maxSupportSubject = 200
+	maxSupportMessage = 5000
+	// supportHistory is how many of their past tickets the form shows.
+	supportHistory = 20
+)
+
+type supportTicket struct {
+	ID        int
+	Subject   string
+	Message   string
+	Status    string
+	CreatedAt time.Time
+}
+
+// supportPage is the support form and the caller's past tickets. Subject
+// and Body refill the form when a post is turned away.
+type supportPage struct {
+	Page
+	Captcha *captcha.Provider
+	Tickets []supportTicket
+	Subject string
+	Body    string
+	From    string
+	Error   string
+	Message string
+}
+
+// supportTicketEmail is the data for the support-ticket email to admins.
+type supportTicketEmail struct {
+	ID      int
+	From    string
+	Subject string
+	Message string
+	Page    string
+	BaseURL string
+}
+
+func (app *Application) supportPage(r *http.Request, data supportPage) (supportPage, error) {
+	user, _ := currentUser(r)
+	data.Page = app.page(r)
+	need, err := app.needsCaptcha(r)
+	if err != nil {
+		// Show the challenge anyway; the post checks again.
+		requestLog(r.Context()).Printf("captcha threshold: %v", err)
+		need = app.Captcha != nil
+	}
+	if need {
+		data.Captcha = app.Captcha
+	}
+	if data.From == "" {
+		data.From = r.Referer()
+	}
+
+	rows, err := app.DB.QueryContext(r.Context(), `
+		SELECT id, subject, message, status, created_at FROM support_tickets
+		WHERE user_id = $1 ORDER BY id DESC LIMIT $2`,
+		user.ID, supportHistory,
+	)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t supportTicket
+		if err := rows.Scan(&t.ID, &t.Subject, &t.Message, &t.Status, &t.CreatedAt); err != nil {
+			return data, err
+		}
+		data.Tickets = append(data.Tickets, t)
+	}
+	return data, rows.Err()
+}
+
+func (app *Application) supportHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := app.supportPage(r, supportPage{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "support.html", data)
+}
+
+// createSupportTicket saves what the caller sent and emails it to every
+// admin, through the outbox in the same transaction. Posts are rate
+// limited by IP in the support group and run past the same bot checks as
+// signing up.
+func (app *Application) createSupportTicket(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	form := supportPage{
+		Subject: strings.TrimSpace(r.PostFormValue("subject")),
+		Body:    strings.TrimSpace(r.PostFormValue("message")),
+		From:    r.PostFormValue("page"),
+	}
+	// htmx swaps in the form and tickets; a post without it gets the page.
+	respond := func(form supportPage) {
+		app.render(w, r, view{Fragment: "support-form", Page: "support.html", Data: form})
+	}
+
+	message, err := app.checkHuman(r, user.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case message != "":
+		form.Error = message
+	case form.Subject == "" || form.Body == "":
+		form.Error = "Say what it's about and what happened."
+	case utf8.RuneCountInString(form.Subject) > maxSupportSubject:
+		form.Error = fmt.Sprintf("Keep the subject under %d characters.", maxSupportSubject)
+	case utf8.RuneCountInString(form.Body) > maxSupportMessage:
+		form.Error = fmt.Sprintf("Keep the message under %d characters.", maxSupportMessage)
+	}
+	if form.Error != "" {
+		// The challenge may be needed now, so the form comes back whole.
+		if form, err = app.supportPage(r, form); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respond(form)
+		return
+	}
+
+	err = app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		var id int
+		if err := app.db(ctx).QueryRowContext(ctx,
+			"INSERT INTO support_tickets (user_id, subject, message, page) VALUES ($1, $2, $3, $4) RETURNING id",
+			user.ID, form.Subject, form.Body, truncate(500, form.From),
+		).Scan(&id); err != nil {
+			return err
+		}
+		rows, err := app.db(ctx).QueryContext(ctx, "SELECT email FROM users WHERE is_admin ORDER BY id")
+		if err != nil {
+			return err
+		}
+		var admins []string
+		for rows.Next() {
+			var email string
+			if err := rows.Scan(&email); err != nil {
+				rows.Close()
+				return err
+			}
+			admins = append(admins, email)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, admin := range admins {
+			err := app.queueEmail(ctx, admin, fmt.Sprintf("Support #%d: %s", id, form.Subject), "support-ticket", supportTicketEmail{
+				ID:      id,
+				From:    user.Email,
+				Subject: form.Subject,
+				Message: form.Body,
+				Page:    form.From,
+				BaseURL: app.Config.BaseURL,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	form, err = app.supportPage(r, supportPage{Message: "Thanks, we've got it. We'll reply to " + user.Email + "."})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respond(form)
+}
@@ -0,0 +1,372 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/connector"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// syncEvery is how often each connection reads its tracker's changes, to
+// catch whatever webhooks missed. Local changes go out sooner; see
+// pushSyncChanges.
+const syncEvery = 5 * time.Minute
+
+type connectionsForm struct {
+	List        model.List
+	Connections []connector.Connection
+	Providers   []connector.Provider
+	// BaseURL is where the webhook URLs shown point.
+	BaseURL string
+	Error   string
+}
+
+func (app *Application) renderConnections(w http.ResponseWriter, r *http.Request, message string) {
+	data := connectionsForm{Providers: connector.Providers(), BaseURL: app.Config.BaseURL, Error: message}
+	var err error
+	if data.List, err = app.loadList(r.Context(), listAccess(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data.Connections, err = app.Connectors.ForList(r.Context(), data.List.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "connections-form", data)
+}
+
+// createConnection connects the list to a tracker, acting there with the
+// credential given, and runs a first sync to bring in its open items.
+func (app *Application) createConnection(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	c := connector.Connection{
+		ListID:     listAccess(r).ListID,
+		UserID:     user.ID,
+		Provider:   r.FormValue("provider"),
+		Target:     strings.TrimSpace(r.FormValue("target")),
+		Credential: strings.TrimSpace(r.FormValue("credential")),
+	}
+	if c.Target == "" || c.Credential == "" {
+		app.renderConnections(w, r, "Enter where to sync with and a token for it.")
+		return
+	}
+
+	c, err := app.Connectors.Create(r.Context(), c)
+	if errors.Is(err, connector.ErrExists) {
+		app.renderConnections(w, r, "This list is already synced with "+c.Target+".")
+		return
+	}
+	if err != nil {
+		app.renderConnections(w, r, err.Error())
+		return
+	}
+	message := ""
+	if err := app.syncConnection(r.Context(), c); err != nil {
+		message = "Connected, but the first sync failed: " + err.Error()
+	}
+	app.renderConnections(w, r, message)
+}
+
+// listConnection returns the connection named in the URL, if it's the
+// list's.
+func (app *Application) listConnection(w http.ResponseWriter, r *http.Request) (connector.Connection, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		app.notFound(w, r)
+		return connector.Connection{}, false
+	}
+	c, err := app.Connectors.Get(r.Context(), id)
+	if errors.Is(err, connector.ErrNotFound) || err == nil && c.ListID != listAccess(r).ListID {
+		app.notFound(w, r)
+		return c, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return c, false
+	}
+	return c, true
+}
+
+func (app *Application) syncConnectionNow(w http.ResponseWriter, r *http.Request) {
+	c, ok := app.listConnection(w, r)
+	if !ok {
+		return
+	}
+	message := ""
+	if err := app.syncConnection(r.Context(), c); err != nil {
+		message = "Sync failed: " + err.Error()
+	}
+	app.renderConnections(w, r, message)
+}
+
+// deleteConnection stops syncing. The todos it brought in stay.
+func (app *Application) deleteConnection(w http.ResponseWriter, r *http.Request) {
+	c, ok := app.listConnection(w, r)
+	if !ok {
+		return
+	}
+	if err := app.Connectors.Delete(r.Context(), c.ListID, c.ID); err != nil && !errors.Is(err, connector.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderConnections(w, r, "")
+}
+
+// syncWebhook takes a tracker's webhook deliveries for a connection and
+// applies the changes they carry straight away.
+func (app *Application) syncWebhook(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	c, err := app.Connectors.Get(r.Context(), id)
+	if errors.Is(err, connector.ErrNotFound) || err == nil && c.Adapter() == nil {
+		jsonError(w, http.StatusNotFound, "No such connection")
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items, err := c.Adapter().Webhook(r, c)
+	if errors.Is(err, connector.ErrSignature) {
+		jsonError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := app.checkConnection(r.Context(), c); err != nil {
+		jsonError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := app.applyItems(r.Context(), c, items); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncConnections is the background sync: each connection that's due
+// sends its local changes and reads its tracker's.
+func (app *Application) syncConnections(ctx context.Context) error {
+	due, err := app.Connectors.Due(ctx, syncEvery)
+	if err != nil {
+		return err
+	}
+	for _, c := range due {
+		if err := app.syncConnection(ctx, c); err != nil {
+			requestLog(ctx).Printf("sync connection %d (%s %s): %v", c.ID, c.Provider, c.Target, err)
+		}
+	}
+	return nil
+}
+
+// pushSyncChanges sends todos completed or reopened here to their
+// trackers, without waiting for their connection's next full sync.
+func (app *Application) pushSyncChanges(ctx context.Context) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT DISTINCT l.connection_id FROM sync_links l JOIN todos t ON t.id = l.todo_id
+		WHERE t.completed <> l.synced_done`)
+	if err != nil {
+		return err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		c, err := app.Connectors.Get(ctx, id)
+		if errors.Is(err, connector.ErrNotFound) {
+			continue
+		}
+		if err == nil {
+			err = app.checkConnection(ctx, c)
+		}
+		if err == nil {
+			err = app.pushChanges(ctx, c)
+		}
+		if err != nil {
+			requestLog(ctx).Printf("push to connection %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func scanIDs(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// syncConnection sends the list's changes to the tracker, then reads the
+// tracker's changes since the connection's cursor into the list, and
+// records how it went.
+func (app *Application) syncConnection(ctx context.Context, c connector.Connection) error {
+	cursor := c.Cursor
+	err := app.checkConnection(ctx, c)
+	if err == nil {
+		err = app.pushChanges(ctx, c)
+	}
+	if err == nil {
+		var items []connector.Item
+		items, cursor, err = c.Adapter().Changes(ctx, c, c.Cursor)
+		// Items read before a failure are still applied, and the cursor
+		// only moves past them once they have been.
+		if applyErr := app.applyItems(ctx, c, items); applyErr != nil {
+			cursor, err = c.Cursor, applyErr
+		}
+	}
+	if saveErr := app.Connectors.Synced(ctx, c.ID, cursor, err); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// checkConnection reports why c can't sync, if it can't. Its credential
+// acts for whoever connected the list, so it stops once they can no longer
+// change the list themselves.
+func (app *Application) checkConnection(ctx context.Context, c connector.Connection) error {
+	if c.Adapter() == nil {
+		return fmt.Errorf("unknown tracker %q", c.Provider)
+	}
+	role, err := app.Queries.MemberRole(ctx, c.ListID, c.UserID)
+	if err == nil && role < model.Editor {
+		err = errors.New("the person who connected this list can no longer edit it")
+	}
+	return err
+}
+
+// pushChanges closes or reopens the tracker's items whose todos were
+// completed or reopened since they were last in step.
+func (app *Application) pushChanges(ctx context.Context, c connector.Connection) error {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT l.remote_id, t.completed FROM sync_links l JOIN todos t ON t.id = l.todo_id
+		WHERE l.connection_id = $1 AND t.completed <> l.synced_done`,
+		c.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type change struct {
+		remoteID string
+		done     bool
+	}
+	var changes []change
+	for rows.Next() {
+		var ch change
+		if err := rows.Scan(&ch.remoteID, &ch.done); err != nil {
+			return err
+		}
+		changes = append(changes, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ch := range changes {
+		if err := c.Adapter().SetDone(ctx, c, ch.remoteID, ch.done); err != nil {
+			return err
+		}
+		_, err := app.DB.ExecContext(ctx,
+			"UPDATE sync_links SET synced_done = $3 WHERE connection_id = $1 AND remote_id = $2",
+			c.ID, ch.remoteID, ch.done,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyItems brings the tracker's changed items into the list. A new open
+// item becomes a todo; closed ones that were never linked are left out.
+// A linked item that was closed, reopened or renamed since it was last in
+// step does the same to its todo. Titles only go this way: renaming a
+// todo doesn't rename its item.
+func (app *Application) applyItems(ctx context.Context, c connector.Connection, items []connector.Item) error {
+	for _, item := range items {
+		if err := app.withTx(ctx, nil, func(ctx context.Context) error { return app.applyItem(ctx, c, item) }); err != nil {
+			return fmt.Errorf("%s %s: %w", c.Target, item.RemoteID, err)
+		}
+	}
+	return nil
+}
+
+func (app *Application) applyItem(ctx context.Context, c connector.Connection, item connector.Item) error {
+	db := app.db(ctx)
+	var (
+		todoID      int
+		syncedTitle string
+		syncedDone  bool
+	)
+	err := db.QueryRowContext(ctx,
+		"SELECT todo_id, synced_title, synced_done FROM sync_links WHERE connection_id = $1 AND remote_id = $2 FOR UPDATE",
+		c.ID, item.RemoteID,
+	).Scan(&todoID, &syncedTitle, &syncedDone)
+	if errors.Is(err, sql.ErrNoRows) {
+		if item.Done {
+			return nil
+		}
+		id, err := app.insertTodo(ctx, "", c.ListID, item.Title, 0, nil)
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO sync_links (connection_id, remote_id, todo_id, url, synced_title, synced_done)
+			VALUES ($1, $2, $3, $4, $5, FALSE)`,
+			c.ID, item.RemoteID, id, item.URL, item.Title,
+		)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if item.Done != syncedDone {
+		_, err := db.ExecContext(ctx, `
+			UPDATE todos SET completed = $2, completed_at = CASE WHEN $2 THEN COALESCE(completed_at, NOW()) END
+			WHERE id = $1`,
+			todoID, item.Done,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if item.Title != syncedTitle && item.Title != "" {
+		if _, err := db.ExecContext(ctx, "UPDATE todos SET title = $2 WHERE id = $1", todoID, item.Title); err != nil {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx,
+		"UPDATE sync_links SET url = $3, synced_title = $4, synced_done = $5 WHERE connection_id = $1 AND remote_id = $2",
+		c.ID, item.RemoteID, item.URL, item.Title, item.Done,
+	)
+	return err
+}
+
+// syncedURL is where the item a todo is synced with lives, if it is.
+func (app *Application) syncedURL(ctx context.Context, todoID int) (string, error) {
+	var url string
+	err := app.DB.QueryRowContext(ctx, "SELECT url FROM sync_links WHERE todo_id = $1", todoID).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return url, err
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/assets"
+)
+
+// Templates renders the pages and fragments in templates/.
+//
+// templates/layout.html and templates/components/*.html form a shared base.
+// Every other file gets its own copy of that base, so pages can each fill in
+// the layout's "title", "head" and "content" blocks without clobbering one
+// another. A page that defines "content" is rendered inside the layout; one
+// that doesn't (todo-list.html, effort.html, ...) is a fragment on its own.
+type Templates struct {
+	base *template.Template
+	// sets maps each page, and each fragment defined inside a page, to the
+	// template set it was parsed into.
+	sets map[string]*template.Template
+}
+
+// layoutBlocks are the names pages define for the layout; they're not
+// addressable on their own.
+var layoutBlocks = map[string]bool{"layout": true, "title": true, "head": true, "content": true}
+
+// parseTemplates parses dir. The asset func resolves a bundle name to its
+// hashed URL in bundles.
+func parseTemplates(dir string, bundles *assets.Pipeline) *Templates {
+	funcs := template.FuncMap{"asset": bundles.URL}
+	base := template.Must(template.New("layout.html").Funcs(templateFuncs).Funcs(funcs).ParseFiles(filepath.Join(dir, "layout.html")))
+	template.Must(base.ParseGlob(filepath.Join(dir, "components", "*.html")))
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		panic(err)
+	}
+
+	t := &Templates{base: base, sets: map[string]*template.Template{}}
+	for _, page := range pages {
+		if filepath.Base(page) == "layout.html" {
+			continue
+		}
+		set := template.Must(template.Must(base.Clone()).ParseFiles(page))
+		for _, tmpl := range set.Templates() {
+			name := tmpl.Name()
+			if layoutBlocks[name] || base.Lookup(name) != nil {
+				continue
+			}
+			if _, dup := t.sets[name]; dup {
+				panic(fmt.Sprintf("template %q is defined in more than one file", name))
+			}
+			t.sets[name] = set
+		}
+	}
+	return t
+}
+
+// CheckTemplates parses the templates, emails and service worker the way
+// New does, and says how many there are. Mistakes in them come back as an
+// error rather than the panic New lets them be.
+func CheckTemplates() (summary string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	staticAssets, err := assets.Build(bundles)
+	if err != nil {
+		return "", fmt.Errorf("build assets: %w", err)
+	}
+	tmpl := parseTemplates("templates", staticAssets)
+	emails := parseEmailTemplates("templates/email")
+	if _, err := buildServiceWorker("templates/sw.js", appShell(staticAssets)); err != nil {
+		return "", fmt.Errorf("build service worker: %w", err)
+	}
+	return fmt.Sprintf("%d pages and fragments, %d emails", len(tmpl.sets), len(emails.Text.Templates())), nil
+}
+
+// ExecuteTemplate renders a page, a fragment defined in a page, or a
+// component by name.
+func (t *Templates) ExecuteTemplate(w io.Writer, name string, data any) error {
+	set, ok := t.sets[name]
+	if !ok {
+		if t.base.Lookup(name) == nil {
+			return fmt.Errorf("template: no template %q", name)
+		}
+		return t.base.ExecuteTemplate(w, name, data)
+	}
+	if filepath.Ext(name) == ".html" && set.Lookup("content") != nil {
+		return set.ExecuteTemplate(w, "layout", data)
+	}
+	return set.ExecuteTemplate(w, name, data)
+}
+
+// RenderComponent renders one of the shared components in
+// templates/components. It renders into a buffer first, so a template error
+// becomes a clean 500 rather than half a fragment swapped into the page.
+func (t *Templates) RenderComponent(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := t.base.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// toast is the data for the "toast" component.
+type toast struct {
+	Message string
+	Error   bool
+}
+
+// modal is the data for the "modal" component. Body is rendered before the
+// modal so any fragment can go in it.
+type modal struct {
+	Title string
+	Body  template.HTML
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// renderTimer responds with the timer fragment for a todo.
+func (app *Application) renderTimer(w http.ResponseWriter, r *http.Request, id int) {
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.Templates.RenderComponent(w, "timer", todo)
+}
+
+func (app *Application) getTimer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	app.renderTimer(w, r, id)
+}
+
+func (app *Application) startTimer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	// The partial unique index allows one running entry per todo, so
+	// starting an already running timer is a no-op.
+	_, err = app.DB.ExecContext(r.Context(), `
+		INSERT INTO time_entries (todo_id) VALUES ($1)
+		ON CONFLICT (todo_id) WHERE stopped_at IS NULL DO NOTHING`,
+		id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.renderTimer(w, r, id)
+}
+
+func (app *Application) stopTimer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	_, err = app.DB.ExecContext(r.Context(),
+		"UPDATE time_entries SET stopped_at = NOW() WHERE todo_id = $1 AND stopped_at IS NULL",
+		id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.renderTimer(w, r, id)
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/config"
+)
+
+// TLSServer returns a server for handler on :443 with autocert-managed
+// certificates, to be started with ServeTLS(ln, "", ""), and one for :80
+// that answers ACME HTTP-01 challenges and redirects everything else to
+// HTTPS, to be started with ListenAndServe. Both are shut down together.
+func TLSServer(cfg config.TLS, handler http.Handler) (srv, redirect *http.Server) {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		Email:      cfg.Email,
+	}
+
+	log.Printf("Serving TLS for %s", strings.Join(cfg.Domains, ", "))
+	srv = &http.Server{
+		Addr:    ":443",
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			GetCertificate: m.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1", "acme-tls/1"},
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+	redirect = &http.Server{
+		Addr:    ":80",
+		Handler: m.HTTPHandler(redirectHTTPS(cfg.Domains)),
+		// Nothing on :80 takes long, so its timeouts can be tight.
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return srv, redirect
+}
+
+// redirectHTTPS sends requests to the same path over HTTPS. The host comes
+// from the request only when it's one of domains, so a forged Host can't
+// turn it into an open redirect; anything else goes to the first domain.
+func redirectHTTPS(domains []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		i := slices.IndexFunc(domains, func(d string) bool { return strings.EqualFold(d, host) })
+		if i < 0 {
+			i = 0
+		}
+		http.Redirect(w, r, "https://"+domains[i]+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+func (app *Application) getTodos(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		modified, err := app.listModified(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, modified) {
+			return
+		}
+	}
+
+	listID := listAccess(r).ListID
+	// The version is read first, so a change made while the todos load
+	// can't be cached under the version from after it.
+	key, err := app.todoListCacheKey(r, listID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	todos, filtered, err := app.visibleTodos(r, listID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if todos == nil {
+		todos = []model.Todo{}
+	}
+	list := todoList{Todos: todos, View: listView(r)}
+	if !filtered {
+		app.Outage.saveTodos(r, listID, list)
+	}
+
+	app.render(w, r, view{
+		Fragment: "todo-list.html",
+		Data:     list,
+		Page:     "index.html",
+		PageData: func() (any, error) { return app.listPage(r) },
+		JSON:     todos,
+		CacheKey: key,
+	})
+}
+
+// todoListCacheKey is the key getTodos' fragment is cached under, or ""
+// while one of the list's timers runs and the list changes by the second.
+func (app *Application) todoListCacheKey(r *http.Request, listID int) (string, error) {
+	user, _ := currentUser(r)
+	if user == nil {
+		return "", nil
+	}
+	version, err := app.Queries.ListModified(r.Context(), listID)
+	if err != nil || version.IsZero() {
+		return "", err
+	}
+	return todoListKey(user.ID, listID, r.URL.Query(), version, user.ListView.UpdatedAt, app.todayDate()), nil
+}
+
+// visibleTodos loads a list's todos as its page shows them, narrowed by the
+// field filters in the request. filtered reports whether there were any.
+func (app *Application) visibleTodos(r *http.Request, listID int) ([]model.Todo, bool, error) {
+	equal, contains, filtered, err := app.fieldFilters(r.Context(), r, listID)
+	if err != nil {
+		return nil, false, err
+	}
+	var todos []model.Todo
+	if filtered {
+		todos, err = app.Queries.FilterTodos(r.Context(), listID, equal, contains)
+	} else {
+		todos, err = app.Queries.ListTodos(r.Context(), listID)
+	}
+	return todos, filtered, err
+}
+
+func (app *Application) createTodo(w http.ResponseWriter, r *http.Request) {
+	title := r.FormValue("title")
+	if title == "" {
+		http.Error(w, "Title required", http.StatusBadRequest)
+		return
+	}
+
+	estimate, due, err := parseEffort(r.FormValue("estimate"), r.FormValue("due_date"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listID := listAccess(r).ListID
+	fields, err := app.listFields(r.Context(), listID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	values, err := parseFields(r, fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "add", nil, func(ctx context.Context) ([]int, error) {
+		id, err := app.insertTodo(ctx, r.Header.Get("Idempotency-Key"), listID, title, estimate, due)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) > 0 {
+			err = app.Queries.SetTodoFields(ctx, id, values)
+		}
+		return []int{id}, err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.advanceOnboarding(r.Context(), user.ID, stepAdd)
+	if due != nil {
+		app.advanceOnboarding(r.Context(), user.ID, stepDue)
+	}
+
+	// Return the todo list
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
+
+func (app *Application) deleteTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	user, _ := currentUser(r)
+	err = app.undoable(r.Context(), user.ID, "delete", []int{id}, func(ctx context.Context) ([]int, error) {
+		_, err := app.Queries.DeleteTodo(ctx, id)
+		return nil, err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// "Don't ask again" on the confirm dialog.
+	if r.FormValue("dont_ask") != "" {
+		if err := app.setConfirmDeletes(r.Context(), user.ID, false); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Return updated list
+	closeModal(w)
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
+
+// showBlockers answers for completing a todo whose blockers are still
+// open, which needs an explicit override, by showing what's in the way
+// next to the todo. It reports whether it did.
+func (app *Application) showBlockers(w http.ResponseWriter, r *http.Request, todo model.Todo) bool {
+	if r.FormValue("override") != "" || todo.Completed || !todo.Blocked {
+		return false
+	}
+	blockers, err := app.openBlockers(r.Context(), todo.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	htmx.Retarget(w, fmt.Sprintf("#todo-%d-panel", todo.ID))
+	htmx.Reswap(w, htmx.InnerHTML)
+	app.Templates.ExecuteTemplate(w, "blocked-notice", blockedNotice{Todo: todo, Blockers: blockers})
+	return true
+}
+
+func (app *Application) toggleTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if app.showBlockers(w, r, todo) {
+		return
+	}
+
+	// Completing a todo that needs approval asks an owner instead, and an
+	// owner completing one someone has asked about approves it.
+	user, _ := currentUser(r)
+	if needsApproval(r, todo) || todo.AwaitingApproval {
+		if todo.AwaitingApproval && !needsApproval(r, todo) {
+			err = app.decideApproval(r.Context(), user, todo, true, 0, "")
+		} else {
+			err = app.requestApproval(r.Context(), user, todo)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		htmx.Trigger(w, "todosChanged")
+		app.getTodos(w, r)
+		return
+	}
+
+	verb := "complete"
+	if todo.Completed {
+		verb = "reopen"
+	}
+	err = app.undoable(r.Context(), user.ID, verb, []int{id}, func(ctx context.Context) ([]int, error) {
+		_, err := app.Queries.ToggleTodo(ctx, id)
+		return nil, err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verb == "complete" {
+		app.advanceOnboarding(r.Context(), user.ID, stepComplete)
+	}
+
+	// Return updated list
+	htmx.Trigger(w, "todosChanged")
+	app.getTodos(w, r)
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/apitoken"
+	"github.com/Trailblazors/htmx-go-postgres/internal/session"
+)
+
+// tokensForm is the API tokens section of the settings page. Created is
+// the token just made, shown this once.
+type tokensForm struct {
+	Tokens  []apitoken.Token
+	Created string
+	Error   string
+}
+
+// loadToken signs in API requests that carry "Authorization: Bearer
+// <token>" as the token's owner, in place of any session cookie. Tools
+// whose API-key auth sends a bare key, like Zapier's, can use an X-API-Key
+// header instead. A bad token is refused rather than falling back to the
+// cookie, so a revoked integration fails loudly.
+func (app *Application) loadToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			raw = r.Header.Get("X-API-Key")
+			ok = raw != ""
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := app.Tokens.Load(r.Context(), strings.TrimSpace(raw))
+		if errors.Is(err, apitoken.ErrNotFound) {
+			jsonError(w, http.StatusUnauthorized, "Invalid API token")
+			return
+		}
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		user, err := app.loadUser(r.Context(), token.UserID)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s := scopeOf(r.Context())
+		s.User, s.Session, s.Impersonator, s.Token = &user, session.Session{}, nil, &token
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiToken returns the token an API request was made with, or nil for one
+// made with the session cookie.
+func apiToken(r *http.Request) *apitoken.Token {
+	return scopeOf(r.Context()).Token
+}
+
+func (app *Application) renderTokens(w http.ResponseWriter, r *http.Request, form tokensForm) {
+	user, _ := currentUser(r)
+	var err error
+	if form.Tokens, err = app.Tokens.List(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.Templates.ExecuteTemplate(w, "api-tokens", form)
+}
+
+// createToken makes an API token. Its namespace defaults to its name, so
+// "GitHub sync" writes metadata as github-sync.
+func (app *Application) createToken(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		app.renderTokens(w, r, tokensForm{Error: "Name required"})
+		return
+	}
+	namespace := strings.TrimSpace(r.FormValue("namespace"))
+	if namespace == "" {
+		namespace = apitoken.Slug(name)
+	}
+	if !apitoken.NamespacePattern.MatchString(namespace) {
+		app.renderTokens(w, r, tokensForm{Error: "Use up to 32 lowercase letters, digits, dashes and underscores for the namespace."})
+		return
+	}
+
+	token, err := app.Tokens.Create(r.Context(), user.ID, name, namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderTokens(w, r, tokensForm{Created: token})
+}
+
+func (app *Application) revokeToken(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	err = app.Tokens.Revoke(r.Context(), user.ID, id)
+	if errors.Is(err, apitoken.ErrNotFound) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderTokens(w, r, tokensForm{})
+}
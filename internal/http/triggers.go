@@ -0,0 +1,193 @@
+package http
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// Polling triggers return at most maxTriggerItems, newest first, which is
+// what Zapier and IFTTT expect: they remember the IDs they've seen and
+// fire for the rest.
+const (
+	defaultTriggerItems = 50
+	maxTriggerItems     = 100
+)
+
+// triggerTodo is a todo as triggers and actions return it, with a link
+// back to it for the zap to use.
+type triggerTodo struct {
+	model.Todo
+	URL string `json:"url"`
+}
+
+// completedEvent is a todo's completion. Completing a todo, reopening it
+// and completing it again are two events, so ID includes when it happened;
+// the todo's own ID is TodoID.
+type completedEvent struct {
+	ID string `json:"id"`
+	triggerTodo
+	TodoID      int       `json:"todo_id"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+func (app *Application) triggerTodo(t model.Todo) triggerTodo {
+	return triggerTodo{Todo: t, URL: fmt.Sprintf("%s/todos/%d", app.Config.BaseURL, t.ID)}
+}
+
+// triggerParams reads the optional list_id and limit a trigger is polled
+// with.
+func triggerParams(r *http.Request) (listID, limit int, err error) {
+	q := r.URL.Query()
+	if v := q.Get("list_id"); v != "" {
+		if listID, err = strconv.Atoi(v); err != nil || listID < 1 {
+			return 0, 0, errors.New("list_id must be a list's ID")
+		}
+	}
+	limit = defaultTriggerItems
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxTriggerItems {
+			return 0, 0, fmt.Errorf("limit must be from 1 to %d", maxTriggerItems)
+		}
+	}
+	return listID, limit, nil
+}
+
+// apiMe returns who the caller is. Zapier calls it to test a connection
+// and labels the account with the email.
+func (app *Application) apiMe(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	writeJSON(w, http.StatusOK, map[string]any{"id": user.ID, "email": user.Email})
+}
+
+// apiListLists returns the caller's lists, for a zap's list picker.
+func (app *Application) apiListLists(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	lists, err := app.Queries.ListUserLists(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if lists == nil {
+		lists = []model.List{}
+	}
+	writeJSON(w, http.StatusOK, lists)
+}
+
+// newTodosTrigger polls for todos made on the caller's lists.
+func (app *Application) newTodosTrigger(w http.ResponseWriter, r *http.Request) {
+	listID, limit, err := triggerParams(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, _ := currentUser(r)
+	todos, err := app.Queries.NewTodos(r.Context(), user.ID, listID, limit)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := make([]triggerTodo, len(todos))
+	for i, t := range todos {
+		out[i] = app.triggerTodo(t)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// completedTodosTrigger polls for todos completed on the caller's lists.
+func (app *Application) completedTodosTrigger(w http.ResponseWriter, r *http.Request) {
+	listID, limit, err := triggerParams(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, _ := currentUser(r)
+	todos, err := app.Queries.CompletedTodos(r.Context(), user.ID, listID, limit)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := make([]completedEvent, len(todos))
+	for i, t := range todos {
+		out[i] = completedEvent{
+			ID:          fmt.Sprintf("%d-%d", t.ID, t.CompletedAt.Unix()),
+			triggerTodo: app.triggerTodo(t.Todo),
+			TodoID:      t.ID,
+			CompletedAt: t.CompletedAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiCompleteTodo is the "complete a todo" action. Unlike toggling it's
+// safe to repeat, as automations retry. Like toggling, a todo with open
+// blockers needs ?override=true, and one that needs approval is only asked
+// about.
+func (app *Application) apiCompleteTodo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !todo.Completed && todo.Blocked && r.URL.Query().Get("override") != "true" {
+		blockers, err := app.openBlockers(r.Context(), id)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":    "Todo is blocked by open todos; pass ?override=true to complete it anyway",
+			"blockers": blockers,
+		})
+		return
+	}
+
+	// A todo that needs approval is only asked about, once, and answered
+	// with 202 Accepted; an owner completing it approves a waiting request.
+	user, _ := currentUser(r)
+	if needsApproval(r, todo) || todo.AwaitingApproval {
+		status := http.StatusOK
+		if needsApproval(r, todo) {
+			status = http.StatusAccepted
+			if !todo.AwaitingApproval {
+				err = app.requestApproval(r.Context(), user, todo)
+			}
+		} else {
+			err = app.decideApproval(r.Context(), user, todo, true, 0, "")
+		}
+		if err == nil {
+			todo, err = app.Queries.GetTodo(r.Context(), id)
+		}
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, status, app.triggerTodo(todo))
+		return
+	}
+
+	if todo, err = app.Queries.CompleteTodo(r.Context(), id); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, app.triggerTodo(todo))
+}
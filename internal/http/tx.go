@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/store"
+)
+
+// maxTxAttempts bounds how many times withTx runs a transaction that keeps
+// losing to concurrent ones.
+const maxTxAttempts = 3
+
+// withTx runs fn in a transaction, committing if it returns nil and rolling
+// back if it returns an error or panics. The transaction travels in the ctx
+// passed to fn: app.Queries and app.db(ctx) use it, and a withTx nested
+// inside joins it rather than starting another. If Postgres aborts the
+// transaction with a serialization failure or a deadlock, fn runs again from
+// the start in a fresh one, so it must only have effects through the
+// database and must reset any results it captures.
+func (app *Application) withTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if store.TxFrom(ctx) != nil {
+		return fn(ctx)
+	}
+	for attempt := 1; ; attempt++ {
+		err := app.runTx(ctx, opts, fn)
+		if err == nil || attempt == maxTxAttempts || !retryable(err) {
+			return err
+		}
+		// Back off with jitter so the same transactions don't collide again.
+		wait := time.Duration(attempt*attempt)*10*time.Millisecond + rand.N(10*time.Millisecond)
+		requestLog(ctx).Printf("retrying transaction after %v", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (app *Application) runTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	tx, err := app.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(store.ContextWithTx(ctx, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// retryable reports whether err means the transaction lost a race and can
+// simply be run again.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", // serialization_failure
+		"40P01": // deadlock_detected
+		return true
+	}
+	return false
+}
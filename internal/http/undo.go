@@ -0,0 +1,357 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// maxUndo is how many changes each user can step back through.
+const maxUndo = 50
+
+var (
+	errNothingToUndo = errors.New("Nothing to undo.")
+	errNothingToRedo = errors.New("Nothing to redo.")
+)
+
+// todoState is a todo as undo puts it back: its row, and the time entries
+// and dependencies that deleting it takes with it. Attachments aren't kept,
+// so a deleted todo comes back without them.
+type todoState struct {
+	Row          json.RawMessage `json:"row"`
+	TimeEntries  json.RawMessage `json:"time_entries"`
+	Dependencies json.RawMessage `json:"dependencies"`
+}
+
+func (s *todoState) row() (listID int, title string) {
+	var row struct {
+		ListID int    `json:"list_id"`
+		Title  string `json:"title"`
+	}
+	json.Unmarshal(s.Row, &row)
+	return row.ListID, row.Title
+}
+
+// todoChange is how one todo looked before and after a change. A nil state
+// means it didn't exist.
+type todoChange struct {
+	TodoID int        `json:"todo_id"`
+	Before *todoState `json:"before"`
+	After  *todoState `json:"after"`
+}
+
+// undoable runs fn, a change to the todos with ids, in a transaction, and
+// records it in userID's undo log as verb ("delete", "move", ...). fn
+// returns the IDs of any todos it creates. A new change clears what the
+// user could have redone, as in an editor.
+func (app *Application) undoable(ctx context.Context, userID int, verb string, ids []int, fn func(ctx context.Context) ([]int, error)) error {
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		before, err := app.todoStates(ctx, ids)
+		if err != nil {
+			return err
+		}
+		created, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		all := append(slices.Clone(ids), created...)
+		after, err := app.todoStates(ctx, all)
+		if err != nil {
+			return err
+		}
+
+		changes := make([]todoChange, 0, len(all))
+		for _, id := range all {
+			changes = append(changes, todoChange{TodoID: id, Before: before[id], After: after[id]})
+		}
+		return app.recordUndo(ctx, userID, verb+" "+changeSubject(changes), changes)
+	})
+}
+
+// changeSubject names what a change was to: a todo's title, or how many
+// there were.
+func changeSubject(changes []todoChange) string {
+	if len(changes) != 1 {
+		return pluralize(len(changes), "todo", "todos")
+	}
+	state := changes[0].After
+	if state == nil {
+		state = changes[0].Before
+	}
+	if state == nil {
+		return "a todo"
+	}
+	_, title := state.row()
+	return "“" + title + "”"
+}
+
+// todoStates snapshots the todos with ids that exist, locking them until
+// the transaction ends.
+func (app *Application) todoStates(ctx context.Context, ids []int) (map[int]*todoState, error) {
+	states := map[int]*todoState{}
+	if len(ids) == 0 {
+		return states, nil
+	}
+	rows, err := app.db(ctx).QueryContext(ctx, `
+		SELECT t.id, to_jsonb(t) - 'updated_at',
+			COALESCE((SELECT jsonb_agg(to_jsonb(e)) FROM time_entries e WHERE e.todo_id = t.id), '[]'),
+			COALESCE((SELECT jsonb_agg(to_jsonb(d)) FROM todo_dependencies d WHERE t.id IN (d.todo_id, d.blocker_id)), '[]')
+		FROM todos t WHERE t.id = ANY($1)
+		FOR UPDATE OF t`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var s todoState
+		if err := rows.Scan(&id, &s.Row, &s.TimeEntries, &s.Dependencies); err != nil {
+			return nil, err
+		}
+		states[id] = &s
+	}
+	return states, rows.Err()
+}
+
+func (app *Application) recordUndo(ctx context.Context, userID int, label string, changes []todoChange) error {
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	db := app.db(ctx)
+	if _, err := db.ExecContext(ctx, "DELETE FROM undo_log WHERE user_id = $1 AND undone", userID); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO undo_log (user_id, label, changes) VALUES ($1, $2, $3::jsonb)", userID, label, string(b))
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM undo_log WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM undo_log WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+		)`,
+		userID, maxUndo,
+	)
+	return err
+}
+
+func (app *Application) undo(w http.ResponseWriter, r *http.Request) {
+	app.replay(w, r, true)
+}
+
+func (app *Application) redo(w http.ResponseWriter, r *http.Request) {
+	app.replay(w, r, false)
+}
+
+// replay undoes the user's latest change that isn't undone, or redoes the
+// earliest one that is. Either refuses, changing nothing, if a todo it
+// would touch has changed since or the user can no longer edit its list.
+func (app *Application) replay(w http.ResponseWriter, r *http.Request, undo bool) {
+	user, _ := currentUser(r)
+	var label string
+	var lists []int
+	err := app.withTx(r.Context(), nil, func(ctx context.Context) error {
+		label, lists = "", nil
+		order := "DESC"
+		if !undo {
+			order = "ASC"
+		}
+		var id int64
+		var raw []byte
+		err := app.db(ctx).QueryRowContext(ctx, `
+			SELECT id, label, changes FROM undo_log
+			WHERE user_id = $1 AND undone = $2
+			ORDER BY id `+order+` LIMIT 1
+			FOR UPDATE`,
+			user.ID, !undo,
+		).Scan(&id, &label, &raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			if undo {
+				return errNothingToUndo
+			}
+			return errNothingToRedo
+		}
+		if err != nil {
+			return err
+		}
+		var changes []todoChange
+		if err := json.Unmarshal(raw, &changes); err != nil {
+			return err
+		}
+
+		if lists, err = app.checkReplay(ctx, user, changes); err != nil {
+			return err
+		}
+		// Undo steps back through the changes in reverse, so a todo that
+		// was changed twice ends up as it first was.
+		if undo {
+			slices.Reverse(changes)
+		}
+		var restored []*todoState
+		for _, c := range changes {
+			from, to := c.Before, c.After
+			if undo {
+				from, to = to, from
+			}
+			if err := app.applyState(ctx, c.TodoID, from, to); err != nil {
+				return err
+			}
+			if from == nil && to != nil {
+				restored = append(restored, to)
+			}
+		}
+		// Dependencies go back once every todo they join is there.
+		for _, s := range restored {
+			if err := app.restoreDependencies(ctx, s); err != nil {
+				return err
+			}
+		}
+		_, err = app.db(ctx).ExecContext(ctx, "UPDATE undo_log SET undone = $2 WHERE id = $1", id, undo)
+		return err
+	})
+
+	var conflict undoConflict
+	switch {
+	case errors.Is(err, errNothingToUndo), errors.Is(err, errNothingToRedo):
+		app.Templates.RenderComponent(w, "toast", toast{Message: err.Error()})
+		return
+	case errors.As(err, &conflict):
+		app.Templates.RenderComponent(w, "toast", toast{Message: conflict.Error(), Error: true})
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, listID := range lists {
+		app.forgetList(r.Context(), listID)
+	}
+	htmx.Trigger(w, "todosChanged", "todosReplayed")
+	verb := "Undid"
+	if !undo {
+		verb = "Redid"
+	}
+	app.Templates.RenderComponent(w, "toast", toast{Message: verb + " " + label + "."})
+}
+
+// undoConflict is why a change can't be undone or redone, said to the user.
+type undoConflict string
+
+func (e undoConflict) Error() string { return string(e) }
+
+// checkReplay makes sure user can still edit every list the changes touch,
+// and returns them.
+func (app *Application) checkReplay(ctx context.Context, user *model.User, changes []todoChange) ([]int, error) {
+	var lists []int
+	for _, c := range changes {
+		for _, s := range []*todoState{c.Before, c.After} {
+			if s == nil {
+				continue
+			}
+			if listID, _ := s.row(); !slices.Contains(lists, listID) {
+				lists = append(lists, listID)
+			}
+		}
+	}
+	for _, listID := range lists {
+		role, err := app.roleFor(ctx, user, listID)
+		if err != nil {
+			return nil, err
+		}
+		if role < model.Editor {
+			return nil, undoConflict("You can no longer edit that list.")
+		}
+	}
+	return lists, nil
+}
+
+// applyState turns todo id from state from into state to. It refuses if
+// the todo isn't as from has it, apart from its position, which moves as
+// other todos come and go.
+func (app *Application) applyState(ctx context.Context, id int, from, to *todoState) error {
+	db := app.db(ctx)
+	var expected any
+	if from != nil {
+		expected = string(from.Row)
+	}
+	var matches bool
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE((to_jsonb(t) - 'updated_at' - 'position') = ($2::jsonb - 'position'), FALSE)
+		FROM todos t WHERE t.id = $1
+		FOR UPDATE`,
+		id, expected,
+	).Scan(&matches)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if exists != (from != nil) || (exists && !matches) {
+		return undoConflict("Someone has changed that todo since, so it was left as it is.")
+	}
+
+	switch {
+	case to == nil:
+		_, err = db.ExecContext(ctx, "DELETE FROM todos WHERE id = $1", id)
+	case from == nil:
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO todos
+			SELECT * FROM jsonb_populate_record(NULL::todos, $1::jsonb || jsonb_build_object(
+				'updated_at', NOW(),
+				-- A status deleted since is left for sync_todo_status to fill in.
+				'status_id', (SELECT id FROM list_statuses WHERE id = ($1::jsonb ->> 'status_id')::integer)
+			))`,
+			string(to.Row),
+		)
+		if err == nil {
+			_, err = db.ExecContext(ctx, `
+				INSERT INTO time_entries
+				SELECT * FROM jsonb_populate_recordset(NULL::time_entries, $1::jsonb)
+				ON CONFLICT DO NOTHING`,
+				string(to.TimeEntries),
+			)
+		}
+	default:
+		// Every column a change can touch. A todo keeps its place
+		// unless it's going back to another list.
+		_, err = db.ExecContext(ctx, `
+			UPDATE todos t SET
+				list_id = s.list_id, title = s.title, description = s.description,
+				completed = s.completed, completed_at = s.completed_at,
+				estimate_minutes = s.estimate_minutes, due_date = s.due_date,
+				place = s.place, latitude = s.latitude, longitude = s.longitude,
+				archived_at = s.archived_at, custom_fields = s.custom_fields, metadata = s.metadata,
+				assignee_id = s.assignee_id, needs_approval = s.needs_approval,
+				approval_requested_by = s.approval_requested_by, approval_requested_at = s.approval_requested_at,
+				status_id = (SELECT id FROM list_statuses WHERE id = s.status_id),
+				position = CASE WHEN t.list_id <> s.list_id THEN s.position ELSE t.position END
+			FROM jsonb_populate_record(NULL::todos, $2::jsonb) s
+			WHERE t.id = $1`,
+			id, string(to.Row),
+		)
+	}
+	return err
+}
+
+// restoreDependencies puts back a restored todo's dependencies on todos
+// that still exist.
+func (app *Application) restoreDependencies(ctx context.Context, s *todoState) error {
+	_, err := app.db(ctx).ExecContext(ctx, `
+		INSERT INTO todo_dependencies
+		SELECT d.* FROM jsonb_populate_recordset(NULL::todo_dependencies, $1::jsonb) d
+		WHERE EXISTS (SELECT 1 FROM todos WHERE id = d.todo_id)
+		  AND EXISTS (SELECT 1 FROM todos WHERE id = d.blocker_id)
+		ON CONFLICT DO NOTHING`,
+		string(s.Dependencies),
+	)
+	return err
+}
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/audit"
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+)
+
+const (
+	// verifyTTL is how long a verification link works.
+	verifyTTL = 48 * time.Hour
+	// A new link can be sent once per resendInterval, and resendDailyMax
+	// times a day, so the resend form can't be used to flood an inbox.
+	resendInterval = 2 * time.Minute
+	resendDailyMax = 5
+)
+
+// errResendThrottled is returned by sendVerification when the user has been
+// sent a link too recently or too often.
+var errResendThrottled = errors.New("verification email sent too recently")
+
+// resendSent is shown after a resend whether or not anything was sent, so
+// the form can't be used to find out which addresses have accounts.
+const resendSent = "If that address has an unconfirmed account, a new link is on its way. 📬"
+
+// hashToken is what's stored of a token that's emailed or kept in a
+// cookie, so reading the database doesn't give away working links.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendVerification emails userID a link that confirms their address. Only
+// the token's hash is stored, like session tokens.
+func (app *Application) sendVerification(ctx context.Context, userID int, email string) error {
+	var recent, today int
+	err := app.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE created_at > NOW() - make_interval(secs => $2)), COUNT(*)
+		FROM email_verifications
+		WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 day'`,
+		userID, resendInterval.Seconds(),
+	).Scan(&recent, &today)
+	if err != nil {
+		return err
+	}
+	if recent > 0 || today >= resendDailyMax {
+		return errResendThrottled
+	}
+
+	token := newToken()
+	_, err = app.DB.ExecContext(ctx,
+		"INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		hashToken(token), userID, time.Now().Add(verifyTTL),
+	)
+	if err != nil {
+		return err
+	}
+	return app.sendEmail(ctx, email, "Confirm your email address", "verify-email", map[string]string{
+		"BaseURL": app.Config.BaseURL,
+		"Token":   token,
+	})
+}
+
+func (app *Application) verifyPage(w http.ResponseWriter, r *http.Request, email, notice, message string) {
+	p := app.authPage(r, email, message)
+	p.Notice = notice
+	app.Templates.ExecuteTemplate(w, "verify.html", p)
+}
+
+// verifyEmail follows the link from a verification email. Old links for
+// the same account stop working once one has been used.
+func (app *Application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var userID int
+	var email string
+	err := app.DB.QueryRowContext(r.Context(), `
+		WITH used AS (
+			DELETE FROM email_verifications
+			WHERE token_hash = $1 AND expires_at > NOW()
+			RETURNING user_id
+		)
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = (SELECT user_id FROM used)
+		RETURNING id, email`,
+		hashToken(r.URL.Query().Get("token")),
+	).Scan(&userID, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.verifyPage(w, r, "", "", "That link is invalid or has expired. Enter your email to get a new one.")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := app.DB.ExecContext(r.Context(), "DELETE FROM email_verifications WHERE user_id = $1", userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, audit.Event{UserID: userID, Email: email, Action: audit.EmailVerified})
+
+	if user, _ := currentUser(r); user != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	p := app.authPage(r, email, "")
+	p.Notice = "Your email is confirmed. Log in to get started. ✅"
+	app.Templates.ExecuteTemplate(w, "login.html", p)
+}
+
+// resendVerification sends a new link, to the signed-in user or to the
+// address in the form.
+func (app *Application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	if user, _ := currentUser(r); user != nil {
+		message := "Your email is already confirmed."
+		if !user.EmailVerified {
+			message = "Check your inbox for a new link. 📬"
+			err := app.sendVerification(r.Context(), user.ID, user.Email)
+			if errors.Is(err, errResendThrottled) {
+				message = "We sent you a link a moment ago. Check your inbox, or try again in a few minutes."
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if htmx.IsRequest(r) {
+			app.Templates.ExecuteTemplate(w, "verify-message", message)
+			return
+		}
+		app.verifyPage(w, r, user.Email, message, "")
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if msg, err := app.checkHuman(r, email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if msg != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		app.verifyPage(w, r, email, "", msg)
+		return
+	}
+
+	var id int
+	err := app.DB.QueryRowContext(r.Context(),
+		"SELECT id FROM users WHERE lower(email) = lower($1) AND email_verified_at IS NULL", email,
+	).Scan(&id)
+	if err == nil {
+		err = app.sendVerification(r.Context(), id, email)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, errResendThrottled) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.verifyPage(w, r, email, resendSent, "")
+}
+
+// requireVerified keeps accounts that haven't confirmed their email from
+// doing things that reach other people, like sharing a list. It only
+// matters when EMAIL_VERIFICATION is optional; otherwise they can't log
+// in at all.
+func (app *Application) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := currentUser(r); user != nil && user.EmailVerified {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			jsonError(w, http.StatusForbidden, "Confirm your email address to do that")
+		case htmx.IsRequest(r):
+			htmx.Retarget(w, "#alerts")
+			htmx.Reswap(w, htmx.InnerHTML)
+			w.WriteHeader(http.StatusForbidden)
+			app.Templates.ExecuteTemplate(w, "unverified", nil)
+		default:
+			http.Error(w, "Confirm your email address to do that", http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+const (
+	// transcribeTimeout is how long a voice note's claim lasts before
+	// another worker takes it over from one that must have died.
+	transcribeTimeout = 10 * time.Minute
+	// maxTranscribeAttempts is how many times a voice note is sent to the
+	// transcriber before it's marked failed.
+	maxTranscribeAttempts = 3
+	// transcribeBatch is how many voice notes one run gets through.
+	transcribeBatch = 5
+)
+
+// uploadedType is the content type to store an upload under. It's sniffed
+// from the file, except that a recording in a container that holds video
+// too, or in a format the sniffer doesn't know, keeps the audio type the
+// browser gave it, so it plays as a voice note.
+func uploadedType(sniffed, claimed string) string {
+	switch sniffed {
+	case "video/webm", "video/mp4", "application/ogg", "application/octet-stream":
+		if mediaType, _, err := mime.ParseMediaType(claimed); err == nil && strings.HasPrefix(mediaType, "audio/") {
+			return mediaType
+		}
+	}
+	return sniffed
+}
+
+// transcribeVoiceNotes sends voice notes waiting for a transcript to the
+// transcriber, one at a time, and adds what it hears to the end of each
+// todo's description. A failure is tried again on the next run, up to
+// maxTranscribeAttempts times.
+func (app *Application) transcribeVoiceNotes(ctx context.Context) error {
+	if app.Transcriber == nil {
+		return nil
+	}
+	for range transcribeBatch {
+		a, attempts, data, err := app.Queries.ClaimTranscription(ctx, transcribeTimeout)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := app.transcribeVoiceNote(ctx, a, attempts, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *Application) transcribeVoiceNote(ctx context.Context, a model.Attachment, attempts int, data []byte) error {
+	// Claims only run over when the worker dies, and a voice note that
+	// keeps killing it shouldn't be tried forever.
+	if attempts > maxTranscribeAttempts {
+		return app.Queries.FinishTranscription(ctx, a.ID, model.TranscriptFailed, "gave up after the worker stopped partway")
+	}
+
+	text, err := app.Transcriber.Transcribe(ctx, a.Filename, a.ContentType, data)
+	if err != nil {
+		requestLog(ctx).Printf("transcribe attachment %d (attempt %d): %v", a.ID, attempts, err)
+		status := model.TranscriptPending
+		if attempts >= maxTranscribeAttempts {
+			status = model.TranscriptFailed
+		}
+		return app.Queries.FinishTranscription(ctx, a.ID, status, err.Error())
+	}
+
+	return app.withTx(ctx, nil, func(ctx context.Context) error {
+		if text != "" {
+			if err := app.Queries.AppendDescription(ctx, a.TodoID, fmt.Sprintf("🎙️ %s", text)); err != nil {
+				return err
+			}
+		}
+		return app.Queries.FinishTranscription(ctx, a.ID, model.TranscriptDone, "")
+	})
+}
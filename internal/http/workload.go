@@ -0,0 +1,230 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/htmx"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// workloadColumn is one person's share of a list's open todos, or with
+// AssigneeID 0 the todos nobody has taken.
+type workloadColumn struct {
+	AssigneeID int          `json:"assignee_id"`
+	Email      string       `json:"email"`
+	Todos      []model.Todo `json:"todos"`
+}
+
+// Minutes is the total estimate of the column's todos.
+func (c workloadColumn) Minutes() int {
+	total := 0
+	for _, t := range c.Todos {
+		total += t.EstimateMinutes
+	}
+	return total
+}
+
+func (c workloadColumn) Estimate() string {
+	return model.FormatDuration(int64(c.Minutes()) * 60)
+}
+
+// workload is how a shared list's open todos are spread across its members.
+type workload struct {
+	List    model.List       `json:"list"`
+	Columns []workloadColumn `json:"columns"`
+	CanEdit bool             `json:"-"`
+}
+
+type workloadPage struct {
+	Page
+	// Lists are the caller's shared lists to pick from.
+	Lists    []model.List
+	Workload workload
+}
+
+// sharedLists returns the unarchived lists userID belongs to that someone
+// else does too, by name.
+func (app *Application) sharedLists(ctx context.Context, userID int) ([]model.List, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT l.id, l.name, m.role FROM lists l
+		JOIN list_members m ON m.list_id = l.id AND m.user_id = $1
+		WHERE l.archived_at IS NULL
+		  AND EXISTS (SELECT 1 FROM list_members o WHERE o.list_id = l.id AND o.user_id <> $1)
+		ORDER BY lower(l.name), l.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lists []model.List
+	for rows.Next() {
+		var l model.List
+		var role string
+		if err := rows.Scan(&l.ID, &l.Name, &role); err != nil {
+			return nil, err
+		}
+		if l.Role, err = model.ParseRole(role); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+// loadWorkload groups a list's open todos by assignee: the unassigned
+// first, then a column for every member, busy or not.
+func (app *Application) loadWorkload(ctx context.Context, list model.List) (workload, error) {
+	w := workload{List: list, CanEdit: list.CanEdit()}
+	members, err := app.Queries.ListMembers(ctx, list.ID)
+	if err != nil {
+		return w, err
+	}
+	todos, err := app.Queries.ListTodos(ctx, list.ID)
+	if err != nil {
+		return w, err
+	}
+
+	w.Columns = []workloadColumn{{Todos: []model.Todo{}}}
+	column := map[int]int{0: 0}
+	for _, m := range members {
+		column[m.UserID] = len(w.Columns)
+		w.Columns = append(w.Columns, workloadColumn{AssigneeID: m.UserID, Email: m.Email, Todos: []model.Todo{}})
+	}
+	for _, t := range todos {
+		if t.Completed {
+			continue
+		}
+		id := 0
+		if t.AssigneeID != nil {
+			id = *t.AssigneeID
+		}
+		i, ok := column[id]
+		if !ok {
+			// Assigned before they left the list, by an admin, say.
+			i = len(w.Columns)
+			column[id] = i
+			w.Columns = append(w.Columns, workloadColumn{AssigneeID: id, Email: t.Assignee, Todos: []model.Todo{}})
+		}
+		w.Columns[i].Todos = append(w.Columns[i].Todos, t)
+	}
+	return w, nil
+}
+
+// workloadHandler shows the workload of one of the caller's shared lists,
+// ?list= or else the first. Editors can drag todos between people to
+// reassign them, which PATCHes reassignWorkload.
+func (app *Application) workloadHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	lists, err := app.sharedLists(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := workloadPage{Page: app.page(r), Lists: lists}
+
+	var list model.List
+	if v := r.URL.Query().Get("list"); v != "" {
+		listID, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "list must be a list ID", http.StatusBadRequest)
+			return
+		}
+		if list, err = app.workloadList(r.Context(), user, listID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				app.forbidden(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if len(lists) > 0 {
+		list = lists[0]
+	}
+
+	if list.ID != 0 {
+		if data.Workload, err = app.loadWorkload(r.Context(), list); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	app.render(w, r, view{
+		Fragment: "workload-board",
+		Data:     data.Workload,
+		Page:     "workload.html",
+		PageData: func() (any, error) { return data, nil },
+		JSON:     data.Workload,
+	})
+}
+
+// workloadList loads a list with the caller's role on it, or returns
+// sql.ErrNoRows when they can't see it.
+func (app *Application) workloadList(ctx context.Context, user *model.User, listID int) (model.List, error) {
+	role, err := app.roleFor(ctx, user, listID)
+	if err != nil {
+		return model.List{}, err
+	}
+	if role < model.Viewer {
+		return model.List{}, sql.ErrNoRows
+	}
+	list, err := app.Queries.GetList(ctx, listID)
+	list.Role = role
+	return list, err
+}
+
+// reassignWorkload moves a todo to another person's column, or to nobody's
+// with an empty assignee_id, and answers with the redrawn board. It's
+// where the board's drag and drop lands.
+func (app *Application) reassignWorkload(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("todo_id"))
+	if err != nil {
+		http.Error(w, "Invalid todo", http.StatusBadRequest)
+		return
+	}
+	todo, err := app.Queries.GetTodo(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, _ := currentUser(r)
+	list, err := app.workloadList(r.Context(), user, todo.ListID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !list.CanEdit() {
+		app.forbidden(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	assignee, err := app.assigneeFrom(r, todo.ListID)
+	if errors.Is(err, errNotAssignable) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := app.reassign(r.Context(), user, todo, assignee); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.forgetList(r.Context(), todo.ListID)
+
+	board, err := app.loadWorkload(r.Context(), list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	htmx.Trigger(w, "todosChanged")
+	app.render(w, r, view{Fragment: "workload-board", Data: board, JSON: board})
+}
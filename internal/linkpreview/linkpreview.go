@@ -0,0 +1,354 @@
+// Package linkpreview fetches the OpenGraph title, description and image of
+// links in todo descriptions, and caches them in Postgres.
+//
+// The URLs come from users, so fetching them is a way into the network the
+// server sits on. The client only speaks HTTP(S) on the standard ports, and
+// its dialer refuses loopback, private, link-local and other non-public
+// addresses after DNS resolution, so neither a redirect nor a hostname
+// that resolves inward gets past it.
+package linkpreview
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxBody is how much of a page is read looking for its metadata.
+	maxBody = 512 << 10
+	// maxRedirects a fetch follows.
+	maxRedirects = 3
+)
+
+// Preview is what a link's page says about itself. Failed fetches are
+// cached too, with Failed set, so a dead link isn't fetched on every view.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+	Failed      bool
+	FetchedAt   time.Time
+}
+
+// Host is the link's host, shown when the page has no site name.
+func (p Preview) Host() string {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+type Fetcher struct {
+	db     *sql.DB
+	client *http.Client
+	// ttl is how long a preview is kept before the link is fetched again,
+	// and failedTTL the same for a link that couldn't be previewed.
+	ttl       time.Duration
+	failedTTL time.Duration
+}
+
+func New(db *sql.DB) *Fetcher {
+	dialer := &net.Dialer{Timeout: 3 * time.Second, Control: refusePrivate}
+	return &Fetcher{
+		db: db,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				// No Proxy: a proxy would do the dialing, past refusePrivate.
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   3 * time.Second,
+				ResponseHeaderTimeout: 3 * time.Second,
+				MaxIdleConns:          10,
+				IdleConnTimeout:       30 * time.Second,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				return checkURL(req.URL)
+			},
+		},
+		ttl:       7 * 24 * time.Hour,
+		failedTTL: time.Hour,
+	}
+}
+
+// Migrate creates the cache table.
+func (f *Fetcher) Migrate(ctx context.Context) error {
+	_, err := f.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS link_previews (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			image TEXT NOT NULL DEFAULT '',
+			site_name TEXT NOT NULL DEFAULT '',
+			failed BOOLEAN NOT NULL DEFAULT FALSE,
+			fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS link_previews_fetched_at ON link_previews (fetched_at);
+	`)
+	return err
+}
+
+// Get returns the preview of rawURL, from the cache while it's fresh and
+// fetched otherwise. Failing to fetch a link isn't an error; the preview
+// comes back with Failed set. Errors are for the cache itself.
+func (f *Fetcher) Get(ctx context.Context, rawURL string) (Preview, error) {
+	var p Preview
+	err := f.db.QueryRowContext(ctx, `
+		SELECT url, title, description, image, site_name, failed, fetched_at
+		FROM link_previews WHERE url = $1`,
+		rawURL,
+	).Scan(&p.URL, &p.Title, &p.Description, &p.Image, &p.SiteName, &p.Failed, &p.FetchedAt)
+	switch {
+	case err == nil:
+		ttl := f.ttl
+		if p.Failed {
+			ttl = f.failedTTL
+		}
+		if time.Since(p.FetchedAt) < ttl {
+			return p, nil
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return p, err
+	}
+
+	p, err = f.fetch(ctx, rawURL)
+	if ctx.Err() != nil {
+		// The viewer went away; that says nothing about the link.
+		return p, ctx.Err()
+	}
+	if err != nil {
+		p = Preview{URL: rawURL, Failed: true}
+	}
+	p.FetchedAt = time.Now()
+	_, err = f.db.ExecContext(ctx, `
+		INSERT INTO link_previews (url, title, description, image, site_name, failed, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (url) DO UPDATE SET title = $2, description = $3, image = $4,
+			site_name = $5, failed = $6, fetched_at = $7`,
+		p.URL, p.Title, p.Description, p.Image, p.SiteName, p.Failed, p.FetchedAt,
+	)
+	return p, err
+}
+
+// Purge drops previews nobody has needed refreshed in a while, so links
+// removed from every description don't stay cached forever.
+func (f *Fetcher) Purge(ctx context.Context) error {
+	_, err := f.db.ExecContext(ctx, "DELETE FROM link_previews WHERE fetched_at < $1", time.Now().Add(-2*f.ttl))
+	return err
+}
+
+func (f *Fetcher) fetch(ctx context.Context, rawURL string) (Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Preview{}, err
+	}
+	if err := checkURL(u); err != nil {
+		return Preview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "htmx-go-postgres link preview")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("%s answered %s", u.Host, resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return Preview{}, fmt.Errorf("%s isn't a web page", rawURL)
+	}
+
+	p := parse(io.LimitReader(resp.Body, maxBody), resp.Request.URL)
+	p.URL = rawURL
+	return p, nil
+}
+
+// parse reads the OpenGraph tags, falling back to <title> and the meta
+// description, until the head ends.
+func parse(r io.Reader, base *url.URL) Preview {
+	var p Preview
+	var title string
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return finish(p, title, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return finish(p, title, base)
+			case "title":
+				if z.Next() == html.TextToken && title == "" {
+					title = strings.TrimSpace(string(z.Text()))
+				}
+			case "meta":
+				if !hasAttr {
+					continue
+				}
+				var key, content string
+				for {
+					k, v, more := z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = strings.TrimSpace(string(v))
+					}
+					if !more {
+						break
+					}
+				}
+				switch key {
+				case "og:title":
+					p.Title = content
+				case "og:description":
+					p.Description = content
+				case "description":
+					if p.Description == "" {
+						p.Description = content
+					}
+				case "og:image":
+					p.Image = content
+				case "og:site_name":
+					p.SiteName = content
+				}
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return finish(p, title, base)
+			}
+		}
+	}
+}
+
+func finish(p Preview, title string, base *url.URL) Preview {
+	if p.Title == "" {
+		p.Title = title
+	}
+	p.Title = truncate(p.Title, 200)
+	p.Description = truncate(p.Description, 300)
+	// Only keep images that are web URLs, made absolute against the page.
+	if p.Image != "" {
+		img, err := base.Parse(p.Image)
+		if err != nil || (img.Scheme != "http" && img.Scheme != "https") {
+			p.Image = ""
+		} else {
+			p.Image = img.String()
+		}
+	}
+	return p
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// checkURL allows http and https URLs on their standard ports.
+func checkURL(u *url.URL) error {
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return fmt.Errorf("can't preview %s links", u.Scheme)
+	case u.User != nil:
+		return errors.New("can't preview links with credentials")
+	case u.Port() != "" && u.Port() != "80" && u.Port() != "443":
+		return fmt.Errorf("can't preview links to port %s", u.Port())
+	}
+	return nil
+}
+
+// refusePrivate is the dialer's Control hook. It runs on the resolved
+// address, just before connecting.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !public(addrPort.Addr()) {
+		return fmt.Errorf("can't preview links to %s", addrPort.Addr())
+	}
+	return nil
+}
+
+// nonPublic are ranges outside the checks netip.Addr has methods for.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which can reach IPv4 ranges above
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+	netip.MustParsePrefix("2002::/16"),      // 6to4, which embeds IPv4 addresses
+	netip.MustParsePrefix("2001::/32"),      // Teredo, likewise
+	netip.MustParsePrefix("100::/64"),       // discard-only
+}
+
+func public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// linkPattern finds bare http(s) URLs in text. Trailing punctuation that
+// usually ends a sentence rather than a URL is trimmed by URLs.
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// URLs returns the first max distinct links in text that could be
+// previewed, in the order they appear.
+func URLs(text string, max int) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, match := range linkPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?")
+		u, err := url.Parse(match)
+		if err != nil || u.Host == "" || checkURL(u) != nil || seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == max {
+			break
+		}
+	}
+	return urls
+}
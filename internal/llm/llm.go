@@ -0,0 +1,164 @@
+// Package llm asks a large language model for text, for the features that
+// offer suggestions, like breaking a todo down into steps.
+//
+// Each model service is a Provider. Two are built in: "openai", which
+// speaks OpenAI's chat completions API, as do Ollama, vLLM and most other
+// self-hosted servers, and "anthropic" for Claude.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Provider is a language model service.
+type Provider interface {
+	// Complete returns the model's reply to prompt, following the
+	// instructions in system.
+	Complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+// New returns the named provider. baseURL replaces the service's public
+// endpoint when set, and model the model it's asked to use.
+func New(name, baseURL, apiKey, model string) (Provider, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch name {
+	case "openai":
+		if baseURL == "" {
+			baseURL = "https://api.openai.com"
+		}
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		return &OpenAI{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, model: model, client: client}, nil
+	case "anthropic":
+		if apiKey == "" {
+			return nil, errors.New("LLM_PROVIDER anthropic needs LLM_API_KEY")
+		}
+		if baseURL == "" {
+			baseURL = "https://api.anthropic.com"
+		}
+		if model == "" {
+			return nil, errors.New("LLM_PROVIDER anthropic needs LLM_MODEL")
+		}
+		return &Anthropic{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, model: model, client: client}, nil
+	default:
+		return nil, fmt.Errorf("LLM_PROVIDER: want openai or anthropic, got %q", name)
+	}
+}
+
+// maxTokens caps the length of a reply. Suggestions are a few lines.
+const maxTokens = 1024
+
+// OpenAI completes through the chat completions endpoint.
+type OpenAI struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func (o *OpenAI) Complete(ctx context.Context, system, prompt string) (string, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	body := map[string]any{
+		"model":      o.model,
+		"max_tokens": maxTokens,
+		"messages":   []message{{"system", system}, {"user", prompt}},
+	}
+	header := http.Header{}
+	if o.apiKey != "" {
+		header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	var result struct {
+		Choices []struct {
+			Message message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := post(ctx, o.client, o.baseURL+"/v1/chat/completions", header, body, &result); err != nil {
+		return "", fmt.Errorf("openai: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", errors.New("openai: no reply")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// Anthropic completes through the Messages API.
+type Anthropic struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func (a *Anthropic) Complete(ctx context.Context, system, prompt string) (string, error) {
+	body := map[string]any{
+		"model":      a.model,
+		"max_tokens": maxTokens,
+		"system":     system,
+		"messages":   []map[string]string{{"role": "user", "content": prompt}},
+	}
+	header := http.Header{}
+	header.Set("X-Api-Key", a.apiKey)
+	header.Set("Anthropic-Version", "2023-06-01")
+
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := post(ctx, a.client, a.baseURL+"/v1/messages", header, body, &result); err != nil {
+		return "", fmt.Errorf("anthropic: %w", err)
+	}
+	var text strings.Builder
+	for _, c := range result.Content {
+		if c.Type == "text" {
+			text.WriteString(c.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+// post sends body as JSON and decodes the reply into result. Both APIs
+// explain a failure in {"error": {"message": ...}}.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, body, result any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&failure) == nil && failure.Error.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, failure.Error.Message)
+		}
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,183 @@
+// Package loadgen fills a database with synthetic users, lists and todos
+// for load testing. What it generates depends only on the options, not on
+// chance, so two runs with the same options give datasets that perform
+// the same.
+//
+// Synthetic users have emails at loadgenDomain and no password; they're
+// signed in with sessions and API tokens made for the run, which Targets
+// lists along with the endpoints worth hammering, for a k6 script to read.
+package loadgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// loadgenDomain is where synthetic users' emails are, which is how their
+// data is found again to be replaced.
+const loadgenDomain = "loadgen.invalid"
+
+// batchSize is how many todos go in per statement, so progress can be
+// logged and no one transaction gets too big.
+const batchSize = 100_000
+
+// ErrExists is returned when the database already has synthetic data and
+// Replace wasn't set.
+var ErrExists = errors.New("the database already has synthetic data")
+
+type Options struct {
+	Users        int
+	ListsPerUser int
+	// Todos is the total, spread evenly over every list.
+	Todos int
+	// Replace deletes synthetic data from an earlier run first.
+	Replace bool
+}
+
+// User is a synthetic user and the lists they own. Session and Token are
+// filled in by whoever signs them in.
+type User struct {
+	ID      int    `json:"id"`
+	Email   string `json:"email"`
+	ListIDs []int  `json:"list_ids"`
+	Session string `json:"session"`
+	Token   string `json:"token"`
+}
+
+// words make up todo titles and list names. Titles combine three, so
+// full-text searches for one word match about one todo in eight.
+var words = []string{
+	"buy", "call", "fix", "write", "review", "plan", "clean", "book",
+	"milk", "report", "garden", "invoice", "dentist", "slides", "tires", "taxes",
+	"groceries", "meeting", "birthday", "laundry", "budget", "flights", "backup", "roof",
+}
+
+// Generate makes the users, their lists and the todos on them. It needs
+// the schema to be migrated.
+func Generate(ctx context.Context, db *sql.DB, opts Options) ([]User, error) {
+	if opts.Users < 1 || opts.ListsPerUser < 1 || opts.Todos < 0 {
+		return nil, errors.New("need at least one user with one list")
+	}
+
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE email LIKE '%@' || $1::text)", loadgenDomain).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists && !opts.Replace {
+		return nil, ErrExists
+	}
+	if exists {
+		if err := Delete(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+
+	users, err := createUsers(ctx, db, opts)
+	if err != nil {
+		return nil, err
+	}
+	var listIDs []int64
+	for _, u := range users {
+		for _, id := range u.ListIDs {
+			listIDs = append(listIDs, int64(id))
+		}
+	}
+	if err := createTodos(ctx, db, listIDs, opts.Todos); err != nil {
+		return nil, err
+	}
+
+	// Plans for the new rows need fresh statistics.
+	if _, err := db.ExecContext(ctx, "ANALYZE users, lists, list_members, todos"); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func createUsers(ctx context.Context, db *sql.DB, opts Options) ([]User, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	users := make([]User, opts.Users)
+	for i := range users {
+		u := &users[i]
+		u.Email = fmt.Sprintf("user-%d@%s", i+1, loadgenDomain)
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO users (email, password_hash, email_verified_at) VALUES ($1, '', NOW()) RETURNING id",
+			u.Email,
+		).Scan(&u.ID)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < opts.ListsPerUser; j++ {
+			var listID int
+			name := fmt.Sprintf("%s %d", words[(i+j)%len(words)], j+1)
+			if err := tx.QueryRowContext(ctx, "INSERT INTO lists (name) VALUES ($1) RETURNING id", name).Scan(&listID); err != nil {
+				return nil, err
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, 'owner')", listID, u.ID)
+			if err != nil {
+				return nil, err
+			}
+			u.ListIDs = append(u.ListIDs, listID)
+		}
+	}
+	return users, tx.Commit()
+}
+
+// createTodos spreads n todos over the lists round-robin. Everything about
+// a todo is worked out from its number: a third are done, a quarter have
+// no due date and the rest are due from a month ago to three months ahead.
+func createTodos(ctx context.Context, db *sql.DB, listIDs []int64, n int) error {
+	for from := 1; from <= n; from += batchSize {
+		to := min(from+batchSize-1, n)
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO todos (title, completed, completed_at, due_date, estimate_minutes, list_id, position)
+			SELECT
+				w[1 + i % cardinality(w)] || ' ' || w[1 + (i / 7) % cardinality(w)] || ' ' || w[1 + (i / 53) % cardinality(w)],
+				i % 3 = 0,
+				CASE WHEN i % 3 = 0 THEN NOW() - make_interval(days => i % 90) END,
+				CASE WHEN i % 4 <> 0 THEN CURRENT_DATE + ((i::bigint * 7919) % 120 - 30)::int END,
+				(i % 5) * 15,
+				l[1 + i % cardinality(l)],
+				i
+			FROM generate_series($1::int, $2::int) i, (SELECT $3::text[] AS w, $4::int[] AS l) p`,
+			from, to, pq.Array(words), pq.Array(listIDs),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("loadgen: %d of %d todos", to, n)
+	}
+	return nil
+}
+
+// Delete removes every synthetic user, with their lists and todos.
+func Delete(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM lists WHERE id IN (
+			SELECT m.list_id FROM list_members m JOIN users u ON u.id = m.user_id
+			WHERE u.email LIKE '%@' || $1::text
+		)`, loadgenDomain)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE email LIKE '%@' || $1::text", loadgenDomain); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
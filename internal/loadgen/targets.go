@@ -0,0 +1,50 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Endpoint is a request for a load test to make. Path can hold {list},
+// for one of the user's lists, and {word}, for a word titles are made of.
+// Auth is "session" to send the user's session cookie or "token" to send
+// their API token as a bearer token.
+type Endpoint struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Auth   string `json:"auth"`
+}
+
+// Endpoints are the reads pagination and search work is measured on.
+var Endpoints = []Endpoint{
+	{Name: "list page", Method: "GET", Path: "/lists/{list}", Auth: "session"},
+	{Name: "list todos", Method: "GET", Path: "/lists/{list}/todos", Auth: "session"},
+	{Name: "search", Method: "GET", Path: "/lists/{list}/search?q={word}", Auth: "session"},
+	{Name: "search operators", Method: "GET", Path: "/lists/{list}/search?q=is:open+due:<today", Auth: "session"},
+	{Name: "api todos", Method: "GET", Path: "/api/v1/todos", Auth: "token"},
+	{Name: "api new todos", Method: "GET", Path: "/api/v1/triggers/new-todos", Auth: "token"},
+}
+
+// Targets is what a load test needs to know about a dataset. It's written
+// as JSON for scripts/loadtest.js, which k6 runs.
+type Targets struct {
+	BaseURL       string     `json:"base_url"`
+	SessionCookie string     `json:"session_cookie"`
+	Words         []string   `json:"words"`
+	Users         []User     `json:"users"`
+	Endpoints     []Endpoint `json:"endpoints"`
+}
+
+// WriteTargets writes the targets for users, signed in, to w.
+func WriteTargets(w io.Writer, baseURL, sessionCookie string, users []User) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Targets{
+		BaseURL:       baseURL,
+		SessionCookie: sessionCookie,
+		Words:         words,
+		Users:         users,
+		Endpoints:     Endpoints,
+	})
+}
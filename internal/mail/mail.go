@@ -0,0 +1,140 @@
+// Package mail sends transactional email over SMTP.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// MessageID, when set, is sent as the Message-ID header, so the same
+	// message sent twice is recognised as one.
+	MessageID string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Log prints messages instead of sending them, for development and for
+// deployments without SMTP configured.
+type Log struct{}
+
+func (Log) Send(_ context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+type SMTP struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+}
+
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	body, err := s.build(msg)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp has no context support; bound the send instead.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, body) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check connects to the server and, with a username set, logs in, the way
+// Send does, then hangs up without sending anything.
+func (s SMTP) Check(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(s.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	return c.Quit()
+}
+
+func (s SMTP) build(msg Message) ([]byte, error) {
+	if strings.ContainsAny(msg.To+msg.Subject+msg.MessageID, "\r\n") {
+		return nil, fmt.Errorf("mail: header contains a newline")
+	}
+
+	boundaryBytes := make([]byte, 12)
+	rand.Read(boundaryBytes)
+	boundary := hex.EncodeToString(boundaryBytes)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if msg.MessageID != "" {
+		fmt.Fprintf(&b, "Message-ID: <%s>\r\n", msg.MessageID)
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+
+	for _, part := range []struct{ typ, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s; charset=UTF-8\r\n", part.typ)
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&b)
+		qp.Write([]byte(part.body))
+		qp.Close()
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,132 @@
+// Package maintenance tracks whether the app is taking writes, serving
+// reads only, or fully down for maintenance.
+//
+// The mode lives in the settings table so an admin can flip it at runtime on
+// every instance at once. MAINTENANCE_MODE in the environment overrides the
+// stored value, for when the database itself is what's being worked on.
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Mode string
+
+const (
+	Off      Mode = "off"
+	ReadOnly Mode = "read-only"
+	Full     Mode = "full"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Off, ReadOnly, Full:
+		return m, nil
+	case "":
+		return Off, nil
+	}
+	return Off, fmt.Errorf("unknown maintenance mode %q", s)
+}
+
+const settingKey = "maintenance_mode"
+
+type Store struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	override Mode
+	mode     Mode
+	loadedAt time.Time
+}
+
+// NewStore returns a Store reading from db. A non-empty override pins the
+// mode regardless of what the database says.
+func NewStore(db *sql.DB, override Mode) *Store {
+	return &Store{db: db, override: override, ttl: 5 * time.Second}
+}
+
+// Migrate creates the settings table the mode is stored in.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	return err
+}
+
+// Overridden reports whether the mode is pinned by the environment.
+func (s *Store) Overridden() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.override != ""
+}
+
+// SetOverride pins the mode, or with an empty override, goes back to the
+// stored one.
+func (s *Store) SetOverride(override Mode) {
+	s.mu.Lock()
+	s.override = override
+	s.mu.Unlock()
+}
+
+// Mode returns the current mode. If the database can't be reached the last
+// known mode is kept, so a flaky connection doesn't flap the banner.
+func (s *Store) Mode(ctx context.Context) Mode {
+	s.mu.RLock()
+	override, mode, fresh := s.override, s.mode, time.Since(s.loadedAt) < s.ttl
+	s.mu.RUnlock()
+	if override != "" {
+		return override
+	}
+	if fresh {
+		return mode
+	}
+
+	var value string
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = $1", settingKey).Scan(&value)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		mode = Off
+	case err != nil:
+		if mode == "" {
+			return Off
+		}
+		return mode
+	default:
+		if mode, err = ParseMode(value); err != nil {
+			mode = Off
+		}
+	}
+
+	s.mu.Lock()
+	s.mode, s.loadedAt = mode, time.Now()
+	s.mu.Unlock()
+	return mode
+}
+
+// Set stores a new mode and applies it to this instance immediately. Other
+// instances pick it up within the cache TTL.
+func (s *Store) Set(ctx context.Context, mode Mode) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = $2, updated_at = NOW()`,
+		settingKey, string(mode),
+	)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.mode, s.loadedAt = mode, time.Now()
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,225 @@
+// Package metrics samples how the app is doing for the status page: each
+// instance counts its requests, server errors and latencies in memory and
+// writes them to Postgres once a minute, with the background job backlog
+// it saw. Latencies are kept as a histogram rather than a percentile, so
+// samples from several instances add up to the right p95.
+//
+// The samples double as a heartbeat: a stretch with none from any instance
+// is a stretch the app was down or couldn't reach its database, which is
+// what uptime is worked out from.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Bounds are the upper bounds of the latency histogram's buckets. Requests
+// slower than the last land in one more bucket past it.
+var Bounds = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type sample struct {
+	requests int64
+	errors   int64
+	buckets  []int64
+}
+
+func newSample() sample {
+	return sample{buckets: make([]int64, len(Bounds)+1)}
+}
+
+func (s *sample) add(o sample) {
+	s.requests += o.requests
+	s.errors += o.errors
+	for i, n := range o.buckets {
+		s.buckets[i] += n
+	}
+}
+
+type Recorder struct {
+	db       *sql.DB
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending sample
+}
+
+// New returns a recorder whose Flush is called every interval, which
+// uptime allows for between samples.
+func New(db *sql.DB, interval time.Duration) *Recorder {
+	return &Recorder{db: db, interval: interval, pending: newSample()}
+}
+
+func (rec *Recorder) Migrate(ctx context.Context) error {
+	_, err := rec.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS status_samples (
+			id BIGSERIAL PRIMARY KEY,
+			at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			requests INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			latency_buckets BIGINT[] NOT NULL,
+			backlog INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS status_samples_at ON status_samples (at);
+	`)
+	return err
+}
+
+// Observe counts one request that took d. Statuses of 500 and above count
+// as errors.
+func (rec *Recorder) Observe(status int, d time.Duration) {
+	i := 0
+	for i < len(Bounds) && d > Bounds[i] {
+		i++
+	}
+
+	rec.mu.Lock()
+	rec.pending.requests++
+	if status >= 500 {
+		rec.pending.errors++
+	}
+	rec.pending.buckets[i]++
+	rec.mu.Unlock()
+}
+
+// Flush writes what's been observed since the last flush as a sample,
+// along with backlog, the jobs waiting to run. What fails to write is put
+// back for the next flush.
+func (rec *Recorder) Flush(ctx context.Context, backlog int) error {
+	rec.mu.Lock()
+	s := rec.pending
+	rec.pending = newSample()
+	rec.mu.Unlock()
+
+	_, err := rec.db.ExecContext(ctx,
+		"INSERT INTO status_samples (requests, errors, latency_buckets, backlog) VALUES ($1, $2, $3, $4)",
+		s.requests, s.errors, pq.Array(s.buckets), backlog,
+	)
+	if err != nil {
+		rec.mu.Lock()
+		rec.pending.add(s)
+		rec.mu.Unlock()
+	}
+	return err
+}
+
+// Purge deletes the samples from before cutoff.
+func (rec *Recorder) Purge(ctx context.Context, cutoff time.Time) error {
+	_, err := rec.db.ExecContext(ctx, "DELETE FROM status_samples WHERE at < $1", cutoff)
+	return err
+}
+
+// Traffic is the requests over a window, from the samples in it.
+type Traffic struct {
+	Requests int64
+	Errors   int64
+	// P95 is the upper bound of the bucket the 95th percentile latency
+	// falls in, or zero without requests. Past the last bound, it's the
+	// last bound and Slow is set.
+	P95  time.Duration
+	Slow bool
+	// Backlog is the latest sample's, and MaxBacklog the most any had.
+	Backlog    int
+	MaxBacklog int
+}
+
+// ErrorRate is the share of requests that failed, in percent.
+func (t Traffic) ErrorRate() float64 {
+	if t.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(t.Errors) / float64(t.Requests)
+}
+
+// Traffic adds up the samples from the last window.
+func (rec *Recorder) Traffic(ctx context.Context, window time.Duration) (Traffic, error) {
+	var t Traffic
+	var buckets []sql.NullInt64
+	err := rec.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(errors), 0), COALESCE(MAX(backlog), 0),
+			COALESCE((SELECT backlog FROM status_samples ORDER BY at DESC LIMIT 1), 0),
+			ARRAY(
+				SELECT SUM(b.n) FROM status_samples s, unnest(s.latency_buckets) WITH ORDINALITY AS b(n, i)
+				WHERE s.at > NOW() - make_interval(secs => $1::float8)
+				GROUP BY b.i ORDER BY b.i
+			)
+		FROM status_samples WHERE at > NOW() - make_interval(secs => $1::float8)`,
+		window.Seconds(),
+	).Scan(&t.Requests, &t.Errors, &t.MaxBacklog, &t.Backlog, pq.Array(&buckets))
+	if err != nil {
+		return t, err
+	}
+
+	var total int64
+	for _, n := range buckets {
+		total += n.Int64
+	}
+	if total == 0 {
+		return t, nil
+	}
+	var seen int64
+	for i, n := range buckets {
+		seen += n.Int64
+		// The smallest bucket holding at least 95% of requests; integer
+		// maths, so 95 of 100 is enough.
+		if seen*100 >= total*95 {
+			if i < len(Bounds) {
+				t.P95 = Bounds[i]
+			} else {
+				t.P95, t.Slow = Bounds[len(Bounds)-1], true
+			}
+			break
+		}
+	}
+	return t, nil
+}
+
+// Uptime is the percentage of the last window the app was up, judged by
+// the gaps between samples: one longer than two intervals, or since the
+// last sample, counts as down past the first interval. The window starts
+// no earlier than the first sample, so a new install isn't counted down
+// for before it existed. ok is false without any samples.
+func (rec *Recorder) Uptime(ctx context.Context, window time.Duration) (percent float64, ok bool, err error) {
+	var first, last, now sql.NullTime
+	var gaps sql.NullFloat64
+	var count int64
+	err = rec.db.QueryRowContext(ctx, `
+		SELECT MIN(at), MAX(at), NOW(),
+			SUM(EXTRACT(EPOCH FROM gap)) FILTER (WHERE gap > make_interval(secs => $2::float8)),
+			COUNT(*) FILTER (WHERE gap > make_interval(secs => $2::float8))
+		FROM (
+			SELECT at, at - LAG(at) OVER (ORDER BY at) AS gap FROM status_samples
+			WHERE at > NOW() - make_interval(secs => $1::float8)
+		) s`,
+		window.Seconds(), 2*rec.interval.Seconds(),
+	).Scan(&first, &last, &now, &gaps, &count)
+	if err != nil || !first.Valid {
+		return 0, false, err
+	}
+
+	period := now.Time.Sub(first.Time)
+	if period < rec.interval {
+		return 100, true, nil
+	}
+	down := time.Duration(gaps.Float64*float64(time.Second)) - time.Duration(count)*rec.interval
+	if since := now.Time.Sub(last.Time); since > 2*rec.interval {
+		down += since - rec.interval
+	}
+	return 100 * max(0, 1-down.Seconds()/period.Seconds()), true, nil
+}
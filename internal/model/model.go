@@ -0,0 +1,412 @@
+// Package model holds the app's domain types: todos, the lists they live on,
+// the people who can see them and what each of them may do. The types are
+// plain data; internal/store reads and writes them and internal/http renders
+// them.
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Todo struct {
+	ID              int        `json:"id"`
+	ListID          int        `json:"list_id"`
+	Title           string     `json:"title"`
+	Description     string     `json:"description"`
+	Completed       bool       `json:"completed"`
+	EstimateMinutes int        `json:"estimate_minutes"`
+	DueDate         *time.Time `json:"due_date"`
+	// Place is where the todo gets done, as typed. Latitude and Longitude
+	// are set when it could be geocoded.
+	Place          string   `json:"place"`
+	Latitude       *float64 `json:"latitude"`
+	Longitude      *float64 `json:"longitude"`
+	Blocked        bool     `json:"blocked"`
+	TrackedSeconds int64    `json:"tracked_seconds"`
+	TimerRunning   bool     `json:"timer_running"`
+	// Fields are the todo's values for its list's custom fields, in the
+	// list's order; fields it has no value for are left out.
+	Fields FieldValues `json:"fields"`
+	// AssigneeID is the member of the list doing the todo, if anyone is,
+	// and Assignee their email.
+	AssigneeID *int   `json:"assignee_id"`
+	Assignee   string `json:"assignee"`
+	// NeedsApproval is set on todos only an owner can complete, when
+	// the list has approvals turned on. AwaitingApproval is set once
+	// someone else has asked to complete one.
+	NeedsApproval    bool `json:"needs_approval"`
+	AwaitingApproval bool `json:"awaiting_approval"`
+	// StatusID is where the todo is in its list's workflow, and Status
+	// that status's name. Completed follows from it.
+	StatusID int    `json:"status_id"`
+	Status   string `json:"status"`
+	// OpenDays is how many whole days an open todo has been open, and
+	// Aging whether that's past its list's thresholds.
+	OpenDays int    `json:"open_days"`
+	Aging    string `json:"aging"`
+}
+
+// Aging levels a todo reaches by staying open past its list's thresholds.
+const (
+	AgingWarn  = "warn"
+	AgingAlert = "alert"
+)
+
+// Value returns the todo's value for a custom field as a form input holds
+// it, empty when it has none.
+func (t Todo) Value(fieldID int) string {
+	for _, v := range t.Fields {
+		if v.FieldID == fieldID {
+			return v.String()
+		}
+	}
+	return ""
+}
+
+// Estimate formats the estimate for display, empty when there is none.
+func (t Todo) Estimate() string {
+	if t.EstimateMinutes == 0 {
+		return ""
+	}
+	return FormatDuration(int64(t.EstimateMinutes) * 60)
+}
+
+// Tracked formats the time tracked on a todo for display.
+func (t Todo) Tracked() string {
+	return FormatDuration(t.TrackedSeconds)
+}
+
+// FormatDuration formats a number of seconds as "1h 05m", "5m 03s" or "3s".
+func FormatDuration(seconds int64) string {
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh %02dm", h, m)
+	case m > 0:
+		return fmt.Sprintf("%dm %02ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+// TodoEvent is a change to one of a list's todos: it was created, updated,
+// deleted or reordered. IDs count up from 1 on each list. Todo is the todo
+// as it is now, or nil once it's no longer on the list.
+type TodoEvent struct {
+	ID     int64     `json:"id"`
+	Type   string    `json:"type"`
+	ListID int       `json:"list_id"`
+	TodoID int       `json:"todo_id"`
+	At     time.Time `json:"at"`
+	Todo   *Todo     `json:"todo,omitempty"`
+}
+
+// Revision is a title and description a todo had until an edit replaced
+// them at ReplacedAt.
+type Revision struct {
+	ID          int64     `json:"id"`
+	TodoID      int       `json:"todo_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+// Attachment is a file uploaded to a todo. Its contents are loaded
+// separately, when it's downloaded.
+type Attachment struct {
+	ID          int       `json:"id"`
+	TodoID      int       `json:"todo_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	// TranscriptStatus is where a voice note's transcription has got to,
+	// or empty for other files and when there's no transcriber.
+	TranscriptStatus string `json:"transcript_status,omitempty"`
+}
+
+// Stages of a voice note's transcription.
+const (
+	TranscriptPending = "pending"
+	TranscriptRunning = "running"
+	TranscriptDone    = "done"
+	TranscriptFailed  = "failed"
+)
+
+// IsImage reports whether the attachment is an image that can be
+// thumbnailed.
+func (a Attachment) IsImage() bool {
+	switch a.ContentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// IsAudio reports whether the attachment is a recording that can be
+// played in the browser.
+func (a Attachment) IsAudio() bool {
+	return strings.HasPrefix(a.ContentType, "audio/")
+}
+
+// Transcribing reports whether the attachment's transcript is still to
+// come.
+func (a Attachment) Transcribing() bool {
+	return a.TranscriptStatus == TranscriptPending || a.TranscriptStatus == TranscriptRunning
+}
+
+// Kinds of custom field.
+const (
+	TextField   = "text"
+	NumberField = "number"
+	SelectField = "select"
+	DateField   = "date"
+)
+
+// FieldKinds lists the kinds in the order the field form offers them.
+var FieldKinds = []string{TextField, NumberField, SelectField, DateField}
+
+// Status is one of the stages a list's todos move through. Todos in a Done
+// status count as completed.
+type Status struct {
+	ID       int    `json:"id"`
+	ListID   int    `json:"list_id"`
+	Name     string `json:"name"`
+	Done     bool   `json:"done"`
+	Position int    `json:"position"`
+}
+
+// Field is a custom field a list's owners add to its todos. Select fields
+// take one of Options.
+type Field struct {
+	ID      int      `json:"id"`
+	ListID  int      `json:"list_id"`
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Options []string `json:"options,omitempty"`
+}
+
+// Parse checks a value entered for the field and returns it the way it's
+// stored: numbers as numbers, everything else as strings, dates as
+// 2006-01-02. A blank value parses to nil, which clears the field.
+func (f Field) Parse(s string) (any, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch f.Kind {
+	case NumberField:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", f.Name)
+		}
+		return n, nil
+	case SelectField:
+		if !slices.Contains(f.Options, s) {
+			return nil, fmt.Errorf("Pick one of the options for %s", f.Name)
+		}
+	case DateField:
+		if _, err := time.Parse(time.DateOnly, s); err != nil {
+			return nil, fmt.Errorf("%s must be a date", f.Name)
+		}
+	default:
+		if len(s) > 500 {
+			return nil, fmt.Errorf("%s is too long", f.Name)
+		}
+	}
+	return s, nil
+}
+
+// FieldValue is a todo's value for one custom field.
+type FieldValue struct {
+	FieldID int    `json:"field_id"`
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Value   any    `json:"value"`
+}
+
+// String formats the value as a form input holds it.
+func (v FieldValue) String() string {
+	if n, ok := v.Value.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	s, _ := v.Value.(string)
+	return s
+}
+
+// Display formats the value for the todo list.
+func (v FieldValue) Display() string {
+	if v.Kind == DateField {
+		if d, err := time.Parse(time.DateOnly, v.String()); err == nil {
+			return d.Format("Jan 2")
+		}
+	}
+	return v.String()
+}
+
+// FieldValues scans from the JSON array TodoColumns builds.
+type FieldValues []FieldValue
+
+func (f *FieldValues) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("scan field values from %T", src)
+	}
+	return json.Unmarshal(b, f)
+}
+
+type List struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+	Archived bool   `json:"archived"`
+	// AutoArchiveDays archives todos this many days after they're
+	// completed; zero keeps them forever.
+	AutoArchiveDays int `json:"auto_archive_days"`
+	// Approvals lets todos be marked as needing an owner's approval to
+	// complete.
+	Approvals bool `json:"approvals"`
+	// AgingWarnDays and AgingAlertDays flag todos open this many days;
+	// zero doesn't.
+	AgingWarnDays  int `json:"aging_warn_days"`
+	AgingAlertDays int `json:"aging_alert_days"`
+	// Counts are only loaded with a user's lists.
+	Counts *TodoCounts `json:"counts,omitempty"`
+}
+
+// TodoCounts is how many of a list's todos are open and done, not counting
+// archived ones.
+type TodoCounts struct {
+	Open      int `json:"open"`
+	Completed int `json:"completed"`
+}
+
+func (l List) CanEdit() bool {
+	return l.Role >= Editor
+}
+
+type Member struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Role   Role   `json:"role"`
+}
+
+type User struct {
+	ID        int
+	Email     string
+	IsAdmin   bool
+	CreatedAt time.Time
+	// EmailVerified is set once the user follows the link emailed at
+	// signup.
+	EmailVerified bool
+	// ConfirmDeletes asks before deleting a todo; users can turn it off
+	// from the dialog or in settings.
+	ConfirmDeletes bool
+	// DemoEnds is when a demo account is deleted; zero for real ones.
+	DemoEnds time.Time
+	// ListView is how they like their lists laid out.
+	ListView ListView
+}
+
+// Densities a list can be laid out in.
+const (
+	Comfortable = "comfortable"
+	Compact     = "compact"
+)
+
+// Columns a list can show for each todo, besides its title.
+const (
+	ColumnDue      = "due"
+	ColumnEstimate = "estimate"
+	ColumnPlace    = "place"
+	ColumnFields   = "fields"
+	ColumnTimer    = "timer"
+	ColumnAssignee = "assignee"
+	ColumnStatus   = "status"
+	ColumnAge      = "age"
+)
+
+// Column is one of the columns a user can show or hide, with its label.
+type Column struct {
+	Key   string
+	Label string
+}
+
+// Columns are every column a list can show, in the order they appear.
+var Columns = []Column{
+	{ColumnStatus, "Status"},
+	{ColumnDue, "Due date"},
+	{ColumnEstimate, "Estimate"},
+	{ColumnPlace, "Place"},
+	{ColumnFields, "Custom fields"},
+	{ColumnTimer, "Time tracked"},
+	{ColumnAssignee, "Assignee"},
+	{ColumnAge, "Days open"},
+}
+
+// ListView is how a user likes lists laid out. The zero value shows every
+// column, comfortably spaced.
+type ListView struct {
+	Density string
+	// Hidden are the columns they've turned off. Columns are listed by
+	// what's hidden so that new ones show up for everyone.
+	Hidden    []string
+	UpdatedAt time.Time
+}
+
+// Compact reports whether todos are packed closer together.
+func (v ListView) Compact() bool {
+	return v.Density == Compact
+}
+
+// Show reports whether a column is shown.
+func (v ListView) Show(column string) bool {
+	return !slices.Contains(v.Hidden, column)
+}
+
+// Role is what a user may do on a list. Roles are ordered, so a check for
+// Editor also lets owners through.
+type Role int
+
+const (
+	NoRole Role = iota
+	Viewer
+	Editor
+	Owner
+)
+
+var roleNames = map[Role]string{Viewer: "viewer", Editor: "editor", Owner: "owner"}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// MarshalText makes roles read "owner", "editor" or "viewer" in JSON.
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText reads a role back, so lists survive a trip through a cache.
+func (r *Role) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*r = NoRole
+		return nil
+	}
+	role, err := ParseRole(string(b))
+	*r = role
+	return err
+}
+
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if name == s {
+			return role, nil
+		}
+	}
+	return NoRole, errors.New("Pick owner, editor or viewer")
+}
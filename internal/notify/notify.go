@@ -0,0 +1,150 @@
+// Package notify wakes code waiting on Postgres NOTIFY, on a connection of
+// its own that LISTENs for as long as the app runs. The connection is
+// reopened when it drops, waiting twice as long after each failed attempt,
+// and it's pinged regularly, so one left dead by a failover is noticed
+// rather than waited on. Notifications sent while it was down are lost, so
+// once it's back every subscriber is woken to check for what it missed.
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	minReconnect = time.Second
+	maxReconnect = time.Minute
+	// pingEvery is how often the connection is checked, as a dead one only
+	// shows once something is sent on it.
+	pingEvery = 30 * time.Second
+)
+
+// Listener wakes subscribers to a channel's notifications by payload.
+type Listener struct {
+	dsn     string
+	channel string
+
+	listening atomic.Bool
+
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+// New returns a Listener for channel, which connects to dsn once Run. An
+// empty dsn never connects, for deployments that can't LISTEN.
+func New(dsn, channel string) *Listener {
+	return &Listener{dsn: dsn, channel: channel, subs: map[string]map[chan struct{}]struct{}{}}
+}
+
+// Listening reports whether notifications are arriving. While they aren't,
+// subscribers should check for changes themselves more often.
+func (l *Listener) Listening() bool {
+	return l.listening.Load()
+}
+
+// Subscribe returns a channel that receives whenever a notification with
+// payload arrives, or the connection comes back, and a func to stop
+// receiving. Notifications that arrive while the last one is still unread
+// are merged into it.
+func (l *Listener) Subscribe(payload string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subs[payload] == nil {
+		l.subs[payload] = map[chan struct{}]struct{}{}
+	}
+	l.subs[payload][ch] = struct{}{}
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs[payload], ch)
+		if len(l.subs[payload]) == 0 {
+			delete(l.subs, payload)
+		}
+	}
+}
+
+// wake wakes the subscribers to payload, or with all every subscriber.
+func (l *Listener) wake(payload string, all bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p, subs := range l.subs {
+		if !all && p != payload {
+			continue
+		}
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Run listens until ctx is done.
+func (l *Listener) Run(ctx context.Context) {
+	if l.dsn == "" {
+		return
+	}
+	listener := pq.NewListener(l.dsn, minReconnect, maxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			l.listening.Store(true)
+		case pq.ListenerEventDisconnected:
+			l.listening.Store(false)
+			log.Printf("notify: lost the connection listening on %s: %v", l.channel, err)
+		case pq.ListenerEventReconnected:
+			l.listening.Store(true)
+			log.Printf("notify: listening on %s again", l.channel)
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("notify: can't connect to listen on %s: %v", l.channel, err)
+		}
+	})
+	defer func() {
+		l.listening.Store(false)
+		listener.Close()
+	}()
+	// Listen blocks until the first connection is made, however long the
+	// database takes to come up.
+	go func() {
+		if err := listener.Listen(l.channel); err != nil && ctx.Err() == nil {
+			log.Printf("notify: listen on %s: %v", l.channel, err)
+		}
+	}()
+
+	// A ping that fails has the connection reopened. One that hangs on a
+	// dead connection is let go by Close.
+	go func() {
+		ping := time.NewTicker(pingEvery)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ping.C:
+				listener.Ping()
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// nil after reconnecting, when anything could have been
+			// missed
+			if n == nil {
+				l.wake("", true)
+			} else {
+				l.wake(n.Extra, false)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package oauth signs people in with Google and GitHub using the OAuth 2.0
+// authorization code flow. The app sends the browser to the provider's
+// consent page, the provider sends it back with a code, and Exchange turns
+// the code into the identity of whoever signed in.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoEmail is returned when the provider won't vouch for an email
+// address, which accounts here are keyed on.
+var ErrNoEmail = errors.New("no verified email address")
+
+// Identity is an account with a provider.
+type Identity struct {
+	Provider string
+	// Subject is the provider's ID for the account, which unlike the
+	// email never changes.
+	Subject string
+	// Email is an address the provider has verified belongs to it.
+	Email string
+}
+
+// Provider is a configured sign-in provider.
+type Provider struct {
+	// Name is "google" or "github", and Label how it's shown on buttons.
+	Name  string
+	Label string
+
+	clientID string
+	secret   string
+	authURL  string
+	tokenURL string
+	scope    string
+	identify func(ctx context.Context, p *Provider, token string) (Identity, error)
+	client   *http.Client
+}
+
+// New returns the named provider, "google" or "github", for the app
+// registered with it as clientID.
+func New(name, clientID, secret string) (*Provider, error) {
+	if clientID == "" || secret == "" {
+		return nil, fmt.Errorf("%s sign-in needs a client ID and secret", name)
+	}
+	p := &Provider{
+		Name:     name,
+		clientID: clientID,
+		secret:   secret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	switch name {
+	case "google":
+		p.Label = "Google"
+		p.authURL = "https://accounts.google.com/o/oauth2/v2/auth"
+		p.tokenURL = "https://oauth2.googleapis.com/token"
+		p.scope = "openid email"
+		p.identify = googleIdentity
+	case "github":
+		p.Label = "GitHub"
+		p.authURL = "https://github.com/login/oauth/authorize"
+		p.tokenURL = "https://github.com/login/oauth/access_token"
+		p.scope = "user:email"
+		p.identify = githubIdentity
+	default:
+		return nil, fmt.Errorf("unknown sign-in provider %q", name)
+	}
+	return p, nil
+}
+
+// AuthURL is the provider's consent page. It sends the browser back to
+// redirectURI with state, which the caller checks to know the response is
+// to a request it made.
+func (p *Provider) AuthURL(state, redirectURI string) string {
+	q := url.Values{
+		"client_id":     {p.clientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {p.scope},
+		"state":         {state},
+	}
+	return p.authURL + "?" + q.Encode()
+}
+
+// Exchange trades the code the provider redirected back with for the
+// identity that signed in. redirectURI must be the one given to AuthURL.
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI string) (Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"client_secret": {p.secret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return Identity{}, fmt.Errorf("%s token: %w", p.Name, err)
+	}
+	if token.AccessToken == "" {
+		return Identity{}, fmt.Errorf("%s token: %s", p.Name, token.Error)
+	}
+
+	id, err := p.identify(ctx, p, token.AccessToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	id.Provider = p.Name
+	return id, nil
+}
+
+// get fetches a provider API resource as the signed-in account.
+func (p *Provider) get(ctx context.Context, token, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if err := p.do(req, v); err != nil {
+		return fmt.Errorf("%s %s: %w", p.Name, req.URL.Path, err)
+	}
+	return nil
+}
+
+func (p *Provider) do(req *http.Request, v any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func googleIdentity(ctx context.Context, p *Provider, token string) (Identity, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := p.get(ctx, token, "https://openidconnect.googleapis.com/v1/userinfo", &info); err != nil {
+		return Identity{}, err
+	}
+	if info.Email == "" || !info.EmailVerified {
+		return Identity{}, ErrNoEmail
+	}
+	return Identity{Subject: info.Sub, Email: info.Email}, nil
+}
+
+func githubIdentity(ctx context.Context, p *Provider, token string) (Identity, error) {
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := p.get(ctx, token, "https://api.github.com/user", &user); err != nil {
+		return Identity{}, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, token, "https://api.github.com/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return Identity{Subject: strconv.FormatInt(user.ID, 10), Email: e.Email}, nil
+		}
+	}
+	return Identity{}, ErrNoEmail
+}
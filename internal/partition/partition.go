@@ -0,0 +1,297 @@
+// Package partition splits tables that only grow, like event logs, into a
+// partition a month by their timestamp, so that old months can be dropped
+// whole instead of deleted row by row, and inserts and queries on recent
+// rows only touch recent partitions.
+//
+// A partitioned table has a partition for each month, named like
+// todo_events_p2026_10, and a default one, todo_events_default, which
+// catches rows for months that have none yet. Months are in UTC.
+package partition
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Table is a table partitioned, or to be partitioned, by month.
+type Table struct {
+	Name string
+	// Column is the timestamp rows are partitioned by.
+	Column string
+	// Key is the table's primary key. A partitioned table's has to include
+	// Column, so it's added.
+	Key []string
+}
+
+// Partitioned reports whether t has been partitioned.
+func Partitioned(ctx context.Context, db *sql.DB, t Table) (bool, error) {
+	var partitioned bool
+	err := db.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT relkind = 'p' FROM pg_class WHERE oid = to_regclass($1)), false)", t.Name,
+	).Scan(&partitioned)
+	return partitioned, err
+}
+
+// Convert partitions t, keeping its rows, columns, defaults, checks,
+// foreign keys and indexes, with partitions from its oldest row's month
+// until ahead months from now. It's done in one transaction that locks t
+// while every row is copied, so on a big table it wants a maintenance
+// window. It reports false if t was already partitioned.
+func Convert(ctx context.Context, db *sql.DB, t Table, now time.Time, ahead int) (bool, error) {
+	if partitioned, err := Partitioned(ctx, db, t); err != nil || partitioned {
+		return false, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	name := pq.QuoteIdentifier(t.Name)
+	old := t.Name + "_unpartitioned"
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE "+name+" IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return false, err
+	}
+
+	// Sequences owned by t's columns would go with the old table.
+	var columns, sequences []string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(attname::text ORDER BY attnum), '{}'),
+			COALESCE(array_agg(pg_get_serial_sequence($1::text, attname) ORDER BY attnum), '{}')
+		FROM pg_attribute
+		WHERE attrelid = $1::text::regclass AND attnum > 0 AND NOT attisdropped
+		  AND pg_get_serial_sequence($1::text, attname) IS NOT NULL`, t.Name,
+	).Scan(pq.Array(&columns), pq.Array(&sequences)); err != nil {
+		return false, err
+	}
+	for _, seq := range sequences {
+		if _, err := tx.ExecContext(ctx, "ALTER SEQUENCE "+seq+" OWNED BY NONE"); err != nil {
+			return false, err
+		}
+	}
+
+	// The indexes and foreign keys to make again, taken before the old
+	// table and its indexes are renamed out of the way. Unique indexes
+	// would have to include Column, so only the primary key is kept.
+	var indexes, renames, foreignKeys []string
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.relname, i.indisunique, pg_get_indexdef(i.indexrelid)
+		FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1::regclass`, t.Name)
+	if err != nil {
+		return false, err
+	}
+	for rows.Next() {
+		var index, def string
+		var unique bool
+		if err := rows.Scan(&index, &unique, &def); err != nil {
+			rows.Close()
+			return false, err
+		}
+		renames = append(renames, fmt.Sprintf("ALTER INDEX %s RENAME TO %s",
+			pq.QuoteIdentifier(index), pq.QuoteIdentifier(index+"_unpartitioned")))
+		if !unique {
+			indexes = append(indexes, def)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows, err = tx.QueryContext(ctx,
+		"SELECT conname, pg_get_constraintdef(oid) FROM pg_constraint WHERE conrelid = $1::regclass AND contype = 'f'", t.Name)
+	if err != nil {
+		return false, err
+	}
+	for rows.Next() {
+		var constraint, def string
+		if err := rows.Scan(&constraint, &def); err != nil {
+			rows.Close()
+			return false, err
+		}
+		foreignKeys = append(foreignKeys, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", name, pq.QuoteIdentifier(constraint), def))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	key := make([]string, 0, len(t.Key)+1)
+	for _, c := range append(t.Key, t.Column) {
+		key = append(key, pq.QuoteIdentifier(c))
+	}
+	stmts := append([]string{"ALTER TABLE " + name + " RENAME TO " + pq.QuoteIdentifier(old)}, renames...)
+	stmts = append(stmts,
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS) PARTITION BY RANGE (%s)",
+			name, pq.QuoteIdentifier(old), pq.QuoteIdentifier(t.Column)),
+		fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", name, strings.Join(key, ", ")),
+	)
+	stmts = append(stmts, foreignKeys...)
+	stmts = append(stmts, indexes...)
+	stmts = append(stmts, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s DEFAULT",
+		pq.QuoteIdentifier(t.Name+"_default"), name))
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return false, err
+		}
+	}
+
+	from := now
+	var oldest sql.NullTime
+	if err := tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT MIN(%s) FROM %s", pq.QuoteIdentifier(t.Column), pq.QuoteIdentifier(old)),
+	).Scan(&oldest); err != nil {
+		return false, err
+	}
+	if oldest.Valid && oldest.Time.Before(from) {
+		from = oldest.Time
+	}
+	if err := create(ctx, tx, t, from, now.AddDate(0, ahead, 0)); err != nil {
+		return false, err
+	}
+
+	stmts = []string{
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", name, pq.QuoteIdentifier(old)),
+		"DROP TABLE " + pq.QuoteIdentifier(old),
+	}
+	for i, seq := range sequences {
+		stmts = append(stmts, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s", seq, name, pq.QuoteIdentifier(columns[i])))
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// Ensure creates t's partitions for this month until ahead months from
+// now, those it doesn't have yet, moving any rows for them out of the
+// default partition. It returns the partitions it created.
+func Ensure(ctx context.Context, db *sql.DB, t Table, now time.Time, ahead int) ([]string, error) {
+	existing, err := partitions(ctx, db, t)
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	for month := monthOf(now); !month.After(now.AddDate(0, ahead, 0)); month = month.AddDate(0, 1, 0) {
+		if _, ok := existing[partitionName(t, month)]; ok {
+			continue
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return created, err
+		}
+		if err := createMonth(ctx, tx, t, month); err != nil {
+			tx.Rollback()
+			return created, err
+		}
+		if err := tx.Commit(); err != nil {
+			return created, err
+		}
+		created = append(created, partitionName(t, month))
+	}
+	return created, nil
+}
+
+// DropBefore removes t's rows from before cutoff: the partitions for
+// months that ended by then, and those rows in the default partition.
+// Rows from cutoff's own month stay until the whole month can go. It
+// returns how many rows were removed.
+func DropBefore(ctx context.Context, db *sql.DB, t Table, cutoff time.Time) (int64, error) {
+	existing, err := partitions(ctx, db, t)
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for name, month := range existing {
+		if month.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+		var n int64
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+pq.QuoteIdentifier(name)).Scan(&n); err != nil {
+			return removed, err
+		}
+		if _, err := db.ExecContext(ctx, "DROP TABLE "+pq.QuoteIdentifier(name)); err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	res, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s < $1",
+		pq.QuoteIdentifier(t.Name+"_default"), pq.QuoteIdentifier(t.Column)), cutoff)
+	if err != nil {
+		return removed, err
+	}
+	n, err := res.RowsAffected()
+	return removed + n, err
+}
+
+// partitions returns t's monthly partitions by name, with the month each
+// holds.
+func partitions(ctx context.Context, db *sql.DB, t Table) (map[string]time.Time, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT inhrelid::regclass::text FROM pg_inherits WHERE inhparent = $1::regclass", t.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if month, err := time.Parse("2006_01", strings.TrimPrefix(name, t.Name+"_p")); err == nil {
+			found[name] = month
+		}
+	}
+	return found, rows.Err()
+}
+
+// create makes t's partitions for from's month until to's, in tx.
+func create(ctx context.Context, tx *sql.Tx, t Table, from, to time.Time) error {
+	for month := monthOf(from); !month.After(to); month = month.AddDate(0, 1, 0) {
+		if err := createMonth(ctx, tx, t, month); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createMonth makes t's partition for month. It's made as a table of its
+// own, filled with the month's rows from the default partition and then
+// attached, since Postgres won't add a partition the default one has rows
+// for.
+func createMonth(ctx context.Context, tx *sql.Tx, t Table, month time.Time) error {
+	name := pq.QuoteIdentifier(t.Name)
+	partition := pq.QuoteIdentifier(partitionName(t, month))
+	column := pq.QuoteIdentifier(t.Column)
+	from, to := month.Format(time.RFC3339), month.AddDate(0, 1, 0).Format(time.RFC3339)
+	for _, stmt := range []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", partition, name),
+		fmt.Sprintf(`WITH moved AS (DELETE FROM %s WHERE %s >= '%s' AND %s < '%s' RETURNING *)
+			INSERT INTO %s SELECT * FROM moved`,
+			pq.QuoteIdentifier(t.Name+"_default"), column, from, column, to, partition),
+		fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')", name, partition, from, to),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", partitionName(t, month), err)
+		}
+	}
+	return nil
+}
+
+func partitionName(t Table, month time.Time) string {
+	return t.Name + "_p" + month.Format("2006_01")
+}
+
+// monthOf is the start of t's month in UTC.
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,119 @@
+// Package pdf writes simple text documents as PDF: lines of text, boxes and
+// rules on fixed-size pages, in Helvetica.
+//
+// Helvetica is one of the fonts every PDF reader has built in, so nothing
+// is embedded and files stay small. The catch is that it only covers the
+// Windows-1252 character set: emoji are left out, and letters from other
+// scripts print as "?".
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Size is a page size in points, 1/72 of an inch.
+type Size struct{ Width, Height float64 }
+
+var (
+	A4     = Size{595, 842}
+	Letter = Size{612, 792}
+)
+
+// Document is a PDF being built a page at a time.
+type Document struct {
+	Title string
+	size  Size
+	pages []*Page
+}
+
+// Page is one page's drawing. Coordinates are in points from the top-left
+// corner, y growing downwards as on screen.
+type Page struct {
+	size    Size
+	content bytes.Buffer
+}
+
+func New(size Size) *Document {
+	return &Document{size: size}
+}
+
+// AddPage starts a new page and returns it for drawing on.
+func (d *Document) AddPage() *Page {
+	p := &Page{size: d.size}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s with its baseline at y, in the given size and gray level
+// (0 is black, 1 white). Bold uses Helvetica-Bold.
+func (p *Page) Text(x, y, size, gray float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT %.3f g /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+		gray, font, size, x, p.size.Height-y, escape(encode(s)))
+}
+
+// Rect outlines a rectangle whose top-left corner is at x, y.
+func (p *Page) Rect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.content, "%.3f G 0.75 w %.2f %.2f %.2f %.2f re S\n", gray, x, p.size.Height-y-h, w, h)
+}
+
+// Line draws a line from x1, y1 to x2, y2.
+func (p *Page) Line(x1, y1, x2, y2, gray float64) {
+	fmt.Fprintf(&p.content, "%.3f G 0.75 w %.2f %.2f m %.2f %.2f l S\n", gray, x1, p.size.Height-y1, x2, p.size.Height-y2)
+}
+
+// WriteTo writes the document out.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1 to 4 are the catalog, page tree, fonts and info; each page
+	// then takes two, itself and its content.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /F1 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>" +
+		" /F2 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >> >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (htmx-go-postgres) >>", escape(encode(d.Title))))
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font 3 0 R >> /Contents %d 0 R >>",
+			p.size.Width, p.size.Height, 6+2*i))
+
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(p.content.Bytes())
+		zw.Close()
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", len(offsets), z.Len())
+		z.WriteTo(&buf)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.WriteTo(w)
+}
+
+// escape makes s safe inside a PDF literal string.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\r", "", "\n", " ").Replace(s)
+}
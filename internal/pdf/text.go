@@ -0,0 +1,97 @@
+package pdf
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Advance widths of the printable ASCII characters, from space to "~", in
+// thousandths of the font size, as given by Adobe's metrics for the base
+// fonts. Other characters are measured as a digit, which is about as wide
+// as an accented letter.
+var (
+	helvetica = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBold = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// encode converts s to Windows-1252, the fonts' encoding. Emoji and other
+// symbols it can't hold are left out; other characters become "?".
+func encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if c, ok := charmap.Windows1252.EncodeRune(r); ok {
+			b.WriteByte(c)
+		} else if !unicode.In(r, unicode.So, unicode.Variation_Selector, unicode.Join_Control) {
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Width is how wide s is, in points, at the given font size.
+func Width(s string, size float64, bold bool) float64 {
+	widths := &helvetica
+	if bold {
+		widths = &helveticaBold
+	}
+	total := 0
+	for _, c := range []byte(encode(s)) {
+		if c >= ' ' && c <= '~' {
+			total += widths[c-' ']
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Wrap breaks s into lines no wider than width, at spaces where it can and
+// mid-word where a word alone is too wide. Line breaks in s are kept.
+func Wrap(s string, size float64, bold bool, width float64) []string {
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			next := word
+			if line != "" {
+				next = line + " " + word
+			}
+			if Width(next, size, bold) <= width {
+				line = next
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Split a word too long for a line of its own.
+			for Width(word, size, bold) > width {
+				r := []rune(word)
+				n := len(r) - 1
+				for n > 1 && Width(string(r[:n]), size, bold) > width {
+					n--
+				}
+				lines = append(lines, string(r[:n]))
+				word = string(r[n:])
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,137 @@
+// Package presence tracks who is looking at which list right now. Pages
+// send a heartbeat while they're open; a viewer who stops sending one drops
+// out after the TTL. It's kept in memory, so each instance only knows about
+// the viewers whose heartbeats reach it.
+package presence
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Viewer struct {
+	UserID int
+	Email  string
+	Seen   time.Time
+}
+
+// Registry is who is viewing each list, by list and user ID.
+type Registry struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	viewers map[int]map[int]Viewer
+	subs    map[int]map[chan struct{}]struct{}
+}
+
+func New(ttl time.Duration) *Registry {
+	return &Registry{
+		ttl:     ttl,
+		viewers: map[int]map[int]Viewer{},
+		subs:    map[int]map[chan struct{}]struct{}{},
+	}
+}
+
+// Beat records that v is viewing listID. Subscribers are told when the
+// viewer is new to the list.
+func (r *Registry) Beat(listID int, v Viewer) {
+	v.Seen = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := r.viewers[listID]
+	if list == nil {
+		list = map[int]Viewer{}
+		r.viewers[listID] = list
+	}
+	_, known := list[v.UserID]
+	list[v.UserID] = v
+	if !known {
+		r.notify(listID)
+	}
+}
+
+// Viewers returns who is viewing listID, ordered by email.
+func (r *Registry) Viewers(listID int) []Viewer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Viewer
+	for _, v := range r.viewers[listID] {
+		if time.Since(v.Seen) < r.ttl {
+			out = append(out, v)
+		}
+	}
+	slices.SortFunc(out, func(a, b Viewer) int { return strings.Compare(a.Email, b.Email) })
+	return out
+}
+
+// Subscribe returns a channel that receives whenever the viewers of listID
+// change, and a func to stop receiving. Changes that arrive while the last
+// one is still unread are merged into it.
+func (r *Registry) Subscribe(listID int) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subs[listID] == nil {
+		r.subs[listID] = map[chan struct{}]struct{}{}
+	}
+	r.subs[listID][ch] = struct{}{}
+
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subs[listID], ch)
+		if len(r.subs[listID]) == 0 {
+			delete(r.subs, listID)
+		}
+	}
+}
+
+// Run expires viewers whose heartbeats stopped, checking every interval,
+// until ctx is cancelled.
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.expire()
+	}
+}
+
+func (r *Registry) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for listID, list := range r.viewers {
+		changed := false
+		for userID, v := range list {
+			if time.Since(v.Seen) >= r.ttl {
+				delete(list, userID)
+				changed = true
+			}
+		}
+		if len(list) == 0 {
+			delete(r.viewers, listID)
+		}
+		if changed {
+			r.notify(listID)
+		}
+	}
+}
+
+// notify tells listID's subscribers its viewers changed. r.mu must be held.
+func (r *Registry) notify(listID int) {
+	for ch := range r.subs[listID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package push delivers Web Push notifications to subscribed browsers.
+//
+// The VAPID key pair identifies this server to browser push services. It can
+// be supplied through the environment; otherwise one is generated on first
+// boot and kept in the settings table, so subscriptions survive restarts.
+//
+// Subscriptions belong to user accounts; the users table must exist before
+// New is called.
+package push
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
+
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+	// Tag collapses notifications about the same thing into one.
+	Tag string `json:"tag,omitempty"`
+}
+
+type Subscription = webpush.Subscription
+
+type Service struct {
+	db         *sql.DB
+	subscriber string
+	publicKey  string
+	privateKey string
+}
+
+// New loads or creates the VAPID keys. subscriber is a mailto: or https:
+// contact URL push services can use to reach the operator. It expects the
+// settings table to exist already.
+func New(ctx context.Context, db *sql.DB, subscriber, publicKey, privateKey string) (*Service, error) {
+	s := &Service{db: db, subscriber: subscriber, publicKey: publicKey, privateKey: privateKey}
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	if s.publicKey == "" || s.privateKey == "" {
+		if err := s.loadKeys(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Service) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS push_subscriptions (
+			id SERIAL PRIMARY KEY,
+			endpoint TEXT UNIQUE NOT NULL,
+			p256dh TEXT NOT NULL,
+			auth TEXT NOT NULL,
+			visitor_id TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS push_subscriptions_visitor_id ON push_subscriptions (visitor_id);
+		ALTER TABLE push_subscriptions ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+		CREATE INDEX IF NOT EXISTS push_subscriptions_user_id ON push_subscriptions (user_id);
+	`)
+	return err
+}
+
+func (s *Service) loadKeys(ctx context.Context) error {
+	err := s.db.QueryRowContext(ctx, `
+		SELECT pub.value, priv.value FROM settings pub, settings priv
+		WHERE pub.key = 'vapid_public_key' AND priv.key = 'vapid_private_key'`,
+	).Scan(&s.publicKey, &s.privateKey)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	priv, pub, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return err
+	}
+	// If another instance raced us, keep whichever pair landed first.
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO settings (key, value) VALUES ('vapid_public_key', $1), ('vapid_private_key', $2)
+		ON CONFLICT (key) DO NOTHING`,
+		pub, priv,
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Generated VAPID keys for web push")
+	return s.loadKeys(ctx)
+}
+
+func (s *Service) PublicKey() string {
+	return s.publicKey
+}
+
+func (s *Service) Subscribe(ctx context.Context, sub Subscription, userID int) error {
+	if sub.Endpoint == "" || sub.Keys.P256dh == "" || sub.Keys.Auth == "" {
+		return errors.New("push: incomplete subscription")
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO push_subscriptions (endpoint, p256dh, auth, user_id) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (endpoint) DO UPDATE SET p256dh = $2, auth = $3, user_id = $4`,
+		sub.Endpoint, sub.Keys.P256dh, sub.Keys.Auth, userID,
+	)
+	return err
+}
+
+func (s *Service) Unsubscribe(ctx context.Context, endpoint string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE endpoint = $1", endpoint)
+	return err
+}
+
+// Broadcast sends n to every subscription.
+func (s *Service) Broadcast(ctx context.Context, n Notification) error {
+	return s.send(ctx, n, "SELECT endpoint, p256dh, auth FROM push_subscriptions")
+}
+
+// SendTo sends n to every browser a user subscribed from.
+func (s *Service) SendTo(ctx context.Context, userID int, n Notification) error {
+	return s.send(ctx, n, "SELECT endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = $1", userID)
+}
+
+func (s *Service) send(ctx context.Context, n Notification, query string, args ...any) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.Endpoint, &sub.Keys.P256dh, &sub.Keys.Auth); err != nil {
+			rows.Close()
+			return err
+		}
+		subs = append(subs, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		resp, err := webpush.SendNotificationWithContext(ctx, payload, &sub, &webpush.Options{
+			Subscriber:      s.subscriber,
+			VAPIDPublicKey:  s.publicKey,
+			VAPIDPrivateKey: s.privateKey,
+			TTL:             60 * 60,
+			Topic:           n.Tag,
+		})
+		if err != nil {
+			log.Printf("push to %s failed: %v", sub.Endpoint, err)
+			continue
+		}
+		resp.Body.Close()
+
+		// The browser unsubscribed or the subscription expired.
+		if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+			if err := s.Unsubscribe(ctx, sub.Endpoint); err != nil {
+				return err
+			}
+		} else if resp.StatusCode >= 400 {
+			log.Printf("push to %s rejected: %s", sub.Endpoint, resp.Status)
+		}
+	}
+	return nil
+}
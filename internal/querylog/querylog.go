@@ -0,0 +1,206 @@
+// Package querylog records the SQL a request runs, for the error page
+// shown in development.
+//
+// Connector wraps a database driver so every statement made with a
+// context is timed and added to the Log carried by that context, if there
+// is one. Statements made without one, as by background jobs, aren't
+// recorded. Only the last few statements of a request are kept.
+package querylog
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// keep is how many of a request's most recent statements a Log holds.
+const keep = 50
+
+type Query struct {
+	SQL      string
+	Args     []any
+	Duration time.Duration
+	Err      error
+}
+
+// Log is the statements run for one request, oldest first.
+type Log struct {
+	mu      sync.Mutex
+	queries []Query
+	dropped int
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying a new, empty log.
+func NewContext(ctx context.Context) (context.Context, *Log) {
+	l := &Log{}
+	return context.WithValue(ctx, contextKey{}, l), l
+}
+
+// FromContext returns the log ctx carries, or nil.
+func FromContext(ctx context.Context) *Log {
+	l, _ := ctx.Value(contextKey{}).(*Log)
+	return l
+}
+
+func (l *Log) add(q Query) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.queries) == keep {
+		l.queries = append(l.queries[:0], l.queries[1:]...)
+		l.dropped++
+	}
+	l.queries = append(l.queries, q)
+}
+
+// Queries returns the statements recorded, and how many earlier ones were
+// dropped to make room for them.
+func (l *Log) Queries() ([]Query, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Query(nil), l.queries...), l.dropped
+}
+
+func record(ctx context.Context, query string, args []driver.NamedValue, start time.Time, err error) {
+	l := FromContext(ctx)
+	// ErrSkip has database/sql prepare the statement instead, which is
+	// recorded when it runs.
+	if l == nil || errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	q := Query{SQL: query, Duration: time.Since(start), Err: err}
+	for _, a := range args {
+		q.Args = append(q.Args, a.Value)
+	}
+	l.add(q)
+}
+
+// Connector wraps c so the statements its connections run are recorded.
+func Connector(c driver.Connector) driver.Connector {
+	return connector{c}
+}
+
+type connector struct {
+	driver.Connector
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{cn}, nil
+}
+
+// conn passes everything through to the driver's connection, recording
+// the statements on the way.
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	record(ctx, query, args, start, err)
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	record(ctx, query, args, start, err)
+	return res, err
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var st driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = p.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{st, query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+type stmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args))
+	}
+	record(ctx, s.query, args, start, err)
+	return rows, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args))
+	}
+	record(ctx, s.query, args, start, err)
+	return res, err
+}
+
+// values is args for statements that predate contexts, like lib/pq's COPY.
+func values(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a.Value
+	}
+	return out
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	start    time.Time
+	current  int
+	previous int
+	window   time.Duration
+}
+
+// Memory keeps counters in process. Limits are per instance, so with N
+// replicas a caller effectively gets N times the quota.
+type Memory struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	swept    time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{counters: make(map[string]*counter)}
+}
+
+func (m *Memory) Hit(_ context.Context, key string, start time.Time, window time.Duration) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.counters[key]
+	switch {
+	case !ok:
+		c = &counter{start: start, window: window}
+		m.counters[key] = c
+	case c.start.Equal(start):
+	case c.start.Add(window).Equal(start):
+		c.start, c.previous, c.current = start, c.current, 0
+	default:
+		c.start, c.previous, c.current = start, 0, 0
+	}
+	c.current++
+
+	m.sweep(start)
+	return c.current, c.previous, nil
+}
+
+// sweep drops counters that no longer affect any window, at most once a
+// minute.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, c := range m.counters {
+		if now.Sub(c.start) > 2*c.window {
+			delete(m.counters, key)
+		}
+	}
+}
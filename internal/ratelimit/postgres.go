@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Postgres keeps counters in a table so limits hold across replicas and
+// restarts.
+type Postgres struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	swept time.Time
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Migrate(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS rate_limit_counters (
+			key TEXT NOT NULL,
+			window_start TIMESTAMPTZ NOT NULL,
+			count INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (key, window_start)
+		);
+		CREATE INDEX IF NOT EXISTS rate_limit_counters_expires_at ON rate_limit_counters (expires_at);
+	`)
+	return err
+}
+
+func (p *Postgres) Hit(ctx context.Context, key string, start time.Time, window time.Duration) (int, int, error) {
+	var current, previous int
+	err := p.db.QueryRowContext(ctx, `
+		WITH hit AS (
+			INSERT INTO rate_limit_counters (key, window_start, count, expires_at)
+			VALUES ($1, $2, 1, $4)
+			ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limit_counters.count + 1
+			RETURNING count
+		)
+		SELECT hit.count, COALESCE((
+			SELECT count FROM rate_limit_counters WHERE key = $1 AND window_start = $3
+		), 0)
+		FROM hit`,
+		key, start, start.Add(-window), start.Add(2*window),
+	).Scan(&current, &previous)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	p.mu.Lock()
+	if time.Since(p.swept) > time.Minute {
+		p.swept = time.Now()
+		go p.db.Exec("DELETE FROM rate_limit_counters WHERE expires_at < NOW()")
+	}
+	p.mu.Unlock()
+	return current, previous, nil
+}
@@ -0,0 +1,191 @@
+// Package ratelimit enforces request quotas per route group and plan using a
+// sliding window counter.
+//
+// Each (group, plan) pair maps to a Policy. The window is approximated from
+// the current and previous fixed windows, weighting the previous count by how
+// much of it still overlaps the sliding window. That needs two counters per
+// key instead of a log of every request, so it works the same in memory and
+// in Postgres.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Plan string
+
+const (
+	Anonymous Plan = "anonymous"
+	Free      Plan = "free"
+	Paid      Plan = "paid"
+)
+
+type Policy struct {
+	Limit  int
+	Window time.Duration
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("%d/%s", p.Limit, p.Window)
+}
+
+// Config maps "group:plan" to the policy that applies.
+type Config map[string]Policy
+
+// DefaultConfig is used for any group/plan not overridden by ParseConfig.
+func DefaultConfig() Config {
+	return Config{
+		"ui:anonymous":  {Limit: 300, Window: time.Minute},
+		"ui:free":       {Limit: 600, Window: time.Minute},
+		"ui:paid":       {Limit: 1200, Window: time.Minute},
+		"api:anonymous": {Limit: 60, Window: time.Minute},
+		"api:free":      {Limit: 300, Window: time.Minute},
+		"api:paid":      {Limit: 3000, Window: time.Minute},
+		// Support tickets email every admin, so they're kept to a few.
+		"support:free": {Limit: 5, Window: time.Hour},
+	}
+}
+
+// ParseConfig overlays a spec like "api:free=300/m, ui:anonymous=100/s" onto
+// the defaults. Windows are s, m, h or any time.ParseDuration string.
+func ParseConfig(spec string) (Config, error) {
+	cfg := DefaultConfig()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, rule, ok := strings.Cut(entry, "=")
+		if !ok || !strings.Contains(key, ":") {
+			return nil, fmt.Errorf("rate limit %q: want group:plan=limit/window", entry)
+		}
+		limit, window, ok := strings.Cut(rule, "/")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: missing window", entry)
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("rate limit %q: bad limit", entry)
+		}
+		d, err := parseWindow(window)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", entry, err)
+		}
+		cfg[strings.TrimSpace(key)] = Policy{Limit: n, Window: d}
+	}
+	return cfg, nil
+}
+
+func parseWindow(s string) (time.Duration, error) {
+	switch s {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad window %q", s)
+	}
+	return d, nil
+}
+
+// Store counts hits per key in fixed windows.
+type Store interface {
+	// Hit records a request for key in the window starting at start and
+	// returns the counts of that window and the one before it.
+	Hit(ctx context.Context, key string, start time.Time, window time.Duration) (current, previous int, err error)
+}
+
+type Result struct {
+	Policy    Policy
+	Remaining int
+	Reset     time.Duration
+	Allowed   bool
+}
+
+type Limiter struct {
+	Store Store
+	now   func() time.Time
+
+	mu     sync.RWMutex
+	config Config
+}
+
+func New(cfg Config, store Store) *Limiter {
+	return &Limiter{config: cfg, Store: store, now: time.Now}
+}
+
+// SetConfig replaces the policies, for requests from then on. Counts so
+// far are kept.
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	l.config = cfg
+	l.mu.Unlock()
+}
+
+// Allow records a request from key against the group/plan policy. Groups
+// without a policy are unlimited.
+func (l *Limiter) Allow(ctx context.Context, group string, plan Plan, key string) (Result, bool, error) {
+	l.mu.RLock()
+	policy, ok := l.config[group+":"+string(plan)]
+	l.mu.RUnlock()
+	if !ok {
+		return Result{}, false, nil
+	}
+
+	now := l.now()
+	start := now.Truncate(policy.Window)
+	current, previous, err := l.Store.Hit(ctx, group+":"+string(plan)+":"+key, start, policy.Window)
+	if err != nil {
+		return Result{}, true, err
+	}
+
+	overlap := 1 - float64(now.Sub(start))/float64(policy.Window)
+	estimate := int(math.Ceil(float64(previous)*overlap)) + current
+
+	res := Result{
+		Policy:    policy,
+		Remaining: max(policy.Limit-estimate, 0),
+		Reset:     start.Add(policy.Window).Sub(now),
+		Allowed:   estimate <= policy.Limit,
+	}
+	return res, true, nil
+}
+
+// Middleware limits requests in group. plan and key identify the caller, e.g.
+// by account tier and user ID, or Anonymous and client IP. If the store
+// fails, requests are let through rather than taking the site down.
+func (l *Limiter) Middleware(group string, plan func(*http.Request) Plan, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, limited, err := l.Allow(r.Context(), group, plan(r), key(r))
+			if err != nil || !limited {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reset := int(math.Ceil(res.Reset.Seconds()))
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(res.Policy.Limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("X-RateLimit-Reset", strconv.Itoa(reset))
+
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(reset))
+				http.Error(w, "Too many requests, slow down a little", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
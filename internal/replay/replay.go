@@ -0,0 +1,120 @@
+// Package replay keeps recent requests in memory so they can be looked at,
+// and run again, when chasing down an error that only happens now and
+// then.
+//
+// A Buffer holds the latest requests added to it, up to the size it's made
+// with, dropping the oldest first. What's kept of a request, and which
+// requests are kept, is up to the caller: this package only stores them
+// and turns them back into requests.
+package replay
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Request struct {
+	ID        int64
+	At        time.Time
+	RequestID string
+	Method    string
+	// URL is the path and query string.
+	URL    string
+	Header http.Header
+	// Form is the urlencoded body. Other bodies aren't kept, and
+	// BodyDropped says one was sent.
+	Form        url.Values
+	BodyDropped bool
+	// UserID is who was logged in, or zero.
+	UserID   int
+	Status   int
+	Duration time.Duration
+}
+
+// Safe reports whether running the request again shouldn't change
+// anything.
+func (req Request) Safe() bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// NewRequest rebuilds the request, to be served again.
+func (req Request) NewRequest(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if req.Form != nil {
+		body = strings.NewReader(req.Form.Encode())
+	}
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	if req.Form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.RemoteAddr = "127.0.0.1:0"
+	return r, nil
+}
+
+type Buffer struct {
+	mu   sync.Mutex
+	size int
+	next int64
+	// reqs is a ring; once full, start is the oldest.
+	reqs  []Request
+	start int
+}
+
+// New returns a buffer keeping the last size requests.
+func New(size int) *Buffer {
+	return &Buffer{size: size}
+}
+
+// Add keeps req, dropping the oldest request if the buffer is full, and
+// returns it with its ID.
+func (b *Buffer) Add(req Request) Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size == 0 {
+		return req
+	}
+	b.next++
+	req.ID = b.next
+	if len(b.reqs) < b.size {
+		b.reqs = append(b.reqs, req)
+	} else {
+		b.reqs[b.start] = req
+		b.start = (b.start + 1) % b.size
+	}
+	return req
+}
+
+// Recent returns the requests kept, newest first.
+func (b *Buffer) Recent() []Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]Request, 0, len(b.reqs))
+	for i := len(b.reqs) - 1; i >= 0; i-- {
+		out = append(out, b.reqs[(b.start+i)%len(b.reqs)])
+	}
+	return out
+}
+
+// Get returns the request with id, if it's still kept.
+func (b *Buffer) Get(id int64) (Request, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, req := range b.reqs {
+		if req.ID == id {
+			return req, true
+		}
+	}
+	return Request{}, false
+}
@@ -0,0 +1,94 @@
+// Package search holds the Go side of todo search: parsing queries into
+// text and operators, splitting the text into terms and highlighting where
+// they matched.
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Segment is a run of text that either matched a search term or didn't.
+type Segment struct {
+	Text  string
+	Match bool
+}
+
+// Terms splits a query into lowercased words.
+func Terms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), isSeparator)
+}
+
+// Highlight splits text into segments, marking words that contain a term or
+// are a close trigram match for one, so typo-tolerant results still show the
+// user why they matched.
+func Highlight(text string, terms []string) []Segment {
+	var segments []Segment
+	add := func(s string, match bool) {
+		if n := len(segments); n > 0 && segments[n-1].Match == match {
+			segments[n-1].Text += s
+			return
+		}
+		segments = append(segments, Segment{Text: s, Match: match})
+	}
+
+	start := 0
+	inWord := false
+	for i, r := range text {
+		sep := isSeparator(r)
+		switch {
+		case sep && inWord:
+			add(text[start:i], matches(text[start:i], terms))
+			start, inWord = i, false
+		case !sep && !inWord:
+			if i > start {
+				add(text[start:i], false)
+			}
+			start, inWord = i, true
+		}
+	}
+	if start < len(text) {
+		add(text[start:], inWord && matches(text[start:], terms))
+	}
+	return segments
+}
+
+func matches(word string, terms []string) bool {
+	word = strings.ToLower(word)
+	for _, t := range terms {
+		if strings.Contains(word, t) || Similarity(word, t) >= 0.25 {
+			return true
+		}
+	}
+	return false
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Similarity is the trigram similarity of two words, computed the way
+// pg_trgm does: each word is padded with two spaces in front and one behind,
+// and the result is shared trigrams over distinct trigrams.
+func Similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(word string) map[string]bool {
+	r := []rune("  " + strings.ToLower(word) + " ")
+	set := make(map[string]bool)
+	for i := 0; i+3 <= len(r); i++ {
+		set[string(r[i:i+3])] = true
+	}
+	return set
+}
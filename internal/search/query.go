@@ -0,0 +1,140 @@
+package search
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Operator is a key:value filter in a query, like is:overdue, due:<friday
+// or list:"groceries". Op is the comparison put before the value, if any.
+type Operator struct {
+	Key   string
+	Op    string
+	Value string
+	// Raw is the operator as it was typed.
+	Raw string
+}
+
+// Query is a search split into the text to look for and the operators
+// that narrow it.
+type Query struct {
+	Text      string
+	Operators []Operator
+}
+
+// comparisons an operator's value can start with, longest first.
+var comparisons = []string{"<=", ">=", "<", ">", "="}
+
+// Keys are the operators every search understands. A list's custom fields
+// add their own, named by FieldKey.
+var Keys = []string{"is", "due", "list"}
+
+// FieldKey is how a custom field is named in an operator: its name in
+// lower case without spaces, dashes or underscores, so priority:high and
+// due-soon:yes both work.
+func FieldKey(name string) string {
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(name))
+}
+
+// Parse splits q into text and operators. An operator is one of Keys or
+// one of fields, the names of the list's custom fields, then a colon and a
+// value, which can be quoted to hold spaces. Everything else is text,
+// quoted phrases and words that only look like operators included, like
+// http://example.com or 10:30, so it can go to the full-text search as it
+// is.
+func Parse(q string, fields ...string) Query {
+	known := make(map[string]bool, len(Keys)+len(fields))
+	for _, k := range Keys {
+		known[k] = true
+	}
+	for _, f := range fields {
+		known[FieldKey(f)] = true
+	}
+
+	var query Query
+	var text []string
+	for rest := strings.TrimSpace(q); rest != ""; rest = strings.TrimLeftFunc(rest, unicode.IsSpace) {
+		if op, n, ok := parseOperator(rest, known); ok {
+			query.Operators = append(query.Operators, op)
+			rest = rest[n:]
+			continue
+		}
+		n := tokenEnd(rest)
+		text = append(text, rest[:n])
+		rest = rest[n:]
+	}
+	query.Text = strings.Join(text, " ")
+	return query
+}
+
+// parseOperator reads an operator from the start of s, returning it and
+// how much of s it took. Its key, as FieldKey names it, has to be known.
+func parseOperator(s string, known map[string]bool) (Operator, int, bool) {
+	colon := strings.IndexByte(s, ':')
+	if colon < 1 {
+		return Operator{}, 0, false
+	}
+	for _, r := range s[:colon] {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return Operator{}, 0, false
+		}
+	}
+	if !known[FieldKey(s[:colon])] {
+		return Operator{}, 0, false
+	}
+
+	op := Operator{Key: strings.ToLower(s[:colon])}
+	i := colon + 1
+	for _, c := range comparisons {
+		if strings.HasPrefix(s[i:], c) {
+			op.Op = c
+			i += len(c)
+			break
+		}
+	}
+	n := i + tokenEnd(s[i:])
+	op.Value = strings.Trim(s[i:n], `"`)
+	if op.Value == "" {
+		return Operator{}, 0, false
+	}
+	op.Raw = s[:n]
+	return op, n, true
+}
+
+// tokenEnd is where the word or quoted phrase at the start of s ends.
+func tokenEnd(s string) int {
+	if strings.HasPrefix(s, `"`) {
+		if end := strings.IndexByte(s[1:], '"'); end >= 0 {
+			return end + 2
+		}
+		return len(s)
+	}
+	if end := strings.IndexFunc(s, unicode.IsSpace); end >= 0 {
+		return end
+	}
+	return len(s)
+}
+
+// Date reads a date in an operator: YYYY-MM-DD, today, tomorrow,
+// yesterday, or a weekday like friday or fri, meaning the next one from
+// today on.
+func Date(value string, today time.Time) (time.Time, bool) {
+	switch v := strings.ToLower(value); v {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), true
+	default:
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := strings.ToLower(d.String())
+			if v == name || len(v) >= 3 && strings.HasPrefix(name, v) {
+				return today.AddDate(0, 0, (int(d)-int(today.Weekday())+7)%7), true
+			}
+		}
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, today.Location())
+	return t, err == nil
+}
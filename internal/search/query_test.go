@@ -0,0 +1,132 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q      string
+		fields []string
+		want   Query
+	}{
+		{q: "", want: Query{}},
+		{q: "  milk  and eggs ", want: Query{Text: "milk and eggs"}},
+		{
+			q:    "milk is:open due:<friday",
+			want: Query{Text: "milk", Operators: []Operator{{Key: "is", Value: "open", Raw: "is:open"}, {Key: "due", Op: "<", Value: "friday", Raw: "due:<friday"}}},
+		},
+		{
+			q:    `list:"weekend chores" "fix the sink"`,
+			want: Query{Text: `"fix the sink"`, Operators: []Operator{{Key: "list", Value: "weekend chores", Raw: `list:"weekend chores"`}}},
+		},
+		{
+			q:    "IS:Done",
+			want: Query{Operators: []Operator{{Key: "is", Value: "Done", Raw: "IS:Done"}}},
+		},
+		{
+			q:      "priority:high points:>=3 due-soon:yes",
+			fields: []string{"Priority", "Points", "Due soon"},
+			want: Query{Operators: []Operator{
+				{Key: "priority", Value: "high", Raw: "priority:high"},
+				{Key: "points", Op: ">=", Value: "3", Raw: "points:>=3"},
+				{Key: "due-soon", Value: "yes", Raw: "due-soon:yes"},
+			}},
+		},
+		{q: "priority:high", want: Query{Text: "priority:high"}},
+		{q: "see http://example.com/a?b=c", want: Query{Text: "see http://example.com/a?b=c"}},
+		{q: "call at 10:30", want: Query{Text: "call at 10:30"}},
+		{q: "re: invoice", want: Query{Text: "re: invoice"}},
+		{q: "is: open", want: Query{Text: "is: open"}},
+		{q: `due:"" soon`, want: Query{Text: `due:"" soon`}},
+		{
+			q:    `list:"unclosed quote`,
+			want: Query{Operators: []Operator{{Key: "list", Value: "unclosed quote", Raw: `list:"unclosed quote`}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := Parse(tt.q, tt.fields...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q, %q) = %+v, want %+v", tt.q, tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOperator(t *testing.T) {
+	known := map[string]bool{"is": true, "due": true, "list": true, "priority": true, "duesoon": true}
+	tests := []struct {
+		s    string
+		want Operator
+		n    int
+		ok   bool
+	}{
+		{s: "is:open rest", want: Operator{Key: "is", Value: "open", Raw: "is:open"}, n: 7, ok: true},
+		{s: "due:<=2026-01-31", want: Operator{Key: "due", Op: "<=", Value: "2026-01-31", Raw: "due:<=2026-01-31"}, n: 16, ok: true},
+		{s: "due:=today", want: Operator{Key: "due", Op: "=", Value: "today", Raw: "due:=today"}, n: 10, ok: true},
+		{s: `list:"a b" c`, want: Operator{Key: "list", Value: "a b", Raw: `list:"a b"`}, n: 10, ok: true},
+		{s: "Priority:High", want: Operator{Key: "priority", Value: "High", Raw: "Priority:High"}, n: 13, ok: true},
+		{s: "due_soon:yes", want: Operator{Key: "due_soon", Value: "yes", Raw: "due_soon:yes"}, n: 12, ok: true},
+		{s: "http://example.com"},
+		{s: "mailto:ann@example.com"},
+		{s: "10:30"},
+		{s: "tag:home"},
+		{s: ":open"},
+		{s: "is:"},
+		{s: "due:<"},
+		{s: "is :open"},
+		{s: "no colon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, n, ok := parseOperator(tt.s, known)
+			if got != tt.want || n != tt.n || ok != tt.ok {
+				t.Errorf("parseOperator(%q) = %+v, %d, %v, want %+v, %d, %v", tt.s, got, n, ok, tt.want, tt.n, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDate(t *testing.T) {
+	// A Wednesday.
+	today := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"today", today, true},
+		{"Today", today, true},
+		{"tomorrow", day(15), true},
+		{"yesterday", day(13), true},
+		{"wednesday", today, true},
+		{"thursday", day(15), true},
+		{"fri", day(16), true},
+		{"tue", day(20), true},
+		{"Sunday", day(18), true},
+		{"2026-12-25", time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC), true},
+		{"mo", time.Time{}, false},
+		{"2026-13-01", time.Time{}, false},
+		{"12/25", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := Date(tt.value, today)
+			if !got.Equal(tt.want) || ok != tt.ok {
+				t.Errorf("Date(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDateKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+13", 13*60*60)
+	got, ok := Date("2026-01-01", time.Date(2025, 12, 31, 0, 0, 0, 0, loc))
+	if !ok || got.Location() != loc || got.Day() != 1 {
+		t.Errorf("Date in %s = %v, %v", loc, got, ok)
+	}
+}
@@ -0,0 +1,184 @@
+// Package secretbox seals secrets the app has to keep in the database and
+// use again later, like a user's credentials for their storage bucket or a
+// tracker's webhook secret.
+//
+// Secrets are sealed with AES-256-GCM under a key from the environment, so
+// a copy of the database alone, a backup say, doesn't give them away. A
+// sealed value starts with "sb1:" and the ID of the key it was sealed
+// with, then its random nonce and the ciphertext, base64-encoded. Keeping
+// the key ID lets a box hold old keys next to the current one, so values
+// can still be opened while Rotate reseals them under the new key.
+package secretbox
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOpen is returned for values that weren't sealed with any of the box's
+// keys, or have been tampered with.
+var ErrOpen = errors.New("secretbox: can't open sealed value")
+
+// prefix starts every sealed value, telling it apart from plaintext stored
+// before a column was sealed.
+const prefix = "sb1:"
+
+type Box struct {
+	current string
+	keys    map[string]cipher.AEAD
+	// order is the key IDs, current first, for values without one.
+	order []string
+}
+
+// New returns a box sealing with key and opening with it or any of old.
+// Keys are 32 bytes.
+func New(key []byte, old ...[]byte) (*Box, error) {
+	b := &Box{keys: map[string]cipher.AEAD{}}
+	for _, k := range append([][]byte{key}, old...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyID(k)
+		if _, dup := b.keys[id]; dup {
+			continue
+		}
+		b.keys[id] = aead
+		b.order = append(b.order, id)
+	}
+	b.current = b.order[0]
+	return b, nil
+}
+
+// KeyID is the short, public name of a key that its sealed values carry.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// IsSealed reports whether v was sealed by a box, rather than being
+// plaintext.
+func IsSealed(v string) bool {
+	return strings.HasPrefix(v, prefix)
+}
+
+// Current reports whether v is sealed under the box's current key, so
+// rotating it would change nothing.
+func (b *Box) Current(v string) bool {
+	return strings.HasPrefix(v, prefix+b.current+":")
+}
+
+// Seal encrypts plaintext under the current key, for storing as text.
+func (b *Box) Seal(plaintext []byte) (string, error) {
+	aead := b.keys[b.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return prefix + b.current + ":" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Open decrypts a value from Seal. Values sealed before they carried a key
+// ID are tried with each key in turn.
+func (b *Box) Open(sealed string) ([]byte, error) {
+	ids, data := b.order, sealed
+	if rest, ok := strings.CutPrefix(sealed, prefix); ok {
+		id, enc, _ := strings.Cut(rest, ":")
+		ids, data = []string{id}, enc
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	for _, id := range ids {
+		aead, ok := b.keys[id]
+		if !ok || len(raw) < aead.NonceSize() {
+			continue
+		}
+		n := aead.NonceSize()
+		if plaintext, err := aead.Open(nil, raw[:n], raw[n:], nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrOpen
+}
+
+// Column is a table's column of sealed values, with the primary key its
+// rows are updated by. Plaintext is set for columns that held plaintext
+// before they were sealed, whose unsealed values Rotate seals as they are.
+type Column struct {
+	Table     string
+	Key       string
+	Name      string
+	Plaintext bool
+}
+
+func (c Column) String() string {
+	return c.Table + "." + c.Name
+}
+
+// Rotate reseals the column's values that aren't sealed under the current
+// key, in one transaction, and returns how many it changed. Empty values
+// are left alone.
+func (b *Box) Rotate(ctx context.Context, db *sql.DB, c Column) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s <> '' FOR UPDATE", c.Key, c.Name, c.Table, c.Name))
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		key   any
+		value string
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.key, &r.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !b.Current(r.value) {
+			stale = append(stale, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	update := fmt.Sprintf("UPDATE %s SET %s = $2 WHERE %s = $1", c.Table, c.Name, c.Key)
+	for _, r := range stale {
+		plaintext := []byte(r.value)
+		if IsSealed(r.value) || !c.Plaintext {
+			if plaintext, err = b.Open(r.value); err != nil {
+				return 0, fmt.Errorf("%s %v: %w", c, r.key, err)
+			}
+		}
+		sealed, err := b.Seal(plaintext)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, update, r.key, sealed); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
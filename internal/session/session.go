@@ -0,0 +1,216 @@
+// Package session keeps login sessions in Postgres, so they survive restarts
+// and can be revoked server-side.
+//
+// The browser only ever holds a random token; the table stores its SHA-256
+// hash. Sessions slide forward on use up to an absolute maximum lifetime, after
+// which the user has to sign in again however active they are.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned for tokens that are unknown, expired or revoked.
+var ErrNotFound = errors.New("session not found")
+
+type Session struct {
+	ID         int64
+	UserID     int
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	UserAgent  string
+	IP         string
+	// Impersonating is the user an admin's session is acting as until
+	// ImpersonationEnds, or 0.
+	Impersonating     int
+	ImpersonationEnds time.Time
+}
+
+// columns are a Session's, with an impersonation that has run out read
+// as none.
+const columns = `id, user_id, created_at, last_seen_at, expires_at, user_agent, ip,
+	CASE WHEN impersonation_ends_at > NOW() THEN impersonating ELSE 0 END,
+	CASE WHEN impersonation_ends_at > NOW() THEN impersonation_ends_at ELSE 'epoch' END`
+
+func scan(row interface{ Scan(...any) error }) (Session, error) {
+	var sess Session
+	err := row.Scan(&sess.ID, &sess.UserID, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt, &sess.UserAgent, &sess.IP,
+		&sess.Impersonating, &sess.ImpersonationEnds)
+	return sess, err
+}
+
+type Store struct {
+	db *sql.DB
+	// IdleTimeout is how long a session lives without being used.
+	IdleTimeout time.Duration
+	// MaxLifetime caps a session's age no matter how often it is used.
+	MaxLifetime time.Duration
+}
+
+func NewStore(db *sql.DB, idleTimeout, maxLifetime time.Duration) *Store {
+	return &Store{db: db, IdleTimeout: idleTimeout, MaxLifetime: maxLifetime}
+}
+
+// Migrate creates the sessions table. It references users, so run it after
+// the users table exists.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id BIGSERIAL PRIMARY KEY,
+			token_hash BYTEA NOT NULL UNIQUE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id);
+		CREATE INDEX IF NOT EXISTS sessions_expires_at ON sessions (expires_at);
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonating INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonation_ends_at TIMESTAMPTZ;
+	`)
+	return err
+}
+
+func hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Create starts a session for userID and returns the token to hand to the
+// browser.
+func (s *Store) Create(ctx context.Context, userID int, userAgent, ip string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err := s.db.ExecContext(ctx, `
+		WITH s AS (
+			INSERT INTO sessions (token_hash, user_id, expires_at, user_agent, ip)
+			VALUES ($1, $2, NOW() + make_interval(secs => $3), $4, $5)
+			RETURNING user_id
+		)
+		UPDATE users SET last_active_at = NOW() FROM s WHERE users.id = s.user_id`,
+		hash(token), userID, s.IdleTimeout.Seconds(), userAgent, ip,
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Load returns the live session for token and pushes its expiry forward,
+// along with when its user was last active. They're only written back once
+// a minute per session, so busy pages don't turn every request into an
+// UPDATE.
+func (s *Store) Load(ctx context.Context, token string) (Session, error) {
+	sess, err := scan(s.db.QueryRowContext(ctx,
+		"SELECT "+columns+" FROM sessions WHERE token_hash = $1 AND expires_at > NOW()",
+		hash(token),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return sess, ErrNotFound
+	}
+	if err != nil {
+		return sess, err
+	}
+
+	if time.Since(sess.LastSeenAt) < time.Minute {
+		return sess, nil
+	}
+	err = s.db.QueryRowContext(ctx, `
+		WITH s AS (
+			UPDATE sessions SET
+				last_seen_at = NOW(),
+				expires_at = LEAST(NOW() + make_interval(secs => $2), created_at + make_interval(secs => $3))
+			WHERE id = $1
+			RETURNING user_id, last_seen_at, expires_at
+		), u AS (
+			UPDATE users SET last_active_at = NOW() FROM s WHERE users.id = s.user_id
+		)
+		SELECT last_seen_at, expires_at FROM s`,
+		sess.ID, s.IdleTimeout.Seconds(), s.MaxLifetime.Seconds(),
+	).Scan(&sess.LastSeenAt, &sess.ExpiresAt)
+	return sess, err
+}
+
+// List returns a user's live sessions, most recently used first.
+func (s *Store) List(ctx context.Context, userID int) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+columns+" FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY last_seen_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		sess, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// Destroy ends the session behind token, as on logout.
+func (s *Store) Destroy(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = $1", hash(token))
+	return err
+}
+
+// Revoke ends one of userID's sessions by ID. Scoping by user keeps one user
+// from revoking another's sessions by guessing IDs.
+func (s *Store) Revoke(ctx context.Context, userID int, id int64) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeOthers ends every session of userID except keep, as after a password
+// change.
+func (s *Store) RevokeOthers(ctx context.Context, userID int, keep int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userID, keep)
+	return err
+}
+
+// Impersonate makes session id act as userID until until. Only the
+// session changes: the admin's other devices carry on as themselves.
+func (s *Store) Impersonate(ctx context.Context, id int64, userID int, until time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET impersonating = $2, impersonation_ends_at = $3 WHERE id = $1",
+		id, userID, until,
+	)
+	return err
+}
+
+// StopImpersonating returns session id to its own user.
+func (s *Store) StopImpersonating(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET impersonating = 0, impersonation_ends_at = NULL WHERE id = $1", id)
+	return err
+}
+
+// Cleanup deletes expired sessions.
+func (s *Store) Cleanup(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= NOW()")
+	return err
+}
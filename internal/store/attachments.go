@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+const (
+	attachmentColumns = "id, todo_id, filename, content_type, size, created_at, transcript_status"
+
+	listAttachments  = "SELECT " + attachmentColumns + " FROM attachments WHERE todo_id = $1 ORDER BY id"
+	getAttachment    = "SELECT " + attachmentColumns + " FROM attachments WHERE id = $1"
+	attachmentData   = "SELECT data FROM attachments WHERE id = $1"
+	attachmentListID = "SELECT t.list_id FROM attachments a JOIN todos t ON t.id = a.todo_id WHERE a.id = $1"
+	createAttachment = `
+		INSERT INTO attachments (todo_id, filename, content_type, size, data, transcript_status)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	copyAttachments = `
+		INSERT INTO attachments (todo_id, filename, content_type, size, data, thumbnail, thumbnail_type, created_at)
+		SELECT $2, filename, content_type, size, data, thumbnail, thumbnail_type, created_at
+		FROM attachments WHERE todo_id = $1 ORDER BY id`
+	deleteAttachment       = "DELETE FROM attachments WHERE id = $1"
+	attachmentThumbnail    = "SELECT thumbnail, COALESCE(thumbnail_type, '') FROM attachments WHERE id = $1"
+	setAttachmentThumbnail = "UPDATE attachments SET thumbnail = $2, thumbnail_type = $3 WHERE id = $1"
+	// A claim left running past the timeout is from a worker that died
+	// partway, so it's taken over.
+	claimTranscription = `
+		UPDATE attachments SET transcript_status = 'running', transcript_claimed_at = NOW(),
+			transcript_attempts = transcript_attempts + 1
+		WHERE id = (
+			SELECT id FROM attachments
+			WHERE transcript_status = 'pending'
+			   OR (transcript_status = 'running' AND transcript_claimed_at < NOW() - make_interval(secs => $1::float8))
+			ORDER BY id LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + attachmentColumns + `, transcript_attempts, data`
+	finishTranscription = "UPDATE attachments SET transcript_status = $2, transcript_error = $3 WHERE id = $1"
+	appendDescription   = `
+		UPDATE todos SET description = CASE WHEN description = '' THEN $2 ELSE description || E'\n\n' || $2 END
+		WHERE id = $1`
+)
+
+func scanAttachment(row interface{ Scan(...any) error }) (model.Attachment, error) {
+	var a model.Attachment
+	err := row.Scan(attachmentFields(&a)...)
+	return a, err
+}
+
+func attachmentFields(a *model.Attachment) []any {
+	return []any{&a.ID, &a.TodoID, &a.Filename, &a.ContentType, &a.Size, &a.CreatedAt, &a.TranscriptStatus}
+}
+
+// ListAttachments returns a todo's attachments, oldest first.
+func (q *Queries) ListAttachments(ctx context.Context, todoID int) ([]model.Attachment, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listAttachments, todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []model.Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func (q *Queries) GetAttachment(ctx context.Context, id int) (model.Attachment, error) {
+	return scanAttachment(q.conn(ctx).QueryRowContext(ctx, getAttachment, id))
+}
+
+// AttachmentData returns an attachment's contents.
+func (q *Queries) AttachmentData(ctx context.Context, id int) ([]byte, error) {
+	var data []byte
+	err := q.conn(ctx).QueryRowContext(ctx, attachmentData, id).Scan(&data)
+	return data, err
+}
+
+// AttachmentListID returns the list an attachment's todo is on, for access
+// checks.
+func (q *Queries) AttachmentListID(ctx context.Context, id int) (int, error) {
+	var listID int
+	err := q.conn(ctx).QueryRowContext(ctx, attachmentListID, id).Scan(&listID)
+	return listID, err
+}
+
+// CreateAttachment stores a file on a todo and returns its ID. A voice note
+// to transcribe should come with a TranscriptStatus of pending.
+func (q *Queries) CreateAttachment(ctx context.Context, a model.Attachment, data []byte) (int, error) {
+	var id int
+	err := q.conn(ctx).QueryRowContext(ctx, createAttachment, a.TodoID, a.Filename, a.ContentType, len(data), data, a.TranscriptStatus).Scan(&id)
+	return id, err
+}
+
+// CopyAttachments gives todo to a copy of each of from's attachments.
+func (q *Queries) CopyAttachments(ctx context.Context, from, to int) error {
+	_, err := q.conn(ctx).ExecContext(ctx, copyAttachments, from, to)
+	return err
+}
+
+func (q *Queries) DeleteAttachment(ctx context.Context, id int) error {
+	_, err := q.conn(ctx).ExecContext(ctx, deleteAttachment, id)
+	return err
+}
+
+// AttachmentThumbnail returns an attachment's cached thumbnail and its
+// content type, or nil if none has been made yet.
+func (q *Queries) AttachmentThumbnail(ctx context.Context, id int) ([]byte, string, error) {
+	var data []byte
+	var contentType string
+	err := q.conn(ctx).QueryRowContext(ctx, attachmentThumbnail, id).Scan(&data, &contentType)
+	return data, contentType, err
+}
+
+// SetAttachmentThumbnail caches the thumbnail made for an attachment.
+func (q *Queries) SetAttachmentThumbnail(ctx context.Context, id int, data []byte, contentType string) error {
+	_, err := q.conn(ctx).ExecContext(ctx, setAttachmentThumbnail, id, data, contentType)
+	return err
+}
+
+// ClaimTranscription takes the oldest voice note waiting to be transcribed,
+// or one whose claim is older than timeout, and marks it running. It
+// returns the attachment, how many times it has now been tried and its
+// contents, or sql.ErrNoRows when there's nothing to do.
+func (q *Queries) ClaimTranscription(ctx context.Context, timeout time.Duration) (model.Attachment, int, []byte, error) {
+	var a model.Attachment
+	var attempts int
+	var data []byte
+	err := q.conn(ctx).QueryRowContext(ctx, claimTranscription, timeout.Seconds()).
+		Scan(append(attachmentFields(&a), &attempts, &data)...)
+	return a, attempts, data, err
+}
+
+// FinishTranscription records how a claimed transcription went: done,
+// failed with the reason, or pending to be tried again.
+func (q *Queries) FinishTranscription(ctx context.Context, id int, status, reason string) error {
+	_, err := q.conn(ctx).ExecContext(ctx, finishTranscription, id, status, reason)
+	return err
+}
+
+// AppendDescription adds a paragraph to the end of a todo's description.
+// It's done in the database so an edit made meanwhile isn't lost.
+func (q *Queries) AppendDescription(ctx context.Context, id int, text string) error {
+	_, err := q.conn(ctx).ExecContext(ctx, appendDescription, id, text)
+	return err
+}
@@ -0,0 +1,980 @@
+// Package store is the app's Postgres layer: the schema and its migrations,
+// and the typed queries for todos, lists and their members.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Trailblazors/htmx-go-postgres/internal/clock"
+	"github.com/Trailblazors/htmx-go-postgres/internal/model"
+)
+
+// DBTX is what Queries runs against: the pool or a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Queries is the typed query layer for todos, lists and their members. Each
+// method runs one statement and returns Go values, so handlers don't write
+// SQL or scan rows. Every statement is listed in statements and prepared by
+// checkStatements at startup, so a query that no longer matches the schema
+// stops the app from booting rather than failing a request later.
+//
+// Queries made from the pool join the transaction carried by ctx when there
+// is one (see ContextWithTx), so code doesn't need to know whether it's
+// running inside one.
+type Queries struct {
+	db    DBTX
+	tx    bool
+	clock clock.Clock
+}
+
+// NewQueries runs queries against db, ageing the todos they read by c's
+// time.
+func NewQueries(db DBTX, c clock.Clock) *Queries {
+	return &Queries{db: db, clock: c}
+}
+
+// WithTx runs the same queries inside tx.
+func (q *Queries) WithTx(tx *sql.Tx) *Queries {
+	return &Queries{db: tx, tx: true, clock: q.clock}
+}
+
+// now is the time todos are aged by, as a query argument.
+func (q *Queries) now() time.Time {
+	return q.clock.Now()
+}
+
+func (q *Queries) conn(ctx context.Context) DBTX {
+	if tx := TxFrom(ctx); tx != nil && !q.tx {
+		return tx
+	}
+	return q.db
+}
+
+// TodoColumns selects everything a Todo is scanned from: the row itself,
+// whether any of its blockers are still open, its tracked time, its
+// custom field values and its assignee's email. Use it with TodoFields wherever todos are read.
+// Parameter $now is the app clock's time, which how long todos have been
+// open is counted to, so it follows serve -freeze-time.
+func TodoColumns(now int) string {
+	return `id, list_id, title, description, completed, estimate_minutes, due_date, place, latitude, longitude,
+	EXISTS (
+		SELECT 1 FROM todo_dependencies d JOIN todos b ON b.id = d.blocker_id
+		WHERE d.todo_id = todos.id AND NOT b.completed
+	) AS blocked,
+	COALESCE((
+		SELECT SUM(EXTRACT(EPOCH FROM COALESCE(e.stopped_at, NOW()) - e.started_at))::BIGINT
+		FROM time_entries e WHERE e.todo_id = todos.id
+	), 0) AS tracked_seconds,
+	EXISTS (
+		SELECT 1 FROM time_entries e WHERE e.todo_id = todos.id AND e.stopped_at IS NULL
+	) AS timer_running,
+	COALESCE((
+		SELECT json_agg(json_build_object(
+			'field_id', f.id, 'name', f.name, 'kind', f.kind, 'value', todos.custom_fields -> f.id::text
+		) ORDER BY f.id)
+		FROM list_fields f WHERE f.list_id = todos.list_id AND todos.custom_fields ? f.id::text
+	), '[]') AS fields,
+	assignee_id, COALESCE((SELECT u.email FROM users u WHERE u.id = todos.assignee_id), '') AS assignee,
+	needs_approval AND (SELECT l.approvals FROM lists l WHERE l.id = todos.list_id) AS needs_approval,
+	approval_requested_at IS NOT NULL AND NOT completed AND (SELECT l.approvals FROM lists l WHERE l.id = todos.list_id) AS awaiting_approval,
+	COALESCE(status_id, 0), COALESCE((SELECT s.name FROM list_statuses s WHERE s.id = todos.status_id), '') AS status,
+	CASE WHEN completed OR created_at IS NULL THEN 0 ELSE EXTRACT(DAY FROM $` + strconv.Itoa(now) + `::timestamptz - created_at)::integer END AS open_days,
+	` + AgingLevel(now) + ` AS aging`
+}
+
+// AgingLevel is how long a todo has been open at time $now against its
+// list's aging thresholds: model.AgingAlert, model.AgingWarn, or empty for
+// neither or a done todo. It's for queries on todos that aren't aliased.
+func AgingLevel(now int) string {
+	age := "$" + strconv.Itoa(now) + "::timestamptz - todos.created_at"
+	return `CASE WHEN todos.completed OR todos.created_at IS NULL THEN '' ELSE COALESCE((
+		SELECT CASE
+			WHEN ` + age + ` >= make_interval(days => l.aging_alert_days) THEN 'alert'
+			WHEN ` + age + ` >= make_interval(days => l.aging_warn_days) THEN 'warn'
+		END
+		FROM lists l WHERE l.id = todos.list_id
+	), '') END`
+}
+
+// TodoFields returns the scan destinations for TodoColumns.
+func TodoFields(t *model.Todo) []any {
+	return []any{
+		&t.ID, &t.ListID, &t.Title, &t.Description, &t.Completed, &t.EstimateMinutes, &t.DueDate,
+		&t.Place, &t.Latitude, &t.Longitude, &t.Blocked, &t.TrackedSeconds, &t.TimerRunning, &t.Fields,
+		&t.AssigneeID, &t.Assignee, &t.NeedsApproval, &t.AwaitingApproval,
+		&t.StatusID, &t.Status, &t.OpenDays, &t.Aging,
+	}
+}
+
+func ScanTodos(rows *sql.Rows) ([]model.Todo, error) {
+	defer rows.Close()
+
+	var todos []model.Todo
+	for rows.Next() {
+		var todo model.Todo
+		if err := rows.Scan(TodoFields(&todo)...); err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+// MemberLists is a subquery for the IDs of the unarchived lists user $n
+// belongs to. Views that span lists (My Day, effort, stats) use it rather
+// than the admin override, so admins only see other people's lists when they
+// go looking.
+func MemberLists(n int) string {
+	return `(SELECT m.list_id FROM list_members m JOIN lists l ON l.id = m.list_id
+		WHERE m.user_id = $` + strconv.Itoa(n) + ` AND l.archived_at IS NULL)`
+}
+
+// NextPosition is a subquery for the position that puts a new todo at the
+// top of list $n.
+func NextPosition(n int) string {
+	return "(SELECT COALESCE(MAX(position), 0) + 1 FROM todos WHERE list_id = $" + strconv.Itoa(n) + ")"
+}
+
+// The queries that read todos take the time to age them by, Queries.now,
+// as their last argument.
+var (
+	getTodo           = "SELECT " + TodoColumns(2) + " FROM todos WHERE id = $1"
+	listTodos         = "SELECT " + TodoColumns(2) + " FROM todos WHERE list_id = $1 AND archived_at IS NULL ORDER BY position DESC, id DESC"
+	firstTodos        = "SELECT " + TodoColumns(3) + " FROM todos WHERE list_id = $1 AND archived_at IS NULL ORDER BY position DESC, id DESC LIMIT $2"
+	listArchivedTodos = "SELECT " + TodoColumns(2) + " FROM todos WHERE list_id = $1 AND archived_at IS NOT NULL ORDER BY archived_at DESC, id DESC"
+	listUserTodos     = "SELECT " + TodoColumns(2) + " FROM todos WHERE archived_at IS NULL AND list_id IN " + MemberLists(1) + " ORDER BY id DESC"
+	// filterTodos is listTodos narrowed by custom fields: $2 holds the
+	// values todos must have exactly, $3 those they must contain.
+	filterTodos = `
+		SELECT ` + TodoColumns(4) + ` FROM todos
+		WHERE list_id = $1 AND archived_at IS NULL AND custom_fields @> $2
+		  AND NOT EXISTS (
+			SELECT 1 FROM jsonb_each_text($3) f
+			WHERE strpos(lower(COALESCE(custom_fields ->> f.key, '')), lower(f.value)) = 0
+		  )
+		ORDER BY position DESC, id DESC`
+	// newTodos and completedTodos feed polling triggers: the latest todos
+	// made, or completed, on user $1's lists, or just list $2 unless it's
+	// zero.
+	newTodos = "SELECT " + TodoColumns(4) + " FROM todos WHERE list_id IN " + MemberLists(1) +
+		" AND ($2::int = 0 OR list_id = $2) ORDER BY id DESC LIMIT $3"
+	completedTodos = "SELECT " + TodoColumns(4) + ", completed_at FROM todos WHERE completed AND completed_at IS NOT NULL" +
+		" AND list_id IN " + MemberLists(1) + " AND ($2::int = 0 OR list_id = $2) ORDER BY completed_at DESC LIMIT $3"
+	todoListID    = "SELECT list_id FROM todos WHERE id = $1"
+	updateTodo    = "UPDATE todos SET title = $2, description = $3, estimate_minutes = $4, due_date = $5 WHERE id = $1"
+	toggleTodo    = "UPDATE todos SET completed = NOT completed, completed_at = CASE WHEN completed THEN NULL ELSE NOW() END, approval_requested_by = NULL, approval_requested_at = NULL WHERE id = $1 RETURNING " + TodoColumns(2)
+	completeTodo  = "UPDATE todos SET completed = TRUE, completed_at = COALESCE(completed_at, NOW()), approval_requested_by = NULL, approval_requested_at = NULL WHERE id = $1 RETURNING " + TodoColumns(2)
+	deleteTodo    = "DELETE FROM todos WHERE id = $1"
+	restoreTodo   = "UPDATE todos SET archived_at = NULL WHERE id = $1"
+	setTodoFields = "UPDATE todos SET custom_fields = $2 WHERE id = $1"
+	setTodoPlace  = "UPDATE todos SET place = $2, latitude = $3, longitude = $4 WHERE id = $1"
+
+	// The metadata queries work on one namespace, $2, of a todo's metadata.
+	// setMetadata refuses a new key once the namespace has $5.
+	getMetadata = "SELECT COALESCE(metadata -> $2::text, '{}') FROM todos WHERE id = $1"
+	setMetadata = `
+		UPDATE todos SET metadata = jsonb_set(metadata, ARRAY[$2::text],
+			COALESCE(metadata -> $2::text, '{}') || jsonb_build_object($3::text, $4::jsonb))
+		WHERE id = $1 AND (
+			metadata -> $2::text ? $3::text
+			OR (SELECT COUNT(*) FROM jsonb_object_keys(COALESCE(metadata -> $2::text, '{}'))) < $5
+		)
+		RETURNING metadata -> $2::text`
+	deleteMetadata = `
+		UPDATE todos SET metadata = CASE
+			WHEN (metadata -> $2::text) - $3::text = '{}' THEN metadata - $2::text
+			ELSE jsonb_set(metadata, ARRAY[$2::text], (metadata -> $2::text) - $3::text)
+		END
+		WHERE id = $1 AND metadata -> $2::text ? $3::text`
+	todosMetadata = "SELECT id, metadata -> $2::text FROM todos WHERE id = ANY($1) AND metadata ? $2::text"
+	// findUserTodos is listUserTodos narrowed to todos whose metadata
+	// key $3 in namespace $2 holds $4, as text.
+	findUserTodos = "SELECT " + TodoColumns(5) + " FROM todos WHERE archived_at IS NULL AND list_id IN " + MemberLists(1) +
+		" AND metadata -> $2::text ->> $3::text = $4 ORDER BY id DESC"
+
+	listFields  = "SELECT id, list_id, name, kind, options FROM list_fields WHERE list_id = $1 ORDER BY id"
+	createField = "INSERT INTO list_fields (list_id, name, kind, options) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
+	// deleteField takes the field's values off the list's todos too.
+	deleteField = `
+		WITH f AS (DELETE FROM list_fields WHERE list_id = $1 AND id = $2 RETURNING id)
+		UPDATE todos SET custom_fields = custom_fields - f.id::text
+		FROM f WHERE todos.list_id = $1 AND todos.custom_fields ? f.id::text`
+
+	listStatuses = "SELECT id, list_id, name, done, position FROM list_statuses WHERE list_id = $1 ORDER BY position, id"
+	createStatus = `
+		INSERT INTO list_statuses (list_id, name, done, position)
+		SELECT $1, $2, $3, COALESCE(MAX(position) + 1, 0) FROM list_statuses WHERE list_id = $1
+		ON CONFLICT DO NOTHING`
+
+	getList       = "SELECT name, archived_at IS NOT NULL, auto_archive_days, approvals, aging_warn_days, aging_alert_days FROM lists WHERE id = $1"
+	listExists    = "SELECT EXISTS (SELECT 1 FROM lists WHERE id = $1)"
+	todoCounts    = "SELECT COALESCE(SUM(open), 0), COALESCE(SUM(completed), 0) FROM todo_counters WHERE list_id = $1"
+	listUserLists = `
+		SELECT l.id, l.name, m.role, l.archived_at IS NOT NULL, COALESCE(c.open, 0), COALESCE(c.completed, 0)
+		FROM lists l
+		JOIN list_members m ON m.list_id = l.id AND m.user_id = $1
+		LEFT JOIN todo_counters c ON c.list_id = l.id
+		ORDER BY l.id`
+	firstOwnedList = `
+		SELECT m.list_id FROM list_members m JOIN lists l ON l.id = m.list_id
+		WHERE m.user_id = $1 AND m.role = 'owner' AND l.archived_at IS NULL
+		ORDER BY m.list_id LIMIT 1`
+	createList         = "INSERT INTO lists (name) VALUES ($1) RETURNING id"
+	setListArchived    = "UPDATE lists SET archived_at = CASE WHEN $2 THEN NOW() END WHERE id = $1"
+	setAutoArchiveDays = "UPDATE lists SET auto_archive_days = $2 WHERE id = $1"
+
+	memberRole  = "SELECT role FROM list_members WHERE list_id = $1 AND user_id = $2"
+	listMembers = `
+		SELECT u.id, u.email, m.role FROM list_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.list_id = $1
+		ORDER BY m.created_at`
+	countMembers = "SELECT COUNT(*) FROM list_members WHERE list_id = $1"
+	// The mention queries match emails starting with $2 and leave out the
+	// caller, $3 or $1.
+	mentionMembers = `
+		SELECT u.id, u.email, m.role FROM list_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.list_id = $1 AND strpos(lower(u.email), lower($2)) = 1 AND u.id <> $3
+		ORDER BY u.email LIMIT $4`
+	mentionTeammates = `
+		SELECT DISTINCT u.id, u.email FROM list_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.list_id IN (SELECT list_id FROM list_members WHERE user_id = $1)
+		  AND strpos(lower(u.email), lower($2)) = 1 AND u.id <> $1
+		ORDER BY u.email LIMIT $3`
+	addMember = "INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, $3)"
+	shareList = `
+		INSERT INTO list_members (list_id, user_id, role)
+		SELECT $1, id, $3 FROM users WHERE lower(email) = lower($2)
+		ON CONFLICT (list_id, user_id) DO UPDATE SET role = $3`
+	removeMember = `
+		DELETE FROM list_members m
+		WHERE m.list_id = $1 AND m.user_id = $2
+		  AND (m.role <> 'owner' OR EXISTS (
+			SELECT 1 FROM list_members o
+			WHERE o.list_id = m.list_id AND o.role = 'owner' AND o.user_id <> m.user_id
+		  ))`
+
+	// A running timer changes what a todo or list renders as by the
+	// second, so there's nothing stable to report for them.
+	listModified = `
+		SELECT CASE WHEN EXISTS (
+			SELECT 1 FROM time_entries e JOIN todos t ON t.id = e.todo_id
+			WHERE t.list_id = l.id AND e.stopped_at IS NULL
+		) THEN NULL ELSE l.updated_at END
+		FROM lists l WHERE l.id = $1`
+	userListsModified = `
+		SELECT MAX(l.updated_at) FROM lists l
+		JOIN list_members m ON m.list_id = l.id
+		WHERE m.user_id = $1`
+	todoModified = `
+		SELECT CASE WHEN EXISTS (
+			SELECT 1 FROM time_entries e WHERE e.todo_id = t.id AND e.stopped_at IS NULL
+		) THEN NULL ELSE GREATEST(t.updated_at, (
+			SELECT MAX(b.updated_at) FROM todo_dependencies d JOIN todos b ON b.id = d.blocker_id
+			WHERE d.todo_id = t.id
+		)) END
+		FROM todos t WHERE t.id = $1`
+
+	listEvents = `
+		SELECT id, kind, todo_id, created_at FROM todo_events
+		WHERE list_id = $1 AND id > $2
+		ORDER BY id LIMIT $3`
+	eventTodos = "SELECT " + TodoColumns(3) + " FROM todos WHERE list_id = $1 AND id = ANY($2)"
+	// eventRange falls back to one past the last event when none are
+	// kept, so first > last means there's nothing to replay.
+	eventRange = `
+		SELECT COALESCE((SELECT MIN(id) FROM todo_events WHERE list_id = l.id), l.last_event_id + 1), l.last_event_id
+		FROM lists l WHERE l.id = $1`
+
+	listRevisions = `
+		SELECT id, todo_id, title, description, replaced_at FROM todo_revisions
+		WHERE todo_id = $1 ORDER BY id DESC`
+	getRevision = `
+		SELECT id, todo_id, title, description, replaced_at FROM todo_revisions
+		WHERE todo_id = $1 AND id = $2`
+	revertTodo = `
+		UPDATE todos t SET title = v.title, description = v.description
+		FROM todo_revisions v WHERE t.id = $1 AND v.todo_id = t.id AND v.id = $2`
+)
+
+// statements is every query Queries runs, for checkStatements.
+var statements = map[string]string{
+	"getTodo":            getTodo,
+	"listTodos":          listTodos,
+	"firstTodos":         firstTodos,
+	"listArchivedTodos":  listArchivedTodos,
+	"listUserTodos":      listUserTodos,
+	"newTodos":           newTodos,
+	"completedTodos":     completedTodos,
+	"todoListID":         todoListID,
+	"updateTodo":         updateTodo,
+	"toggleTodo":         toggleTodo,
+	"completeTodo":       completeTodo,
+	"deleteTodo":         deleteTodo,
+	"restoreTodo":        restoreTodo,
+	"filterTodos":        filterTodos,
+	"setTodoFields":      setTodoFields,
+	"setTodoPlace":       setTodoPlace,
+	"getMetadata":        getMetadata,
+	"setMetadata":        setMetadata,
+	"deleteMetadata":     deleteMetadata,
+	"todosMetadata":      todosMetadata,
+	"findUserTodos":      findUserTodos,
+	"listFields":         listFields,
+	"createField":        createField,
+	"deleteField":        deleteField,
+	"listStatuses":       listStatuses,
+	"createStatus":       createStatus,
+	"getList":            getList,
+	"listExists":         listExists,
+	"todoCounts":         todoCounts,
+	"listUserLists":      listUserLists,
+	"firstOwnedList":     firstOwnedList,
+	"createList":         createList,
+	"setListArchived":    setListArchived,
+	"setAutoArchiveDays": setAutoArchiveDays,
+	"memberRole":         memberRole,
+	"listMembers":        listMembers,
+	"countMembers":       countMembers,
+	"mentionMembers":     mentionMembers,
+	"mentionTeammates":   mentionTeammates,
+	"addMember":          addMember,
+	"shareList":          shareList,
+	"removeMember":       removeMember,
+	"listModified":       listModified,
+	"userListsModified":  userListsModified,
+	"todoModified":       todoModified,
+	"listEvents":         listEvents,
+	"eventTodos":         eventTodos,
+	"eventRange":         eventRange,
+
+	"listRevisions": listRevisions,
+	"getRevision":   getRevision,
+	"revertTodo":    revertTodo,
+
+	"listAttachments":        listAttachments,
+	"getAttachment":          getAttachment,
+	"attachmentData":         attachmentData,
+	"attachmentListID":       attachmentListID,
+	"createAttachment":       createAttachment,
+	"copyAttachments":        copyAttachments,
+	"deleteAttachment":       deleteAttachment,
+	"attachmentThumbnail":    attachmentThumbnail,
+	"setAttachmentThumbnail": setAttachmentThumbnail,
+	"claimTranscription":     claimTranscription,
+	"finishTranscription":    finishTranscription,
+	"appendDescription":      appendDescription,
+}
+
+// checkStatements has Postgres prepare every statement, which checks its
+// tables, columns and types against the live schema without running it.
+// They're prepared in a transaction, so behind a transaction pooler each
+// is prepared and closed on the same server connection, with a savepoint
+// so one that fails doesn't stop the rest being checked.
+func checkStatements(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var errs []error
+	for name, query := range statements {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT check_statement"); err != nil {
+			return err
+		}
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT check_statement"); err != nil {
+				return err
+			}
+			continue
+		}
+		stmt.Close()
+	}
+	return errors.Join(errs...)
+}
+
+func (q *Queries) GetTodo(ctx context.Context, id int) (model.Todo, error) {
+	var todo model.Todo
+	err := q.conn(ctx).QueryRowContext(ctx, getTodo, id, q.now()).Scan(TodoFields(&todo)...)
+	return todo, err
+}
+
+// ListTodos returns a list's todos, newest position first, leaving out
+// archived ones.
+func (q *Queries) ListTodos(ctx context.Context, listID int) ([]model.Todo, error) {
+	return q.todos(ctx, listTodos, listID)
+}
+
+// EachTodo calls fn with the first limit of a list's todos, in the order
+// ListTodos returns them, as each is read, for lists too big to hold at
+// once. It stops at the first error fn returns.
+func (q *Queries) EachTodo(ctx context.Context, listID, limit int, fn func(model.Todo) error) error {
+	rows, err := q.conn(ctx).QueryContext(ctx, firstTodos, listID, limit, q.now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var todo model.Todo
+		if err := rows.Scan(TodoFields(&todo)...); err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListArchivedTodos returns a list's archived todos, most recently archived
+// first.
+func (q *Queries) ListArchivedTodos(ctx context.Context, listID int) ([]model.Todo, error) {
+	return q.todos(ctx, listArchivedTodos, listID)
+}
+
+// ListUserTodos returns the todos on every unarchived list userID belongs
+// to.
+func (q *Queries) ListUserTodos(ctx context.Context, userID int) ([]model.Todo, error) {
+	return q.todos(ctx, listUserTodos, userID)
+}
+
+// NewTodos returns the latest limit todos made on userID's lists, or on
+// listID alone if it's not zero, newest first.
+func (q *Queries) NewTodos(ctx context.Context, userID, listID, limit int) ([]model.Todo, error) {
+	return q.todos(ctx, newTodos, userID, listID, limit)
+}
+
+// CompletedTodo is a todo with when it was completed.
+type CompletedTodo struct {
+	model.Todo
+	CompletedAt time.Time
+}
+
+// CompletedTodos returns the latest limit todos completed on userID's
+// lists, or on listID alone if it's not zero, most recent first.
+func (q *Queries) CompletedTodos(ctx context.Context, userID, listID, limit int) ([]CompletedTodo, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, completedTodos, userID, listID, limit, q.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var todos []CompletedTodo
+	for rows.Next() {
+		var t CompletedTodo
+		if err := rows.Scan(append(TodoFields(&t.Todo), &t.CompletedAt)...); err != nil {
+			return nil, err
+		}
+		todos = append(todos, t)
+	}
+	return todos, rows.Err()
+}
+
+// todos runs a query for todos with args and, last, the time to age them
+// by.
+func (q *Queries) todos(ctx context.Context, query string, args ...any) ([]model.Todo, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, query, append(args, q.now())...)
+	if err != nil {
+		return nil, err
+	}
+	return ScanTodos(rows)
+}
+
+// TodoListID returns the list a todo is on, or sql.ErrNoRows.
+func (q *Queries) TodoListID(ctx context.Context, id int) (int, error) {
+	var listID int
+	err := q.conn(ctx).QueryRowContext(ctx, todoListID, id).Scan(&listID)
+	return listID, err
+}
+
+type UpdateTodoParams struct {
+	ID              int
+	Title           string
+	Description     string
+	EstimateMinutes int
+	DueDate         *time.Time
+}
+
+func (q *Queries) UpdateTodo(ctx context.Context, arg UpdateTodoParams) error {
+	_, err := q.conn(ctx).ExecContext(ctx, updateTodo, arg.ID, arg.Title, arg.Description, arg.EstimateMinutes, arg.DueDate)
+	return err
+}
+
+// ToggleTodo flips a todo between open and completed and returns it.
+func (q *Queries) ToggleTodo(ctx context.Context, id int) (model.Todo, error) {
+	var todo model.Todo
+	err := q.conn(ctx).QueryRowContext(ctx, toggleTodo, id, q.now()).Scan(TodoFields(&todo)...)
+	return todo, err
+}
+
+// CompleteTodo marks a todo completed, if it isn't already, and returns it.
+func (q *Queries) CompleteTodo(ctx context.Context, id int) (model.Todo, error) {
+	var todo model.Todo
+	err := q.conn(ctx).QueryRowContext(ctx, completeTodo, id, q.now()).Scan(TodoFields(&todo)...)
+	return todo, err
+}
+
+// DeleteTodo reports whether there was a todo to delete.
+func (q *Queries) DeleteTodo(ctx context.Context, id int) (bool, error) {
+	return q.affected(q.conn(ctx).ExecContext(ctx, deleteTodo, id))
+}
+
+func (q *Queries) RestoreTodo(ctx context.Context, id int) error {
+	_, err := q.conn(ctx).ExecContext(ctx, restoreTodo, id)
+	return err
+}
+
+// FilterTodos returns a list's unarchived todos whose custom fields hold
+// every value in equal and contain, ignoring case, every value in contains.
+// Both are keyed by field ID.
+func (q *Queries) FilterTodos(ctx context.Context, listID int, equal map[string]any, contains map[string]string) ([]model.Todo, error) {
+	eq, err := json.Marshal(equal)
+	if err != nil {
+		return nil, err
+	}
+	like, err := json.Marshal(contains)
+	if err != nil {
+		return nil, err
+	}
+	return q.todos(ctx, filterTodos, listID, string(eq), string(like))
+}
+
+// SetTodoFields replaces a todo's custom field values, keyed by field ID.
+func (q *Queries) SetTodoFields(ctx context.Context, id int, values map[string]any) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = q.conn(ctx).ExecContext(ctx, setTodoFields, id, string(b))
+	return err
+}
+
+// SetTodoPlace sets where a todo gets done. latitude and longitude are nil
+// for a place that hasn't been geocoded.
+func (q *Queries) SetTodoPlace(ctx context.Context, id int, place string, latitude, longitude *float64) error {
+	_, err := q.conn(ctx).ExecContext(ctx, setTodoPlace, id, place, latitude, longitude)
+	return err
+}
+
+// ErrTooManyKeys is returned by SetMetadata for a new key in a namespace
+// that's already full.
+var ErrTooManyKeys = errors.New("too many metadata keys")
+
+// GetMetadata returns a todo's metadata in namespace, an empty object if
+// there's none.
+func (q *Queries) GetMetadata(ctx context.Context, id int, namespace string) (json.RawMessage, error) {
+	var m []byte
+	err := q.conn(ctx).QueryRowContext(ctx, getMetadata, id, namespace).Scan(&m)
+	return m, err
+}
+
+// SetMetadata sets key to value in a todo's metadata namespace, which may
+// hold at most maxKeys keys, and returns the namespace's metadata.
+func (q *Queries) SetMetadata(ctx context.Context, id int, namespace, key string, value json.RawMessage, maxKeys int) (json.RawMessage, error) {
+	var m []byte
+	err := q.conn(ctx).QueryRowContext(ctx, setMetadata, id, namespace, key, string(value), maxKeys).Scan(&m)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTooManyKeys
+	}
+	return m, err
+}
+
+// DeleteMetadata removes key from a todo's metadata namespace and reports
+// whether it was there.
+func (q *Queries) DeleteMetadata(ctx context.Context, id int, namespace, key string) (bool, error) {
+	return q.affected(q.conn(ctx).ExecContext(ctx, deleteMetadata, id, namespace, key))
+}
+
+// TodosMetadata returns the metadata namespace holds for each of ids that
+// has any.
+func (q *Queries) TodosMetadata(ctx context.Context, ids []int64, namespace string) (map[int]json.RawMessage, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, todosMetadata, pq.Array(ids), namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := map[int]json.RawMessage{}
+	for rows.Next() {
+		var id int
+		var m []byte
+		if err := rows.Scan(&id, &m); err != nil {
+			return nil, err
+		}
+		metadata[id] = m
+	}
+	return metadata, rows.Err()
+}
+
+// FindUserTodos returns the todos on userID's lists whose metadata key in
+// namespace is value, such as the todo synced with an issue.
+func (q *Queries) FindUserTodos(ctx context.Context, userID int, namespace, key, value string) ([]model.Todo, error) {
+	return q.todos(ctx, findUserTodos, userID, namespace, key, value)
+}
+
+// ListFields returns a list's custom fields, oldest first.
+func (q *Queries) ListFields(ctx context.Context, listID int) ([]model.Field, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listFields, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []model.Field
+	for rows.Next() {
+		var f model.Field
+		if err := rows.Scan(&f.ID, &f.ListID, &f.Name, &f.Kind, pq.Array(&f.Options)); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+// CreateField adds a custom field to a list. It reports false if the list
+// already has a field by that name.
+func (q *Queries) CreateField(ctx context.Context, f model.Field) (bool, error) {
+	return q.affected(q.conn(ctx).ExecContext(ctx, createField, f.ListID, f.Name, f.Kind, pq.Array(f.Options)))
+}
+
+// DeleteField removes a custom field and its values.
+func (q *Queries) DeleteField(ctx context.Context, listID, id int) error {
+	_, err := q.conn(ctx).ExecContext(ctx, deleteField, listID, id)
+	return err
+}
+
+// ListStatuses returns a list's statuses in order.
+func (q *Queries) ListStatuses(ctx context.Context, listID int) ([]model.Status, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listStatuses, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []model.Status
+	for rows.Next() {
+		var s model.Status
+		if err := rows.Scan(&s.ID, &s.ListID, &s.Name, &s.Done, &s.Position); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// CreateStatus adds a status to the end of a list's. It reports false if
+// the list already has a status by that name.
+func (q *Queries) CreateStatus(ctx context.Context, s model.Status) (bool, error) {
+	return q.affected(q.conn(ctx).ExecContext(ctx, createStatus, s.ListID, s.Name, s.Done))
+}
+
+// GetList loads a list's own columns; the caller's Role is left unset.
+func (q *Queries) GetList(ctx context.Context, id int) (model.List, error) {
+	l := model.List{ID: id}
+	var days, warn, alert sql.NullInt64
+	err := q.conn(ctx).QueryRowContext(ctx, getList, id).Scan(&l.Name, &l.Archived, &days, &l.Approvals, &warn, &alert)
+	l.AutoArchiveDays = int(days.Int64)
+	l.AgingWarnDays, l.AgingAlertDays = int(warn.Int64), int(alert.Int64)
+	return l, err
+}
+
+// TodoCounts returns how many of a list's unarchived todos are open and
+// done, from todo_counters.
+func (q *Queries) TodoCounts(ctx context.Context, listID int) (model.TodoCounts, error) {
+	var c model.TodoCounts
+	err := q.conn(ctx).QueryRowContext(ctx, todoCounts, listID).Scan(&c.Open, &c.Completed)
+	return c, err
+}
+
+func (q *Queries) ListExists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := q.conn(ctx).QueryRowContext(ctx, listExists, id).Scan(&exists)
+	return exists, err
+}
+
+// ListUserLists returns the lists userID belongs to, with their role on
+// each and their counts from todo_counters.
+func (q *Queries) ListUserLists(ctx context.Context, userID int) ([]model.List, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listUserLists, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []model.List
+	for rows.Next() {
+		l := model.List{Counts: &model.TodoCounts{}}
+		var role string
+		if err := rows.Scan(&l.ID, &l.Name, &role, &l.Archived, &l.Counts.Open, &l.Counts.Completed); err != nil {
+			return nil, err
+		}
+		if l.Role, err = model.ParseRole(role); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+// FirstOwnedList returns the oldest unarchived list userID owns, or
+// sql.ErrNoRows.
+func (q *Queries) FirstOwnedList(ctx context.Context, userID int) (int, error) {
+	var id int
+	err := q.conn(ctx).QueryRowContext(ctx, firstOwnedList, userID).Scan(&id)
+	return id, err
+}
+
+func (q *Queries) CreateList(ctx context.Context, name string) (int, error) {
+	var id int
+	err := q.conn(ctx).QueryRowContext(ctx, createList, name).Scan(&id)
+	return id, err
+}
+
+func (q *Queries) SetListArchived(ctx context.Context, id int, archived bool) error {
+	_, err := q.conn(ctx).ExecContext(ctx, setListArchived, id, archived)
+	return err
+}
+
+// SetAutoArchiveDays sets a list's retention policy; nil turns it off.
+func (q *Queries) SetAutoArchiveDays(ctx context.Context, id int, days *int) error {
+	_, err := q.conn(ctx).ExecContext(ctx, setAutoArchiveDays, id, days)
+	return err
+}
+
+// MemberRole returns userID's role on a list, or NoRole if they aren't a
+// member.
+func (q *Queries) MemberRole(ctx context.Context, listID, userID int) (model.Role, error) {
+	var name string
+	err := q.conn(ctx).QueryRowContext(ctx, memberRole, listID, userID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.NoRole, nil
+	}
+	if err != nil {
+		return model.NoRole, err
+	}
+	return model.ParseRole(name)
+}
+
+func (q *Queries) ListMembers(ctx context.Context, listID int) ([]model.Member, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listMembers, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []model.Member
+	for rows.Next() {
+		var m model.Member
+		var role string
+		if err := rows.Scan(&m.UserID, &m.Email, &role); err != nil {
+			return nil, err
+		}
+		if m.Role, err = model.ParseRole(role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (q *Queries) AddMember(ctx context.Context, listID, userID int, role model.Role) error {
+	_, err := q.conn(ctx).ExecContext(ctx, addMember, listID, userID, role.String())
+	return err
+}
+
+// ShareList adds the account with email to a list, or changes its role if
+// it's already a member. It reports whether such an account exists.
+func (q *Queries) ShareList(ctx context.Context, listID int, email string, role model.Role) (bool, error) {
+	return q.affected(q.conn(ctx).ExecContext(ctx, shareList, listID, email, role.String()))
+}
+
+// RemoveMember takes userID off a list unless they're its last owner, and
+// reports whether they were removed.
+func (q *Queries) RemoveMember(ctx context.Context, listID, userID int) (bool, error) {
+	return q.affected(q.conn(ctx).ExecContext(ctx, removeMember, listID, userID))
+}
+
+// CountMembers returns how many people a list is shared with, its owners
+// included.
+func (q *Queries) CountMembers(ctx context.Context, listID int) (int, error) {
+	var n int
+	err := q.conn(ctx).QueryRowContext(ctx, countMembers, listID).Scan(&n)
+	return n, err
+}
+
+// MentionMembers returns up to limit members of a list other than userID
+// whose email starts with prefix.
+func (q *Queries) MentionMembers(ctx context.Context, listID, userID int, prefix string, limit int) ([]model.Member, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, mentionMembers, listID, prefix, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []model.Member
+	for rows.Next() {
+		var m model.Member
+		var role string
+		if err := rows.Scan(&m.UserID, &m.Email, &role); err != nil {
+			return nil, err
+		}
+		if m.Role, err = model.ParseRole(role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// MentionTeammates returns up to limit people who share a list with userID
+// and whose email starts with prefix. They come without a role, since it
+// differs from list to list.
+func (q *Queries) MentionTeammates(ctx context.Context, userID int, prefix string, limit int) ([]model.Member, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, mentionTeammates, userID, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []model.Member
+	for rows.Next() {
+		var m model.Member
+		if err := rows.Scan(&m.UserID, &m.Email); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// ListModified returns when a list, its members or its todos last changed,
+// or the zero time while a timer runs on it.
+func (q *Queries) ListModified(ctx context.Context, id int) (time.Time, error) {
+	return q.modified(ctx, listModified, id)
+}
+
+// UserListsModified returns when any list userID belongs to last changed,
+// which covers the list sidebar.
+func (q *Queries) UserListsModified(ctx context.Context, userID int) (time.Time, error) {
+	return q.modified(ctx, userListsModified, userID)
+}
+
+// TodoModified returns when a todo or any of its blockers last changed, or
+// the zero time while its timer runs.
+func (q *Queries) TodoModified(ctx context.Context, id int) (time.Time, error) {
+	return q.modified(ctx, todoModified, id)
+}
+
+// ListEvents returns up to limit of a list's events after the one with ID
+// after, oldest first, each with its todo as it is now.
+func (q *Queries) ListEvents(ctx context.Context, listID int, after int64, limit int) ([]model.TodoEvent, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listEvents, listID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.TodoEvent
+	var ids []int64
+	for rows.Next() {
+		e := model.TodoEvent{ListID: listID}
+		if err := rows.Scan(&e.ID, &e.Type, &e.TodoID, &e.At); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+		ids = append(ids, int64(e.TodoID))
+	}
+	if err := rows.Err(); err != nil || len(events) == 0 {
+		return events, err
+	}
+
+	todos, err := q.todos(ctx, eventTodos, listID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*model.Todo, len(todos))
+	for i := range todos {
+		byID[todos[i].ID] = &todos[i]
+	}
+	for i := range events {
+		events[i].Todo = byID[events[i].TodoID]
+	}
+	return events, nil
+}
+
+// EventRange returns the IDs of the oldest event still kept for a list and
+// of its latest. first is last+1 when none are kept.
+func (q *Queries) EventRange(ctx context.Context, listID int) (first, last int64, err error) {
+	err = q.conn(ctx).QueryRowContext(ctx, eventRange, listID).Scan(&first, &last)
+	return first, last, err
+}
+
+// ListRevisions returns the versions a todo's title and description have
+// had, newest first.
+func (q *Queries) ListRevisions(ctx context.Context, todoID int) ([]model.Revision, error) {
+	rows, err := q.conn(ctx).QueryContext(ctx, listRevisions, todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []model.Revision
+	for rows.Next() {
+		var v model.Revision
+		if err := rows.Scan(&v.ID, &v.TodoID, &v.Title, &v.Description, &v.ReplacedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, v)
+	}
+	return revisions, rows.Err()
+}
+
+func (q *Queries) GetRevision(ctx context.Context, todoID int, id int64) (model.Revision, error) {
+	var v model.Revision
+	err := q.conn(ctx).QueryRowContext(ctx, getRevision, todoID, id).Scan(&v.ID, &v.TodoID, &v.Title, &v.Description, &v.ReplacedAt)
+	return v, err
+}
+
+// RevertTodo puts back the title and description a todo had in one of its
+// revisions, answering sql.ErrNoRows if it has no such revision. The
+// version it replaces becomes a revision in turn.
+func (q *Queries) RevertTodo(ctx context.Context, todoID int, revisionID int64) error {
+	res, err := q.conn(ctx).ExecContext(ctx, revertTodo, todoID, revisionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (q *Queries) modified(ctx context.Context, query string, id int) (time.Time, error) {
+	var t sql.NullTime
+	err := q.conn(ctx).QueryRowContext(ctx, query, id).Scan(&t)
+	return t.Time, err
+}
+
+func (q *Queries) affected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,883 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// schemaVersion is the schema this build migrates to. Bump it whenever
+// createTables changes, so an older build started against the newer schema
+// (a rollback, or one replica lagging behind a deploy) refuses to boot
+// instead of failing requests.
+const schemaVersion = 32
+
+// requiredIndexes are created by createTables and createSearchIndexes.
+// Queries are written assuming they exist, so a missing one is treated like
+// a missing column rather than quietly slowing everything down.
+var requiredIndexes = []string{
+	"users_email",
+	"email_verifications_user_id",
+	"identities_user_id",
+	"list_members_user_id",
+	"todos_due_date",
+	"todos_list_id",
+	"todos_title_fts",
+	"todo_dependencies_blocker_id",
+	"time_entries_todo_id",
+	"attachments_todo_id",
+	"time_entries_started_at",
+	"time_entries_running",
+	"my_day_day",
+	"my_day_user_todo_day",
+	"list_fields_name",
+	"todos_custom_fields",
+	"todos_metadata",
+	"saved_searches_user_id",
+	"undo_log_user_id",
+	"todo_revisions_todo_id",
+	"attachments_transcript_queue",
+	"todos_assignee_id",
+	"list_statuses_name",
+	"todos_status_id",
+	"export_schedules_next_run",
+	"export_downloads_user_id",
+	"export_downloads_pending",
+	"support_tickets_user_id",
+	"outbox_next_attempt",
+}
+
+// Migrate brings the schema up to schemaVersion. It refuses a database a
+// newer build has already migrated past this one, since this build's
+// queries may no longer match it.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if err := checkSchemaVersion(ctx, db); err != nil {
+		return err
+	}
+	if err := createTables(ctx, db); err != nil {
+		return fmt.Errorf("create tables: %w", err)
+	}
+	if err := createSearchIndexes(ctx, db); err != nil {
+		return fmt.Errorf("create search index: %w", err)
+	}
+	return recordSchemaVersion(ctx, db)
+}
+
+// createTables creates and migrates the core tables. Every statement is
+// idempotent, so it runs on every boot.
+func createTables(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			email TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS users_email ON users (lower(email));
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS confirm_deletes BOOLEAN NOT NULL DEFAULT TRUE;
+		-- Accounts from before verification count as verified: the default
+		-- fills in the existing rows and is then dropped for new ones.
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ DEFAULT NOW();
+		ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT;
+		-- last_active_at moves forward as the account's sessions are used,
+		-- for the retention policy; anonymized_at marks an account it has
+		-- stripped of everything that identifies its owner.
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS last_active_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMPTZ;
+		-- Accounts made for visitors in demo mode, deleted once this passes.
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS demo_expires_at TIMESTAMPTZ;
+		-- How the user likes lists laid out; list_view_updated_at moves on
+		-- with every change so cached copies of their lists go stale.
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS list_density TEXT NOT NULL DEFAULT 'comfortable';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS hidden_columns TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS list_view_updated_at TIMESTAMPTZ;
+
+		CREATE TABLE IF NOT EXISTS email_verifications (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS email_verifications_user_id ON email_verifications (user_id, created_at);
+
+		-- Sign-ins with Google or GitHub. Accounts made that way have an
+		-- empty password_hash until they set a password.
+		CREATE TABLE IF NOT EXISTS identities (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (provider, subject)
+		);
+		CREATE INDEX IF NOT EXISTS identities_user_id ON identities (user_id);
+
+		-- Identities waiting for the user to confirm linking them to an
+		-- account, and merging in the account they belonged to if any.
+		CREATE TABLE IF NOT EXISTS pending_links (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT NOT NULL,
+			merge_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS lists (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS auto_archive_days INTEGER CHECK (auto_archive_days > 0);
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS approvals BOOLEAN NOT NULL DEFAULT FALSE;
+		-- How many days a todo can stay open before it's flagged as aging,
+		-- and then as overdue for attention; NULL doesn't flag it.
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS aging_warn_days INTEGER CHECK (aging_warn_days > 0);
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS aging_alert_days INTEGER CHECK (aging_alert_days > 0);
+
+		CREATE TABLE IF NOT EXISTS list_members (
+			list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (list_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS list_members_user_id ON list_members (user_id);
+
+		CREATE TABLE IF NOT EXISTS todos (
+			id SERIAL PRIMARY KEY,
+			title TEXT NOT NULL,
+			completed BOOLEAN DEFAULT FALSE
+		);
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS estimate_minutes INTEGER NOT NULL DEFAULT 0 CHECK (estimate_minutes >= 0);
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_date DATE;
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+		CREATE INDEX IF NOT EXISTS todos_due_date ON todos (due_date) WHERE NOT completed;
+		-- Added without a default first, so todos from before it have no
+		-- creation time rather than the time of the migration.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+		ALTER TABLE todos ALTER COLUMN created_at SET DEFAULT NOW();
+
+		-- Todos from before lists existed go into a "Shared" list with no
+		-- members; admins can see it and share it with whoever should own it.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS list_id INTEGER REFERENCES lists(id) ON DELETE CASCADE;
+		WITH shared AS (
+			INSERT INTO lists (name)
+			SELECT 'Shared' WHERE EXISTS (SELECT 1 FROM todos WHERE list_id IS NULL)
+			RETURNING id
+		)
+		UPDATE todos SET list_id = shared.id FROM shared WHERE todos.list_id IS NULL;
+		ALTER TABLE todos ALTER COLUMN list_id SET NOT NULL;
+		CREATE INDEX IF NOT EXISTS todos_list_id ON todos (list_id);
+
+		-- Order within a list, highest first. Existing todos keep their
+		-- newest-first order.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS position INTEGER;
+		UPDATE todos t SET position = n.rn
+		FROM (SELECT id, row_number() OVER (PARTITION BY list_id ORDER BY id) AS rn FROM todos) n
+		WHERE t.id = n.id AND t.position IS NULL;
+		ALTER TABLE todos ALTER COLUMN position SET NOT NULL;
+
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+		-- Where a todo gets done: the place as typed, and its coordinates
+		-- once a geocoder has found it.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS place TEXT NOT NULL DEFAULT '';
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+
+		-- Custom fields a list's owners add to its todos. A todo's values
+		-- are kept in custom_fields, keyed by field ID, and validated
+		-- against the field before they're written.
+		CREATE TABLE IF NOT EXISTS list_fields (
+			id SERIAL PRIMARY KEY,
+			list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL CHECK (kind IN ('text', 'number', 'select', 'date')),
+			options TEXT[] NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS list_fields_name ON list_fields (list_id, lower(name));
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}';
+		CREATE INDEX IF NOT EXISTS todos_custom_fields ON todos USING GIN (custom_fields jsonb_path_ops);
+		-- What integrations keep on a todo through the API, such as the ID
+		-- of what it's synced with: an object per API token namespace,
+		-- keyed "<user id>:<namespace>".
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+		CREATE INDEX IF NOT EXISTS todos_metadata ON todos USING GIN (metadata jsonb_path_ops);
+		-- Who on a shared list is doing a todo. Assignees are list members;
+		-- the app unassigns them when they leave the list or the todo does.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS todos_assignee_id ON todos (assignee_id) WHERE assignee_id IS NOT NULL;
+		-- On a list with approvals turned on, a todo that needs_approval is
+		-- only completed by an owner; anyone else completing it asks for
+		-- their approval instead, and approval_requested_at is set until
+		-- an owner approves or rejects it.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS needs_approval BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS approval_requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS approval_requested_at TIMESTAMPTZ;
+
+		CREATE TABLE IF NOT EXISTS todo_dependencies (
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			blocker_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			PRIMARY KEY (todo_id, blocker_id),
+			CHECK (todo_id <> blocker_id)
+		);
+		CREATE INDEX IF NOT EXISTS todo_dependencies_blocker_id ON todo_dependencies (blocker_id);
+
+		CREATE TABLE IF NOT EXISTS time_entries (
+			id SERIAL PRIMARY KEY,
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			stopped_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS time_entries_todo_id ON time_entries (todo_id);
+		CREATE INDEX IF NOT EXISTS time_entries_started_at ON time_entries (started_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS time_entries_running ON time_entries (todo_id) WHERE stopped_at IS NULL;
+
+		CREATE TABLE IF NOT EXISTS my_day (
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			day DATE NOT NULL DEFAULT CURRENT_DATE,
+			added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (todo_id, day)
+		);
+		CREATE INDEX IF NOT EXISTS my_day_day ON my_day (day);
+
+		-- Plans are per user. Plans made before accounts only ever covered
+		-- a single day, so they're dropped rather than assigned to anyone.
+		ALTER TABLE my_day ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+		DELETE FROM my_day WHERE user_id IS NULL;
+		ALTER TABLE my_day ALTER COLUMN user_id SET NOT NULL;
+		ALTER TABLE my_day DROP CONSTRAINT IF EXISTS my_day_pkey;
+		CREATE UNIQUE INDEX IF NOT EXISTS my_day_user_todo_day ON my_day (user_id, todo_id, day);
+
+		CREATE TABLE IF NOT EXISTS digest_subscriptions (
+			id SERIAL PRIMARY KEY,
+			email TEXT UNIQUE NOT NULL,
+			weekday SMALLINT NOT NULL DEFAULT 1 CHECK (weekday BETWEEN 0 AND 6),
+			hour SMALLINT NOT NULL DEFAULT 8 CHECK (hour BETWEEN 0 AND 23),
+			timezone TEXT NOT NULL DEFAULT 'UTC',
+			token TEXT UNIQUE NOT NULL,
+			confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+			last_sent_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- A digest covers the lists of the account that subscribed.
+		-- Subscriptions from before accounts are linked by email address.
+		ALTER TABLE digest_subscriptions ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+		UPDATE digest_subscriptions s SET user_id = u.id
+		FROM users u WHERE s.user_id IS NULL AND lower(u.email) = s.email;
+
+		-- Each run of a retention job, and how many rows it removed or, in
+		-- a dry run, would have.
+		CREATE TABLE IF NOT EXISTS retention_runs (
+			id SERIAL PRIMARY KEY,
+			job TEXT NOT NULL,
+			dry_run BOOLEAN NOT NULL,
+			affected INTEGER NOT NULL,
+			ran_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Searches people asked to be alerted about. saved_search_matches
+		-- holds the todos each has matched so far, so a run only alerts
+		-- about the ones that are new.
+		CREATE TABLE IF NOT EXISTS saved_searches (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+			query TEXT NOT NULL,
+			channel TEXT NOT NULL CHECK (channel IN ('email', 'push')),
+			checked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS saved_searches_user_id ON saved_searches (user_id);
+		CREATE TABLE IF NOT EXISTS saved_search_matches (
+			search_id INTEGER NOT NULL REFERENCES saved_searches(id) ON DELETE CASCADE,
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			PRIMARY KEY (search_id, todo_id)
+		);
+
+		-- Each user's recent changes to todos, for undo and redo: how every
+		-- todo a change touched looked before and after, as JSON. Undone
+		-- changes stay until a new one replaces them, so they can be redone.
+		CREATE TABLE IF NOT EXISTS undo_log (
+			id BIGSERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			label TEXT NOT NULL,
+			changes JSONB NOT NULL,
+			undone BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS undo_log_user_id ON undo_log (user_id, id);
+
+		CREATE TABLE IF NOT EXISTS push_reminders (
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			due_date DATE NOT NULL,
+			sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (todo_id, due_date)
+		);
+
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key TEXT PRIMARY KEY,
+			todo_id INTEGER REFERENCES todos(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Files uploaded to todos. The thumbnail of an image is made the
+		-- first time it's asked for, then kept alongside it.
+		CREATE TABLE IF NOT EXISTS attachments (
+			id SERIAL PRIMARY KEY,
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			data BYTEA NOT NULL,
+			thumbnail BYTEA,
+			thumbnail_type TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS attachments_todo_id ON attachments (todo_id);
+
+		-- Voice notes waiting to be transcribed, or being transcribed by
+		-- the worker that claimed them at transcript_claimed_at. Files that
+		-- aren't voice notes have an empty transcript_status.
+		ALTER TABLE attachments ADD COLUMN IF NOT EXISTS transcript_status TEXT NOT NULL DEFAULT '';
+		ALTER TABLE attachments ADD COLUMN IF NOT EXISTS transcript_error TEXT NOT NULL DEFAULT '';
+		ALTER TABLE attachments ADD COLUMN IF NOT EXISTS transcript_attempts INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE attachments ADD COLUMN IF NOT EXISTS transcript_claimed_at TIMESTAMPTZ;
+		CREATE INDEX IF NOT EXISTS attachments_transcript_queue ON attachments (id)
+			WHERE transcript_status IN ('pending', 'running');
+
+		-- updated_at backs Last-Modified. Triggers keep it current however
+		-- a row is written: a todo is touched when it, its time entries or
+		-- its dependencies change, and a list when it, its members or any
+		-- of its todos do. clock_timestamp() rather than NOW(), so a long
+		-- transaction doesn't stamp its changes with when it began.
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+		CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+		BEGIN
+			NEW.updated_at := clock_timestamp();
+			RETURN NEW;
+		END
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE FUNCTION touch_todo_list() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP IN ('UPDATE', 'DELETE') THEN
+				UPDATE lists SET updated_at = clock_timestamp() WHERE id = OLD.list_id;
+			END IF;
+			IF TG_OP = 'INSERT' THEN
+				UPDATE lists SET updated_at = clock_timestamp() WHERE id = NEW.list_id;
+			ELSIF TG_OP = 'UPDATE' AND NEW.list_id <> OLD.list_id THEN
+				UPDATE lists SET updated_at = clock_timestamp() WHERE id = NEW.list_id;
+			END IF;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE FUNCTION touch_member_list() RETURNS trigger AS $$
+		BEGIN
+			UPDATE lists SET updated_at = clock_timestamp()
+			WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.list_id ELSE NEW.list_id END;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE FUNCTION touch_todo() RETURNS trigger AS $$
+		BEGIN
+			UPDATE todos SET updated_at = clock_timestamp()
+			WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.todo_id ELSE NEW.todo_id END;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS lists_updated_at ON lists;
+		CREATE TRIGGER lists_updated_at BEFORE UPDATE ON lists
+			FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+		DROP TRIGGER IF EXISTS todos_updated_at ON todos;
+		CREATE TRIGGER todos_updated_at BEFORE UPDATE ON todos
+			FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+		DROP TRIGGER IF EXISTS todos_touch_list ON todos;
+		CREATE TRIGGER todos_touch_list AFTER INSERT OR UPDATE OR DELETE ON todos
+			FOR EACH ROW EXECUTE FUNCTION touch_todo_list();
+		DROP TRIGGER IF EXISTS list_members_touch_list ON list_members;
+		CREATE TRIGGER list_members_touch_list AFTER INSERT OR UPDATE OR DELETE ON list_members
+			FOR EACH ROW EXECUTE FUNCTION touch_member_list();
+		DROP TRIGGER IF EXISTS time_entries_touch_todo ON time_entries;
+		CREATE TRIGGER time_entries_touch_todo AFTER INSERT OR UPDATE OR DELETE ON time_entries
+			FOR EACH ROW EXECUTE FUNCTION touch_todo();
+		DROP TRIGGER IF EXISTS attachments_touch_todo ON attachments;
+		CREATE TRIGGER attachments_touch_todo AFTER INSERT OR DELETE ON attachments
+			FOR EACH ROW EXECUTE FUNCTION touch_todo();
+		DROP TRIGGER IF EXISTS todo_dependencies_touch_todo ON todo_dependencies;
+		CREATE TRIGGER todo_dependencies_touch_todo AFTER INSERT OR DELETE ON todo_dependencies
+			FOR EACH ROW EXECUTE FUNCTION touch_todo();
+
+		-- Every change to a list's todos, for the events API to stream and
+		-- resume from. A trigger records them however a todo is written; a
+		-- todo moved between lists is deleted from one and created on the
+		-- other. Events are numbered per list from lists.last_event_id,
+		-- whose row stays locked until the change commits, so they commit
+		-- in order and a reader that has seen N has seen everything before.
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS last_event_id BIGINT NOT NULL DEFAULT 0;
+		CREATE TABLE IF NOT EXISTS todo_events (
+			list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+			id BIGINT NOT NULL,
+			todo_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK (kind IN ('created', 'updated', 'deleted', 'reordered')),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (list_id, id)
+		);
+		-- Events no longer carry the todo's row as the change left it.
+		ALTER TABLE todo_events DROP COLUMN IF EXISTS data, DROP COLUMN IF EXISTS seq;
+		DROP SEQUENCE IF EXISTS todo_events_seq;
+		DROP FUNCTION IF EXISTS add_todo_event(INTEGER, INTEGER, TEXT, JSONB);
+
+		CREATE OR REPLACE FUNCTION add_todo_event(event_list INTEGER, event_todo INTEGER, event_kind TEXT) RETURNS void AS $$
+		DECLARE
+			seq BIGINT;
+		BEGIN
+			UPDATE lists SET last_event_id = last_event_id + 1 WHERE id = event_list
+			RETURNING last_event_id INTO seq;
+			-- Nothing to record when the list itself is being deleted.
+			-- Streams on the list are told once the change commits.
+			IF FOUND THEN
+				INSERT INTO todo_events (list_id, id, todo_id, kind) VALUES (event_list, seq, event_todo, event_kind);
+				PERFORM pg_notify('todo_events', event_list::text);
+			END IF;
+		END
+		$$ LANGUAGE plpgsql;
+
+		-- An update that only moves a todo up or down is a reorder. One
+		-- that changes nothing but updated_at came from a timer,
+		-- attachment or dependency, which show on the todo too.
+		CREATE OR REPLACE FUNCTION record_todo_event() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND NEW.list_id <> OLD.list_id) THEN
+				PERFORM add_todo_event(OLD.list_id, OLD.id, 'deleted');
+			END IF;
+			IF TG_OP = 'INSERT' OR (TG_OP = 'UPDATE' AND NEW.list_id <> OLD.list_id) THEN
+				PERFORM add_todo_event(NEW.list_id, NEW.id, 'created');
+			ELSIF TG_OP = 'UPDATE' THEN
+				IF to_jsonb(NEW) - 'position' - 'updated_at' IS DISTINCT FROM to_jsonb(OLD) - 'position' - 'updated_at' THEN
+					PERFORM add_todo_event(NEW.list_id, NEW.id, 'updated');
+				ELSIF NEW.position <> OLD.position THEN
+					PERFORM add_todo_event(NEW.list_id, NEW.id, 'reordered');
+				ELSE
+					PERFORM add_todo_event(NEW.list_id, NEW.id, 'updated');
+				END IF;
+			END IF;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS todos_record_event ON todos;
+		CREATE TRIGGER todos_record_event AFTER INSERT OR UPDATE OR DELETE ON todos
+			FOR EACH ROW EXECUTE FUNCTION record_todo_event();
+
+		-- The title and description a todo had before each edit to them,
+		-- however the edit was made. Only the latest 20 are kept per todo.
+		CREATE TABLE IF NOT EXISTS todo_revisions (
+			id BIGSERIAL PRIMARY KEY,
+			todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL,
+			replaced_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS todo_revisions_todo_id ON todo_revisions (todo_id, id);
+
+		CREATE OR REPLACE FUNCTION record_todo_revision() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO todo_revisions (todo_id, title, description) VALUES (OLD.id, OLD.title, OLD.description);
+			DELETE FROM todo_revisions WHERE todo_id = OLD.id AND id NOT IN (
+				SELECT id FROM todo_revisions WHERE todo_id = OLD.id ORDER BY id DESC LIMIT 20
+			);
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS todos_record_revision ON todos;
+		CREATE TRIGGER todos_record_revision AFTER UPDATE OF title, description ON todos
+			FOR EACH ROW
+			WHEN (OLD.title IS DISTINCT FROM NEW.title OR OLD.description IS DISTINCT FROM NEW.description)
+			EXECUTE FUNCTION record_todo_revision();
+
+		-- How many days each user's todos with a word in their title
+		-- usually take from being added to being done, rebuilt nightly
+		-- for suggesting due dates.
+		CREATE TABLE IF NOT EXISTS due_date_patterns (
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			keyword TEXT NOT NULL,
+			days INTEGER NOT NULL,
+			samples INTEGER NOT NULL,
+			PRIMARY KEY (user_id, keyword)
+		);
+
+		-- A new user's progress through the onboarding checklist, and the
+		-- Getting started list of sample todos made with it. Steps are
+		-- done by doing them or skipped; dismissing hides the lot.
+		CREATE TABLE IF NOT EXISTS onboarding (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			list_id INTEGER REFERENCES lists(id) ON DELETE SET NULL,
+			done TEXT[] NOT NULL DEFAULT '{}',
+			skipped TEXT[] NOT NULL DEFAULT '{}',
+			dismissed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- The statuses a list's todos move through, in order. A todo in a
+		-- done status is completed: sync_todo_status keeps completed in
+		-- step with status_id whichever is written, so code that only
+		-- cares whether a todo is done goes on reading completed.
+		CREATE TABLE IF NOT EXISTS list_statuses (
+			id SERIAL PRIMARY KEY,
+			list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			done BOOLEAN NOT NULL DEFAULT FALSE,
+			position INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS list_statuses_name ON list_statuses (list_id, lower(name));
+		-- No ON DELETE: a status's todos are moved to another before it's
+		-- deleted, and deleting the list takes both.
+		ALTER TABLE todos ADD COLUMN IF NOT EXISTS status_id INTEGER REFERENCES list_statuses(id);
+		CREATE INDEX IF NOT EXISTS todos_status_id ON todos (status_id);
+
+		CREATE OR REPLACE FUNCTION add_default_statuses(status_list INTEGER) RETURNS void AS $$
+			INSERT INTO list_statuses (list_id, name, done, position)
+			VALUES (status_list, 'To do', FALSE, 0), (status_list, 'In progress', FALSE, 1), (status_list, 'Done', TRUE, 2);
+		$$ LANGUAGE sql;
+
+		CREATE OR REPLACE FUNCTION seed_list_statuses() RETURNS trigger AS $$
+		BEGIN
+			PERFORM add_default_statuses(NEW.id);
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS lists_seed_statuses ON lists;
+		CREATE TRIGGER lists_seed_statuses AFTER INSERT ON lists
+			FOR EACH ROW EXECUTE FUNCTION seed_list_statuses();
+
+		-- A todo moved to one of its list's statuses is completed or
+		-- reopened to match. One completed, reopened, moved to another list
+		-- or added without a status gets the first status that fits,
+		-- preferring one named like the status it had.
+		CREATE OR REPLACE FUNCTION sync_todo_status() RETURNS trigger AS $$
+		DECLARE
+			status_done BOOLEAN;
+		BEGIN
+			IF TG_OP = 'INSERT' OR NEW.status_id IS DISTINCT FROM OLD.status_id THEN
+				SELECT done INTO status_done FROM list_statuses WHERE id = NEW.status_id AND list_id = NEW.list_id;
+			END IF;
+			IF status_done IS NOT NULL THEN
+				NEW.completed := status_done;
+				NEW.completed_at := CASE WHEN status_done THEN COALESCE(NEW.completed_at, NOW()) END;
+			ELSIF NOT EXISTS (
+				SELECT 1 FROM list_statuses
+				WHERE id = NEW.status_id AND list_id = NEW.list_id AND done = COALESCE(NEW.completed, FALSE)
+			) THEN
+				NEW.status_id := (
+					SELECT s.id FROM list_statuses s
+					WHERE s.list_id = NEW.list_id AND s.done = COALESCE(NEW.completed, FALSE)
+					ORDER BY lower(s.name) IS NOT DISTINCT FROM (SELECT lower(o.name) FROM list_statuses o WHERE o.id = NEW.status_id) DESC,
+						s.position, s.id
+					LIMIT 1
+				);
+			END IF;
+			RETURN NEW;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS todos_sync_status ON todos;
+		CREATE TRIGGER todos_sync_status BEFORE INSERT OR UPDATE OF status_id, completed, list_id ON todos
+			FOR EACH ROW EXECUTE FUNCTION sync_todo_status();
+
+		-- Lists from before statuses get the defaults, and their todos
+		-- To do or Done.
+		SELECT add_default_statuses(l.id) FROM lists l
+		WHERE NOT EXISTS (SELECT 1 FROM list_statuses s WHERE s.list_id = l.id);
+		UPDATE todos t SET status_id = (
+			SELECT s.id FROM list_statuses s
+			WHERE s.list_id = t.list_id AND s.done = COALESCE(t.completed, FALSE)
+			ORDER BY s.position, s.id
+			LIMIT 1
+		)
+		WHERE t.status_id IS NULL;
+
+		-- Nightly exports of a user's todos to their own bucket. The
+		-- credentials are sealed with ENCRYPTION_KEY. The last_ columns
+		-- are how the latest delivery went, for the settings page.
+		CREATE TABLE IF NOT EXISTS export_schedules (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider TEXT NOT NULL CHECK (provider IN ('s3', 'gcs')),
+			bucket TEXT NOT NULL,
+			prefix TEXT NOT NULL DEFAULT '',
+			region TEXT NOT NULL DEFAULT '',
+			endpoint TEXT NOT NULL DEFAULT '',
+			format TEXT NOT NULL CHECK (format IN ('json', 'csv')),
+			credentials TEXT NOT NULL,
+			timezone TEXT NOT NULL DEFAULT 'UTC',
+			next_run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_run_at TIMESTAMPTZ,
+			last_error TEXT NOT NULL DEFAULT '',
+			last_key TEXT NOT NULL DEFAULT '',
+			last_size BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS export_schedules_next_run ON export_schedules (next_run_at);
+
+		-- Exports of a user's todos to download, made by a background job
+		-- rather than in the request that asked for them. The job claims a
+		-- pending one, and takes over a claim that outlives its timeout,
+		-- like the outbox relay. Once ready, data can be downloaded until
+		-- expires_at, when the row is purged, with the token emailed then.
+		-- Only its hash is stored, like session tokens.
+		CREATE TABLE IF NOT EXISTS export_downloads (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			format TEXT NOT NULL CHECK (format IN ('json', 'csv')),
+			token_hash TEXT UNIQUE,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready', 'failed')),
+			attempts INTEGER NOT NULL DEFAULT 0,
+			claimed_at TIMESTAMPTZ,
+			error TEXT NOT NULL DEFAULT '',
+			content_type TEXT NOT NULL DEFAULT '',
+			data BYTEA,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ
+		);
+		-- Links from when tokens were stored as they are stop working;
+		-- they expired within a day anyway.
+		ALTER TABLE export_downloads ADD COLUMN IF NOT EXISTS token_hash TEXT UNIQUE;
+		ALTER TABLE export_downloads DROP COLUMN IF EXISTS token;
+		CREATE INDEX IF NOT EXISTS export_downloads_user_id ON export_downloads (user_id, created_at);
+		CREATE INDEX IF NOT EXISTS export_downloads_pending ON export_downloads (created_at) WHERE status = 'pending';
+
+		-- What users sent through the support form. Admins are emailed each
+		-- one and answer by email; page is where the user was when they
+		-- opened the form.
+		CREATE TABLE IF NOT EXISTS support_tickets (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			subject TEXT NOT NULL,
+			message TEXT NOT NULL,
+			page TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS support_tickets_user_id ON support_tickets (user_id, created_at);
+
+		-- Emails and push notifications a change sends, written in the
+		-- same transaction as the change so they go out if and only if it
+		-- commits. The relay claims a row while delivering it and deletes
+		-- it once delivered; a claim that outlives its timeout was by an
+		-- instance that died, and is taken over. next_attempt_at is NULL
+		-- once the relay has given up on it.
+		CREATE TABLE IF NOT EXISTS outbox (
+			id BIGSERIAL PRIMARY KEY,
+			kind TEXT NOT NULL CHECK (kind IN ('email', 'push')),
+			payload JSONB NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ DEFAULT NOW(),
+			claimed_at TIMESTAMPTZ,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS outbox_next_attempt ON outbox (next_attempt_at);
+
+		-- How many of each list's todos are open and done, leaving out
+		-- archived ones, so the sidebar and stats never count todos. The
+		-- triggers keep them in the same transaction as the change, and
+		-- a list's row locks while it's updated, so concurrent changes
+		-- add up. A list gets its row when it's made; lists from before
+		-- are counted once, after the triggers are in place, which holds
+		-- off writes to todos until this commits.
+		CREATE TABLE IF NOT EXISTS todo_counters (
+			list_id INTEGER PRIMARY KEY REFERENCES lists(id) ON DELETE CASCADE,
+			open INTEGER NOT NULL DEFAULT 0,
+			completed INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE OR REPLACE FUNCTION count_todos() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP <> 'INSERT' AND OLD.archived_at IS NULL THEN
+				UPDATE todo_counters SET open = open - (NOT OLD.completed)::int, completed = completed - OLD.completed::int
+				WHERE list_id = OLD.list_id;
+			END IF;
+			IF TG_OP <> 'DELETE' AND NEW.archived_at IS NULL THEN
+				UPDATE todo_counters SET open = open + (NOT NEW.completed)::int, completed = completed + NEW.completed::int
+				WHERE list_id = NEW.list_id;
+			END IF;
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE FUNCTION add_todo_counter() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO todo_counters (list_id) VALUES (NEW.id);
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS todos_count ON todos;
+		CREATE TRIGGER todos_count AFTER INSERT OR DELETE ON todos
+			FOR EACH ROW EXECUTE FUNCTION count_todos();
+		DROP TRIGGER IF EXISTS todos_count_update ON todos;
+		CREATE TRIGGER todos_count_update AFTER UPDATE OF list_id, completed, archived_at ON todos
+			FOR EACH ROW
+			WHEN ((OLD.list_id, OLD.completed, OLD.archived_at IS NULL) IS DISTINCT FROM (NEW.list_id, NEW.completed, NEW.archived_at IS NULL))
+			EXECUTE FUNCTION count_todos();
+		DROP TRIGGER IF EXISTS lists_add_counter ON lists;
+		CREATE TRIGGER lists_add_counter AFTER INSERT ON lists
+			FOR EACH ROW EXECUTE FUNCTION add_todo_counter();
+
+		INSERT INTO todo_counters (list_id, open, completed)
+		SELECT l.id,
+			(SELECT COUNT(*) FROM todos t WHERE t.list_id = l.id AND t.archived_at IS NULL AND NOT t.completed),
+			(SELECT COUNT(*) FROM todos t WHERE t.list_id = l.id AND t.archived_at IS NULL AND t.completed)
+		FROM lists l
+		WHERE NOT EXISTS (SELECT 1 FROM todo_counters c WHERE c.list_id = l.id);
+	`)
+	return err
+}
+
+// createSearchIndexes adds the full-text index and, if the pg_trgm extension
+// is available, the trigram index used for typo-tolerant fallback. Without
+// pg_trgm, search works but doesn't forgive typos.
+func createSearchIndexes(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS todos_title_fts ON todos USING GIN (to_tsvector('english', title))`)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS pg_trgm"); err != nil {
+		log.Printf("pg_trgm unavailable, fuzzy search disabled: %v", err)
+		return nil
+	}
+	_, err = db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS todos_title_trgm ON todos USING GIN (title gin_trgm_ops)")
+	if err != nil {
+		log.Printf("Failed to create trigram index, fuzzy search disabled: %v", err)
+	}
+	return nil
+}
+
+// TrigramSearch reports whether the trigram index exists, so typo-tolerant
+// search can be used.
+func TrigramSearch(ctx context.Context, db *sql.DB) (bool, error) {
+	var ok bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE schemaname = current_schema() AND indexname = 'todos_title_trgm')",
+	).Scan(&ok)
+	return ok, err
+}
+
+// checkSchemaVersion runs before migrating and refuses a database that a
+// newer build has already migrated past this one.
+func checkSchemaVersion(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			version INTEGER NOT NULL,
+			migrated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, "SELECT version FROM schema_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("the database is at schema version %d but this build only knows up to %d; deploy a newer build", version, schemaVersion)
+	}
+	return nil
+}
+
+// Version returns the schema version db was last migrated to, or 0 if no
+// build has migrated it yet.
+func Version(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('schema_version') IS NOT NULL").Scan(&exists); err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT version FROM schema_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// BuildVersion is the schema version this build migrates databases to.
+func BuildVersion() int {
+	return schemaVersion
+}
+
+// recordSchemaVersion marks the database as migrated to schemaVersion.
+func recordSchemaVersion(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO schema_version (version) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET version = $1, migrated_at = NOW()
+		WHERE schema_version.version <> $1`,
+		schemaVersion,
+	)
+	return err
+}
+
+// Check verifies the migrated schema is what the code expects: every
+// required index exists and every statement in the query layer prepares.
+func Check(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx,
+		"SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND indexname = ANY($1)",
+		pq.Array(requiredIndexes),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, name := range requiredIndexes {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	var errs []error
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("missing indexes: %s", strings.Join(missing, ", ")))
+	}
+	if err := checkStatements(ctx, db); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type txKey struct{}
+
+// ContextWithTx returns a copy of ctx carrying tx. Queries and Conn called
+// with it run inside tx.
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFrom returns the transaction ctx carries, or nil.
+func TxFrom(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(txKey{}).(*sql.Tx)
+	return tx
+}
+
+// Conn returns what queries in ctx should run against: the transaction it
+// carries, or db.
+func Conn(ctx context.Context, db DBTX) DBTX {
+	if tx := TxFrom(ctx); tx != nil {
+		return tx
+	}
+	return db
+}
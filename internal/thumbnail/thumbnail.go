@@ -0,0 +1,95 @@
+// Package thumbnail scales images down for previews. It only uses the
+// standard library's decoders, so it handles JPEG, PNG and GIF.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// maxPixels keeps a small file that decodes into a huge image (a
+// decompression bomb) from eating the server's memory.
+const maxPixels = 40_000_000
+
+var ErrTooLarge = errors.New("image is too large to thumbnail")
+
+// Make scales the image in data to fit within size×size, keeping its aspect
+// ratio, and returns it with its content type. Images that are already
+// small enough are re-encoded as they are, which also drops their metadata.
+// Opaque images become JPEGs and ones with transparency PNGs.
+func Make(data []byte, size int) ([]byte, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, "", ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	dst := scale(src, size)
+	var buf bytes.Buffer
+	if opaque(dst) {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(&buf, dst)
+	return buf.Bytes(), "image/png", err
+}
+
+// scale box-filters src down to fit within size×size. Each destination
+// pixel is the average of the source pixels it covers.
+func scale(src image.Image, size int) *image.NRGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/b.Dx())
+		} else {
+			w, h = max(1, w*size/b.Dy()), size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+max((y+1)*b.Dy()/h, y*b.Dy()/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+max((x+1)*b.Dx()/w, x*b.Dx()/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			alpha := a / n
+			if alpha == 0 {
+				dst.Pix[i+3] = 0
+				continue
+			}
+			// The sums are alpha-premultiplied; NRGBA isn't.
+			dst.Pix[i+0] = uint8(r * 0xff / a)
+			dst.Pix[i+1] = uint8(g * 0xff / a)
+			dst.Pix[i+2] = uint8(bl * 0xff / a)
+			dst.Pix[i+3] = uint8(alpha >> 8)
+		}
+	}
+	return dst
+}
+
+func opaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+// Package transcribe turns voice notes attached to todos into text.
+//
+// Each speech-to-text service is a Transcriber. The one built in speaks
+// OpenAI's Whisper API, which self-hosted servers like faster-whisper-server
+// and LocalAI also offer, so pointing it at another URL is enough to keep
+// recordings in-house.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Transcriber is a speech-to-text service.
+type Transcriber interface {
+	// Transcribe returns what is said in audio, a file of the given name
+	// and content type.
+	Transcribe(ctx context.Context, filename, contentType string, audio []byte) (string, error)
+}
+
+// New returns the named transcriber. baseURL replaces the service's public
+// endpoint when set, and model the model it's asked to use.
+func New(name, baseURL, apiKey, model string) (Transcriber, error) {
+	switch name {
+	case "whisper":
+		if baseURL == "" {
+			baseURL = "https://api.openai.com"
+		}
+		if model == "" {
+			model = "whisper-1"
+		}
+		return &Whisper{
+			baseURL: strings.TrimSuffix(baseURL, "/"),
+			apiKey:  apiKey,
+			model:   model,
+			// A few minutes of audio can take a while to get through.
+			client: &http.Client{Timeout: 2 * time.Minute},
+		}, nil
+	default:
+		return nil, fmt.Errorf("TRANSCRIBER: want whisper, got %q", name)
+	}
+}
+
+// Whisper transcribes through the Whisper API's transcriptions endpoint.
+type Whisper struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func (w *Whisper) Transcribe(ctx context.Context, filename, contentType string, audio []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("model", w.model)
+	mw.WriteField("response_format", "json")
+	part, err := mw.CreatePart(map[string][]string{
+		"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename=%q`, filename)},
+		"Content-Type":        {contentType},
+	})
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.baseURL+"/v1/audio/transcriptions", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if w.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+w.apiKey)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The API explains itself in {"error": {"message": ...}}.
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&failure) == nil && failure.Error.Message != "" {
+			return "", fmt.Errorf("whisper: %s: %s", resp.Status, failure.Error.Message)
+		}
+		return "", fmt.Errorf("whisper: %s", resp.Status)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("whisper: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
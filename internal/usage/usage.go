@@ -0,0 +1,225 @@
+// Package usage counts requests per subject (user, token or client) and
+// route, buffering in memory and flushing daily rollups to Postgres so
+// tracking never adds a write to the request path.
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type key struct {
+	Day     string // YYYY-MM-DD, UTC
+	Subject string
+	Method  string
+	Route   string
+}
+
+type counts struct {
+	Requests int
+	Errors   int
+}
+
+type Recorder struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending map[key]counts
+}
+
+func NewRecorder(db *sql.DB) *Recorder {
+	return &Recorder{db: db, pending: make(map[key]counts)}
+}
+
+func (rec *Recorder) Migrate(ctx context.Context) error {
+	_, err := rec.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS usage_rollups (
+			day DATE NOT NULL,
+			subject TEXT NOT NULL,
+			method TEXT NOT NULL,
+			route TEXT NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, subject, method, route)
+		);
+		CREATE INDEX IF NOT EXISTS usage_rollups_subject_day ON usage_rollups (subject, day);
+	`)
+	return err
+}
+
+// Record counts one request. Statuses of 500 and above count as errors.
+func (rec *Recorder) Record(subject, method, route string, status int) {
+	k := key{Day: time.Now().UTC().Format("2006-01-02"), Subject: subject, Method: method, Route: route}
+
+	rec.mu.Lock()
+	c := rec.pending[k]
+	c.Requests++
+	if status >= 500 {
+		c.Errors++
+	}
+	rec.pending[k] = c
+	rec.mu.Unlock()
+}
+
+// Flush writes buffered counts to the rollup table. Counts that fail to
+// write are put back for the next flush.
+func (rec *Recorder) Flush(ctx context.Context) error {
+	rec.mu.Lock()
+	batch := rec.pending
+	rec.pending = make(map[key]counts)
+	rec.mu.Unlock()
+
+	for k, c := range batch {
+		_, err := rec.db.ExecContext(ctx, `
+			INSERT INTO usage_rollups (day, subject, method, route, requests, errors)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (day, subject, method, route) DO UPDATE SET
+				requests = usage_rollups.requests + EXCLUDED.requests,
+				errors = usage_rollups.errors + EXCLUDED.errors`,
+			k.Day, k.Subject, k.Method, k.Route, c.Requests, c.Errors,
+		)
+		if err != nil {
+			rec.mu.Lock()
+			for k, c := range batch {
+				p := rec.pending[k]
+				p.Requests += c.Requests
+				p.Errors += c.Errors
+				rec.pending[k] = p
+			}
+			rec.mu.Unlock()
+			return err
+		}
+		delete(batch, k)
+	}
+	return nil
+}
+
+// Run flushes every interval until ctx is done, then flushes once more.
+func (rec *Recorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := rec.Flush(context.Background()); err != nil {
+				log.Printf("usage flush failed: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := rec.Flush(ctx); err != nil {
+				log.Printf("usage flush failed: %v", err)
+			}
+		}
+	}
+}
+
+type RouteUsage struct {
+	Method   string
+	Route    string
+	Requests int
+	Errors   int
+}
+
+type SubjectUsage struct {
+	Subject  string
+	Requests int
+	Errors   int
+}
+
+type DayUsage struct {
+	Day      time.Time
+	Requests int
+	Errors   int
+}
+
+// ForSubjects returns the usage per route of subjects together over the
+// last days, like a user's with their API tokens'.
+func (rec *Recorder) ForSubjects(ctx context.Context, subjects []string, days int) ([]RouteUsage, error) {
+	return rec.routes(ctx, `
+		SELECT method, route, SUM(requests), SUM(errors) FROM usage_rollups
+		WHERE subject = ANY($1) AND day > CURRENT_DATE - $2::INTEGER
+		GROUP BY method, route ORDER BY SUM(requests) DESC`,
+		pq.Array(subjects), days,
+	)
+}
+
+// TopRoutes returns the busiest routes across all subjects.
+func (rec *Recorder) TopRoutes(ctx context.Context, days, limit int) ([]RouteUsage, error) {
+	return rec.routes(ctx, `
+		SELECT method, route, SUM(requests), SUM(errors) FROM usage_rollups
+		WHERE day > CURRENT_DATE - $1::INTEGER
+		GROUP BY method, route ORDER BY SUM(requests) DESC LIMIT $2`,
+		days, limit,
+	)
+}
+
+func (rec *Recorder) routes(ctx context.Context, query string, args ...any) ([]RouteUsage, error) {
+	rows, err := rec.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RouteUsage
+	for rows.Next() {
+		var u RouteUsage
+		if err := rows.Scan(&u.Method, &u.Route, &u.Requests, &u.Errors); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// TopSubjects returns the heaviest users across all routes.
+func (rec *Recorder) TopSubjects(ctx context.Context, days, limit int) ([]SubjectUsage, error) {
+	rows, err := rec.db.QueryContext(ctx, `
+		SELECT subject, SUM(requests), SUM(errors) FROM usage_rollups
+		WHERE day > CURRENT_DATE - $1::INTEGER
+		GROUP BY subject ORDER BY SUM(requests) DESC LIMIT $2`,
+		days, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SubjectUsage
+	for rows.Next() {
+		var u SubjectUsage
+		if err := rows.Scan(&u.Subject, &u.Requests, &u.Errors); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// Daily returns total requests per day, oldest first.
+func (rec *Recorder) Daily(ctx context.Context, days int) ([]DayUsage, error) {
+	rows, err := rec.db.QueryContext(ctx, `
+		SELECT day, SUM(requests), SUM(errors) FROM usage_rollups
+		WHERE day > CURRENT_DATE - $1::INTEGER
+		GROUP BY day ORDER BY day`,
+		days,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DayUsage
+	for rows.Next() {
+		var u DayUsage
+		if err := rows.Scan(&u.Day, &u.Requests, &u.Errors); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
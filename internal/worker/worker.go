@@ -0,0 +1,82 @@
+// Package worker runs the app's background jobs: periodic cleanups, digests
+// and reminders, and long-running loops like the usage recorder's flusher.
+// Jobs run until the Scheduler is stopped, and Stop waits for them, so a
+// job isn't cut off halfway through at shutdown.
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type Scheduler struct {
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func New() *Scheduler {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, stop: stop}
+}
+
+// Go runs fn until the scheduler stops. fn should return once its context
+// is cancelled.
+func (s *Scheduler) Go(fn func(context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn(s.ctx)
+	}()
+}
+
+// Daily runs fn once a day at the given offset past local midnight.
+// Failures are logged and retried the next day.
+func (s *Scheduler) Daily(name string, offset time.Duration, fn func(context.Context) error) {
+	s.Go(func(ctx context.Context) {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Add(offset)
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+
+			if err := fn(ctx); err != nil {
+				log.Printf("job %s failed: %v", name, err)
+			}
+		}
+	})
+}
+
+// Every runs fn at a fixed interval.
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(context.Context) error) {
+	s.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := fn(ctx); err != nil {
+				log.Printf("job %s failed: %v", name, err)
+			}
+		}
+	})
+}
+
+// Stop cancels every job's context and waits for them to return.
+func (s *Scheduler) Stop() {
+	s.stop()
+	s.wg.Wait()
+}
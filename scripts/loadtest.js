@@ -0,0 +1,41 @@
+// k6 load test over a dataset made by `go run ./cmd/web loadgen`. Each
+// virtual user signs in as one of the synthetic users and requests the
+// endpoints the targets file lists, tagged by name so k6 reports each one.
+//
+//   k6 run scripts/loadtest.js
+//   k6 run -e TARGETS=other.json -e VUS=50 -e DURATION=5m scripts/loadtest.js
+import http from "k6/http";
+import { check } from "k6";
+import { SharedArray } from "k6/data";
+
+const targets = new SharedArray("targets", () => [JSON.parse(open(__ENV.TARGETS || "../loadtest-targets.json"))])[0];
+
+export const options = {
+  vus: Number(__ENV.VUS || 20),
+  duration: __ENV.DURATION || "1m",
+  thresholds: {
+    http_req_failed: ["rate<0.01"],
+    http_req_duration: ["p(95)<500"],
+  },
+};
+
+function pick(items) {
+  return items[Math.floor(Math.random() * items.length)];
+}
+
+export default function () {
+  const user = targets.users[(__VU - 1) % targets.users.length];
+  const requests = targets.endpoints.map((e) => {
+    const path = e.path
+      .replace("{list}", pick(user.list_ids))
+      .replace("{word}", pick(targets.words));
+    const headers = e.auth === "token"
+      ? { Authorization: `Bearer ${user.token}` }
+      : { Cookie: `${targets.session_cookie}=${user.session}` };
+    return { method: e.method, url: targets.base_url + path, params: { headers, tags: { name: e.name } } };
+  });
+
+  for (const res of http.batch(requests)) {
+    check(res, { "status is 200": (r) => r.status === 200 });
+  }
+}
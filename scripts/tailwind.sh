@@ -0,0 +1,13 @@
+#!/bin/sh
+# Builds static/css/tailwind.css from the classes templates, scripts and Go
+# code use, with the Tailwind version pinned here. The built file is in the
+# repo and bundled into app.css at startup, so nothing runs at build time:
+# run this after using a class that isn't in it yet, and commit the result.
+# It isn't minified here; the asset pipeline does that.
+set -eu
+
+TAILWIND_VERSION=3.4.1
+
+cd "$(dirname "$0")/.."
+npx --yes "tailwindcss@${TAILWIND_VERSION}" -c tailwind.config.js -o static/css/tailwind.css
+echo "Built static/css/tailwind.css with Tailwind ${TAILWIND_VERSION}"
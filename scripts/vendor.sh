@@ -0,0 +1,23 @@
+#!/bin/sh
+# Fetches a new htmx into static/vendor, for upgrading it. The copy in the
+# repo is what the app and the Docker build use, so nothing is downloaded
+# at build time. Set the version here and its integrity hash, as htmx
+# publishes it, then update the pin in internal/http/assets.go to match.
+set -eu
+
+HTMX_VERSION=1.9.10
+HTMX_INTEGRITY=sha384-D1Kt99CQMDuVetoL1lrYwg5t+9QdHe7NLX/SoJYkXDFfX37iInKRy5xLSi8nO7UC
+
+cd "$(dirname "$0")/.."
+mkdir -p static/vendor
+tmp=$(mktemp)
+trap 'rm -f "$tmp"' EXIT
+curl -fsSL "https://unpkg.com/htmx.org@${HTMX_VERSION}/dist/htmx.min.js" -o "$tmp"
+
+got="sha384-$(openssl dgst -sha384 -binary "$tmp" | openssl base64 -A)"
+if [ "$got" != "$HTMX_INTEGRITY" ]; then
+	echo "htmx ${HTMX_VERSION} hashes to $got, not $HTMX_INTEGRITY" >&2
+	exit 1
+fi
+mv "$tmp" static/vendor/htmx.min.js
+echo "Vendored htmx ${HTMX_VERSION} into static/vendor"
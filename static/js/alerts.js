@@ -0,0 +1,23 @@
+// htmx leaves 4xx and 5xx responses unswapped. Let 403, 404 and 405
+// through so the notice the server retargets to #alerts is shown, and
+// server errors the server retargeted, like the 500 page after a panic.
+document.addEventListener("htmx:beforeSwap", (event) => {
+    const xhr = event.detail.xhr;
+    if ([403, 404, 405].includes(xhr.status) || (xhr.status >= 500 && xhr.getResponseHeader("HX-Retarget"))) {
+        event.detail.shouldSwap = true;
+        event.detail.isError = false;
+    }
+});
+
+// Toasts dismiss themselves after a few seconds.
+document.addEventListener("htmx:load", (event) => {
+    const elt = event.detail.elt;
+    const toasts = elt.matches("[data-toast]") ? [elt] : elt.querySelectorAll("[data-toast]");
+    toasts.forEach((toast) => setTimeout(() => toast.remove(), 4000));
+});
+
+// Send the page's CSRF token with every htmx request.
+document.addEventListener("htmx:configRequest", (event) => {
+    const token = document.querySelector('meta[name="csrf-token"]');
+    if (token) event.detail.headers["X-CSRF-Token"] = token.content;
+});
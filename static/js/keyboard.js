@@ -0,0 +1,82 @@
+// On a list's page, j and k move down and up the todos, x ticks or unticks
+// the one you're on, shift+x ticks everything from the last one you ticked
+// to it, and e archives what's ticked, or the todo you're on when nothing
+// is. Like undo's shortcuts they're left to the field while typing. Range
+// selection asks the server, which knows the list's order under the
+// current filters (see selectRange).
+(() => {
+    const list = () => document.getElementById("todo-list");
+    const rows = () => [...list().querySelectorAll(":scope > [data-todo]")];
+    let current = null; // the id of the todo you're on
+    let anchor = null;  // the id of the todo last ticked with x
+
+    function moveTo(row) {
+        if (!row) return;
+        current = row.dataset.todo;
+        row.focus({ preventScroll: true });
+        row.scrollIntoView({ block: "nearest" });
+    }
+
+    function selected() {
+        return [...list().querySelectorAll("input[name=todo_ids]:checked")].map((box) => box.value);
+    }
+
+    document.addEventListener("keydown", (event) => {
+        if (event.ctrlKey || event.metaKey || event.altKey) return;
+        if (!list() || document.querySelector('#modal [role="dialog"]')) return;
+        if (event.target.closest('input:not([type="checkbox"]), textarea, select, [contenteditable]')) return;
+
+        const all = rows();
+        const here = event.target.closest?.("[data-todo]") || all.find((row) => row.dataset.todo === current);
+        const index = all.indexOf(here);
+        switch (event.key) {
+        case "j":
+            moveTo(all[index + 1] || all[all.length - 1]);
+            break;
+        case "k":
+            moveTo(index > 0 ? all[index - 1] : all[0]);
+            break;
+        case "x":
+        case "X": {
+            if (!here) return;
+            const id = here.dataset.todo;
+            const box = document.getElementById(`select-${id}`);
+            if (event.shiftKey && anchor && anchor !== id) {
+                htmx.ajax("GET", list().dataset.selection, {
+                    source: list(), swap: "none", values: { from: anchor, to: id },
+                });
+            } else if (box) {
+                box.checked = !box.checked;
+            }
+            anchor = id;
+            current = id;
+            break;
+        }
+        case "e": {
+            if (!list().dataset.archive) return;
+            const ids = selected();
+            if (ids.length === 0 && here) ids.push(here.dataset.todo);
+            if (ids.length === 0) return;
+            // Land on the next todo that's staying once these are gone.
+            const next = all.slice(index + 1).find((row) => !ids.includes(row.dataset.todo))
+                || all.slice(0, index).reverse().find((row) => !ids.includes(row.dataset.todo));
+            current = next?.dataset.todo ?? null;
+            htmx.ajax("POST", list().dataset.archive, {
+                source: document.body, swap: "none", values: { todo_ids: ids },
+            });
+            break;
+        }
+        default:
+            return;
+        }
+        event.preventDefault();
+    });
+
+    // Stay on the same todo when the list reloads around it, and on the
+    // next one when it's archived.
+    document.addEventListener("htmx:afterSettle", () => {
+        if (!current || !list()) return;
+        const row = document.getElementById(`todo-${current}`);
+        if (row && document.activeElement === document.body) moveTo(row);
+    });
+})();
@@ -0,0 +1,51 @@
+// Modals are swapped into #modal by htmx (see templates/components/modal.html).
+const modal = () => document.getElementById("modal");
+const focusable = 'a[href], button:not([disabled]), input:not([disabled]):not([type="hidden"]), select, textarea, [tabindex]:not([tabindex="-1"])';
+let opener = null;
+
+function closeModal() {
+    const container = modal();
+    if (!container || !container.firstElementChild) return;
+    container.innerHTML = "";
+    if (opener && document.contains(opener)) opener.focus();
+    opener = null;
+}
+
+// Focus the [autofocus] field, or the first control, once a modal lands.
+document.addEventListener("htmx:afterSwap", (event) => {
+    if (event.detail.target !== modal()) return;
+    opener = opener || event.detail.requestConfig?.elt || null;
+    const dialog = modal().querySelector('[role="dialog"]');
+    if (!dialog) return;
+    (dialog.querySelector("[autofocus]") || dialog.querySelector(focusable))?.focus();
+});
+
+// The server fires closeModal once a modal's action has succeeded.
+document.body.addEventListener("closeModal", closeModal);
+
+document.addEventListener("click", (event) => {
+    if (event.target.closest("[data-modal-close]") || event.target.matches("[data-modal-backdrop]")) {
+        closeModal();
+    }
+});
+
+// Escape closes; Tab cycles through the dialog instead of the page behind it.
+document.addEventListener("keydown", (event) => {
+    const dialog = modal()?.querySelector('[role="dialog"]');
+    if (!dialog) return;
+    if (event.key === "Escape") {
+        closeModal();
+        return;
+    }
+    if (event.key !== "Tab") return;
+    const items = [...dialog.querySelectorAll(focusable)];
+    if (items.length === 0) return;
+    const first = items[0], last = items[items.length - 1];
+    if (event.shiftKey && document.activeElement === first) {
+        last.focus();
+        event.preventDefault();
+    } else if (!event.shiftKey && document.activeElement === last) {
+        first.focus();
+        event.preventDefault();
+    }
+});
@@ -0,0 +1,26 @@
+// The nearby view's "Use my location" asks the browser where it is and
+// posts the coordinates with the form, so todos come back sorted by
+// distance. Any place typed in is cleared, since the server prefers it.
+document.addEventListener("click", (event) => {
+    const button = event.target.closest("[data-locate]");
+    if (!button) return;
+    const form = button.form;
+    const results = document.getElementById("nearby");
+    if (!navigator.geolocation) {
+        results.textContent = "This browser can't share its location. Type a place instead.";
+        return;
+    }
+    button.disabled = true;
+    navigator.geolocation.getCurrentPosition((position) => {
+        button.disabled = false;
+        form.elements.latitude.value = position.coords.latitude;
+        form.elements.longitude.value = position.coords.longitude;
+        if (form.elements.place) form.elements.place.value = "";
+        htmx.trigger(form, "submit");
+    }, (error) => {
+        button.disabled = false;
+        results.textContent = error.code === error.PERMISSION_DENIED
+            ? "Location access was blocked. Allow it for this site, or type a place."
+            : "Your location couldn't be found. Try again, or type a place.";
+    });
+});
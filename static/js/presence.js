@@ -0,0 +1,11 @@
+// The presence strip on shared lists is re-rendered by the server and
+// pushed over server-sent events whenever someone opens or leaves the list.
+// EventSource reconnects by itself when the stream ends.
+document.addEventListener("DOMContentLoaded", () => {
+    const strip = document.querySelector("[data-presence-stream]");
+    if (!strip || !window.EventSource) return;
+    const events = new EventSource(strip.dataset.presenceStream);
+    events.addEventListener("presence", (event) => {
+        strip.innerHTML = event.data;
+    });
+});
@@ -0,0 +1,45 @@
+// Subscribes this browser to push notifications. Wired to the
+// "Enable reminders" button on the main page.
+
+function urlBase64ToUint8Array(base64) {
+    const padding = "=".repeat((4 - (base64.length % 4)) % 4);
+    const raw = atob((base64 + padding).replace(/-/g, "+").replace(/_/g, "/"));
+    return Uint8Array.from(raw, (c) => c.charCodeAt(0));
+}
+
+async function enablePush(button) {
+    const permission = await Notification.requestPermission();
+    if (permission !== "granted") {
+        button.textContent = "🔕 Notifications blocked";
+        return;
+    }
+
+    const registration = await navigator.serviceWorker.register("/sw.js");
+    const key = await (await fetch("/push/key")).text();
+    const subscription = await registration.pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: urlBase64ToUint8Array(key),
+    });
+
+    await fetch("/push/subscriptions", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+            "X-CSRF-Token": document.querySelector('meta[name="csrf-token"]')?.content ?? "",
+        },
+        body: JSON.stringify(subscription),
+    });
+    button.textContent = "🔔 Reminders on";
+    button.disabled = true;
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+    const button = document.getElementById("enable-push");
+    if (!button) return;
+    if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+        button.remove();
+        return;
+    }
+    button.hidden = false;
+    button.addEventListener("click", () => enablePush(button));
+});
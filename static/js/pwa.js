@@ -0,0 +1,30 @@
+// Registers the service worker and replays todos that were created offline
+// as soon as the connection comes back.
+
+if ("serviceWorker" in navigator) {
+    navigator.serviceWorker.register("/sw.js");
+
+    const replay = () =>
+        navigator.serviceWorker.ready.then((registration) => {
+            if ("sync" in registration) {
+                registration.sync.register("replay").catch(() => registration.active.postMessage("replay"));
+            } else {
+                registration.active.postMessage("replay");
+            }
+        });
+
+    window.addEventListener("online", replay);
+    if (navigator.onLine) replay();
+
+    navigator.serviceWorker.addEventListener("message", (event) => {
+        if (event.data !== "replayed") return;
+        document.getElementById("offline-notice").hidden = true;
+        htmx.ajax("GET", "/todos", { target: "#todo-list", swap: "innerHTML" });
+        htmx.trigger(document.body, "todosChanged");
+    });
+
+    document.addEventListener("queuedOffline", () => {
+        document.getElementById("offline-notice").hidden = false;
+        document.querySelector("form[hx-post='/todos']").reset();
+    });
+}
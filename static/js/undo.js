@@ -0,0 +1,15 @@
+// Ctrl+Z (⌘Z on a Mac) undoes the last change to a todo, and Ctrl+Shift+Z
+// or Ctrl+Y redoes it. While typing they're left to the text field, and
+// they only work on a list's page, which reloads its todos afterwards.
+document.addEventListener("keydown", (event) => {
+    if (!(event.ctrlKey || event.metaKey) || event.altKey) return;
+    if (!document.getElementById("todo-list")) return;
+    if (event.target.closest("input, textarea, select, [contenteditable]")) return;
+    const key = event.key.toLowerCase();
+    let path;
+    if (key === "z") path = event.shiftKey ? "/redo" : "/undo";
+    else if (key === "y" && !event.shiftKey) path = "/redo";
+    else return;
+    event.preventDefault();
+    htmx.ajax("POST", path, { source: document.body, swap: "none" });
+});
@@ -0,0 +1,39 @@
+// On the workload board, dragging a todo into someone's column assigns it
+// to them, or to nobody in Unassigned. The drop PATCHes /team/workload,
+// which answers with the board redrawn.
+document.addEventListener("dragstart", (event) => {
+    const card = event.target.closest?.("[data-workload] [data-todo-id]");
+    if (!card) return;
+    event.dataTransfer.setData("text/x-todo-id", card.dataset.todoId);
+    event.dataTransfer.effectAllowed = "move";
+});
+
+document.addEventListener("dragover", (event) => {
+    const column = event.target.closest?.("[data-workload] [data-assignee]");
+    if (!column || !event.dataTransfer.types.includes("text/x-todo-id")) return;
+    event.preventDefault();
+    event.dataTransfer.dropEffect = "move";
+    column.classList.add("ring-2", "ring-blue-300");
+});
+
+document.addEventListener("dragleave", (event) => {
+    const column = event.target.closest?.("[data-assignee]");
+    if (column && !column.contains(event.relatedTarget)) column.classList.remove("ring-2", "ring-blue-300");
+});
+
+document.addEventListener("drop", (event) => {
+    const column = event.target.closest?.("[data-workload] [data-assignee]");
+    if (!column) return;
+    const id = event.dataTransfer.getData("text/x-todo-id");
+    if (!id) return;
+    event.preventDefault();
+    column.classList.remove("ring-2", "ring-blue-300");
+    if (column.querySelector(`[data-todo-id="${id}"]`)) return;
+    const board = column.closest("[data-workload]");
+    htmx.ajax("PATCH", board.dataset.workload, {
+        source: board,
+        target: "#workload",
+        swap: "innerHTML",
+        values: { todo_id: id, assignee_id: column.dataset.assignee },
+    });
+});
@@ -0,0 +1,9 @@
+// Tailwind scans these for class names when scripts/tailwind.sh builds
+// static/css/tailwind.css. A class is only found when it's written out
+// whole, so don't put one together from pieces, in a template or in Go.
+/** @type {import('tailwindcss').Config} */
+module.exports = {
+  content: ["templates/**/*.html", "static/js/**/*.js", "internal/**/*.go"],
+  theme: {},
+  plugins: [],
+};
@@ -0,0 +1,163 @@
+// Service worker, generated by the server (see cmd/web/pwa.go).
+//
+// It caches the app shell so the page opens offline, queues todos created
+// while offline in IndexedDB, and replays them with their idempotency keys
+// once the connection is back. It also shows push notifications.
+
+const CACHE = "shell-{{.Version}}";
+const SHELL = {{.Shell}};
+const QUEUE_DB = "offline-queue";
+// The per-list todo fragment and create endpoint, /lists/{id}/todos.
+const TODOS = /^\/lists\/\d+\/todos$/;
+
+self.addEventListener("install", (event) => {
+    event.waitUntil(
+        caches.open(CACHE).then((cache) =>
+            Promise.all(SHELL.map((url) => cache.add(new Request(url, { mode: url.startsWith("/") ? "same-origin" : "no-cors" }))))
+        ).then(() => self.skipWaiting())
+    );
+});
+
+self.addEventListener("activate", (event) => {
+    event.waitUntil(
+        caches.keys()
+            .then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+            .then(() => self.clients.claim())
+    );
+});
+
+self.addEventListener("fetch", (event) => {
+    const req = event.request;
+    const url = new URL(req.url);
+
+    if (req.method === "POST" && url.origin === location.origin && TODOS.test(url.pathname)) {
+        event.respondWith(createOrQueue(req));
+        return;
+    }
+    if (req.method !== "GET") return;
+
+    // Pages and fragments: network first so data is fresh, cache as fallback.
+    if (req.mode === "navigate" || (url.origin === location.origin && TODOS.test(url.pathname))) {
+        event.respondWith(
+            fetch(req)
+                .then((res) => {
+                    const copy = res.clone();
+                    caches.open(CACHE).then((cache) => cache.put(req, copy));
+                    return res;
+                })
+                .catch(() => caches.match(req).then((hit) => hit || caches.match("/")))
+        );
+        return;
+    }
+
+    // Shell assets: cache first.
+    event.respondWith(caches.match(req).then((hit) => hit || fetch(req)));
+});
+
+async function createOrQueue(req) {
+    const body = await req.clone().text();
+    const key = req.headers.get("Idempotency-Key") || crypto.randomUUID();
+    try {
+        return await fetch(req);
+    } catch (err) {
+        await enqueue({
+            key,
+            url: new URL(req.url).pathname,
+            body,
+            contentType: req.headers.get("Content-Type"),
+            csrf: req.headers.get("X-CSRF-Token"),
+        });
+        // Keep the list as it is and let the page know the todo is queued.
+        return new Response("", {
+            status: 200,
+            headers: { "HX-Reswap": "none", "HX-Trigger": "queuedOffline" },
+        });
+    }
+}
+
+function openQueue() {
+    return new Promise((resolve, reject) => {
+        const open = indexedDB.open(QUEUE_DB, 1);
+        open.onupgradeneeded = () => open.result.createObjectStore("creates", { keyPath: "key" });
+        open.onsuccess = () => resolve(open.result);
+        open.onerror = () => reject(open.error);
+    });
+}
+
+async function enqueue(item) {
+    const db = await openQueue();
+    return new Promise((resolve, reject) => {
+        const tx = db.transaction("creates", "readwrite");
+        tx.objectStore("creates").put(item);
+        tx.oncomplete = resolve;
+        tx.onerror = () => reject(tx.error);
+    });
+}
+
+async function replay() {
+    const db = await openQueue();
+    const items = await new Promise((resolve, reject) => {
+        const req = db.transaction("creates").objectStore("creates").getAll();
+        req.onsuccess = () => resolve(req.result);
+        req.onerror = () => reject(req.error);
+    });
+
+    let sent = 0;
+    for (const item of items) {
+        try {
+            // Items queued before lists existed have no URL. The old /todos
+            // endpoint is gone, so they fail with a 4xx and are dropped below.
+            const res = await fetch(item.url || "/todos", {
+                method: "POST",
+                headers: {
+                    "Content-Type": item.contentType,
+                    "Idempotency-Key": item.key,
+                    "X-CSRF-Token": item.csrf || "",
+                },
+                body: item.body,
+            });
+            if (!res.ok && res.status < 500) {
+                console.warn("dropping queued todo", res.status);
+            } else if (!res.ok) {
+                continue;
+            }
+        } catch (err) {
+            return; // still offline; try again later
+        }
+        await new Promise((resolve) => {
+            const tx = db.transaction("creates", "readwrite");
+            tx.objectStore("creates").delete(item.key);
+            tx.oncomplete = resolve;
+        });
+        sent++;
+    }
+
+    if (sent > 0) {
+        const windows = await self.clients.matchAll({ type: "window" });
+        windows.forEach((client) => client.postMessage("replayed"));
+    }
+}
+
+self.addEventListener("sync", (event) => {
+    if (event.tag === "replay") event.waitUntil(replay());
+});
+
+self.addEventListener("message", (event) => {
+    if (event.data === "replay") event.waitUntil(replay());
+});
+
+self.addEventListener("push", (event) => {
+    const data = event.data ? event.data.json() : {};
+    event.waitUntil(
+        self.registration.showNotification(data.title || "Todos", {
+            body: data.body,
+            tag: data.tag,
+            data: { url: data.url || "/" },
+        })
+    );
+});
+
+self.addEventListener("notificationclick", (event) => {
+    event.notification.close();
+    event.waitUntil(self.clients.openWindow(event.notification.data.url));
+});